@@ -13,6 +13,8 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/mattermost/rtcd/service/rtc/dc"
+
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
@@ -157,7 +159,11 @@ func (c *Client) StopScreenShare() error {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	var screenStreamID string
 	for _, trx := range c.screenTransceivers {
+		if track := trx.Sender().Track(); track != nil {
+			screenStreamID = track.StreamID()
+		}
 		if err := c.pc.RemoveTrack(trx.Sender()); err != nil {
 			return fmt.Errorf("failed to remove track: %w", err)
 		}
@@ -165,7 +171,37 @@ func (c *Client) StopScreenShare() error {
 
 	c.screenTransceivers = nil
 
-	return c.sendWS(wsEventScreenOff, nil, false)
+	data, err := json.Marshal(map[string]string{
+		"screenStreamID": screenStreamID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %w", err)
+	}
+
+	return c.sendWS(wsEventScreenOff, map[string]any{
+		"data": string(data),
+	}, false)
+}
+
+// SetVideoPreference lets the SFU know the client preferences for the given
+// received video track. maxLevel caps the simulcast level (an empty value
+// means no limit) while paused stops the track from being forwarded.
+func (c *Client) SetVideoPreference(trackID, maxLevel string, paused bool) error {
+	dataCh := c.dc.Load()
+	if dataCh == nil || dataCh.ReadyState() != webrtc.DataChannelStateOpen {
+		return fmt.Errorf("data channel is not connected")
+	}
+
+	msg, err := dc.EncodeMessage(dc.MessageTypeVideoPreference, dc.MessageVideoPreference{
+		TrackID:  trackID,
+		MaxLevel: maxLevel,
+		Paused:   paused,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode dc message: %w", err)
+	}
+
+	return dataCh.Send(msg)
 }
 
 func (c *Client) RaiseHand() error {
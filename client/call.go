@@ -9,10 +9,12 @@ import (
 
 func (c *Client) joinCall() error {
 	if err := c.SendWS(wsEventJoin, CallJoinMessage{
-		ChannelID:   c.cfg.ChannelID,
-		JobID:       c.cfg.JobID,
-		AV1Support:  c.cfg.EnableAV1,
-		DCSignaling: c.cfg.EnableDCSignaling,
+		ChannelID:    c.cfg.ChannelID,
+		JobID:        c.cfg.JobID,
+		AV1Support:   c.cfg.EnableAV1,
+		DCSignaling:  c.cfg.EnableDCSignaling,
+		VideoSupport: c.cfg.EnableVideo,
+		VideoCodecs:  c.cfg.VideoCodecs,
 	}, false); err != nil {
 		return fmt.Errorf("failed to send ws msg: %w", err)
 	}
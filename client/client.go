@@ -27,6 +27,7 @@ const (
 	RTCDisconnectEvent       EventType = "RTCDisconnect"
 	RTCTrackEvent            EventType = "RTCTrack"
 	RTCSenderRTCPPacketEvent EventType = "RTCSenderRTCPPacket"
+	RTCAudioSlotsEvent       EventType = "RTCAudioSlots"
 
 	CloseEvent EventType = "Close"
 	ErrorEvent EventType = "Error"
@@ -48,7 +49,7 @@ const (
 
 func (e EventType) IsValid() bool {
 	switch e {
-	case RTCConnectEvent, RTCDisconnectEvent, RTCTrackEvent, RTCSenderRTCPPacketEvent,
+	case RTCConnectEvent, RTCDisconnectEvent, RTCTrackEvent, RTCSenderRTCPPacketEvent, RTCAudioSlotsEvent,
 		CloseEvent,
 		ErrorEvent,
 		WSConnectEvent, WSDisconnectEvent,
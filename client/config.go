@@ -25,6 +25,13 @@ type Config struct {
 	// EnableAV1 controls whether the client should advertise support
 	// for receiving the AV1 codec.
 	EnableAV1 bool
+	// EnableVideo controls whether the client should advertise support
+	// for sending and receiving (camera) video tracks.
+	EnableVideo bool
+	// VideoCodecs is the optional list of video codecs (mime types) the
+	// client should advertise support for receiving. If empty, the server
+	// falls back to VP8 (and AV1 if EnableAV1 is set).
+	VideoCodecs []string
 	// EnableDCSignaling controls whether the client should use data channels
 	// for signaling of media tracks.
 	EnableDCSignaling bool
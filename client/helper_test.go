@@ -17,6 +17,7 @@ import (
 
 	"github.com/mattermost/mattermost/server/public/model"
 
+	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
@@ -168,6 +169,60 @@ func (th *TestHelper) transmitScreenTrack(c *Client) {
 	go th.screenTrackWriter(track, closeCh)
 }
 
+func (th *TestHelper) newVideoTrack(mimeType string) *webrtc.TrackLocalStaticRTP {
+	th.tb.Helper()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType:    mimeType,
+		ClockRate:   90000,
+		SDPFmtpLine: "",
+		RTCPFeedback: []webrtc.RTCPFeedback{
+			{Type: "goog-remb", Parameter: ""},
+			{Type: "ccm", Parameter: "fir"},
+			{Type: "nack", Parameter: ""},
+			{Type: "nack", Parameter: "pli"},
+		},
+	}, "video", "video_"+random.NewID())
+	require.NoError(th.tb, err)
+
+	return track
+}
+
+// transmitVideoTrack starts sending a (camera) video track from the given
+// client. The returned channel is closed on the first PLI request received for it.
+func (th *TestHelper) transmitVideoTrack(c *Client) (*webrtc.RTPSender, <-chan struct{}) {
+	th.tb.Helper()
+
+	track := th.newVideoTrack(webrtc.MimeTypeVP8)
+
+	sender, err := c.pc.AddTrack(track)
+	require.NoError(th.tb, err)
+
+	closeCh := make(chan struct{})
+	pliCh := make(chan struct{})
+	go func() {
+		var pliReceived bool
+		for {
+			pkts, _, rtcpErr := sender.ReadRTCP()
+			if rtcpErr != nil {
+				log.Printf("failed to read rtcp: %s", rtcpErr.Error())
+				close(closeCh)
+				return
+			}
+			for _, pkt := range pkts {
+				if _, ok := pkt.(*rtcp.PictureLossIndication); ok && !pliReceived {
+					pliReceived = true
+					close(pliCh)
+				}
+			}
+		}
+	}()
+
+	go th.screenTrackWriter(track, closeCh)
+
+	return sender, pliCh
+}
+
 func (th *TestHelper) newVoiceTrack() *webrtc.TrackLocalStaticSample {
 	th.tb.Helper()
 
@@ -290,7 +290,7 @@ func (c *Client) initRTCSession() error {
 			return
 		}
 
-		if trackType != TrackTypeVoice && trackType != TrackTypeScreen {
+		if trackType != TrackTypeVoice && trackType != TrackTypeScreen && trackType != TrackTypeVideo {
 			c.log.Debug("ignoring unsupported track type", slog.Any("trackType", trackType))
 			if err := receiver.Stop(); err != nil {
 				c.log.Error("failed to stop receiver", slog.String("err", err.Error()))
@@ -298,8 +298,8 @@ func (c *Client) initRTCSession() error {
 			return
 		}
 
-		if trackType == TrackTypeScreen {
-			c.log.Debug("sending PLI request for received screen track", slog.String("trackID", track.ID()), slog.Any("SSRC", track.SSRC()))
+		if trackType == TrackTypeScreen || trackType == TrackTypeVideo {
+			c.log.Debug("sending PLI request for received video track", slog.String("trackID", track.ID()), slog.Any("SSRC", track.SSRC()))
 			if err := pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(track.SSRC())}}); err != nil {
 				c.log.Error("failed to write RTCP packet", slog.String("err", err.Error()))
 			}
@@ -488,6 +488,8 @@ func (c *Client) initRTCSession() error {
 					c.log.Error("failed to answer", slog.String("err", err.Error()))
 				}
 			}
+		case dc.MessageTypeAudioSlots:
+			c.emit(RTCAudioSlotsEvent, payload)
 		default:
 			c.log.Error("unexpected dc message type", slog.Any("mt", mt))
 		}
@@ -537,4 +537,262 @@ func TestRTCTrack(t *testing.T) {
 			require.Fail(t, "timed out waiting for close event")
 		}
 	})
+
+	t.Run("video track", func(t *testing.T) {
+		th := setupTestHelper(t, "calls0")
+		th.adminClient.cfg.EnableVideo = true
+		th.userClient.cfg.EnableVideo = true
+
+		rtcConnectChA := make(chan struct{})
+		err := th.userClient.On(RTCConnectEvent, func(_ any) error {
+			close(rtcConnectChA)
+			return nil
+		})
+		require.NoError(t, err)
+
+		rtcConnectChB := make(chan struct{})
+		err = th.adminClient.On(RTCConnectEvent, func(_ any) error {
+			close(rtcConnectChB)
+			return nil
+		})
+		require.NoError(t, err)
+
+		closeChA := make(chan struct{})
+		err = th.userClient.On(CloseEvent, func(_ any) error {
+			close(closeChA)
+			return nil
+		})
+		require.NoError(t, err)
+
+		closeChB := make(chan struct{})
+		err = th.adminClient.On(CloseEvent, func(_ any) error {
+			close(closeChB)
+			return nil
+		})
+		require.NoError(t, err)
+
+		rtcTrackCh := make(chan struct{})
+		trackEndCh := make(chan struct{})
+		err = th.userClient.On(RTCTrackEvent, func(ctx any) error {
+			ctxMap, ok := ctx.(map[string]any)
+			require.True(t, ok)
+			track, ok := ctxMap["track"].(*webrtc.TrackRemote)
+			require.True(t, ok)
+			require.Equal(t, webrtc.MimeTypeVP8, track.Codec().MimeType)
+
+			trackType, sessionID, err := ParseTrackID(track.ID())
+			require.NoError(t, err)
+			require.Equal(t, TrackTypeVideo, trackType)
+			require.Equal(t, th.adminClient.originalConnID, sessionID)
+
+			close(rtcTrackCh)
+
+			go func() {
+				defer close(trackEndCh)
+				for {
+					if _, _, err := track.ReadRTP(); err != nil {
+						return
+					}
+				}
+			}()
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		go func() {
+			err := th.userClient.Connect()
+			require.NoError(t, err)
+		}()
+
+		go func() {
+			err := th.adminClient.Connect()
+			require.NoError(t, err)
+		}()
+
+		select {
+		case <-rtcConnectChA:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for rtc connect event")
+		}
+
+		select {
+		case <-rtcConnectChB:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for rtc connect event")
+		}
+
+		sender, pliCh := th.transmitVideoTrack(th.adminClient)
+
+		select {
+		case <-rtcTrackCh:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for rtc track event")
+		}
+
+		// The receiver requests a key frame as soon as it gets the track, which
+		// should be forwarded to the publisher.
+		select {
+		case <-pliCh:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for PLI request")
+		}
+
+		// Stopping the track should remove it from the receiver.
+		err = th.adminClient.pc.RemoveTrack(sender)
+		require.NoError(t, err)
+
+		select {
+		case <-trackEndCh:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for track to end")
+		}
+
+		go func() {
+			err := th.userClient.Close()
+			require.NoError(t, err)
+		}()
+
+		go func() {
+			err := th.adminClient.Close()
+			require.NoError(t, err)
+		}()
+
+		select {
+		case <-closeChA:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for close event")
+		}
+
+		select {
+		case <-closeChB:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for close event")
+		}
+	})
+
+	t.Run("video track after join", func(t *testing.T) {
+		th := setupTestHelper(t, "calls0")
+		th.adminClient.cfg.EnableVideo = true
+		th.userClient.cfg.EnableVideo = true
+
+		rtcConnectChA := make(chan struct{})
+		err := th.userClient.On(RTCConnectEvent, func(_ any) error {
+			close(rtcConnectChA)
+			return nil
+		})
+		require.NoError(t, err)
+
+		rtcConnectChB := make(chan struct{})
+		err = th.adminClient.On(RTCConnectEvent, func(_ any) error {
+			close(rtcConnectChB)
+			return nil
+		})
+		require.NoError(t, err)
+
+		closeChA := make(chan struct{})
+		err = th.userClient.On(CloseEvent, func(_ any) error {
+			close(closeChA)
+			return nil
+		})
+		require.NoError(t, err)
+
+		closeChB := make(chan struct{})
+		err = th.adminClient.On(CloseEvent, func(_ any) error {
+			close(closeChB)
+			return nil
+		})
+		require.NoError(t, err)
+
+		rtcTrackCh := make(chan struct{})
+		trackEndCh := make(chan struct{})
+		err = th.userClient.On(RTCTrackEvent, func(ctx any) error {
+			ctxMap, ok := ctx.(map[string]any)
+			require.True(t, ok)
+			track, ok := ctxMap["track"].(*webrtc.TrackRemote)
+			require.True(t, ok)
+
+			trackType, _, err := ParseTrackID(track.ID())
+			require.NoError(t, err)
+			require.Equal(t, TrackTypeVideo, trackType)
+
+			close(rtcTrackCh)
+
+			go func() {
+				defer close(trackEndCh)
+				for {
+					if _, _, err := track.ReadRTP(); err != nil {
+						return
+					}
+				}
+			}()
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = th.adminClient.Connect()
+		require.NoError(t, err)
+
+		select {
+		case <-rtcConnectChB:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for rtc connect event")
+		}
+
+		_, pliCh := th.transmitVideoTrack(th.adminClient)
+
+		// Give the track some time to reach the server before joining.
+		time.Sleep(time.Second)
+
+		err = th.userClient.Connect()
+		require.NoError(t, err)
+
+		select {
+		case <-rtcConnectChA:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for rtc connect event")
+		}
+
+		select {
+		case <-rtcTrackCh:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for rtc track event")
+		}
+
+		select {
+		case <-pliCh:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for PLI request")
+		}
+
+		// The publisher leaving should remove the track from the receiver.
+		go func() {
+			err := th.adminClient.Close()
+			require.NoError(t, err)
+		}()
+
+		select {
+		case <-closeChB:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for close event")
+		}
+
+		select {
+		case <-trackEndCh:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for track to end")
+		}
+
+		go func() {
+			err := th.userClient.Close()
+			require.NoError(t, err)
+		}()
+
+		select {
+		case <-closeChA:
+		case <-time.After(waitTimeout):
+			require.Fail(t, "timed out waiting for close event")
+		}
+	})
 }
@@ -6,10 +6,12 @@ package client
 const pluginID = "com.mattermost.calls"
 
 type CallJoinMessage struct {
-	ChannelID   string `json:"channelID"`
-	JobID       string `json:"jobID"`
-	AV1Support  bool   `json:"av1Support"`
-	DCSignaling bool   `json:"dcSignaling"`
+	ChannelID    string   `json:"channelID"`
+	JobID        string   `json:"jobID"`
+	AV1Support   bool     `json:"av1Support"`
+	DCSignaling  bool     `json:"dcSignaling"`
+	VideoSupport bool     `json:"videoSupport"`
+	VideoCodecs  []string `json:"videoCodecs,omitempty"`
 }
 
 type CallReconnectMessage struct {
@@ -43,4 +45,5 @@ func (cjs *CallJobState) FromMap(m map[string]any) {
 const (
 	TrackTypeVoice  = "voice"
 	TrackTypeScreen = "screen"
+	TrackTypeVideo  = "video"
 )
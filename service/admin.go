@@ -0,0 +1,189 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const adminPathPrefix = "/admin/"
+
+// adminAuthHandler authenticates the request, only allowing the admin client.
+func (s *Service) adminAuthHandler(w http.ResponseWriter, r *http.Request) (int, error) {
+	clientID, code, err := s.authHandler(w, r)
+	if err != nil {
+		return code, err
+	}
+
+	// An empty clientID means admin.
+	if clientID != "" {
+		return http.StatusForbidden, errors.New("admin access required")
+	}
+
+	return http.StatusOK, nil
+}
+
+// handleAdmin implements the admin endpoints, exposing the live state of the
+// RTC server:
+//
+//	GET /admin/groups lists the groups and their calls.
+//	GET /admin/calls lists the calls, optionally filtered by groupID.
+//	GET /admin/sessions lists the sessions, optionally filtered by groupID
+//	and callID.
+//	GET /admin/stats returns the sessions' WebRTC stats, optionally filtered
+//	by groupID, callID and sessionID.
+//
+// and acting on it:
+//
+//	DELETE /admin/calls/{groupID}/{callID} ends the call.
+//	DELETE /admin/sessions/{groupID}/{callID}/{sessionID} closes the session.
+//	DELETE /admin/screens/{groupID}/{callID}/{streamID} stops the screen share.
+func (s *Service) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getAdminInfo(w, r)
+	case http.MethodDelete:
+		s.handleAdminAction(w, r)
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodDelete}, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Service) getAdminInfo(w http.ResponseWriter, r *http.Request) {
+	data := &httpData{
+		reqData: map[string]string{},
+		resData: map[string]string{},
+	}
+
+	if code, err := s.adminAuthHandler(w, r); err != nil {
+		data.err = err.Error()
+		data.code = code
+		s.httpAudit("getAdminInfo", data, w, r)
+		return
+	}
+
+	groupID := r.URL.Query().Get("groupID")
+	callID := r.URL.Query().Get("callID")
+
+	var res any
+	switch r.URL.Path {
+	case adminPathPrefix + "groups":
+		res = s.rtcServer.GetGroupsInfo()
+	case adminPathPrefix + "calls":
+		res = s.rtcServer.GetCallsInfo(groupID)
+	case adminPathPrefix + "sessions":
+		res = s.rtcServer.GetSessionsInfo(groupID, callID)
+	case adminPathPrefix + "stats":
+		res = s.rtcServer.GetSessionsStats(groupID, callID, r.URL.Query().Get("sessionID"))
+	default:
+		data.err = "not found"
+		data.code = http.StatusNotFound
+		s.httpAudit("getAdminInfo", data, w, r)
+		return
+	}
+
+	data.code = http.StatusOK
+	s.httpAudit("getAdminInfo", data, nil, r)
+
+	w.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		s.log.Error("failed to encode data", mlog.Err(err))
+	}
+}
+
+func (s *Service) handleAdminAction(w http.ResponseWriter, r *http.Request) {
+	data := &httpData{
+		reqData: map[string]string{},
+		resData: map[string]string{},
+	}
+	defer s.httpAudit("handleAdminAction", data, w, r)
+
+	if code, err := s.adminAuthHandler(w, r); err != nil {
+		data.err = err.Error()
+		data.code = code
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, adminPathPrefix), "/")
+	for _, part := range parts {
+		if part == "" {
+			data.err = "invalid path"
+			data.code = http.StatusBadRequest
+			return
+		}
+	}
+
+	var err error
+	switch {
+	case len(parts) == 3 && parts[0] == "calls":
+		err = s.rtcServer.EndCall(parts[1], parts[2])
+		if err == nil {
+			s.log.Info("admin: call ended", mlog.String("groupID", parts[1]), mlog.String("callID", parts[2]))
+		}
+	case len(parts) == 4 && parts[0] == "sessions":
+		err = s.rtcServer.CloseCallSession(parts[1], parts[2], parts[3])
+		if err == nil {
+			s.log.Info("admin: session closed", mlog.String("groupID", parts[1]), mlog.String("callID", parts[2]),
+				mlog.String("sessionID", parts[3]))
+		}
+	case len(parts) == 4 && parts[0] == "screens":
+		err = s.stopScreenShare(parts[1], parts[2], parts[3])
+		if err == nil {
+			s.log.Info("admin: screen share stopped", mlog.String("groupID", parts[1]), mlog.String("callID", parts[2]),
+				mlog.String("screenStreamID", parts[3]))
+		}
+	default:
+		data.err = "not found"
+		data.code = http.StatusNotFound
+		return
+	}
+
+	if err != nil {
+		data.err = err.Error()
+		data.code = http.StatusNotFound
+		return
+	}
+
+	data.code = http.StatusOK
+}
+
+// stopScreenShare stops the given screen sharing stream, notifying the
+// connection owning the publishing session through a screen_off message so
+// that it can update its state. Sessions closed by the other actions are
+// notified through their close callback.
+func (s *Service) stopScreenShare(groupID, callID, streamID string) error {
+	sessionID, err := s.rtcServer.StopScreenShare(groupID, callID, streamID)
+	if err != nil {
+		return err
+	}
+
+	s.mut.RLock()
+	connID := s.connMap[sessionID]
+	s.mut.RUnlock()
+	if connID == "" {
+		// WHIP sessions have no connection to notify.
+		return nil
+	}
+
+	data, err := NewPackedClientMessage(ClientMessageScreenOff, map[string]string{
+		"sessionID":      sessionID,
+		"screenStreamID": streamID,
+	})
+	if err != nil {
+		s.log.Error("failed to pack screen_off message", mlog.Err(err), mlog.String("sessionID", sessionID))
+		return nil
+	}
+
+	if err := s.sendClientMessage(connID, groupID, data); err != nil {
+		s.log.Error("failed to send screen_off message", mlog.Err(err), mlog.String("sessionID", sessionID))
+	}
+
+	return nil
+}
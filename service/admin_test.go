@@ -0,0 +1,274 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/rtcd/service/auth"
+	"github.com/mattermost/rtcd/service/random"
+	"github.com/mattermost/rtcd/service/rtc"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleAdmin(t *testing.T) {
+	th := SetupTestHelper(t, nil)
+	defer th.Teardown()
+
+	err := th.adminClient.Register("clientA", "Ey4-H_BJA00_TVByPi8DozE12ekN3S7L")
+	require.NoError(t, err)
+
+	doRequest := func(t *testing.T, method, path, clientID, authKey string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, th.apiURL+path, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(clientID, authKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	adminKey := th.srvc.cfg.API.Security.AdminSecretKey
+
+	t.Run("invalid method", func(t *testing.T) {
+		resp := doRequest(t, http.MethodPost, "/admin/sessions", "", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+		require.Equal(t, "GET, DELETE", resp.Header.Get("Allow"))
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/sessions", "", "invalid")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("non admin client", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/sessions", "clientA", "Ey4-H_BJA00_TVByPi8DozE12ekN3S7L")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/unknown", "", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("groups", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/groups", "", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var groups []rtc.GroupInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&groups))
+		require.Empty(t, groups)
+	})
+
+	t.Run("calls", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/calls?groupID=clientA", "", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var calls []rtc.CallInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&calls))
+		require.Empty(t, calls)
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/sessions?groupID=clientA&callID=callID", "", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var sessions []rtc.SessionInfo
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&sessions))
+		require.Empty(t, sessions)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		resp := doRequest(t, http.MethodGet, "/admin/stats?groupID=clientA&sessionID=sessionID", "", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var stats []rtc.SessionStats
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		require.Empty(t, stats)
+	})
+}
+
+func TestHandleAdminDisabled(t *testing.T) {
+	cfg := MakeDefaultCfg(t)
+	cfg.API.Security.EnableAdmin = false
+	th := SetupTestHelper(t, cfg)
+	defer th.Teardown()
+
+	resp, err := http.Get(th.apiURL + "/admin/sessions")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestHandleAdminActions(t *testing.T) {
+	th := SetupTestHelper(t, nil)
+	defer th.Teardown()
+
+	clientID := "clientA"
+	authKey, err := random.NewSecureString(auth.MinKeyLen)
+	require.NoError(t, err)
+	require.NoError(t, th.adminClient.Register(clientID, authKey))
+
+	c, err := NewClient(ClientConfig{
+		URL:      th.apiURL,
+		ClientID: clientID,
+		AuthKey:  authKey,
+	})
+	require.NoError(t, err)
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	msg, ok := <-c.ReceiveCh()
+	require.True(t, ok)
+	require.Equal(t, ClientMessageHello, msg.Type)
+
+	join := func(t *testing.T, callID, sessionID string) {
+		t.Helper()
+		require.NoError(t, c.Send(ClientMessage{Type: ClientMessageJoin, Data: map[string]any{
+			"callID":    callID,
+			"userID":    "userID",
+			"sessionID": sessionID,
+		}}))
+		require.Eventually(t, func() bool {
+			return len(th.srvc.rtcServer.GetSessionsInfo(clientID, callID)) > 0
+		}, 5*time.Second, 50*time.Millisecond)
+	}
+
+	waitForClose := func(t *testing.T, sessionID string) {
+		t.Helper()
+		select {
+		case msg := <-c.ReceiveCh():
+			require.Equal(t, ClientMessageClose, msg.Type)
+			require.Equal(t, map[string]string{"sessionID": sessionID}, msg.Data)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for close message")
+		}
+	}
+
+	doRequest := func(t *testing.T, path, authKey string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodDelete, th.apiURL+path, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(clientID, authKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	adminKey := th.srvc.cfg.API.Security.AdminSecretKey
+
+	t.Run("non admin client", func(t *testing.T) {
+		resp := doRequest(t, "/admin/calls/clientA/callID", authKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("invalid path", func(t *testing.T) {
+		resp := doRequest(t, "/admin/sessions/clientA/callID/", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = doRequest(t, "/admin/groups/clientA", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp := doRequest(t, "/admin/calls/clientA/callID", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = doRequest(t, "/admin/sessions/clientA/callID/sessionID", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = doRequest(t, "/admin/screens/clientA/callID/streamID", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("close session", func(t *testing.T) {
+		join(t, "callID", "sessionA")
+
+		// The session needs to belong to the given call.
+		resp := doRequest(t, "/admin/sessions/clientA/otherCallID/sessionA", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = doRequest(t, "/admin/sessions/clientA/callID/sessionA", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		waitForClose(t, "sessionA")
+		require.Empty(t, th.srvc.rtcServer.GetSessionsInfo(clientID, ""))
+	})
+
+	t.Run("stop screen share", func(t *testing.T) {
+		join(t, "callID", "sessionA")
+
+		data, err := json.Marshal(map[string]string{"screenStreamID": "screenA"})
+		require.NoError(t, err)
+		require.NoError(t, c.Send(ClientMessage{Type: ClientMessageRTC, Data: rtc.Message{
+			GroupID:   clientID,
+			CallID:    "callID",
+			UserID:    "userID",
+			SessionID: "sessionA",
+			Type:      rtc.ScreenOnMessage,
+			Data:      data,
+		}}))
+
+		require.Eventually(t, func() bool {
+			resp := doRequest(t, "/admin/screens/clientA/callID/screenA", adminKey)
+			defer resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}, 5*time.Second, 50*time.Millisecond)
+
+		select {
+		case msg := <-c.ReceiveCh():
+			require.Equal(t, ClientMessageScreenOff, msg.Type)
+			require.Equal(t, map[string]string{"sessionID": "sessionA", "screenStreamID": "screenA"}, msg.Data)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for screen_off message")
+		}
+
+		// The session carries on.
+		require.Len(t, th.srvc.rtcServer.GetSessionsInfo(clientID, "callID"), 1)
+
+		resp := doRequest(t, "/admin/sessions/clientA/callID/sessionA", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		waitForClose(t, "sessionA")
+	})
+
+	t.Run("end call", func(t *testing.T) {
+		join(t, "callID", "sessionA")
+		join(t, "callID", "sessionB")
+
+		resp := doRequest(t, "/admin/calls/clientA/callID", adminKey)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		closed := map[string]bool{}
+		for len(closed) < 2 {
+			select {
+			case msg := <-c.ReceiveCh():
+				require.Equal(t, ClientMessageClose, msg.Type)
+				data, ok := msg.Data.(map[string]string)
+				require.True(t, ok)
+				closed[data["sessionID"]] = true
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "timed out waiting for close messages")
+			}
+		}
+		require.Equal(t, map[string]bool{"sessionA": true, "sessionB": true}, closed)
+		require.Empty(t, th.srvc.rtcServer.GetCallsInfo(clientID))
+	})
+}
@@ -10,6 +10,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/mattermost/rtcd/service/auth"
+
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
 )
 
@@ -65,6 +67,10 @@ func (s *Service) bearerAuthHandler(_ http.ResponseWriter, r *http.Request) (str
 		return "", http.StatusUnauthorized, errors.New("authentication failed: invalid auth header")
 	}
 
+	if s.jwtVerifier != nil && auth.IsJWT(bearerToken) {
+		return s.jwtAuthHandler(r, bearerToken)
+	}
+
 	session, err := s.sessionCache.Get(bearerToken)
 	if err != nil {
 		return "", http.StatusUnauthorized, fmt.Errorf("authentication failed: %w", err)
@@ -77,6 +83,40 @@ func (s *Service) bearerAuthHandler(_ http.ResponseWriter, r *http.Request) (str
 	return session.ClientID, http.StatusOK, nil
 }
 
+// jwtAuthHandler authenticates the request through the given JWT. Tokens
+// restricted to a set of scopes are only allowed on the matching endpoints.
+func (s *Service) jwtAuthHandler(r *http.Request, token string) (string, int, error) {
+	claims, err := s.jwtVerifier.Verify(token)
+	if err != nil {
+		s.log.Error("authentication failed", mlog.Err(err))
+		return "", http.StatusUnauthorized, errors.New("authentication failed")
+	}
+
+	if !claims.HasScope(getRequiredScope(r)) {
+		return "", http.StatusForbidden, errors.New("authentication failed: missing scope")
+	}
+
+	return claims.ClientID, http.StatusOK, nil
+}
+
+// getRequiredScope returns the JWT scope needed to access the requested
+// endpoint. Endpoints not mapped to a scope can only be accessed through
+// unrestricted tokens.
+func getRequiredScope(r *http.Request) string {
+	switch {
+	case r.URL.Path == "/ws":
+		return auth.ScopeWS
+	case strings.HasPrefix(r.URL.Path, whipPathPrefix):
+		return auth.ScopeWHIP
+	case strings.HasPrefix(r.URL.Path, whepPathPrefix):
+		return auth.ScopeWHEP
+	case r.URL.Path == "/register", r.URL.Path == "/unregister":
+		return auth.ScopeClients
+	default:
+		return ""
+	}
+}
+
 func parseBearerAuth(auth string) (token string, ok bool) {
 	if len(auth) < len(bearerPrefix) || !strings.EqualFold(auth[:len(bearerPrefix)], bearerPrefix) {
 		return
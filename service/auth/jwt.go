@@ -0,0 +1,300 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Scopes a JWT can be restricted to. Tokens carrying no scope are allowed
+// everything a registered client is.
+const (
+	ScopeWS      = "ws"
+	ScopeWHIP    = "whip"
+	ScopeWHEP    = "whep"
+	ScopeClients = "clients"
+)
+
+// jwtLeeway accounts for clock skew between the token issuer and rtcd.
+const jwtLeeway = time.Minute
+
+type JWTConfig struct {
+	// Whether or not to accept signed JWTs as bearer tokens.
+	Enable bool `toml:"enable"`
+	// The key used to verify HMAC (HS256, HS384, HS512) signed tokens.
+	SigningKey string `toml:"signing_key"`
+	// The path to a JWKS file holding the public keys used to verify RSA
+	// (RS*, PS*) and EC (ES*) signed tokens.
+	JWKSFile string `toml:"jwks_file"`
+	// The expected issuer (iss) of the tokens, if set.
+	Issuer string `toml:"issuer"`
+	// The expected audience (aud) of the tokens, if set.
+	Audience string `toml:"audience"`
+}
+
+func (c JWTConfig) IsValid() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if c.SigningKey == "" && c.JWKSFile == "" {
+		return errors.New("invalid JWT config: either SigningKey or JWKSFile should be set")
+	}
+
+	if c.SigningKey != "" && len(c.SigningKey) < MinKeyLen {
+		return fmt.Errorf("invalid SigningKey value: should be at least %d characters long", MinKeyLen)
+	}
+
+	return nil
+}
+
+// JWTClaims are the claims expected in the tokens. Expiration (exp) is
+// required.
+type JWTClaims struct {
+	ClientID string `json:"clientID"`
+	// Scope is a space separated list of scopes the token is restricted to.
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// HasScope returns whether the token allows the given scope.
+func (c *JWTClaims) HasScope(scope string) bool {
+	if c.Scope == "" {
+		return true
+	}
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// JWTVerifier validates JWTs against the configured keys. Since tokens are
+// self-contained, they can be verified by any instance and across restarts.
+type JWTVerifier struct {
+	signingKey []byte
+	// publicKeys maps key IDs (kid) to the keys loaded from the JWKS file.
+	publicKeys map[string]crypto.PublicKey
+	parser     *jwt.Parser
+}
+
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	if err := cfg.IsValid(); err != nil {
+		return nil, err
+	}
+
+	v := &JWTVerifier{}
+
+	var methods []string
+	if cfg.SigningKey != "" {
+		v.signingKey = []byte(cfg.SigningKey)
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+
+	if cfg.JWKSFile != "" {
+		var err error
+		v.publicKeys, err = loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWKS: %w", err)
+		}
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+
+	return v, nil
+}
+
+func (v *JWTVerifier) getKey(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return v.signingKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" && len(v.publicKeys) == 1 {
+		for _, key := range v.publicKeys {
+			return key, nil
+		}
+	}
+
+	key, ok := v.publicKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("key not found: %q", kid)
+	}
+
+	return key, nil
+}
+
+// Verify parses the given token, checking its signature and claims.
+func (v *JWTVerifier) Verify(tokenString string) (*JWTClaims, error) {
+	var claims JWTClaims
+	if _, err := v.parser.ParseWithClaims(tokenString, &claims, v.getKey); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if claims.ClientID == "" {
+		return nil, errors.New("invalid token: missing clientID")
+	}
+
+	return &claims, nil
+}
+
+// IsJWT returns whether the given bearer token looks like a JWT rather than
+// a session token.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// loadJWKS reads the public keys from the JWKS file at the given path. Keys
+// not meant for signatures are ignored.
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal file: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		if _, ok := keys[jwk.Kid]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", jwk.Kid)
+		}
+
+		var key crypto.PublicKey
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaPublicKey()
+		case "EC":
+			key, err = jwk.ecPublicKey()
+		default:
+			err = fmt.Errorf("unsupported key type %q", jwk.Kty)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found")
+	}
+
+	return keys, nil
+}
+
+func decodeBase64URLInt(val string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(val)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBase64URLInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode modulus: %w", err)
+	}
+	e, err := decodeBase64URLInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode exponent: %w", err)
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid exponent")
+	}
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jsonWebKey) ecPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	var ecdhCurve ecdh.Curve
+	switch k.Crv {
+	case "P-256":
+		curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+
+	x, err := decodeBase64URLInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode x: %w", err)
+	}
+	y, err := decodeBase64URLInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode y: %w", err)
+	}
+
+	// The point is validated by parsing its uncompressed encoding.
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x.Bytes()) > size || len(y.Bytes()) > size {
+		return nil, errors.New("invalid point")
+	}
+	point := make([]byte, 1+2*size)
+	point[0] = 4
+	x.FillBytes(point[1 : 1+size])
+	y.FillBytes(point[1+size:])
+	if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid point: %w", err)
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
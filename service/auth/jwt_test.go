@@ -0,0 +1,290 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+const testSigningKey = "Ey4-H_BJA00_TVByPi8DozE12ekN3S7L"
+
+func writeJWKS(t *testing.T, keys ...map[string]string) string {
+	t.Helper()
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func encodeInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   encodeInt(key.N),
+		"e":   encodeInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": key.Curve.Params().Name,
+		"x":   encodeInt(key.X),
+		"y":   encodeInt(key.Y),
+	}
+}
+
+func newClaims(clientID, scope string, expiresAt time.Time) JWTClaims {
+	return JWTClaims{
+		ClientID: clientID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.Claims, key any) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	str, err := token.SignedString(key)
+	require.NoError(t, err)
+	return str
+}
+
+func TestJWTConfigIsValid(t *testing.T) {
+	require.NoError(t, JWTConfig{}.IsValid())
+	require.EqualError(t, JWTConfig{Enable: true}.IsValid(),
+		"invalid JWT config: either SigningKey or JWKSFile should be set")
+	require.EqualError(t, JWTConfig{Enable: true, SigningKey: "short"}.IsValid(),
+		"invalid SigningKey value: should be at least 32 characters long")
+	require.NoError(t, JWTConfig{Enable: true, SigningKey: testSigningKey}.IsValid())
+	require.NoError(t, JWTConfig{Enable: true, JWKSFile: "jwks.json"}.IsValid())
+}
+
+func TestJWTClaimsHasScope(t *testing.T) {
+	claims := JWTClaims{}
+	require.True(t, claims.HasScope(ScopeWS))
+	require.True(t, claims.HasScope(""))
+
+	claims.Scope = "ws whip"
+	require.True(t, claims.HasScope(ScopeWS))
+	require.True(t, claims.HasScope(ScopeWHIP))
+	require.False(t, claims.HasScope(ScopeWHEP))
+	require.False(t, claims.HasScope(""))
+}
+
+func TestIsJWT(t *testing.T) {
+	token, err := newRandomToken()
+	require.NoError(t, err)
+	require.False(t, IsJWT(token))
+	require.True(t, IsJWT(signToken(t, jwt.SigningMethodHS256, "", newClaims("clientA", "", time.Now().Add(time.Hour)), []byte(testSigningKey))))
+}
+
+func TestJWTVerifierHMAC(t *testing.T) {
+	v, err := NewJWTVerifier(JWTConfig{
+		Enable:     true,
+		SigningKey: testSigningKey,
+		Issuer:     "issuer",
+		Audience:   "rtcd",
+	})
+	require.NoError(t, err)
+
+	makeClaims := func() JWTClaims {
+		claims := newClaims("clientA", "ws", time.Now().Add(time.Hour))
+		claims.Issuer = "issuer"
+		claims.Audience = jwt.ClaimStrings{"rtcd"}
+		return claims
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		claims, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", makeClaims(), []byte(testSigningKey)))
+		require.NoError(t, err)
+		require.Equal(t, "clientA", claims.ClientID)
+		require.Equal(t, "ws", claims.Scope)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", makeClaims(), []byte("Ey4-H_BJA00_TVByPi8DozE12ekN3S7M")))
+		require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		claims := makeClaims()
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", claims, []byte(testSigningKey)))
+		require.ErrorIs(t, err, jwt.ErrTokenExpired)
+	})
+
+	t.Run("missing expiration", func(t *testing.T) {
+		claims := makeClaims()
+		claims.ExpiresAt = nil
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", claims, []byte(testSigningKey)))
+		require.ErrorIs(t, err, jwt.ErrTokenRequiredClaimMissing)
+	})
+
+	t.Run("invalid issuer", func(t *testing.T) {
+		claims := makeClaims()
+		claims.Issuer = "other"
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", claims, []byte(testSigningKey)))
+		require.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+	})
+
+	t.Run("invalid audience", func(t *testing.T) {
+		claims := makeClaims()
+		claims.Audience = jwt.ClaimStrings{"other"}
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", claims, []byte(testSigningKey)))
+		require.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	})
+
+	t.Run("missing clientID", func(t *testing.T) {
+		claims := makeClaims()
+		claims.ClientID = ""
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", claims, []byte(testSigningKey)))
+		require.EqualError(t, err, "invalid token: missing clientID")
+	})
+
+	t.Run("unsigned", func(t *testing.T) {
+		_, err := v.Verify(signToken(t, jwt.SigningMethodNone, "", makeClaims(), jwt.UnsafeAllowNoneSignatureType))
+		require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+	})
+
+	t.Run("RSA not configured", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "", makeClaims(), key))
+		require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+	})
+}
+
+func TestJWTVerifierJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	v, err := NewJWTVerifier(JWTConfig{
+		Enable:   true,
+		JWKSFile: writeJWKS(t, rsaJWK("rsaKey", &rsaKey.PublicKey), ecJWK("ecKey", &ecKey.PublicKey)),
+	})
+	require.NoError(t, err)
+
+	claims := newClaims("clientA", "", time.Now().Add(time.Hour))
+
+	t.Run("RSA", func(t *testing.T) {
+		verified, err := v.Verify(signToken(t, jwt.SigningMethodRS256, "rsaKey", claims, rsaKey))
+		require.NoError(t, err)
+		require.Equal(t, "clientA", verified.ClientID)
+
+		verified, err = v.Verify(signToken(t, jwt.SigningMethodPS384, "rsaKey", claims, rsaKey))
+		require.NoError(t, err)
+		require.Equal(t, "clientA", verified.ClientID)
+	})
+
+	t.Run("EC", func(t *testing.T) {
+		verified, err := v.Verify(signToken(t, jwt.SigningMethodES256, "ecKey", claims, ecKey))
+		require.NoError(t, err)
+		require.Equal(t, "clientA", verified.ClientID)
+	})
+
+	t.Run("key mismatch", func(t *testing.T) {
+		_, err := v.Verify(signToken(t, jwt.SigningMethodES256, "rsaKey", claims, ecKey))
+		require.Error(t, err)
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		_, err := v.Verify(signToken(t, jwt.SigningMethodRS256, "unknown", claims, rsaKey))
+		require.ErrorIs(t, err, jwt.ErrTokenUnverifiable)
+
+		// The key ID can only be omitted if there's a single key.
+		_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "", claims, rsaKey))
+		require.ErrorIs(t, err, jwt.ErrTokenUnverifiable)
+	})
+
+	t.Run("HMAC not configured", func(t *testing.T) {
+		_, err := v.Verify(signToken(t, jwt.SigningMethodHS256, "", claims, []byte(testSigningKey)))
+		require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+	})
+
+	t.Run("single key", func(t *testing.T) {
+		v, err := NewJWTVerifier(JWTConfig{
+			Enable:   true,
+			JWKSFile: writeJWKS(t, ecJWK("ecKey", &ecKey.PublicKey)),
+		})
+		require.NoError(t, err)
+		verified, err := v.Verify(signToken(t, jwt.SigningMethodES256, "", claims, ecKey))
+		require.NoError(t, err)
+		require.Equal(t, "clientA", verified.ClientID)
+	})
+}
+
+func TestLoadJWKS(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := loadJWKS(filepath.Join(t.TempDir(), "missing.json"))
+		require.Error(t, err)
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		_, err := loadJWKS(writeJWKS(t))
+		require.EqualError(t, err, "no signing keys found")
+	})
+
+	t.Run("encryption keys are ignored", func(t *testing.T) {
+		jwk := ecJWK("ecKey", &ecKey.PublicKey)
+		jwk["use"] = "enc"
+		_, err := loadJWKS(writeJWKS(t, jwk))
+		require.EqualError(t, err, "no signing keys found")
+	})
+
+	t.Run("unsupported key type", func(t *testing.T) {
+		_, err := loadJWKS(writeJWKS(t, map[string]string{"kty": "oct", "kid": "key"}))
+		require.EqualError(t, err, `invalid key "key": unsupported key type "oct"`)
+	})
+
+	t.Run("duplicate key ID", func(t *testing.T) {
+		jwk := ecJWK("ecKey", &ecKey.PublicKey)
+		_, err := loadJWKS(writeJWKS(t, jwk, jwk))
+		require.EqualError(t, err, `duplicate key ID "ecKey"`)
+	})
+
+	t.Run("invalid point", func(t *testing.T) {
+		jwk := ecJWK("ecKey", &ecKey.PublicKey)
+		jwk["y"] = encodeInt(new(big.Int).Add(ecKey.Y, big.NewInt(1)))
+		_, err := loadJWKS(writeJWKS(t, jwk))
+		require.ErrorContains(t, err, "invalid point")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		keys, err := loadJWKS(writeJWKS(t, ecJWK("ecKey", &ecKey.PublicKey)))
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		require.True(t, ecKey.PublicKey.Equal(keys["ecKey"]))
+	})
+}
@@ -60,6 +60,10 @@ func (s *Service) Authenticate(id, authToken string) error {
 }
 
 func (s *Service) Register(id, key string) error {
+	if store.IsReservedKey(id) {
+		return errors.New("registration failed: invalid client id")
+	}
+
 	if len(key) < MinKeyLen {
 		return errors.New("registration failed: key not long enough")
 	}
@@ -91,17 +95,22 @@ func (s *Service) Register(id, key string) error {
 }
 
 func (s *Service) Unregister(id string) error {
+	if store.IsReservedKey(id) {
+		return errors.New("unregister failed: invalid client id")
+	}
+
 	if _, err := s.store.Get(id); err != nil {
 		return fmt.Errorf("unregister failed: %w", err)
 	}
 
-	err := s.store.Delete(id)
-	if err != nil {
+	// Revoke the client's token first so that it can't outlive the client.
+	if err := s.sessionCache.Delete(id); err != nil {
 		return fmt.Errorf("unregister failed: %w", err)
 	}
 
-	// Invalidate token when unregistering
-	s.sessionCache.Delete(id)
+	if err := s.store.Delete(id); err != nil {
+		return fmt.Errorf("unregister failed: %w", err)
+	}
 
 	return nil
 }
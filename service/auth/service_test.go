@@ -9,9 +9,20 @@ import (
 
 	"github.com/mattermost/rtcd/service/store"
 
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
 	"github.com/stretchr/testify/require"
 )
 
+func newTestLogger(t *testing.T) mlog.LoggerIFace {
+	t.Helper()
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, log.Shutdown())
+	})
+	return log
+}
+
 func newTestDBStore(t *testing.T) (store.Store, func()) {
 	t.Helper()
 	dbDir, err := os.MkdirTemp("", "db")
@@ -73,6 +84,19 @@ func TestRegister(t *testing.T) {
 
 	authKey, err := newRandomString(MinKeyLen)
 	require.NoError(t, err)
+
+	err = s.Register(store.WebhookKeyPrefix+"instanceA", authKey)
+	require.Error(t, err)
+	require.EqualError(t, err, "registration failed: invalid client id")
+
+	err = s.Register(store.SessionKeyPrefix+"instanceA", authKey)
+	require.Error(t, err)
+	require.EqualError(t, err, "registration failed: invalid client id")
+
+	err = s.Unregister(store.WebhookKeyPrefix + "instanceA")
+	require.Error(t, err)
+	require.EqualError(t, err, "unregister failed: invalid client id")
+
 	err = s.Register("instanceA", authKey)
 	require.NoError(t, err)
 
@@ -123,3 +147,29 @@ func TestAuthenticate(t *testing.T) {
 	require.Error(t, err)
 	require.EqualError(t, err, "authentication failed: error: not found")
 }
+
+func TestUnregisterRevokesToken(t *testing.T) {
+	dbStore, teardown := newTestDBStore(t)
+	defer teardown()
+	sessionCache := newTestSessionCache(t)
+
+	s, err := NewService(dbStore, sessionCache)
+	require.NoError(t, err)
+
+	authKey, err := newRandomString(MinKeyLen)
+	require.NoError(t, err)
+	err = s.Register("instanceA", authKey)
+	require.NoError(t, err)
+
+	token, err := s.Login("instanceA", authKey)
+	require.NoError(t, err)
+	session, err := sessionCache.Get(token)
+	require.NoError(t, err)
+	require.Equal(t, "instanceA", session.ClientID)
+
+	err = s.Unregister("instanceA")
+	require.NoError(t, err)
+
+	_, err = sessionCache.Get(token)
+	require.EqualError(t, err, "token is invalid")
+}
@@ -4,9 +4,22 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
 	"time"
+
+	"github.com/mattermost/rtcd/service/store"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const (
+	sessionKeyPrefix     = store.SessionKeyPrefix
+	sessionSweepInterval = time.Minute
 )
 
 type CachedSession struct {
@@ -16,6 +29,9 @@ type CachedSession struct {
 
 type SessionCacheConfig struct {
 	ExpirationMinutes int `toml:"expiration_minutes"`
+	// Whether or not to persist sessions in the data store so that tokens
+	// survive restarts.
+	Persist bool `toml:"persist"`
 }
 
 func (c SessionCacheConfig) IsValid() error {
@@ -25,30 +41,143 @@ func (c SessionCacheConfig) IsValid() error {
 	return nil
 }
 
+type SessionCacheOption func(c *SessionCache) error
+
+// WithSessionStore lets the caller set a store the sessions are written
+// through to. Sessions found in the store are loaded on creation.
+func WithSessionStore(store store.Store, log mlog.LoggerIFace) SessionCacheOption {
+	return func(c *SessionCache) error {
+		if store == nil {
+			return errors.New("store should not be nil")
+		}
+		if log == nil {
+			return errors.New("log should not be nil")
+		}
+		c.store = store
+		c.log = log
+		return nil
+	}
+}
+
 type SessionCache struct {
-	cfg        SessionCacheConfig
+	cfg SessionCacheConfig
+	// sessionMap maps the hashes of the tokens to their sessions. Tokens are
+	// not kept in clear so that they can't be recovered from the store.
 	sessionMap map[string]CachedSession
+	store      store.Store
+	log        mlog.LoggerIFace
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
 
 	mut sync.RWMutex
 }
 
-func NewSessionCache(cfg SessionCacheConfig) (*SessionCache, error) {
+func NewSessionCache(cfg SessionCacheConfig, opts ...SessionCacheOption) (*SessionCache, error) {
 	if err := cfg.IsValid(); err != nil {
 		return nil, err
 	}
-	return &SessionCache{cfg: cfg, sessionMap: make(map[string]CachedSession)}, nil
+
+	t := &SessionCache{
+		cfg:        cfg,
+		sessionMap: make(map[string]CachedSession),
+		stopCh:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.store != nil {
+		if err := t.load(); err != nil {
+			return nil, fmt.Errorf("failed to load sessions: %w", err)
+		}
+	}
+
+	t.wg.Add(1)
+	go t.sweeper()
+
+	return t, nil
+}
+
+// Close stops the background sweeping of expired sessions.
+func (t *SessionCache) Close() {
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+// hashToken returns the encoded SHA-256 hash of the given token. Base64 is used
+// to keep store keys within size limits.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (t *SessionCache) load() error {
+	now := time.Now()
+	return t.store.Scan(sessionKeyPrefix, func(key, value string) error {
+		var session CachedSession
+		if err := json.Unmarshal([]byte(value), &session); err != nil {
+			// The key was not necessarily written by us so it's left untouched.
+			t.log.Warn("failed to unmarshal session, skipping", mlog.Err(err), mlog.String("key", key))
+			return nil
+		}
+
+		if now.After(session.ExpirationDate) {
+			return t.store.Delete(key)
+		}
+
+		t.sessionMap[key[len(sessionKeyPrefix):]] = session
+		return nil
+	})
+}
+
+func (t *SessionCache) sweeper() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.sweep()
+		case <-t.stopCh:
+			return
+		}
+	}
+}
+
+// sweep removes the expired sessions. Sessions failing to be deleted from
+// the store are kept so that it gets retried on the next run.
+func (t *SessionCache) sweep() {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	now := time.Now()
+	for hash, session := range t.sessionMap {
+		if now.After(session.ExpirationDate) {
+			if err := t.deleteSession(hash); err != nil {
+				t.log.Error("failed to sweep session", mlog.Err(err), mlog.String("clientID", session.ClientID))
+			}
+		}
+	}
 }
 
 func (t *SessionCache) Get(token string) (CachedSession, error) {
+	hash := hashToken(token)
+
 	t.mut.RLock()
-	session, ok := t.sessionMap[token]
+	session, ok := t.sessionMap[hash]
 	t.mut.RUnlock()
 	if !ok {
 		return CachedSession{}, errors.New("token is invalid")
 	}
 	if time.Now().After(session.ExpirationDate) {
 		t.mut.Lock()
-		delete(t.sessionMap, token)
+		// Any error is ignored as the sweeper will try again.
+		_ = t.deleteSession(hash)
 		t.mut.Unlock()
 		return CachedSession{}, errors.New("session is expired")
 	}
@@ -63,34 +192,66 @@ func (t *SessionCache) Put(clientID, token string) error {
 		return errors.New("can not cache: invalid token")
 	}
 
+	hash := hashToken(token)
+
 	t.mut.Lock()
 	defer t.mut.Unlock()
 
-	_, ok := t.sessionMap[token]
+	_, ok := t.sessionMap[hash]
 	if ok {
 		return errors.New("can not cache: token in use")
 	}
 
 	// Make sure there is only one bearer token per client.
-	t.delete(clientID)
-	t.sessionMap[token] = CachedSession{
+	if err := t.delete(clientID); err != nil {
+		return fmt.Errorf("can not cache: %w", err)
+	}
+
+	session := CachedSession{
 		ClientID:       clientID,
 		ExpirationDate: time.Now().Add(time.Duration(t.cfg.ExpirationMinutes) * time.Minute),
 	}
+
+	if t.store != nil {
+		data, err := json.Marshal(session)
+		if err != nil {
+			return fmt.Errorf("can not cache: %w", err)
+		}
+		if err := t.store.Set(sessionKeyPrefix+hash, string(data)); err != nil {
+			return fmt.Errorf("can not cache: %w", err)
+		}
+	}
+
+	t.sessionMap[hash] = session
 	return nil
 }
 
-func (t *SessionCache) Delete(clientID string) {
+// Delete revokes the token of the given client, if any.
+func (t *SessionCache) Delete(clientID string) error {
 	t.mut.Lock()
-	t.delete(clientID)
-	t.mut.Unlock()
+	defer t.mut.Unlock()
+	return t.delete(clientID)
 }
 
-func (t *SessionCache) delete(clientID string) {
-	for token, session := range t.sessionMap {
+func (t *SessionCache) delete(clientID string) error {
+	for hash, session := range t.sessionMap {
 		if session.ClientID == clientID {
-			delete(t.sessionMap, token)
-			return
+			if err := t.deleteSession(hash); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
+}
+
+// deleteSession removes the session with the given token hash.
+// NOTE: this is expected to be called under lock.
+func (t *SessionCache) deleteSession(hash string) error {
+	if t.store != nil {
+		if err := t.store.Delete(sessionKeyPrefix + hash); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+	}
+	delete(t.sessionMap, hash)
+	return nil
 }
@@ -4,6 +4,7 @@
 package auth
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
@@ -46,7 +47,7 @@ func TestGet(t *testing.T) {
 	})
 
 	t.Run("session is expired", func(t *testing.T) {
-		tc.sessionMap = map[string]CachedSession{"foo": {ClientID: "bar", ExpirationDate: time.Now().Add(-10 * time.Minute)}}
+		tc.sessionMap = map[string]CachedSession{hashToken("foo"): {ClientID: "bar", ExpirationDate: time.Now().Add(-10 * time.Minute)}}
 		session, err := tc.Get("foo")
 		require.Error(t, err)
 		require.Equal(t, "session is expired", err.Error())
@@ -55,7 +56,7 @@ func TestGet(t *testing.T) {
 
 	t.Run("valid session returned", func(t *testing.T) {
 		expirationDate := time.Now().Add(10 * time.Minute)
-		tc.sessionMap = map[string]CachedSession{"foo": {ClientID: "bar", ExpirationDate: expirationDate}}
+		tc.sessionMap = map[string]CachedSession{hashToken("foo"): {ClientID: "bar", ExpirationDate: expirationDate}}
 		session, err := tc.Get("foo")
 		require.NoError(t, err)
 		require.NotNil(t, session)
@@ -110,7 +111,124 @@ func TestDelete(t *testing.T) {
 		err := tc.Put("foo", "bar")
 		require.NoError(t, err)
 		require.Len(t, tc.sessionMap, 1)
-		tc.Delete("foo")
+		require.NoError(t, tc.Delete("foo"))
 		require.Len(t, tc.sessionMap, 0)
 	})
 }
+
+func TestSessionCachePersistence(t *testing.T) {
+	dbStore, teardown := newTestDBStore(t)
+	defer teardown()
+	log := newTestLogger(t)
+
+	cfg := SessionCacheConfig{ExpirationMinutes: 1440, Persist: true}
+
+	tc, err := NewSessionCache(cfg, WithSessionStore(dbStore, log))
+	require.NoError(t, err)
+	require.NoError(t, tc.Put("clientA", "tokenA"))
+	require.NoError(t, tc.Put("clientB", "tokenB"))
+	tc.Close()
+
+	t.Run("tokens are not stored in clear", func(t *testing.T) {
+		_, err := dbStore.Get(sessionKeyPrefix + "tokenA")
+		require.Error(t, err)
+		_, err = dbStore.Get(sessionKeyPrefix + hashToken("tokenA"))
+		require.NoError(t, err)
+	})
+
+	t.Run("sessions are restored", func(t *testing.T) {
+		tc, err := NewSessionCache(cfg, WithSessionStore(dbStore, log))
+		require.NoError(t, err)
+		defer tc.Close()
+
+		session, err := tc.Get("tokenA")
+		require.NoError(t, err)
+		require.Equal(t, "clientA", session.ClientID)
+
+		require.NoError(t, tc.Delete("clientB"))
+		_, err = tc.Get("tokenB")
+		require.EqualError(t, err, "token is invalid")
+	})
+
+	t.Run("deleted sessions are not restored", func(t *testing.T) {
+		tc, err := NewSessionCache(cfg, WithSessionStore(dbStore, log))
+		require.NoError(t, err)
+		defer tc.Close()
+
+		_, err = tc.Get("tokenA")
+		require.NoError(t, err)
+		_, err = tc.Get("tokenB")
+		require.EqualError(t, err, "token is invalid")
+	})
+
+	t.Run("expired sessions are not restored", func(t *testing.T) {
+		data, err := json.Marshal(CachedSession{ClientID: "clientC", ExpirationDate: time.Now().Add(-time.Minute)})
+		require.NoError(t, err)
+		require.NoError(t, dbStore.Set(sessionKeyPrefix+hashToken("tokenC"), string(data)))
+
+		tc, err := NewSessionCache(cfg, WithSessionStore(dbStore, log))
+		require.NoError(t, err)
+		defer tc.Close()
+
+		_, err = tc.Get("tokenC")
+		require.EqualError(t, err, "token is invalid")
+		_, err = dbStore.Get(sessionKeyPrefix + hashToken("tokenC"))
+		require.Error(t, err)
+	})
+
+	t.Run("malformed sessions are skipped", func(t *testing.T) {
+		require.NoError(t, dbStore.Set(sessionKeyPrefix+"malformed", "not a session"))
+
+		tc, err := NewSessionCache(cfg, WithSessionStore(dbStore, log))
+		require.NoError(t, err)
+		defer tc.Close()
+
+		_, err = tc.Get("tokenA")
+		require.NoError(t, err)
+		// The key is not ours to remove.
+		value, err := dbStore.Get(sessionKeyPrefix + "malformed")
+		require.NoError(t, err)
+		require.Equal(t, "not a session", value)
+	})
+
+	t.Run("nil store", func(t *testing.T) {
+		tc, err := NewSessionCache(cfg, WithSessionStore(nil, log))
+		require.EqualError(t, err, "store should not be nil")
+		require.Nil(t, tc)
+	})
+
+	t.Run("nil log", func(t *testing.T) {
+		tc, err := NewSessionCache(cfg, WithSessionStore(dbStore, nil))
+		require.EqualError(t, err, "log should not be nil")
+		require.Nil(t, tc)
+	})
+}
+
+func TestSessionCacheSweep(t *testing.T) {
+	dbStore, teardown := newTestDBStore(t)
+	defer teardown()
+	log := newTestLogger(t)
+
+	tc, err := NewSessionCache(SessionCacheConfig{ExpirationMinutes: 1440}, WithSessionStore(dbStore, log))
+	require.NoError(t, err)
+	defer tc.Close()
+
+	require.NoError(t, tc.Put("clientA", "tokenA"))
+	require.NoError(t, tc.Put("clientB", "tokenB"))
+
+	tc.mut.Lock()
+	session := tc.sessionMap[hashToken("tokenA")]
+	session.ExpirationDate = time.Now().Add(-time.Minute)
+	tc.sessionMap[hashToken("tokenA")] = session
+	tc.mut.Unlock()
+
+	tc.sweep()
+
+	require.Len(t, tc.sessionMap, 1)
+	_, err = tc.Get("tokenB")
+	require.NoError(t, err)
+	_, err = dbStore.Get(sessionKeyPrefix + hashToken("tokenA"))
+	require.Error(t, err)
+	_, err = dbStore.Get(sessionKeyPrefix + hashToken("tokenB"))
+	require.NoError(t, err)
+}
@@ -12,9 +12,12 @@ import (
 	"net/http"
 	"net/url"
 	"testing"
+	"time"
 
+	"github.com/mattermost/rtcd/service/auth"
 	"github.com/mattermost/rtcd/service/ws"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
 )
 
@@ -298,6 +301,77 @@ func TestWSAuthHandler(t *testing.T) {
 	})
 }
 
+func TestJWTAuthHandler(t *testing.T) {
+	signingKey := "Ey4-H_BJA00_TVByPi8DozE12ekN3S7L"
+	cfg := MakeDefaultCfg(t)
+	cfg.API.Security.JWT = auth.JWTConfig{
+		Enable:     true,
+		SigningKey: signingKey,
+	}
+	th := SetupTestHelper(t, cfg)
+	defer th.Teardown()
+
+	_, port, err := net.SplitHostPort(th.srvc.apiServer.Addr())
+	require.NoError(t, err)
+	wsURL := url.URL{Scheme: "ws", Host: "localhost:" + port, Path: "/ws"}
+
+	newToken := func(t *testing.T, clientID, scope string, expiresAt time.Time) string {
+		t.Helper()
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.JWTClaims{
+			ClientID: clientID,
+			Scope:    scope,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(expiresAt),
+			},
+		}).SignedString([]byte(signingKey))
+		require.NoError(t, err)
+		return token
+	}
+
+	connect := func(token string) (*ws.Client, error) {
+		return ws.NewClient(ws.ClientConfig{
+			URL:       wsURL.String(),
+			AuthToken: token,
+			AuthType:  ws.BearerClientAuthType,
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		// Clients don't need to be registered.
+		wsClient, err := connect(newToken(t, "clientA", "", time.Now().Add(time.Hour)))
+		require.NoError(t, err)
+		require.NotNil(t, wsClient)
+		wsClient.Close()
+
+		wsClient, err = connect(newToken(t, "clientA", "whip ws", time.Now().Add(time.Hour)))
+		require.NoError(t, err)
+		require.NotNil(t, wsClient)
+		wsClient.Close()
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		wsClient, err := connect(newToken(t, "clientA", "", time.Now().Add(-time.Hour)))
+		require.Error(t, err)
+		require.Nil(t, wsClient)
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		wsClient, err := connect(newToken(t, "clientA", "whip", time.Now().Add(time.Hour)))
+		require.Error(t, err)
+		require.Nil(t, wsClient)
+	})
+
+	t.Run("no admin access", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, th.apiURL+"/admin/sessions", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+newToken(t, "clientA", "", time.Now().Add(time.Hour)))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+}
+
 func registerClient(t *testing.T, th *TestHelper, clientID string, authKey string) {
 	bufStr := fmt.Sprintf(`{"clientID": "%s", "authKey": "%s"}`, clientID, authKey)
 	buf := bytes.NewBuffer([]byte(bufStr))
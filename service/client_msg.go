@@ -24,6 +24,20 @@ const (
 	ClientMessageReconnect = "reconnect"
 	ClientMessageClose     = "close"
 	ClientMessageVAD       = "vad"
+
+	ClientMessageStartRecording = "start_recording"
+	ClientMessageStopRecording  = "stop_recording"
+
+	// ClientMessageScreenOff notifies the client that one of its screen
+	// sharing streams was stopped (e.g. by an admin), the session itself
+	// carrying on.
+	ClientMessageScreenOff = "screen_off"
+
+	// ClientMessageMigrate asks the client to move a session to a different
+	// rtcd instance as this one is draining. Its data is the rtc.SessionState
+	// needed to rebuild the session. The client picks the target instance and
+	// sends it the same message, in place of a join, to restore the session.
+	ClientMessageMigrate = "migrate"
 )
 
 var _ msgpack.CustomEncoder = (*ClientMessage)(nil)
@@ -48,7 +62,8 @@ func (cm *ClientMessage) DecodeMsgpack(dec *msgpack.Decoder) error {
 			return fmt.Errorf("failed to decode msg.Data: %w", err)
 		}
 		cm.Data = data
-	case ClientMessageLeave, ClientMessageHello, ClientMessageReconnect, ClientMessageClose:
+	case ClientMessageLeave, ClientMessageHello, ClientMessageReconnect, ClientMessageClose,
+		ClientMessageStartRecording, ClientMessageStopRecording, ClientMessageScreenOff:
 		data, err := dec.DecodeTypedMap()
 		if err != nil {
 			return fmt.Errorf("failed to decode msg.Data: %w", err)
@@ -60,6 +75,12 @@ func (cm *ClientMessage) DecodeMsgpack(dec *msgpack.Decoder) error {
 			return fmt.Errorf("failed to decode rtc.Message: %w", err)
 		}
 		cm.Data = rtcMsg
+	case ClientMessageMigrate:
+		var state rtc.SessionState
+		if err = dec.Decode(&state); err != nil {
+			return fmt.Errorf("failed to decode rtc.SessionState: %w", err)
+		}
+		cm.Data = state
 	default:
 		data, err := dec.DecodeInterface()
 		if err != nil {
@@ -50,6 +50,22 @@ func TestClientMessage(t *testing.T) {
 		require.Equal(t, ClientMessageLeave, msg2.Type)
 	})
 
+	t.Run("with recording types", func(t *testing.T) {
+		msgData := map[string]string{
+			"callID": "call_id",
+		}
+		for _, msgType := range []string{ClientMessageStartRecording, ClientMessageStopRecording} {
+			msg := NewClientMessage(msgType, msgData)
+			data, err := msg.Pack()
+			require.NoError(t, err)
+			msg2 := &ClientMessage{}
+			err = msg2.Unpack(data)
+			require.NoError(t, err)
+			require.Equal(t, msg, msg2)
+			require.Equal(t, msgType, msg2.Type)
+		}
+	})
+
 	t.Run("with rtc type", func(t *testing.T) {
 		rtcMsg := rtc.Message{
 			SessionID: "session_id",
@@ -67,4 +83,24 @@ func TestClientMessage(t *testing.T) {
 		require.Equal(t, ClientMessageRTC, msg2.Type)
 		require.Equal(t, rtcMsg, msg2.Data)
 	})
+
+	t.Run("with migrate type", func(t *testing.T) {
+		state := rtc.SessionState{
+			GroupID:         "group_id",
+			CallID:          "call_id",
+			UserID:          "user_id",
+			SessionID:       "session_id",
+			Props:           rtc.SessionProps{"channelID": "channel_id", "videoSupport": true},
+			Muted:           true,
+			ScreenStreamIDs: []string{"screen_stream_id"},
+		}
+		msg := NewClientMessage(ClientMessageMigrate, state)
+		data, err := msg.Pack()
+		require.NoError(t, err)
+		msg2 := &ClientMessage{}
+		err = msg2.Unpack(data)
+		require.NoError(t, err)
+		require.Equal(t, msg, msg2)
+		require.Equal(t, ClientMessageMigrate, msg2.Type)
+	})
 }
@@ -12,7 +12,10 @@ import (
 
 	"github.com/mattermost/rtcd/logger"
 	"github.com/mattermost/rtcd/service/api"
+	"github.com/mattermost/rtcd/service/perf"
 	"github.com/mattermost/rtcd/service/rtc"
+	"github.com/mattermost/rtcd/service/tracing"
+	"github.com/mattermost/rtcd/service/webhook"
 )
 
 type SecurityConfig struct {
@@ -23,9 +26,14 @@ type SecurityConfig struct {
 	// Whether or not to allow clients to self-register.
 	AllowSelfRegistration bool                    `toml:"allow_self_registration"`
 	SessionCache          auth.SessionCacheConfig `toml:"session_cache"`
+	JWT                   auth.JWTConfig          `toml:"jwt"`
 }
 
 func (c SecurityConfig) IsValid() error {
+	if err := c.JWT.IsValid(); err != nil {
+		return err
+	}
+
 	if !c.EnableAdmin {
 		return nil
 	}
@@ -43,10 +51,13 @@ type APIConfig struct {
 }
 
 type Config struct {
-	API    APIConfig
-	RTC    rtc.ServerConfig
-	Store  StoreConfig
-	Logger logger.Config
+	API     APIConfig
+	RTC     rtc.ServerConfig
+	Store   StoreConfig
+	Logger  logger.Config
+	Metrics perf.Config
+	Tracing tracing.Config
+	Webhook webhook.Config
 }
 
 func (c APIConfig) IsValid() error {
@@ -74,6 +85,18 @@ func (c Config) IsValid() error {
 		return err
 	}
 
+	if err := c.Metrics.IsValid(); err != nil {
+		return err
+	}
+
+	if err := c.Tracing.IsValid(); err != nil {
+		return err
+	}
+
+	if err := c.Webhook.IsValid(); err != nil {
+		return err
+	}
+
 	return c.Logger.IsValid()
 }
 
@@ -83,7 +106,12 @@ func (c *Config) SetDefaults() {
 	c.RTC.ICEPortUDP = 8443
 	c.RTC.ICEPortTCP = 8443
 	c.RTC.TURNConfig.CredentialsExpirationMinutes = 1440
+	c.RTC.TURNConfig.Server.ListenAddress = ":3478"
+	c.RTC.TURNConfig.Server.RelayAddress = "0.0.0.0"
 	c.RTC.UDPSocketsCount = rtc.GetDefaultUDPListeningSocketsCount()
+	c.RTC.MaxScreenSharesPerCall = 1
+	c.RTC.ICERestartGracePeriodSeconds = 30
+	c.RTC.SimulcastLevels = rtc.GetDefaultSimulcastLevels()
 	c.Store.DataSource = "/tmp/rtcd_db"
 	c.Logger.EnableConsole = true
 	c.Logger.ConsoleJSON = false
@@ -93,6 +121,25 @@ func (c *Config) SetDefaults() {
 	c.Logger.FileLocation = "rtcd.log"
 	c.Logger.FileLevel = "DEBUG"
 	c.Logger.EnableColor = false
+	c.Tracing.Exporter = tracing.ExporterFile
+	c.Tracing.FileLocation = "rtcd_traces.json"
+	c.Tracing.SampleRatio = 1
+	// Voice activity events are left out as they are fired too often to be
+	// of use to most receivers.
+	c.Webhook.Events = []string{
+		string(rtc.CallStartedEvent),
+		string(rtc.CallEndedEvent),
+		string(rtc.SessionJoinedEvent),
+		string(rtc.SessionLeftEvent),
+		string(rtc.ScreenOnEvent),
+		string(rtc.ScreenOffEvent),
+		string(rtc.MuteEvent),
+		string(rtc.UnmuteEvent),
+	}
+	c.Webhook.MaxAttempts = 10
+	c.Webhook.RetryIntervalSeconds = 5
+	c.Webhook.RetryMaxIntervalSeconds = 300
+	c.Webhook.MaxQueueSize = 10000
 }
 
 type StoreConfig struct {
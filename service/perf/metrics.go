@@ -4,7 +4,9 @@
 package perf
 
 import (
+	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
@@ -15,21 +17,56 @@ const (
 	metricsSubSystemRTC       = "rtc"
 	metricsSubSystemRTCClient = "rtc_client"
 	metricsSubSystemWS        = "ws"
+	metricsSubSystemTURN      = "turn"
 )
 
+// otherGroupLabel is the groupID label value shared by the groups exceeding
+// the configured limit.
+const otherGroupLabel = "_other"
+
 var (
-	latencyBuckets = []float64{.001, .005, .0075, .01, .025, .05, .075, .1, .25, .3, .4, .5, .75, 1}
-	lossBuckets    = []float64{.001, .005, .0075, .01, .025, .05, .075, .1, .25, .5, .75, 1}
+	latencyBuckets       = []float64{.001, .005, .0075, .01, .025, .05, .075, .1, .25, .3, .4, .5, .75, 1}
+	lossBuckets          = []float64{.001, .005, .0075, .01, .025, .05, .075, .1, .25, .5, .75, 1}
+	renegotiationBuckets = []float64{.05, .1, .25, .5, .75, 1, 1.5, 2, 3, 5, 10}
+	bitrateBuckets       = []float64{100_000, 250_000, 500_000, 750_000, 1_000_000, 1_500_000, 2_000_000, 2_500_000, 3_000_000, 4_000_000, 5_000_000}
 )
 
+type Config struct {
+	// The maximum number of distinct groupID label values. Metrics for any
+	// group past this limit are aggregated under a single "_other" value.
+	// Zero means no limit.
+	MaxGroupLabels int `toml:"max_group_labels"`
+}
+
+func (c Config) IsValid() error {
+	if c.MaxGroupLabels < 0 {
+		return fmt.Errorf("invalid MaxGroupLabels value: should not be negative")
+	}
+
+	return nil
+}
+
 type Metrics struct {
 	registry *prometheus.Registry
 
+	groupsMut      sync.RWMutex
+	groups         map[string]struct{}
+	maxGroupLabels int
+
 	RTPTracks            *prometheus.GaugeVec
 	RTPTrackWrites       *prometheus.HistogramVec
 	RTCSessions          *prometheus.GaugeVec
 	RTCConnStateCounters *prometheus.CounterVec
 	RTCErrors            *prometheus.CounterVec
+	RTCPublicIPChanges   prometheus.Counter
+
+	RTPBytes               *prometheus.CounterVec
+	RTPPackets             *prometheus.CounterVec
+	RTCPPackets            *prometheus.CounterVec
+	SimulcastLevelSwitches *prometheus.CounterVec
+	RTCRenegotiations      *prometheus.CounterVec
+	RTCRenegotiationTime   *prometheus.HistogramVec
+	RTCBWETargetBitrate    *prometheus.HistogramVec
 
 	RTCClientLoss   *prometheus.HistogramVec
 	RTCClientRTT    *prometheus.HistogramVec
@@ -37,10 +74,16 @@ type Metrics struct {
 
 	WSConnections     *prometheus.GaugeVec
 	WSMessageCounters *prometheus.CounterVec
+
+	TURNAllocations  *prometheus.GaugeVec
+	TURNRelayedBytes *prometheus.CounterVec
+	TURNAuthFailures prometheus.Counter
 }
 
 func NewMetrics(namespace string, registry *prometheus.Registry) *Metrics {
-	var m Metrics
+	m := Metrics{
+		groups: map[string]struct{}{},
+	}
 
 	if registry != nil {
 		m.registry = registry
@@ -108,6 +151,95 @@ func NewMetrics(namespace string, registry *prometheus.Registry) *Metrics {
 	)
 	m.registry.MustRegister(m.RTCErrors)
 
+	m.RTCPublicIPChanges = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "public_ip_changes_total",
+			Help:      "Total number of public IP address changes detected through STUN",
+		},
+	)
+	m.registry.MustRegister(m.RTCPublicIPChanges)
+
+	m.RTPBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "rtp_bytes_total",
+			Help:      "Total number of sent/received RTP bytes",
+		},
+		[]string{"groupID", "direction", "type"},
+	)
+	m.registry.MustRegister(m.RTPBytes)
+
+	m.RTPPackets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "rtp_packets_total",
+			Help:      "Total number of sent/received RTP packets",
+		},
+		[]string{"groupID", "direction", "type"},
+	)
+	m.registry.MustRegister(m.RTPPackets)
+
+	m.RTCPPackets = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "rtcp_packets_total",
+			Help:      "Total number of sent/received RTCP feedback packets (NACK, PLI)",
+		},
+		[]string{"groupID", "direction", "type"},
+	)
+	m.registry.MustRegister(m.RTCPPackets)
+
+	m.SimulcastLevelSwitches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "simulcast_level_switches_total",
+			Help:      "Total number of simulcast level switches",
+		},
+		[]string{"groupID", "direction"},
+	)
+	m.registry.MustRegister(m.SimulcastLevelSwitches)
+
+	m.RTCRenegotiations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "renegotiations_total",
+			Help:      "Total number of renegotiations started by the server",
+		},
+		[]string{"groupID"},
+	)
+	m.registry.MustRegister(m.RTCRenegotiations)
+
+	m.RTCRenegotiationTime = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "renegotiations_time",
+			Help:      "Time taken for renegotiations started by the server to complete",
+			Buckets:   renegotiationBuckets,
+		},
+		[]string{"groupID"},
+	)
+	m.registry.MustRegister(m.RTCRenegotiationTime)
+
+	m.RTCBWETargetBitrate = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemRTC,
+			Name:      "bwe_target_bitrate",
+			Help:      "Target bitrate (bps) estimated for sessions receiving media",
+			Buckets:   bitrateBuckets,
+		},
+		[]string{"groupID"},
+	)
+	m.registry.MustRegister(m.RTCBWETargetBitrate)
+
 	m.WSConnections = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Namespace: namespace,
@@ -168,15 +300,83 @@ func NewMetrics(namespace string, registry *prometheus.Registry) *Metrics {
 	)
 	m.registry.MustRegister(m.RTCClientJitter)
 
+	// TURN metrics
+
+	m.TURNAllocations = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemTURN,
+			Name:      "allocations_total",
+			Help:      "Total number of active TURN allocations",
+		},
+		[]string{"protocol"},
+	)
+	m.registry.MustRegister(m.TURNAllocations)
+
+	m.TURNRelayedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemTURN,
+			Name:      "relayed_bytes_total",
+			Help:      "Total number of bytes relayed through TURN allocations",
+		},
+		[]string{"direction"},
+	)
+	m.registry.MustRegister(m.TURNRelayedBytes)
+
+	m.TURNAuthFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: metricsSubSystemTURN,
+			Name:      "auth_failures_total",
+			Help:      "Total number of failed TURN authentication attempts",
+		},
+	)
+	m.registry.MustRegister(m.TURNAuthFailures)
+
 	return &m
 }
 
+// SetMaxGroupLabels sets the maximum number of distinct groupID label values.
+// It should be called before any metric is recorded.
+func (m *Metrics) SetMaxGroupLabels(limit int) {
+	m.groupsMut.Lock()
+	defer m.groupsMut.Unlock()
+	m.maxGroupLabels = limit
+}
+
+// groupLabel returns the label value to use for the given group. Groups are
+// assigned their own value on a first come first served basis until the
+// limit is reached, and are never evicted so that series don't flip between
+// values.
+func (m *Metrics) groupLabel(groupID string) string {
+	m.groupsMut.RLock()
+	_, ok := m.groups[groupID]
+	limit := m.maxGroupLabels
+	m.groupsMut.RUnlock()
+	if ok || limit == 0 {
+		return groupID
+	}
+
+	m.groupsMut.Lock()
+	defer m.groupsMut.Unlock()
+	if _, ok := m.groups[groupID]; ok {
+		return groupID
+	}
+	if len(m.groups) >= m.maxGroupLabels {
+		return otherGroupLabel
+	}
+	m.groups[groupID] = struct{}{}
+
+	return groupID
+}
+
 func (m *Metrics) IncRTCSessions(groupID string) {
-	m.RTCSessions.With(prometheus.Labels{"groupID": groupID}).Inc()
+	m.RTCSessions.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Inc()
 }
 
 func (m *Metrics) DecRTCSessions(groupID string) {
-	m.RTCSessions.With(prometheus.Labels{"groupID": groupID}).Dec()
+	m.RTCSessions.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Dec()
 }
 
 func (m *Metrics) IncRTCConnState(state string) {
@@ -184,15 +384,45 @@ func (m *Metrics) IncRTCConnState(state string) {
 }
 
 func (m *Metrics) IncRTCErrors(groupID string, errType string) {
-	m.RTCErrors.With(prometheus.Labels{"type": errType, "groupID": groupID}).Inc()
+	m.RTCErrors.With(prometheus.Labels{"type": errType, "groupID": m.groupLabel(groupID)}).Inc()
 }
 
 func (m *Metrics) IncRTPTracks(groupID, direction, trackType string) {
-	m.RTPTracks.With(prometheus.Labels{"groupID": groupID, "direction": direction, "type": trackType}).Inc()
+	m.RTPTracks.With(prometheus.Labels{"groupID": m.groupLabel(groupID), "direction": direction, "type": trackType}).Inc()
 }
 
 func (m *Metrics) DecRTPTracks(groupID, direction, trackType string) {
-	m.RTPTracks.With(prometheus.Labels{"groupID": groupID, "direction": direction, "type": trackType}).Dec()
+	m.RTPTracks.With(prometheus.Labels{"groupID": m.groupLabel(groupID), "direction": direction, "type": trackType}).Dec()
+}
+
+func (m *Metrics) IncRTCPublicIPChanges() {
+	m.RTCPublicIPChanges.Inc()
+}
+
+func (m *Metrics) IncRTPPackets(groupID, direction, trackType string, bytes int) {
+	labels := prometheus.Labels{"groupID": m.groupLabel(groupID), "direction": direction, "type": trackType}
+	m.RTPPackets.With(labels).Inc()
+	m.RTPBytes.With(labels).Add(float64(bytes))
+}
+
+func (m *Metrics) IncRTCPPackets(groupID, direction, pktType string) {
+	m.RTCPPackets.With(prometheus.Labels{"groupID": m.groupLabel(groupID), "direction": direction, "type": pktType}).Inc()
+}
+
+func (m *Metrics) IncSimulcastLevelSwitches(groupID, direction string) {
+	m.SimulcastLevelSwitches.With(prometheus.Labels{"groupID": m.groupLabel(groupID), "direction": direction}).Inc()
+}
+
+func (m *Metrics) IncRTCRenegotiations(groupID string) {
+	m.RTCRenegotiations.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Inc()
+}
+
+func (m *Metrics) ObserveRTCRenegotiationTime(groupID string, dur float64) {
+	m.RTCRenegotiationTime.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Observe(dur)
+}
+
+func (m *Metrics) ObserveRTCBWETargetBitrate(groupID string, rate int) {
+	m.RTCBWETargetBitrate.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Observe(float64(rate))
 }
 
 func (m *Metrics) IncWSConnections(clientID string) {
@@ -212,17 +442,33 @@ func (m *Metrics) Handler() http.Handler {
 }
 
 func (m *Metrics) ObserveRTPTracksWrite(groupID, trackType string, dur float64) {
-	m.RTPTrackWrites.With(prometheus.Labels{"groupID": groupID, "type": trackType}).Observe(dur)
+	m.RTPTrackWrites.With(prometheus.Labels{"groupID": m.groupLabel(groupID), "type": trackType}).Observe(dur)
 }
 
 func (m *Metrics) ObserveRTCClientLossRate(groupID string, val float64) {
-	m.RTCClientLoss.With(prometheus.Labels{"groupID": groupID}).Observe(val)
+	m.RTCClientLoss.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Observe(val)
 }
 
 func (m *Metrics) ObserveRTCClientRTT(groupID string, val float64) {
-	m.RTCClientRTT.With(prometheus.Labels{"groupID": groupID}).Observe(val)
+	m.RTCClientRTT.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Observe(val)
 }
 
 func (m *Metrics) ObserveRTCClientJitter(groupID string, val float64) {
-	m.RTCClientJitter.With(prometheus.Labels{"groupID": groupID}).Observe(val)
+	m.RTCClientJitter.With(prometheus.Labels{"groupID": m.groupLabel(groupID)}).Observe(val)
+}
+
+func (m *Metrics) IncTURNAllocations(protocol string) {
+	m.TURNAllocations.With(prometheus.Labels{"protocol": protocol}).Inc()
+}
+
+func (m *Metrics) DecTURNAllocations(protocol string) {
+	m.TURNAllocations.With(prometheus.Labels{"protocol": protocol}).Dec()
+}
+
+func (m *Metrics) AddTURNRelayedBytes(direction string, n int) {
+	m.TURNRelayedBytes.With(prometheus.Labels{"direction": direction}).Add(float64(n))
+}
+
+func (m *Metrics) IncTURNAuthFailures() {
+	m.TURNAuthFailures.Inc()
 }
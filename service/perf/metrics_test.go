@@ -0,0 +1,66 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package perf
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigIsValid(t *testing.T) {
+	t.Run("empty struct", func(t *testing.T) {
+		var cfg Config
+		require.NoError(t, cfg.IsValid())
+	})
+
+	t.Run("invalid MaxGroupLabels", func(t *testing.T) {
+		cfg := Config{MaxGroupLabels: -1}
+		err := cfg.IsValid()
+		require.Error(t, err)
+		require.Equal(t, "invalid MaxGroupLabels value: should not be negative", err.Error())
+	})
+}
+
+func TestGroupLabel(t *testing.T) {
+	t.Run("no limit", func(t *testing.T) {
+		m := NewMetrics("rtcd", nil)
+		for _, groupID := range []string{"groupA", "groupB", "groupC"} {
+			require.Equal(t, groupID, m.groupLabel(groupID))
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		m := NewMetrics("rtcd", nil)
+		m.SetMaxGroupLabels(2)
+
+		require.Equal(t, "groupA", m.groupLabel("groupA"))
+		require.Equal(t, "groupB", m.groupLabel("groupB"))
+		require.Equal(t, otherGroupLabel, m.groupLabel("groupC"))
+		require.Equal(t, otherGroupLabel, m.groupLabel("groupD"))
+
+		// Groups that got their own label keep it.
+		require.Equal(t, "groupA", m.groupLabel("groupA"))
+		require.Equal(t, "groupB", m.groupLabel("groupB"))
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		m := NewMetrics("rtcd", nil)
+		m.SetMaxGroupLabels(1)
+
+		m.IncRTPPackets("groupA", "in", "audio", 100)
+		m.IncRTPPackets("groupB", "in", "audio", 200)
+		m.IncRTPPackets("groupC", "in", "audio", 300)
+
+		labels := prometheus.Labels{"groupID": "groupA", "direction": "in", "type": "audio"}
+		require.Equal(t, float64(1), testutil.ToFloat64(m.RTPPackets.With(labels)))
+		require.Equal(t, float64(100), testutil.ToFloat64(m.RTPBytes.With(labels)))
+
+		labels["groupID"] = otherGroupLabel
+		require.Equal(t, float64(2), testutil.ToFloat64(m.RTPPackets.With(labels)))
+		require.Equal(t, float64(500), testutil.ToFloat64(m.RTPBytes.With(labels)))
+	})
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// GroupInfo describes a group of calls currently hosted by the server.
+type GroupInfo struct {
+	ID      string   `json:"id"`
+	CallIDs []string `json:"callIDs"`
+}
+
+// CallInfo describes a call currently hosted by the server.
+type CallInfo struct {
+	GroupID    string   `json:"groupID"`
+	ID         string   `json:"id"`
+	SessionIDs []string `json:"sessionIDs"`
+	// ScreenStreamIDs holds the IDs of the screen streams currently being
+	// shared.
+	ScreenStreamIDs []string `json:"screenStreamIDs"`
+	Recording       bool     `json:"recording"`
+}
+
+// SessionInfo describes the live state of a session.
+type SessionInfo struct {
+	GroupID   string `json:"groupID"`
+	CallID    string `json:"callID"`
+	UserID    string `json:"userID"`
+	SessionID string `json:"sessionID"`
+	// PublishedTracks are the tracks received from the session, one per
+	// codec and simulcast level for video.
+	PublishedTracks []TrackInfo `json:"publishedTracks"`
+	// ReceivedTracks are the tracks forwarded to the session.
+	ReceivedTracks []TrackInfo `json:"receivedTracks"`
+	// SimulcastLevel is the level the session is expected to receive given
+	// its estimated bandwidth.
+	SimulcastLevel        string             `json:"simulcastLevel"`
+	ConnectionState       string             `json:"connectionState"`
+	SelectedCandidatePair *CandidatePairInfo `json:"selectedCandidatePair,omitempty"`
+}
+
+// TrackInfo describes a media track.
+type TrackInfo struct {
+	ID       string `json:"id"`
+	StreamID string `json:"streamID"`
+	Type     string `json:"type"`
+	MimeType string `json:"mimeType"`
+	// RID is the simulcast level of a published track.
+	RID string `json:"rid,omitempty"`
+	// PublisherID is the ID of the session publishing a received track.
+	PublisherID string `json:"publisherID,omitempty"`
+	// Level is the simulcast level currently forwarded for a received track.
+	Level string `json:"level,omitempty"`
+}
+
+// CandidatePairInfo describes the ICE candidate pair used by a session.
+type CandidatePairInfo struct {
+	Local  CandidateInfo `json:"local"`
+	Remote CandidateInfo `json:"remote"`
+}
+
+type CandidateInfo struct {
+	Type     string `json:"type"`
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+}
+
+func (s *Server) getGroups() []*group {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	groups := make([]*group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].id < groups[j].id
+	})
+
+	return groups
+}
+
+func (g *group) getCalls() []*call {
+	g.mut.RLock()
+	defer g.mut.RUnlock()
+
+	calls := make([]*call, 0, len(g.calls))
+	for _, c := range g.calls {
+		calls = append(calls, c)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].id < calls[j].id
+	})
+
+	return calls
+}
+
+func (c *call) getSessions() []*session {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	sessions := make([]*session, 0, len(c.sessions))
+	for _, us := range c.sessions {
+		sessions = append(sessions, us)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].cfg.SessionID < sessions[j].cfg.SessionID
+	})
+
+	return sessions
+}
+
+// getCalls returns the calls in the given group, or in all groups if groupID
+// is empty.
+func (s *Server) getCalls(groupID string) []*call {
+	var calls []*call
+	for _, g := range s.getGroups() {
+		if groupID != "" && g.id != groupID {
+			continue
+		}
+		calls = append(calls, g.getCalls()...)
+	}
+	return calls
+}
+
+// GetGroupsInfo returns the groups currently hosted by the server.
+func (s *Server) GetGroupsInfo() []GroupInfo {
+	groups := s.getGroups()
+	infos := make([]GroupInfo, 0, len(groups))
+	for _, g := range groups {
+		info := GroupInfo{
+			ID:      g.id,
+			CallIDs: []string{},
+		}
+		for _, c := range g.getCalls() {
+			info.CallIDs = append(info.CallIDs, c.id)
+		}
+		infos = append(infos, info)
+	}
+
+	return infos
+}
+
+// GetCallsInfo returns the calls currently hosted by the server, optionally
+// filtered by group.
+func (s *Server) GetCallsInfo(groupID string) []CallInfo {
+	calls := s.getCalls(groupID)
+	infos := make([]CallInfo, 0, len(calls))
+	for _, c := range calls {
+		infos = append(infos, c.getInfo())
+	}
+
+	return infos
+}
+
+// GetSessionsInfo returns the sessions currently connected to the server,
+// optionally filtered by group and call.
+func (s *Server) GetSessionsInfo(groupID, callID string) []SessionInfo {
+	infos := []SessionInfo{}
+	for _, c := range s.getCalls(groupID) {
+		if callID != "" && c.id != callID {
+			continue
+		}
+		for _, us := range c.getSessions() {
+			infos = append(infos, us.getInfo())
+		}
+	}
+
+	return infos
+}
+
+func (c *call) getInfo() CallInfo {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	info := CallInfo{
+		ID:              c.id,
+		SessionIDs:      make([]string, 0, len(c.sessions)),
+		ScreenStreamIDs: make([]string, 0, len(c.screenStreams)),
+		Recording:       c.recorder.Load() != nil,
+	}
+	for sessionID, us := range c.sessions {
+		info.GroupID = us.cfg.GroupID
+		info.SessionIDs = append(info.SessionIDs, sessionID)
+	}
+	for streamID := range c.screenStreams {
+		info.ScreenStreamIDs = append(info.ScreenStreamIDs, streamID)
+	}
+	sort.Strings(info.SessionIDs)
+	sort.Strings(info.ScreenStreamIDs)
+
+	return info
+}
+
+// newTrackInfo describes a local track, inferring its type and publisher from
+// its ID when possible.
+func newTrackInfo(track webrtc.TrackLocal) TrackInfo {
+	info := TrackInfo{
+		ID:       track.ID(),
+		StreamID: track.StreamID(),
+		Type:     getTrackType(track.Kind()),
+	}
+
+	if tt, publisherID, err := parseTrackID(track.ID()); err == nil {
+		info.Type = string(tt)
+		info.PublisherID = publisherID
+	}
+
+	switch t := track.(type) {
+	case *simulcastTrack:
+		info.MimeType = t.mimeType
+		info.Level = t.getLevel()
+	case *audioSlotTrack:
+		info.MimeType = t.Codec().MimeType
+		info.PublisherID = t.getSourceID()
+	case *webrtc.TrackLocalStaticRTP:
+		info.MimeType = t.Codec().MimeType
+	}
+
+	return info
+}
+
+func (s *session) getInfo() SessionInfo {
+	info := SessionInfo{
+		GroupID:         s.cfg.GroupID,
+		CallID:          s.cfg.CallID,
+		UserID:          s.cfg.UserID,
+		SessionID:       s.cfg.SessionID,
+		PublishedTracks: []TrackInfo{},
+		ReceivedTracks:  []TrackInfo{},
+		SimulcastLevel:  s.getExpectedSimulcastLevel(),
+		ConnectionState: s.rtcConn.ConnectionState().String(),
+	}
+
+	// Senders are fetched before locking the session since the peer
+	// connection has a lock of its own.
+	senders := s.rtcConn.GetSenders()
+
+	s.mut.RLock()
+	if s.outVoiceTrack != nil {
+		trackInfo := newTrackInfo(s.outVoiceTrack)
+		trackInfo.PublisherID = ""
+		info.PublishedTracks = append(info.PublishedTracks, trackInfo)
+	}
+	for _, ms := range s.streams {
+		for _, track := range ms.remoteTracks {
+			info.PublishedTracks = append(info.PublishedTracks, TrackInfo{
+				ID:       track.ID(),
+				StreamID: ms.id,
+				Type:     string(ms.trackType),
+				MimeType: track.Codec().MimeType,
+				RID:      track.RID(),
+			})
+		}
+		if ms.outAudioTrack != nil {
+			trackInfo := newTrackInfo(ms.outAudioTrack)
+			trackInfo.PublisherID = ""
+			info.PublishedTracks = append(info.PublishedTracks, trackInfo)
+		}
+	}
+	for _, sender := range senders {
+		track := sender.Track()
+		// Idle WHEP senders are bound to placeholder tracks.
+		if track == nil || s.whepSenders[sender] == track {
+			continue
+		}
+		info.ReceivedTracks = append(info.ReceivedTracks, newTrackInfo(track))
+	}
+	s.mut.RUnlock()
+
+	sortTrackInfos := func(tracks []TrackInfo) {
+		sort.Slice(tracks, func(i, j int) bool {
+			if tracks[i].ID != tracks[j].ID {
+				return tracks[i].ID < tracks[j].ID
+			}
+			return tracks[i].RID < tracks[j].RID
+		})
+	}
+	sortTrackInfos(info.PublishedTracks)
+	sortTrackInfos(info.ReceivedTracks)
+
+	pair, err := s.rtcConn.SCTP().Transport().ICETransport().GetSelectedCandidatePair()
+	if err == nil && pair != nil && pair.Local != nil && pair.Remote != nil {
+		info.SelectedCandidatePair = &CandidatePairInfo{
+			Local:  newCandidateInfo(pair.Local),
+			Remote: newCandidateInfo(pair.Remote),
+		}
+	}
+
+	return info
+}
+
+func newCandidateInfo(c *webrtc.ICECandidate) CandidateInfo {
+	return CandidateInfo{
+		Type:     c.Typ.String(),
+		Protocol: c.Protocol.String(),
+		Address:  c.Address,
+		Port:     int(c.Port),
+	}
+}
+
+// CloseCallSession closes the given session, making sure it belongs to the
+// given group and call.
+func (s *Server) CloseCallSession(groupID, callID, sessionID string) error {
+	s.mut.RLock()
+	cfg, ok := s.sessions[sessionID]
+	s.mut.RUnlock()
+
+	if !ok || cfg.GroupID != groupID || cfg.CallID != callID {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	return s.CloseSession(sessionID)
+}
+
+// EndCall closes all the sessions in the given call.
+func (s *Server) EndCall(groupID, callID string) error {
+	var sessionIDs []string
+	s.mut.RLock()
+	for sessionID, cfg := range s.sessions {
+		if cfg.GroupID == groupID && cfg.CallID == callID {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+	s.mut.RUnlock()
+
+	if len(sessionIDs) == 0 {
+		return fmt.Errorf("call not found: %s", callID)
+	}
+
+	var err error
+	for _, sessionID := range sessionIDs {
+		if closeErr := s.CloseSession(sessionID); closeErr != nil {
+			s.log.Error("failed to close RTC session", mlog.Err(closeErr), mlog.String("sessionID", sessionID))
+			err = fmt.Errorf("failed to close session %s: %w", sessionID, closeErr)
+		}
+	}
+
+	return err
+}
+
+// StopScreenShare stops the given screen sharing stream, returning the ID of
+// the session that was publishing it.
+func (s *Server) StopScreenShare(groupID, callID, streamID string) (string, error) {
+	g := s.getGroup(groupID)
+	if g == nil {
+		return "", fmt.Errorf("group not found: %s", groupID)
+	}
+	c := g.getCall(callID)
+	if c == nil {
+		return "", fmt.Errorf("call not found: %s", callID)
+	}
+
+	c.mut.RLock()
+	us := c.screenStreams[streamID]
+	c.mut.RUnlock()
+	if us == nil {
+		return "", fmt.Errorf("screen stream not found: %s", streamID)
+	}
+
+	if err := c.clearScreenState(us, streamID); err != nil {
+		return "", fmt.Errorf("failed to clear screen state: %w", err)
+	}
+
+	return us.cfg.SessionID, nil
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetInfo(t *testing.T) {
+	s := setupRelayServer(t, 30441)
+
+	go func() {
+		for msg := range s.ReceiveCh() {
+			require.Failf(t, "unexpected message", "%+v", msg)
+		}
+	}()
+
+	require.Empty(t, s.GetGroupsInfo())
+	require.Empty(t, s.GetCallsInfo(""))
+	require.Empty(t, s.GetSessionsInfo("", ""))
+
+	viewer, offer := newWHEPOffer(t, webrtc.RTPCodecTypeAudio)
+	viewerCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "viewer", SessionID: "whepSession"}
+	answer, err := s.InitWHEPSession(viewerCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, viewer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	voiceTrack, err := webrtc.NewTrackLocalStaticSample(rtpAudioCodec, "audio", "whipStream")
+	require.NoError(t, err)
+	publisher, offer := newWHIPOffer(t, voiceTrack)
+	publisherCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "obs", SessionID: "whipSession"}
+	answer, err = s.InitWHIPSession(publisherCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				require.NoError(t, voiceTrack.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond}))
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	t.Run("groups", func(t *testing.T) {
+		require.Equal(t, []GroupInfo{{ID: "groupID", CallIDs: []string{"callID"}}}, s.GetGroupsInfo())
+	})
+
+	t.Run("calls", func(t *testing.T) {
+		require.Empty(t, s.GetCallsInfo("otherGroupID"))
+		require.Equal(t, []CallInfo{{
+			GroupID:         "groupID",
+			ID:              "callID",
+			SessionIDs:      []string{"whepSession", "whipSession"},
+			ScreenStreamIDs: []string{},
+		}}, s.GetCallsInfo("groupID"))
+	})
+
+	t.Run("sessions", func(t *testing.T) {
+		require.Empty(t, s.GetSessionsInfo("groupID", "otherCallID"))
+
+		// Wait for the voice track to be forwarded to the viewer.
+		var infos []SessionInfo
+		require.Eventually(t, func() bool {
+			infos = s.GetSessionsInfo("groupID", "callID")
+			return len(infos) == 2 && len(infos[0].ReceivedTracks) == 1 &&
+				infos[0].SelectedCandidatePair != nil && infos[1].SelectedCandidatePair != nil
+		}, 10*time.Second, 50*time.Millisecond)
+
+		viewerInfo := infos[0]
+		require.Equal(t, "viewer", viewerInfo.UserID)
+		require.Equal(t, webrtc.PeerConnectionStateConnected.String(), viewerInfo.ConnectionState)
+		require.Equal(t, SimulcastLevelLow, viewerInfo.SimulcastLevel)
+		require.Empty(t, viewerInfo.PublishedTracks)
+		require.Equal(t, "voice", viewerInfo.ReceivedTracks[0].Type)
+		require.Equal(t, "whipSession", viewerInfo.ReceivedTracks[0].PublisherID)
+		require.Equal(t, webrtc.MimeTypeOpus, viewerInfo.ReceivedTracks[0].MimeType)
+		require.Equal(t, "host", viewerInfo.SelectedCandidatePair.Local.Type)
+		require.Equal(t, 30441, viewerInfo.SelectedCandidatePair.Local.Port)
+
+		publisherInfo := infos[1]
+		require.Equal(t, "obs", publisherInfo.UserID)
+		require.Len(t, publisherInfo.PublishedTracks, 1)
+		require.Equal(t, "voice", publisherInfo.PublishedTracks[0].Type)
+		require.Empty(t, publisherInfo.PublishedTracks[0].PublisherID)
+		require.Empty(t, publisherInfo.ReceivedTracks)
+	})
+
+	require.NoError(t, s.CloseWHIPSession("groupID", "callID", "whipSession"))
+	require.NoError(t, s.CloseWHEPSession("groupID", "callID", "whepSession"))
+	require.Eventually(t, func() bool {
+		return len(s.GetGroupsInfo()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestAdminActions(t *testing.T) {
+	s := setupRelayServer(t, 30442)
+
+	go func() {
+		for msg := range s.ReceiveCh() {
+			require.Failf(t, "unexpected message", "%+v", msg)
+		}
+	}()
+
+	screenTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "whipStream")
+	require.NoError(t, err)
+	publisher, offer := newWHIPOffer(t, screenTrack)
+	publisherCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "obs", SessionID: "whipSession"}
+	answer, err := s.InitWHIPSession(publisherCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				require.NoError(t, screenTrack.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond}))
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	var streamID string
+	require.Eventually(t, func() bool {
+		calls := s.GetCallsInfo("groupID")
+		if len(calls) != 1 || len(calls[0].ScreenStreamIDs) != 1 {
+			return false
+		}
+		streamID = calls[0].ScreenStreamIDs[0]
+		return true
+	}, 10*time.Second, 50*time.Millisecond)
+
+	t.Run("stop screen share", func(t *testing.T) {
+		_, err := s.StopScreenShare("otherGroupID", "callID", streamID)
+		require.EqualError(t, err, "group not found: otherGroupID")
+		_, err = s.StopScreenShare("groupID", "otherCallID", streamID)
+		require.EqualError(t, err, "call not found: otherCallID")
+		_, err = s.StopScreenShare("groupID", "callID", "otherStreamID")
+		require.EqualError(t, err, "screen stream not found: otherStreamID")
+
+		sessionID, err := s.StopScreenShare("groupID", "callID", streamID)
+		require.NoError(t, err)
+		require.Equal(t, "whipSession", sessionID)
+		require.Empty(t, s.GetCallsInfo("groupID")[0].ScreenStreamIDs)
+	})
+
+	t.Run("close session", func(t *testing.T) {
+		require.EqualError(t, s.CloseCallSession("groupID", "otherCallID", "whipSession"), "session not found: whipSession")
+	})
+
+	t.Run("end call", func(t *testing.T) {
+		require.EqualError(t, s.EndCall("groupID", "otherCallID"), "call not found: otherCallID")
+		require.NoError(t, s.EndCall("groupID", "callID"))
+		require.Empty(t, s.GetGroupsInfo())
+	})
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/mattermost/rtcd/service/random"
+	"github.com/mattermost/rtcd/service/rtc/dc"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const (
+	// audioSlotsUpdateInterval is the minimum time between slot assignments.
+	audioSlotsUpdateInterval = 200 * time.Millisecond
+	// audioSlotsActiveWindow is for how long a speaker is considered to be
+	// actively speaking after its last voice sample.
+	audioSlotsActiveWindow = time.Second
+	// audioSlotsNoiseLevel is the audio level (-dBov) above which samples are
+	// treated as silence.
+	audioSlotsNoiseLevel = 70
+	// audioSlotsLoudnessWeight is the weight given to each new sample when
+	// averaging a speaker's loudness.
+	audioSlotsLoudnessWeight = 0.1
+)
+
+// audioSlotTrack is an outgoing voice track whose source (the session being
+// forwarded) can change over time. Sequence numbers and timestamps are
+// rewritten so that switching source looks like a single continuous stream
+// to the receiver and doesn't require any renegotiation.
+type audioSlotTrack struct {
+	*webrtc.TrackLocalStaticRTP
+
+	mut sync.Mutex
+	// sourceID is the ID of the session currently being forwarded. Empty if
+	// the slot is idle.
+	sourceID string
+	// resync is set when the source changed and offsets need to be recomputed
+	// on the next packet.
+	resync    bool
+	started   bool
+	seqOffset uint16
+	tsOffset  uint32
+	// baseSeq is the first (rewritten) sequence number sent since the last
+	// source change.
+	baseSeq     uint16
+	lastSeq     uint16
+	lastTS      uint32
+	lastWriteAt time.Time
+}
+
+func newAudioSlotTrack(receiverID string) (*audioSlotTrack, error) {
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(rtpAudioCodec, genTrackID(trackTypeVoice, receiverID), random.NewID())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local track: %w", err)
+	}
+
+	return &audioSlotTrack{
+		TrackLocalStaticRTP: localTrack,
+	}, nil
+}
+
+func (t *audioSlotTrack) getSourceID() string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.sourceID
+}
+
+func (t *audioSlotTrack) setSourceID(sourceID string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.sourceID != sourceID {
+		t.sourceID = sourceID
+		t.resync = true
+	}
+}
+
+// rewriteRTP returns the packet to forward for the given incoming packet sent
+// by the given session or false if it should be dropped.
+func (t *audioSlotTrack) rewriteRTP(pkt *rtp.Packet, sourceID string) (rtp.Packet, bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if sourceID == "" || sourceID != t.sourceID {
+		return rtp.Packet{}, false
+	}
+
+	if t.resync {
+		t.resync = false
+		if t.started {
+			// We make it look like the new source's packets follow the last ones sent.
+			elapsed := uint32(time.Since(t.lastWriteAt).Seconds() * float64(rtpAudioCodec.ClockRate))
+			if elapsed == 0 {
+				elapsed = 1
+			}
+			t.seqOffset = t.lastSeq + 1 - pkt.SequenceNumber
+			t.tsOffset = t.lastTS + elapsed - pkt.Timestamp
+			t.baseSeq = t.lastSeq + 1
+		}
+	}
+
+	out := *pkt
+	out.SequenceNumber = pkt.SequenceNumber + t.seqOffset
+	out.Timestamp = pkt.Timestamp + t.tsOffset
+
+	if !t.started {
+		t.started = true
+		t.baseSeq = out.SequenceNumber
+		t.lastSeq = out.SequenceNumber
+		t.lastTS = out.Timestamp
+		t.lastWriteAt = time.Now()
+		return out, true
+	}
+
+	// Late packets from before the switch would collide with the ones
+	// already sent from the previous source.
+	if int16(out.SequenceNumber-t.baseSeq) < 0 {
+		return rtp.Packet{}, false
+	}
+
+	if int16(out.SequenceNumber-t.lastSeq) > 0 {
+		t.lastSeq = out.SequenceNumber
+		t.lastTS = out.Timestamp
+		t.lastWriteAt = time.Now()
+	}
+
+	return out, true
+}
+
+// writeRTP forwards the given packet, sent by the given session, if it's the
+// slot's current source.
+func (t *audioSlotTrack) writeRTP(pkt *rtp.Packet, sourceID string) error {
+	out, ok := t.rewriteRTP(pkt, sourceID)
+	if !ok {
+		return nil
+	}
+	return t.TrackLocalStaticRTP.WriteRTP(&out)
+}
+
+type speakerActivity struct {
+	// loudness is the average loudness of the speaker's voice samples.
+	loudness float64
+	// lastActiveAt is the time of the last voice (non silent) sample.
+	lastActiveAt time.Time
+}
+
+// audioSlots keeps track of the speakers in a call in order to map the most
+// relevant ones into each session's audio slots.
+type audioSlots struct {
+	size int
+	now  func() time.Time
+
+	mut      sync.RWMutex
+	speakers map[string]*speakerActivity
+	// sources maps the ID of each session being forwarded to the slots
+	// forwarding it.
+	sources      map[string][]*audioSlotTrack
+	lastUpdateAt time.Time
+}
+
+// newAudioSlots returns nil if size is zero, meaning all voice tracks should
+// be forwarded.
+func newAudioSlots(size int) *audioSlots {
+	if size <= 0 {
+		return nil
+	}
+
+	return &audioSlots{
+		size:     size,
+		now:      time.Now,
+		speakers: make(map[string]*speakerActivity),
+		sources:  make(map[string][]*audioSlotTrack),
+	}
+}
+
+// pushAudioLevel records an audio level sample for the given speaker and
+// returns whether slots are due to be reassigned.
+func (a *audioSlots) pushAudioLevel(speakerID string, level uint8) bool {
+	now := a.now()
+
+	var loudness float64
+	if level < audioSlotsNoiseLevel {
+		loudness = float64(audioSlotsNoiseLevel - level)
+	}
+
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	sp := a.speakers[speakerID]
+	if sp == nil {
+		sp = &speakerActivity{}
+		a.speakers[speakerID] = sp
+	}
+	sp.loudness += (loudness - sp.loudness) * audioSlotsLoudnessWeight
+	if loudness > 0 {
+		sp.lastActiveAt = now
+	}
+
+	if now.Sub(a.lastUpdateAt) < audioSlotsUpdateInterval {
+		return false
+	}
+	a.lastUpdateAt = now
+
+	return true
+}
+
+// rank returns the IDs of the speakers that should be forwarded, in order of
+// relevance: first the ones actively speaking (loudest first) followed by the
+// most recently active ones. Speakers that never spoke are left out.
+// NOTE: this is expected to be called under lock.
+func (a *audioSlots) rank() []string {
+	now := a.now()
+
+	var active, inactive []string
+	for speakerID, sp := range a.speakers {
+		if sp.lastActiveAt.IsZero() {
+			continue
+		}
+		if now.Sub(sp.lastActiveAt) <= audioSlotsActiveWindow {
+			active = append(active, speakerID)
+		} else {
+			inactive = append(inactive, speakerID)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		li, lj := a.speakers[active[i]].loudness, a.speakers[active[j]].loudness
+		if li != lj {
+			return li > lj
+		}
+		return active[i] < active[j]
+	})
+
+	sort.Slice(inactive, func(i, j int) bool {
+		ti, tj := a.speakers[inactive[i]].lastActiveAt, a.speakers[inactive[j]].lastActiveAt
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return inactive[i] < inactive[j]
+	})
+
+	ranking := append(active, inactive...)
+	// One extra speaker is needed as receivers skip themselves.
+	if len(ranking) > a.size+1 {
+		ranking = ranking[:a.size+1]
+	}
+
+	return ranking
+}
+
+// setSlotSource changes the source of the given slot, keeping the sources
+// map in sync.
+// NOTE: this is expected to be called under lock.
+func (a *audioSlots) setSlotSource(slot *audioSlotTrack, sourceID string) {
+	if prevID := slot.getSourceID(); prevID != "" {
+		slots := a.sources[prevID]
+		for i := range slots {
+			if slots[i] == slot {
+				slots = append(slots[:i], slots[i+1:]...)
+				break
+			}
+		}
+		if len(slots) == 0 {
+			delete(a.sources, prevID)
+		} else {
+			a.sources[prevID] = slots
+		}
+	}
+
+	if sourceID != "" {
+		a.sources[sourceID] = append(a.sources[sourceID], slot)
+	}
+
+	slot.setSourceID(sourceID)
+}
+
+// assign maps the highest ranked speakers (other than the receiver itself)
+// into the receiver's slots. Speakers already forwarded keep their slot.
+// It returns whether any slot changed.
+// NOTE: this is expected to be called under lock.
+func (a *audioSlots) assign(receiverID string, slots []*audioSlotTrack, ranking []string) bool {
+	wanted := make(map[string]bool, len(slots))
+	var candidates []string
+	for _, speakerID := range ranking {
+		if len(candidates) == len(slots) {
+			break
+		}
+		if speakerID == receiverID {
+			continue
+		}
+		wanted[speakerID] = true
+		candidates = append(candidates, speakerID)
+	}
+
+	assigned := make(map[string]bool, len(slots))
+	for _, slot := range slots {
+		if sourceID := slot.getSourceID(); wanted[sourceID] {
+			assigned[sourceID] = true
+		}
+	}
+
+	var changed bool
+	for _, slot := range slots {
+		sourceID := slot.getSourceID()
+		if wanted[sourceID] {
+			continue
+		}
+
+		var nextID string
+		for _, speakerID := range candidates {
+			if !assigned[speakerID] {
+				nextID = speakerID
+				break
+			}
+		}
+
+		if nextID == sourceID {
+			continue
+		}
+
+		if nextID != "" {
+			assigned[nextID] = true
+		}
+		a.setSlotSource(slot, nextID)
+		changed = true
+	}
+
+	return changed
+}
+
+// removeSession clears any state associated with the given session and
+// returns the slots that stopped forwarding it.
+// NOTE: this is expected to be called under lock.
+func (a *audioSlots) removeSession(sessionID string, slots []*audioSlotTrack) []*audioSlotTrack {
+	delete(a.speakers, sessionID)
+
+	for _, slot := range slots {
+		a.setSlotSource(slot, "")
+	}
+
+	cleared := a.sources[sessionID]
+	for _, slot := range cleared {
+		slot.setSourceID("")
+	}
+	delete(a.sources, sessionID)
+
+	return cleared
+}
+
+func getAudioSlotsMessage(slots []*audioSlotTrack) dc.MessageAudioSlots {
+	msg := make(dc.MessageAudioSlots, len(slots))
+	for _, slot := range slots {
+		msg[slot.ID()] = slot.getSourceID()
+	}
+	return msg
+}
+
+// forwardsVoice returns whether voice tracks are forwarded as they are to the
+// given receiver rather than through audio slots. Outgoing relays get all of
+// them so that the remote node can make its own selection.
+func (c *call) forwardsVoice(receiver *session) bool {
+	return c.audioSlots == nil || receiver.relayDirection() == RelayDirectionOut
+}
+
+func (c *call) newAudioSlotTracks(cfg SessionConfig, log mlog.LoggerIFace) []*audioSlotTrack {
+	// Relays don't need slots as they either get all the voice tracks or none.
+	// WHIP sessions don't receive anything.
+	if c.audioSlots == nil || cfg.Props.RelayDirection() != "" || cfg.Props.WHIP() {
+		return nil
+	}
+
+	receiverID := cfg.SessionID
+
+	// WHEP players expect a single audio track.
+	size := c.audioSlots.size
+	if cfg.Props.WHEP() {
+		size = 1
+	}
+
+	slots := make([]*audioSlotTrack, 0, size)
+	for i := 0; i < size; i++ {
+		slot, err := newAudioSlotTrack(receiverID)
+		if err != nil {
+			log.Error("failed to create audio slot", mlog.Err(err), mlog.String("sessionID", receiverID))
+			continue
+		}
+		slots = append(slots, slot)
+	}
+
+	return slots
+}
+
+// pushSpeakerLevel feeds the audio slots selection with the given audio
+// level sent by the session, reassigning slots if due.
+func (c *call) pushSpeakerLevel(sessionID string, level uint8) {
+	if c.audioSlots == nil || !c.audioSlots.pushAudioLevel(sessionID, level) {
+		return
+	}
+	c.updateAudioSlots()
+}
+
+// updateAudioSlots maps the highest ranked speakers into each session's audio
+// slots, notifying the sessions whose slots changed.
+func (c *call) updateAudioSlots() {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	c.audioSlots.mut.Lock()
+	ranking := c.audioSlots.rank()
+	changed := make(map[*session]dc.MessageAudioSlots)
+	for _, ss := range c.sessions {
+		if c.audioSlots.assign(ss.cfg.SessionID, ss.audioSlots, ranking) {
+			changed[ss] = getAudioSlotsMessage(ss.audioSlots)
+		}
+	}
+	c.audioSlots.mut.Unlock()
+
+	for ss, msg := range changed {
+		if err := ss.sendDCMessage(dc.MessageTypeAudioSlots, msg); err != nil {
+			ss.log.Debug("failed to send audio slots message", mlog.Err(err), mlog.String("sessionID", ss.cfg.SessionID))
+		}
+	}
+}
+
+// writeAudioSlots forwards the given voice packet, sent by the given session,
+// to all the slots currently forwarding it.
+func (c *call) writeAudioSlots(sourceID string, pkt *rtp.Packet) error {
+	c.audioSlots.mut.RLock()
+	defer c.audioSlots.mut.RUnlock()
+
+	for _, slot := range c.audioSlots.sources[sourceID] {
+		if err := slot.writeRTP(pkt, sourceID); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// clearAudioSlots removes the closing session from the audio slots selection.
+// NOTE: this is expected to be called under call lock.
+func (c *call) clearAudioSlots(us *session) {
+	c.removeAudioSlotsSpeaker(us.cfg.SessionID, us.audioSlots)
+}
+
+// removeAudioSlotsSpeaker removes the given speaker, along with its own slots
+// if any, from the audio slots selection.
+// NOTE: this is expected to be called under call lock.
+func (c *call) removeAudioSlotsSpeaker(sessionID string, slots []*audioSlotTrack) {
+	if c.audioSlots == nil {
+		return
+	}
+
+	c.audioSlots.mut.Lock()
+	cleared := c.audioSlots.removeSession(sessionID, slots)
+	c.audioSlots.mut.Unlock()
+
+	// The freed slots will be reassigned on the next update but we let
+	// receivers know right away the speaker is gone.
+	for _, ss := range c.sessions {
+		if ss.cfg.SessionID == sessionID {
+			continue
+		}
+		for _, slot := range ss.audioSlots {
+			if !containsSlot(cleared, slot) {
+				continue
+			}
+			if err := ss.sendDCMessage(dc.MessageTypeAudioSlots, getAudioSlotsMessage(ss.audioSlots)); err != nil {
+				ss.log.Debug("failed to send audio slots message", mlog.Err(err), mlog.String("sessionID", ss.cfg.SessionID))
+			}
+			break
+		}
+	}
+}
+
+func containsSlot(slots []*audioSlotTrack, slot *audioSlotTrack) bool {
+	for _, s := range slots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAudioSlotTrack(t *testing.T) *audioSlotTrack {
+	t.Helper()
+
+	slot, err := newAudioSlotTrack("receiverID")
+	require.NoError(t, err)
+	require.NotNil(t, slot)
+	require.True(t, isValidTrackID(slot.ID()))
+
+	return slot
+}
+
+func newTestOpusPacket(seq uint16, ts uint32) *rtp.Packet {
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+		},
+		Payload: []byte{0x78, 0x01, 0x02},
+	}
+}
+
+func TestAudioSlotTrackRewriteRTP(t *testing.T) {
+	t.Run("idle", func(t *testing.T) {
+		slot := newTestAudioSlotTrack(t)
+		_, ok := slot.rewriteRTP(newTestOpusPacket(100, 1000), "sessionA")
+		require.False(t, ok)
+		_, ok = slot.rewriteRTP(newTestOpusPacket(100, 1000), "")
+		require.False(t, ok)
+	})
+
+	t.Run("switch source", func(t *testing.T) {
+		slot := newTestAudioSlotTrack(t)
+		slot.setSourceID("sessionA")
+
+		for i := 0; i < 10; i++ {
+			pkt, ok := slot.rewriteRTP(newTestOpusPacket(uint16(100+i), uint32(1000+i*960)), "sessionA")
+			require.True(t, ok)
+			require.Equal(t, uint16(100+i), pkt.SequenceNumber)
+			require.Equal(t, uint32(1000+i*960), pkt.Timestamp)
+		}
+
+		// Other sources are dropped.
+		_, ok := slot.rewriteRTP(newTestOpusPacket(5000, 50000), "sessionB")
+		require.False(t, ok)
+
+		slot.setSourceID("sessionB")
+		require.Equal(t, "sessionB", slot.getSourceID())
+
+		// Previous source is now dropped.
+		_, ok = slot.rewriteRTP(newTestOpusPacket(110, 10600), "sessionA")
+		require.False(t, ok)
+
+		// New source continues from the last packet sent.
+		pkt, ok := slot.rewriteRTP(newTestOpusPacket(5000, 50000), "sessionB")
+		require.True(t, ok)
+		require.Equal(t, uint16(110), pkt.SequenceNumber)
+		require.Greater(t, pkt.Timestamp, uint32(1000+9*960))
+		switchTS := pkt.Timestamp
+
+		pkt, ok = slot.rewriteRTP(newTestOpusPacket(5001, 50960), "sessionB")
+		require.True(t, ok)
+		require.Equal(t, uint16(111), pkt.SequenceNumber)
+		require.Equal(t, switchTS+960, pkt.Timestamp)
+
+		// Late packets from before the switch are dropped.
+		_, ok = slot.rewriteRTP(newTestOpusPacket(4999, 49040), "sessionB")
+		require.False(t, ok)
+	})
+}
+
+func TestAudioSlots(t *testing.T) {
+	require.Nil(t, newAudioSlots(0))
+
+	setup := func(t *testing.T, size int) (*audioSlots, *time.Time) {
+		t.Helper()
+		a := newAudioSlots(size)
+		require.NotNil(t, a)
+		now := time.Now()
+		a.now = func() time.Time { return now }
+		return a, &now
+	}
+
+	t.Run("update interval", func(t *testing.T) {
+		a, now := setup(t, 2)
+		require.True(t, a.pushAudioLevel("sessionA", 30))
+		require.False(t, a.pushAudioLevel("sessionA", 30))
+		*now = now.Add(audioSlotsUpdateInterval)
+		require.True(t, a.pushAudioLevel("sessionA", 30))
+	})
+
+	t.Run("rank", func(t *testing.T) {
+		a, now := setup(t, 2)
+		require.Empty(t, a.rank())
+
+		// Silent speakers are left out.
+		a.pushAudioLevel("sessionA", 127)
+		require.Empty(t, a.rank())
+
+		a.pushAudioLevel("sessionB", 50)
+		*now = now.Add(2 * audioSlotsActiveWindow)
+		a.pushAudioLevel("sessionC", 40)
+		*now = now.Add(2 * audioSlotsActiveWindow)
+		a.pushAudioLevel("sessionD", 60)
+		a.pushAudioLevel("sessionE", 10)
+
+		// Active speakers first (loudest first), then the most recently active,
+		// capped to the number of slots plus one.
+		require.Equal(t, []string{"sessionE", "sessionD", "sessionC"}, a.rank())
+
+		*now = now.Add(2 * audioSlotsActiveWindow)
+		a.pushAudioLevel("sessionB", 50)
+		require.Equal(t, []string{"sessionB", "sessionD", "sessionE"}, a.rank())
+	})
+
+	t.Run("assign", func(t *testing.T) {
+		a, _ := setup(t, 2)
+		slots := []*audioSlotTrack{newTestAudioSlotTrack(t), newTestAudioSlotTrack(t)}
+
+		require.False(t, a.assign("sessionA", slots, nil))
+		require.Empty(t, slots[0].getSourceID())
+		require.Empty(t, slots[1].getSourceID())
+
+		// Receivers don't get their own voice.
+		require.True(t, a.assign("sessionA", slots, []string{"sessionA", "sessionB"}))
+		require.Equal(t, "sessionB", slots[0].getSourceID())
+		require.Empty(t, slots[1].getSourceID())
+		require.Equal(t, []*audioSlotTrack{slots[0]}, a.sources["sessionB"])
+
+		require.True(t, a.assign("sessionA", slots, []string{"sessionC", "sessionA", "sessionB"}))
+		require.Equal(t, "sessionB", slots[0].getSourceID())
+		require.Equal(t, "sessionC", slots[1].getSourceID())
+		require.False(t, a.assign("sessionA", slots, []string{"sessionB", "sessionC"}))
+
+		// Speakers still selected keep their slot.
+		require.True(t, a.assign("sessionA", slots, []string{"sessionD", "sessionC", "sessionB"}))
+		require.Equal(t, "sessionD", slots[0].getSourceID())
+		require.Equal(t, "sessionC", slots[1].getSourceID())
+		require.Empty(t, a.sources["sessionB"])
+		require.Equal(t, []*audioSlotTrack{slots[0]}, a.sources["sessionD"])
+		require.Equal(t, []*audioSlotTrack{slots[1]}, a.sources["sessionC"])
+	})
+
+	t.Run("remove session", func(t *testing.T) {
+		a, _ := setup(t, 1)
+		a.pushAudioLevel("sessionA", 30)
+		slotsA := []*audioSlotTrack{newTestAudioSlotTrack(t)}
+		slotsB := []*audioSlotTrack{newTestAudioSlotTrack(t)}
+
+		require.True(t, a.assign("sessionA", slotsA, []string{"sessionA", "sessionB"}))
+		require.True(t, a.assign("sessionB", slotsB, []string{"sessionA", "sessionB"}))
+		require.Equal(t, "sessionB", slotsA[0].getSourceID())
+		require.Equal(t, "sessionA", slotsB[0].getSourceID())
+
+		cleared := a.removeSession("sessionA", slotsA)
+		require.Equal(t, slotsB, cleared)
+		require.Empty(t, slotsA[0].getSourceID())
+		require.Empty(t, slotsB[0].getSourceID())
+		require.Empty(t, a.sources)
+		require.Empty(t, a.speakers)
+	})
+}
+
+func TestCallAudioSlots(t *testing.T) {
+	c, sA, sB := newTestCall(t, 1)
+	require.Nil(t, c.audioSlots)
+	require.Empty(t, sA.audioSlots)
+	require.Empty(t, sB.audioSlots)
+
+	c.audioSlots = newAudioSlots(2)
+	sC, ok := c.addSession(SessionConfig{GroupID: "groupID", CallID: c.id, UserID: "userC", SessionID: "sessionC"}, nil, nil, sA.log)
+	require.True(t, ok)
+	require.Len(t, sC.audioSlots, 2)
+
+	c.pushSpeakerLevel("sessionA", 30)
+	require.Equal(t, "sessionA", sC.audioSlots[0].getSourceID())
+	require.Empty(t, sC.audioSlots[1].getSourceID())
+
+	select {
+	case msg := <-sC.dcMsgCh:
+		require.NotEmpty(t, msg)
+	default:
+		require.Fail(t, "audio slots message expected")
+	}
+
+	c.clearAudioSlots(sA)
+	require.Empty(t, sC.audioSlots[0].getSourceID())
+	require.Len(t, sC.dcMsgCh, 1)
+}
@@ -6,19 +6,37 @@ package rtc
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pion/webrtc/v3"
 	"golang.org/x/time/rate"
 
+	"github.com/mattermost/rtcd/service/rtc/vad"
+
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
 )
 
 type call struct {
-	id            string
-	sessions      map[string]*session
-	screenSession *session
-	pliLimiters   map[webrtc.SSRC]*rate.Limiter
-	metrics       Metrics
+	id       string
+	sessions map[string]*session
+	// screenStreams maps the ID of each active screen sharing stream to the
+	// session publishing it.
+	screenStreams   map[string]*session
+	maxScreenShares int
+	// simulcastLevels holds the configured simulcast levels, sorted by rate.
+	simulcastLevels SimulcastLevels
+	pliLimiters     map[webrtc.SSRC]*rate.Limiter
+	// dominantSpeaker ranks the call's speakers based on the audio levels
+	// they send.
+	dominantSpeaker *vad.DominantSpeakerDetector
+	// audioSlots selects the speakers forwarded to each session when the
+	// number of audio slots is limited. Nil means all voice tracks are
+	// forwarded.
+	audioSlots *audioSlots
+	// recorder is set while the call is being recorded.
+	recorder atomic.Pointer[callRecorder]
+	metrics  Metrics
+	events   *eventQueue
 
 	mut sync.RWMutex
 }
@@ -29,6 +47,40 @@ func (c *call) getSession(sessionID string) *session {
 	return c.sessions[sessionID]
 }
 
+func (c *call) initDominantSpeaker(log mlog.LoggerIFace, msgCh chan<- Message) error {
+	detector, err := vad.NewDominantSpeakerDetector((vad.DominantSpeakerConfig{}).SetDefaults(), func(sessionID string) {
+		log.Debug("dominant speaker", mlog.String("callID", c.id), mlog.String("sessionID", sessionID))
+
+		// HTTP sessions have no signaling channel to be notified on.
+		s := c.getSession(sessionID)
+		if s == nil || s.hasHTTPSignaling() {
+			return
+		}
+
+		select {
+		case msgCh <- newMessage(s, DominantSpeakerMessage, nil):
+		default:
+			log.Error("failed to send dominant speaker message: channel is full")
+		}
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create dominant speaker detector: %w", err)
+	}
+
+	c.dominantSpeaker = detector
+
+	return nil
+}
+
+// pushAudioLevel feeds the dominant speaker detection with the given audio
+// level sent by the session.
+func (c *call) pushAudioLevel(sessionID string, level uint8) {
+	if c.dominantSpeaker == nil {
+		return
+	}
+	c.dominantSpeaker.PushAudioLevel(sessionID, level)
+}
+
 func (c *call) addSession(cfg SessionConfig, rtcConn *webrtc.PeerConnection, closeCb func() error, log mlog.LoggerIFace) (*session, bool) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
@@ -37,41 +89,65 @@ func (c *call) addSession(cfg SessionConfig, rtcConn *webrtc.PeerConnection, clo
 	}
 
 	s := &session{
-		cfg:                cfg,
-		rtcConn:            rtcConn,
-		iceInCh:            make(chan []byte, signalChSize*2),
-		sdpOfferInCh:       make(chan offerMessage, signalChSize),
-		sdpAnswerInCh:      make(chan webrtc.SessionDescription, signalChSize),
-		dcSDPCh:            make(chan Message, signalChSize),
-		closeCh:            make(chan struct{}),
-		closeCb:            closeCb,
-		doneCh:             make(chan struct{}),
-		tracksCh:           make(chan trackActionContext, tracksChSize),
-		outScreenTracks:    make(map[string][]*webrtc.TrackLocalStaticRTP),
-		remoteScreenTracks: make(map[string]*webrtc.TrackRemote),
-		screenRateMonitors: make(map[string]*RateMonitor),
-		log:                log,
-		call:               c,
+		cfg:             cfg,
+		rtcConn:         rtcConn,
+		iceInCh:         make(chan []byte, signalChSize*2),
+		iceRestartCh:    make(chan struct{}, 1),
+		sdpOfferInCh:    make(chan offerMessage, signalChSize),
+		sdpAnswerInCh:   make(chan webrtc.SessionDescription, signalChSize),
+		dcSDPCh:         make(chan Message, signalChSize),
+		dcMsgCh:         make(chan []byte, signalChSize),
+		closeCh:         make(chan struct{}),
+		closeCb:         closeCb,
+		doneCh:          make(chan struct{}),
+		tracksCh:        make(chan trackActionContext, tracksChSize),
+		screenStreamIDs: make(map[string]bool),
+		streams:         make(map[string]*mediaStream),
+		streamSenders:   make(map[string]*webrtc.RTPSender),
+		statsSamples:    make(map[uint32]trackStatsSample),
+		audioSlots:      c.newAudioSlotTracks(cfg, log),
+		log:             log,
+		call:            c,
+	}
+
+	if r := c.recorder.Load(); r != nil {
+		r.addParticipant(s)
 	}
 
 	c.sessions[cfg.SessionID] = s
 	return s, true
 }
 
-func (c *call) getScreenSession() *session {
-	c.mut.RLock()
-	defer c.mut.RUnlock()
-	return c.screenSession
-}
-
-func (c *call) setScreenSession(s *session) bool {
+// addScreenStream registers a new screen sharing stream published by the
+// given session, failing if the call has reached the maximum number of
+// concurrent screen shares.
+func (c *call) addScreenStream(s *session, streamID string) error {
 	c.mut.Lock()
 	defer c.mut.Unlock()
-	if c.screenSession == nil {
-		c.screenSession = s
-		return true
+
+	if streamID == "" {
+		return fmt.Errorf("streamID should not be empty")
+	}
+
+	if c.screenStreams[streamID] != nil {
+		return fmt.Errorf("screen stream %s is already set", streamID)
+	}
+
+	if len(c.screenStreams) >= c.maxScreenShares {
+		return fmt.Errorf("max number of screen shares (%d) reached", c.maxScreenShares)
 	}
-	return false
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.streams[streamID] != nil {
+		return fmt.Errorf("stream %s is already in use", streamID)
+	}
+
+	s.streams[streamID] = newMediaStream(streamID, s.cfg.SessionID, trackTypeScreen)
+	c.screenStreams[streamID] = s
+
+	return nil
 }
 
 func (c *call) iterSessions(cb func(s *session)) {
@@ -82,7 +158,10 @@ func (c *call) iterSessions(cb func(s *session)) {
 	}
 }
 
-func (c *call) clearScreenState(screenSession *session) error {
+// clearScreenState stops the given screen sharing stream, removing it from
+// any receiving session. If streamID is empty all the screen streams
+// published by screenSession are stopped.
+func (c *call) clearScreenState(screenSession *session, streamID string) error {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
@@ -90,27 +169,70 @@ func (c *call) clearScreenState(screenSession *session) error {
 		return fmt.Errorf("screenSession should not be nil")
 	}
 
-	if c.screenSession == nil {
-		return fmt.Errorf("call.screenSession should not be nil")
+	screenSession.mut.Lock()
+	if streamID != "" {
+		delete(screenSession.screenStreamIDs, streamID)
+	} else {
+		screenSession.screenStreamIDs = make(map[string]bool)
 	}
+	screenSession.mut.Unlock()
 
-	if c.screenSession != screenSession {
-		return fmt.Errorf("screenSession mismatch, call.screenSession=%s, screenSession=%s",
-			c.screenSession.cfg.SessionID, screenSession.cfg.SessionID)
+	var streamIDs []string
+	if streamID != "" {
+		s := c.screenStreams[streamID]
+		if s == nil {
+			return fmt.Errorf("screen stream %s not found", streamID)
+		}
+		if s != screenSession {
+			return fmt.Errorf("screenSession mismatch, streamID=%s, screenSession=%s, sessionID=%s",
+				streamID, s.cfg.SessionID, screenSession.cfg.SessionID)
+		}
+		streamIDs = append(streamIDs, streamID)
+	} else {
+		for id, s := range c.screenStreams {
+			if s == screenSession {
+				streamIDs = append(streamIDs, id)
+			}
+		}
+		if len(streamIDs) == 0 {
+			return fmt.Errorf("no screen stream found for session %s", screenSession.cfg.SessionID)
+		}
+	}
+
+	screenSession.mut.Lock()
+	for _, id := range streamIDs {
+		if ms := screenSession.streams[id]; ms != nil {
+			for _, remoteTrack := range ms.remoteTracks {
+				delete(c.pliLimiters, remoteTrack.SSRC())
+			}
+		}
+		delete(screenSession.streams, id)
+		delete(c.screenStreams, id)
+	}
+	screenSession.mut.Unlock()
+
+	for _, id := range streamIDs {
+		c.events.send(newScreenEvent(ScreenOffEvent, screenSession.cfg, id))
 	}
 
 	for _, s := range c.sessions {
+		if s == screenSession {
+			continue
+		}
+
 		s.mut.Lock()
-		if s == c.screenSession {
-			s.clearScreenState()
-			c.screenSession = nil
-		} else if s.screenTrackSender != nil {
+		for _, id := range streamIDs {
+			key := getStreamSenderKey(screenSession.cfg.SessionID, id)
+			sender := s.streamSenders[key]
+			if sender == nil {
+				continue
+			}
 			select {
-			case s.tracksCh <- trackActionContext{action: trackActionRemove, track: s.screenTrackSender.Track()}:
+			case s.tracksCh <- trackActionContext{action: trackActionRemove, track: sender.Track()}:
 			default:
 				s.log.Error("failed to send screen track: channel is full", mlog.String("sessionID", s.cfg.SessionID))
 			}
-			s.screenTrackSender = nil
+			delete(s.streamSenders, key)
 		}
 		s.mut.Unlock()
 	}
@@ -118,12 +240,109 @@ func (c *call) clearScreenState(screenSession *session) error {
 	return nil
 }
 
+// clearVideoTrack cleans up the state associated with a (camera or relayed)
+// video track published by the given session. Receiving sessions forwarded
+// that track are switched to another level of the stream if available,
+// otherwise the stream is removed from them.
+func (c *call) clearVideoTrack(us *session, streamID, trackIdx string) {
+	c.mut.Lock()
+
+	us.mut.Lock()
+	ms := us.streams[streamID]
+	// Screen streams are cleared explicitly through clearScreenState unless
+	// coming from a relay.
+	if ms == nil || (ms.trackType != trackTypeVideo && us.relayDirection() != RelayDirectionIn) {
+		us.mut.Unlock()
+		c.mut.Unlock()
+		return
+	}
+	remoteTrack := ms.remoteTracks[trackIdx]
+	if remoteTrack != nil {
+		delete(c.pliLimiters, remoteTrack.SSRC())
+	}
+	delete(ms.remoteTracks, trackIdx)
+	delete(ms.rateMonitors, trackIdx)
+	if len(ms.remoteTracks) == 0 {
+		delete(us.streams, streamID)
+	}
+
+	// Receivers of the track getting cleared are either switched to the
+	// remaining level (if any) or have the stream removed.
+	var keyFrameTracks []*webrtc.TrackRemote
+	removedTracks := map[string]bool{}
+	for _, outTrack := range ms.getOutTracks() {
+		if remoteTrack == nil || outTrack.mimeType != remoteTrack.Codec().MimeType {
+			continue
+		}
+		if getTrackIndex(outTrack.mimeType, outTrack.getLevel()) != trackIdx &&
+			getTrackIndex(outTrack.mimeType, outTrack.getTargetLevel()) != trackIdx {
+			continue
+		}
+
+		var newTrack *webrtc.TrackRemote
+		for i := len(c.simulcastLevels) - 1; i >= 0; i-- {
+			level := c.simulcastLevels[i].RID
+			if track := ms.remoteTracks[getTrackIndex(outTrack.mimeType, level)]; track != nil {
+				newTrack = track
+				outTrack.setTargetLevel(level)
+				break
+			}
+		}
+
+		if newTrack != nil {
+			keyFrameTracks = append(keyFrameTracks, newTrack)
+		} else {
+			removedTracks[outTrack.ID()] = true
+			ms.removeOutTrack(outTrack)
+		}
+	}
+	us.mut.Unlock()
+
+	senderKey := getStreamSenderKey(ms.publisherID, streamID)
+	for _, ss := range c.sessions {
+		if ss == us {
+			continue
+		}
+
+		ss.mut.Lock()
+		if sender := ss.streamSenders[senderKey]; sender != nil {
+			if track := sender.Track(); track != nil && removedTracks[track.ID()] {
+				select {
+				case ss.tracksCh <- trackActionContext{action: trackActionRemove, track: track}:
+				default:
+					ss.log.Error("failed to send video track: channel is full", mlog.String("sessionID", ss.cfg.SessionID))
+				}
+				delete(ss.streamSenders, senderKey)
+			}
+		}
+		ss.mut.Unlock()
+	}
+
+	c.mut.Unlock()
+
+	for _, track := range keyFrameTracks {
+		if err := us.requestKeyFrame(track); err != nil {
+			us.log.Error("failed to request keyframe", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+		}
+	}
+}
+
 // handleSessionClose cleans up resources such as senders or receivers for the
 // closing session.
 // NOTE: this is expected to always be called under lock (call.mut).
 func (c *call) handleSessionClose(us *session) {
 	us.log.Debug("handleSessionClose", mlog.String("sessionID", us.cfg.SessionID))
 
+	if c.dominantSpeaker != nil {
+		c.dominantSpeaker.RemoveSpeaker(us.cfg.SessionID)
+	}
+
+	c.clearAudioSlots(us)
+
+	if r := c.recorder.Load(); r != nil {
+		r.removeParticipant(us.cfg.SessionID)
+	}
+
 	us.mut.Lock()
 	defer us.mut.Unlock()
 
@@ -152,16 +371,28 @@ func (c *call) handleSessionClose(us *session) {
 
 	// If the session getting closed was screen sharing we need to do some extra
 	// cleanup.
-	if us == c.screenSession {
-		c.screenSession = nil
-		for _, ss := range c.sessions {
-			if ss.cfg.SessionID == us.cfg.SessionID {
-				continue
+	for streamID, ss := range c.screenStreams {
+		if ss == us {
+			delete(c.screenStreams, streamID)
+		}
+	}
+
+	// Any stream (screen or camera) the closing session was publishing is going
+	// away and the ones it was receiving no longer need to be forwarded to it.
+	for _, ss := range c.sessions {
+		if ss.cfg.SessionID == us.cfg.SessionID {
+			continue
+		}
+		ss.mut.Lock()
+		for streamID, ms := range us.streams {
+			delete(ss.streamSenders, getStreamSenderKey(ms.publisherID, streamID))
+		}
+		for _, ms := range ss.streams {
+			if outTrack := ms.getOutTrack(us.cfg.SessionID); outTrack != nil {
+				ms.removeOutTrack(outTrack)
 			}
-			ss.mut.Lock()
-			ss.screenTrackSender = nil
-			ss.mut.Unlock()
 		}
+		ss.mut.Unlock()
 	}
 
 	// First we cleanup any track the closing session may have been receiving and stop
@@ -182,11 +413,11 @@ func (c *call) handleSessionClose(us *session) {
 	if us.outVoiceTrack != nil {
 		outTracks[us.outVoiceTrack.ID()] = true
 	}
-	if us.outScreenAudioTrack != nil {
-		outTracks[us.outScreenAudioTrack.ID()] = true
-	}
-	for _, tracks := range us.outScreenTracks {
-		for _, track := range tracks {
+	for _, ms := range us.streams {
+		if ms.outAudioTrack != nil {
+			outTracks[ms.outAudioTrack.ID()] = true
+		}
+		for _, track := range ms.getOutTracks() {
 			outTracks[track.ID()] = true
 		}
 	}
@@ -213,13 +444,14 @@ func (c *call) handleSessionClose(us *session) {
 					mlog.String("sessionID", ss.cfg.SessionID),
 					mlog.String("trackID", track.ID()),
 				)
-				// If it's a screen sharing track we should remove it as we normally would when
-				// sharing ends.
-				if track.Kind() == webrtc.RTPCodecTypeVideo {
+				// If it's a video (screen or camera) track we should remove it as we normally
+				// would when sharing ends. WHEP senders are never stopped as they
+				// can't be renegotiated.
+				if track.Kind() == webrtc.RTPCodecTypeVideo || ss.isWHEP() {
 					select {
 					case ss.tracksCh <- trackActionContext{action: trackActionRemove, track: track}:
 					default:
-						ss.log.Error("failed to send screen track: channel is full", mlog.String("sessionID", ss.cfg.SessionID))
+						ss.log.Error("failed to send video track: channel is full", mlog.String("sessionID", ss.cfg.SessionID))
 					}
 				} else {
 					cleanUp(ss.cfg.SessionID, sender, track)
@@ -0,0 +1,124 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+func newTestCall(t *testing.T, maxScreenShares int) (*call, *session, *session) {
+	t.Helper()
+
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, log.Shutdown())
+	})
+
+	c := &call{
+		id:              "callID",
+		sessions:        map[string]*session{},
+		screenStreams:   map[string]*session{},
+		maxScreenShares: maxScreenShares,
+		simulcastLevels: GetDefaultSimulcastLevels().sorted(),
+		pliLimiters:     map[webrtc.SSRC]*rate.Limiter{},
+		events:          newEventQueue(log, 0),
+	}
+
+	sA, ok := c.addSession(SessionConfig{GroupID: "groupID", CallID: c.id, UserID: "userA", SessionID: "sessionA"}, nil, nil, log)
+	require.True(t, ok)
+	sB, ok := c.addSession(SessionConfig{GroupID: "groupID", CallID: c.id, UserID: "userB", SessionID: "sessionB"}, nil, nil, log)
+	require.True(t, ok)
+
+	return c, sA, sB
+}
+
+func TestCallAddScreenStream(t *testing.T) {
+	t.Run("empty stream ID", func(t *testing.T) {
+		c, sA, _ := newTestCall(t, 1)
+		err := c.addScreenStream(sA, "")
+		require.EqualError(t, err, "streamID should not be empty")
+	})
+
+	t.Run("max screen shares", func(t *testing.T) {
+		c, sA, sB := newTestCall(t, 2)
+
+		require.NoError(t, c.addScreenStream(sA, "streamA"))
+		require.NoError(t, c.addScreenStream(sB, "streamB"))
+
+		err := c.addScreenStream(sA, "streamC")
+		require.EqualError(t, err, "max number of screen shares (2) reached")
+
+		require.Equal(t, trackTypeScreen, sA.getStreamType("streamA"))
+		require.Equal(t, trackTypeScreen, sB.getStreamType("streamB"))
+		require.Empty(t, sA.getStreamType("streamC"))
+	})
+
+	t.Run("duplicate stream", func(t *testing.T) {
+		c, sA, sB := newTestCall(t, 2)
+
+		require.NoError(t, c.addScreenStream(sA, "streamA"))
+		err := c.addScreenStream(sB, "streamA")
+		require.EqualError(t, err, "screen stream streamA is already set")
+	})
+}
+
+func TestCallClearScreenState(t *testing.T) {
+	t.Run("nil session", func(t *testing.T) {
+		c, _, _ := newTestCall(t, 1)
+		err := c.clearScreenState(nil, "")
+		require.EqualError(t, err, "screenSession should not be nil")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		c, sA, _ := newTestCall(t, 1)
+		err := c.clearScreenState(sA, "streamA")
+		require.EqualError(t, err, "screen stream streamA not found")
+
+		err = c.clearScreenState(sA, "")
+		require.EqualError(t, err, "no screen stream found for session sessionA")
+	})
+
+	t.Run("session mismatch", func(t *testing.T) {
+		c, sA, sB := newTestCall(t, 1)
+		require.NoError(t, c.addScreenStream(sA, "streamA"))
+
+		err := c.clearScreenState(sB, "streamA")
+		require.EqualError(t, err, "screenSession mismatch, streamID=streamA, screenSession=sessionA, sessionID=sessionB")
+	})
+
+	t.Run("single stream", func(t *testing.T) {
+		c, sA, sB := newTestCall(t, 3)
+		require.NoError(t, c.addScreenStream(sA, "streamA"))
+		require.NoError(t, c.addScreenStream(sA, "streamB"))
+		require.NoError(t, c.addScreenStream(sB, "streamC"))
+
+		require.NoError(t, c.clearScreenState(sA, "streamA"))
+		require.Empty(t, sA.getStreamType("streamA"))
+		require.Equal(t, trackTypeScreen, sA.getStreamType("streamB"))
+		require.Len(t, c.screenStreams, 2)
+
+		// The slot should now be available.
+		require.NoError(t, c.addScreenStream(sB, "streamD"))
+	})
+
+	t.Run("all session streams", func(t *testing.T) {
+		c, sA, sB := newTestCall(t, 3)
+		require.NoError(t, c.addScreenStream(sA, "streamA"))
+		require.NoError(t, c.addScreenStream(sA, "streamB"))
+		require.NoError(t, c.addScreenStream(sB, "streamC"))
+
+		require.NoError(t, c.clearScreenState(sA, ""))
+		require.Empty(t, sA.getStreamType("streamA"))
+		require.Empty(t, sA.getStreamType("streamB"))
+		require.Equal(t, trackTypeScreen, sB.getStreamType("streamC"))
+		require.Len(t, c.screenStreams, 1)
+	})
+}
@@ -29,6 +29,10 @@ type ServerConfig struct {
 	// A list of ICE server (STUN/TURN) configurations to use.
 	ICEServers ICEServers `toml:"ice_servers"`
 	TURNConfig TURNConfig `toml:"turn"`
+	// PublicIPRefreshIntervalMinutes controls how often the public IP
+	// addresses discovered through STUN are refreshed. A zero value (default)
+	// means they are only discovered on start.
+	PublicIPRefreshIntervalMinutes int `toml:"public_ip_refresh_interval_minutes"`
 	// EnableIPv6 specifies whether or not IPv6 should be used.
 	EnableIPv6 bool `toml:"enable_ipv6"`
 	// UDPSocketsCount controls the number of listening UDP sockets used for each local
@@ -38,6 +42,37 @@ type ServerConfig struct {
 	// a constant multiplier of 100. E.g. On a 4 CPUs node, 400 sockets per local
 	// network address will be open.
 	UDPSocketsCount int `toml:"udp_sockets_count"`
+	// MaxScreenSharesPerCall controls the maximum number of screen shares that
+	// can be active at the same time in a call. A zero value means a single
+	// screen share (default).
+	MaxScreenSharesPerCall int `toml:"max_screen_shares_per_call"`
+	// SimulcastLevels is the list of simulcast levels (RIDs) the service
+	// can forward, along with their target rates. If empty (default), the
+	// levels returned by GetDefaultSimulcastLevels are used.
+	SimulcastLevels SimulcastLevels `toml:"simulcast_levels"`
+	// ICERestartGracePeriodSeconds controls for how long a session whose
+	// connection failed is kept, waiting for the client to restart ICE. A
+	// zero value (default) means the session is closed right away.
+	ICERestartGracePeriodSeconds int `toml:"ice_restart_grace_period_seconds"`
+	// MigrateSessionsOnStop controls whether, on shutdown, clients are asked
+	// to move their ongoing sessions to a different instance. By default the
+	// service waits for the sessions to end.
+	MigrateSessionsOnStop bool `toml:"migrate_sessions_on_stop"`
+	// AudioSlots controls the number of voice tracks forwarded to each
+	// session. When set, the loudest or most recently active speakers are
+	// mapped into the available slots without renegotiating. A zero value
+	// (default) means all voice tracks are forwarded.
+	AudioSlots int `toml:"audio_slots"`
+	// RecordingsDir is the directory where call recordings are stored.
+	// Recording is disabled if empty.
+	RecordingsDir string `toml:"recordings_dir"`
+	// EnableSessionStats controls whether per-track RTP stats (bitrates,
+	// packet loss, jitter, RTT, NACK/PLI/FIR counts) are collected for each
+	// session. It adds some processing to every packet.
+	EnableSessionStats bool `toml:"enable_session_stats"`
+	// SessionStatsDumpIntervalSeconds controls how often the stats of all
+	// sessions are logged at debug level. A zero value (default) disables it.
+	SessionStatsDumpIntervalSeconds int `toml:"session_stats_dump_interval_seconds"`
 }
 
 func (c ServerConfig) IsValid() error {
@@ -69,10 +104,36 @@ func (c ServerConfig) IsValid() error {
 		return fmt.Errorf("invalid ICEHostPortOverride value: %w", err)
 	}
 
+	if c.PublicIPRefreshIntervalMinutes < 0 {
+		return fmt.Errorf("invalid PublicIPRefreshIntervalMinutes value: should not be negative")
+	}
+
 	if c.UDPSocketsCount <= 0 {
 		return fmt.Errorf("invalid UDPSocketsCount value: should be greater than 0")
 	}
 
+	if c.MaxScreenSharesPerCall < 0 {
+		return fmt.Errorf("invalid MaxScreenSharesPerCall value: should not be negative")
+	}
+
+	if len(c.SimulcastLevels) > 0 {
+		if err := c.SimulcastLevels.IsValid(); err != nil {
+			return fmt.Errorf("invalid SimulcastLevels value: %w", err)
+		}
+	}
+
+	if c.ICERestartGracePeriodSeconds < 0 {
+		return fmt.Errorf("invalid ICERestartGracePeriodSeconds value: should not be negative")
+	}
+
+	if c.AudioSlots < 0 {
+		return fmt.Errorf("invalid AudioSlots value: should not be negative")
+	}
+
+	if c.SessionStatsDumpIntervalSeconds < 0 {
+		return fmt.Errorf("invalid SessionStatsDumpIntervalSeconds value: should not be negative")
+	}
+
 	return nil
 }
 
@@ -101,11 +162,52 @@ func (p SessionProps) AV1Support() bool {
 	return val
 }
 
+// VideoCodecs returns the mime types of the video codecs the session accepts.
+func (p SessionProps) VideoCodecs() []string {
+	switch val := p["videoCodecs"].(type) {
+	case []string:
+		return val
+	case []any:
+		codecs := make([]string, 0, len(val))
+		for _, v := range val {
+			if codec, ok := v.(string); ok {
+				codecs = append(codecs, codec)
+			}
+		}
+		return codecs
+	}
+	return nil
+}
+
+func (p SessionProps) VideoSupport() bool {
+	val, _ := p["videoSupport"].(bool)
+	return val
+}
+
 func (p SessionProps) DCSignaling() bool {
 	val, _ := p["dcSignaling"].(bool)
 	return val
 }
 
+// WHIP returns whether the session was created through the WHIP endpoint.
+func (p SessionProps) WHIP() bool {
+	val, _ := p["whip"].(bool)
+	return val
+}
+
+// WHEP returns whether the session was created through the WHEP endpoint.
+func (p SessionProps) WHEP() bool {
+	val, _ := p["whep"].(bool)
+	return val
+}
+
+// RelayDirection returns the direction of the relay the session stands for,
+// or an empty string for regular sessions.
+func (p SessionProps) RelayDirection() string {
+	val, _ := p["relayDirection"].(string)
+	return val
+}
+
 func (c SessionConfig) IsValid() error {
 	if c.GroupID == "" {
 		return fmt.Errorf("invalid GroupID value: should not be empty")
@@ -123,6 +225,12 @@ func (c SessionConfig) IsValid() error {
 		return fmt.Errorf("invalid SessionID value: should not be empty")
 	}
 
+	switch c.Props.RelayDirection() {
+	case "", RelayDirectionIn, RelayDirectionOut:
+	default:
+		return fmt.Errorf("invalid RelayDirection value: should be either %q or %q", RelayDirectionIn, RelayDirectionOut)
+	}
+
 	return nil
 }
 
@@ -139,9 +247,12 @@ func (c *SessionConfig) FromMap(m map[string]any) error {
 	c.UserID, _ = m["userID"].(string)
 	c.SessionID, _ = m["sessionID"].(string)
 	c.Props = SessionProps{
-		"channelID":   m["channelID"],
-		"av1Support":  m["av1Support"],
-		"dcSignaling": m["dcSignaling"],
+		"channelID":      m["channelID"],
+		"av1Support":     m["av1Support"],
+		"dcSignaling":    m["dcSignaling"],
+		"videoSupport":   m["videoSupport"],
+		"videoCodecs":    m["videoCodecs"],
+		"relayDirection": m["relayDirection"],
 	}
 
 	return nil
@@ -257,6 +368,57 @@ func (s *ICEServers) UnmarshalTOML(data interface{}) error {
 	return nil
 }
 
+type SimulcastLevelConfig struct {
+	// RID is the RTP stream identifier of the level as sent by clients.
+	RID string `toml:"rid" json:"rid"`
+	// Rate is the target rate, in bits per second, of the level.
+	Rate int `toml:"rate" json:"rate"`
+	// MonitorWindowMs is the duration, in milliseconds, of the window used to
+	// measure the actual rate of the level.
+	MonitorWindowMs int `toml:"monitor_window_ms" json:"monitor_window_ms"`
+}
+
+type SimulcastLevels []SimulcastLevelConfig
+
+func (c SimulcastLevelConfig) IsValid() error {
+	if c.RID == "" {
+		return fmt.Errorf("invalid empty RID")
+	}
+
+	if c.Rate <= 0 {
+		return fmt.Errorf("invalid Rate for level %s: should be greater than 0", c.RID)
+	}
+
+	if c.MonitorWindowMs <= 0 {
+		return fmt.Errorf("invalid MonitorWindowMs for level %s: should be greater than 0", c.RID)
+	}
+
+	return nil
+}
+
+func (l SimulcastLevels) IsValid() error {
+	if len(l) == 0 {
+		return fmt.Errorf("should not be empty")
+	}
+
+	rids := make(map[string]bool, len(l))
+	for _, cfg := range l {
+		if err := cfg.IsValid(); err != nil {
+			return err
+		}
+		if rids[cfg.RID] {
+			return fmt.Errorf("duplicate RID %s", cfg.RID)
+		}
+		rids[cfg.RID] = true
+	}
+
+	return nil
+}
+
+func (l *SimulcastLevels) Decode(value string) error {
+	return json.Unmarshal([]byte(value), l)
+}
+
 type ICEHostPortOverride string
 
 func (s *ICEHostPortOverride) SinglePort() int {
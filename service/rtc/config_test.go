@@ -104,6 +104,81 @@ func TestServerConfigIsValid(t *testing.T) {
 		require.EqualError(t, err, "invalid UDPSocketsCount value: should be greater than 0")
 	})
 
+	t.Run("invalid MaxScreenSharesPerCall", func(t *testing.T) {
+		var cfg ServerConfig
+		cfg.ICEPortUDP = 8443
+		cfg.ICEPortTCP = 8443
+		cfg.UDPSocketsCount = 1
+		cfg.MaxScreenSharesPerCall = -1
+		err := cfg.IsValid()
+		require.EqualError(t, err, "invalid MaxScreenSharesPerCall value: should not be negative")
+	})
+
+	t.Run("invalid SimulcastLevels", func(t *testing.T) {
+		var cfg ServerConfig
+		cfg.ICEPortUDP = 8443
+		cfg.ICEPortTCP = 8443
+		cfg.UDPSocketsCount = 1
+		cfg.SimulcastLevels = SimulcastLevels{{RID: "", Rate: 1000, MonitorWindowMs: 1000}}
+		err := cfg.IsValid()
+		require.EqualError(t, err, "invalid SimulcastLevels value: invalid empty RID")
+
+		cfg.SimulcastLevels = SimulcastLevels{{RID: "h", Rate: 0, MonitorWindowMs: 1000}}
+		err = cfg.IsValid()
+		require.EqualError(t, err, "invalid SimulcastLevels value: invalid Rate for level h: should be greater than 0")
+
+		cfg.SimulcastLevels = SimulcastLevels{{RID: "h", Rate: 1000, MonitorWindowMs: 0}}
+		err = cfg.IsValid()
+		require.EqualError(t, err, "invalid SimulcastLevels value: invalid MonitorWindowMs for level h: should be greater than 0")
+
+		cfg.SimulcastLevels = SimulcastLevels{
+			{RID: "h", Rate: 1000, MonitorWindowMs: 1000},
+			{RID: "h", Rate: 2000, MonitorWindowMs: 1000},
+		}
+		err = cfg.IsValid()
+		require.EqualError(t, err, "invalid SimulcastLevels value: duplicate RID h")
+	})
+
+	t.Run("invalid PublicIPRefreshIntervalMinutes", func(t *testing.T) {
+		var cfg ServerConfig
+		cfg.ICEPortUDP = 8443
+		cfg.ICEPortTCP = 8443
+		cfg.UDPSocketsCount = 1
+		cfg.PublicIPRefreshIntervalMinutes = -1
+		err := cfg.IsValid()
+		require.EqualError(t, err, "invalid PublicIPRefreshIntervalMinutes value: should not be negative")
+	})
+
+	t.Run("invalid ICERestartGracePeriodSeconds", func(t *testing.T) {
+		var cfg ServerConfig
+		cfg.ICEPortUDP = 8443
+		cfg.ICEPortTCP = 8443
+		cfg.UDPSocketsCount = 1
+		cfg.ICERestartGracePeriodSeconds = -1
+		err := cfg.IsValid()
+		require.EqualError(t, err, "invalid ICERestartGracePeriodSeconds value: should not be negative")
+	})
+
+	t.Run("invalid AudioSlots", func(t *testing.T) {
+		var cfg ServerConfig
+		cfg.ICEPortUDP = 8443
+		cfg.ICEPortTCP = 8443
+		cfg.UDPSocketsCount = 1
+		cfg.AudioSlots = -1
+		err := cfg.IsValid()
+		require.EqualError(t, err, "invalid AudioSlots value: should not be negative")
+	})
+
+	t.Run("invalid SessionStatsDumpIntervalSeconds", func(t *testing.T) {
+		var cfg ServerConfig
+		cfg.ICEPortUDP = 8443
+		cfg.ICEPortTCP = 8443
+		cfg.UDPSocketsCount = 1
+		cfg.SessionStatsDumpIntervalSeconds = -1
+		err := cfg.IsValid()
+		require.EqualError(t, err, "invalid SessionStatsDumpIntervalSeconds value: should not be negative")
+	})
+
 	t.Run("valid", func(t *testing.T) {
 		var cfg ServerConfig
 		cfg.ICEAddressUDP = "127.0.0.1"
@@ -113,6 +188,10 @@ func TestServerConfigIsValid(t *testing.T) {
 		cfg.UDPSocketsCount = 1
 		err := cfg.IsValid()
 		require.NoError(t, err)
+
+		cfg.SimulcastLevels = GetDefaultSimulcastLevels()
+		err = cfg.IsValid()
+		require.NoError(t, err)
 	})
 }
 
@@ -354,9 +433,12 @@ func TestSessionConfigFromMap(t *testing.T) {
 			UserID:    "userID",
 			CallID:    "callID",
 			Props: SessionProps{
-				"channelID":   nil,
-				"av1Support":  nil,
-				"dcSignaling": nil,
+				"channelID":      nil,
+				"av1Support":     nil,
+				"dcSignaling":    nil,
+				"videoSupport":   nil,
+				"videoCodecs":    nil,
+				"relayDirection": nil,
 			},
 		}, cfg)
 	})
@@ -364,13 +446,16 @@ func TestSessionConfigFromMap(t *testing.T) {
 	t.Run("complete", func(t *testing.T) {
 		var cfg SessionConfig
 		err := cfg.FromMap(map[string]any{
-			"callID":      "callID",
-			"sessionID":   "sessionID",
-			"groupID":     "groupID",
-			"userID":      "userID",
-			"channelID":   "channelID",
-			"av1Support":  true,
-			"dcSignaling": true,
+			"callID":         "callID",
+			"sessionID":      "sessionID",
+			"groupID":        "groupID",
+			"userID":         "userID",
+			"channelID":      "channelID",
+			"av1Support":     true,
+			"dcSignaling":    true,
+			"videoSupport":   true,
+			"videoCodecs":    []any{"video/VP8", "video/H264"},
+			"relayDirection": RelayDirectionOut,
 		})
 		require.NoError(t, err)
 		require.NoError(t, cfg.IsValid())
@@ -380,9 +465,12 @@ func TestSessionConfigFromMap(t *testing.T) {
 			UserID:    "userID",
 			CallID:    "callID",
 			Props: SessionProps{
-				"channelID":   "channelID",
-				"av1Support":  true,
-				"dcSignaling": true,
+				"channelID":      "channelID",
+				"av1Support":     true,
+				"dcSignaling":    true,
+				"videoSupport":   true,
+				"videoCodecs":    []any{"video/VP8", "video/H264"},
+				"relayDirection": RelayDirectionOut,
 			},
 		}, cfg)
 	})
@@ -395,16 +483,35 @@ func TestSessionProps(t *testing.T) {
 		}
 		require.Empty(t, cfg.Props.ChannelID())
 		require.False(t, cfg.Props.AV1Support())
+		require.False(t, cfg.Props.VideoSupport())
+		require.Empty(t, cfg.Props.VideoCodecs())
 	})
 
 	t.Run("complete props", func(t *testing.T) {
 		cfg := SessionConfig{
 			Props: SessionProps{
-				"channelID":  "channelID",
-				"av1Support": true,
+				"channelID":    "channelID",
+				"av1Support":   true,
+				"videoSupport": true,
+				"videoCodecs":  []any{"video/VP8", "video/H264", 45},
 			},
 		}
 		require.Equal(t, "channelID", cfg.Props.ChannelID())
 		require.True(t, cfg.Props.AV1Support())
+		require.True(t, cfg.Props.VideoSupport())
+		require.Equal(t, []string{"video/VP8", "video/H264"}, cfg.Props.VideoCodecs())
 	})
 }
+
+func TestSimulcastLevelsDecode(t *testing.T) {
+	var levels SimulcastLevels
+	err := levels.Decode(`[{"rid":"h","rate":2500000,"monitor_window_ms":2000},{"rid":"m","rate":1000000,"monitor_window_ms":3000}]`)
+	require.NoError(t, err)
+	require.Equal(t, SimulcastLevels{
+		{RID: "h", Rate: 2500000, MonitorWindowMs: 2000},
+		{RID: "m", Rate: 1000000, MonitorWindowMs: 3000},
+	}, levels)
+
+	err = levels.Decode("invalid")
+	require.Error(t, err)
+}
@@ -19,17 +19,40 @@ import (
 type MessageType uint8
 
 const (
-	MessageTypePing          MessageType = iota + 1 // no payload
-	MessageTypePong                                 // no payload
-	MessageTypeSDP                                  // MessageSDP
-	MessageTypeLossRate                             // float64
-	MessageTypeRoundTripTime                        // float64
-	MessageTypeJitter                               // float64
+	MessageTypePing            MessageType = iota + 1 // no payload
+	MessageTypePong                                   // no payload
+	MessageTypeSDP                                    // MessageSDP
+	MessageTypeLossRate                               // float64
+	MessageTypeRoundTripTime                          // float64
+	MessageTypeJitter                                 // float64
+	MessageTypeVideoPreference                        // MessageVideoPreference
+	MessageTypeAudioSlots                             // MessageAudioSlots
+	MessageTypeICERestart                             // no payload
 )
 
 // Supported payloads
 type MessageSDP []byte // payload is zlib compressed data of a JSON serialized webrtc.SessionDescription
 
+// MessageVideoPreference is sent by a receiving client to express its
+// preferences about one of the video tracks it's receiving
+// (e.g. a thumbnail view only needing a low level or a hidden tab not
+// needing the track at all).
+type MessageVideoPreference struct {
+	// TrackID is the ID of the (receiving) track the preferences refer to.
+	TrackID string `msgpack:"trackID"`
+	// MaxLevel is the highest simulcast level (RID) the client wants to
+	// receive. An empty value means no limit.
+	MaxLevel string `msgpack:"maxLevel"`
+	// Paused controls whether the track should stop being forwarded.
+	Paused bool `msgpack:"paused"`
+}
+
+// MessageAudioSlots is sent to a receiving client whenever the speakers
+// forwarded through its audio slots change. It maps the ID of each slot
+// (receiving) track to the ID of the session currently being forwarded
+// through it. An empty session ID means the slot is idle.
+type MessageAudioSlots map[string]string
+
 func unpackData(data []byte) ([]byte, error) {
 	rd, err := zlib.NewReader(bytes.NewBuffer(data))
 	if err != nil {
@@ -96,6 +119,8 @@ func DecodeMessage(msg []byte) (MessageType, any, error) {
 		return MessageTypePong, nil, nil
 	case MessageTypePing:
 		return MessageTypePing, nil, nil
+	case MessageTypeICERestart:
+		return MessageTypeICERestart, nil, nil
 	case MessageTypeSDP:
 		var payload MessageSDP
 		err := dec.Decode(&payload)
@@ -118,6 +143,20 @@ func DecodeMessage(msg []byte) (MessageType, any, error) {
 			return 0, nil, fmt.Errorf("failed to decode message type %d: %w", t, err)
 		}
 		return MessageType(t), payload, nil
+	case MessageTypeVideoPreference:
+		var payload MessageVideoPreference
+		err := dec.Decode(&payload)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to decode video preference message: %w", err)
+		}
+		return MessageTypeVideoPreference, payload, nil
+	case MessageTypeAudioSlots:
+		var payload MessageAudioSlots
+		err := dec.Decode(&payload)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to decode audio slots message: %w", err)
+		}
+		return MessageTypeAudioSlots, payload, nil
 	}
 
 	return 0, nil, fmt.Errorf("unexpected dc message type: %d", t)
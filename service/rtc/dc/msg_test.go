@@ -33,6 +33,16 @@ func TestEncodeMessage(t *testing.T) {
 		require.Nil(t, payload)
 	})
 
+	t.Run("ice restart", func(t *testing.T) {
+		dcMsg, err := EncodeMessage(MessageTypeICERestart, nil)
+		require.NoError(t, err)
+
+		mt, payload, err := DecodeMessage(dcMsg)
+		require.NoError(t, err)
+		require.Equal(t, MessageTypeICERestart, mt)
+		require.Nil(t, payload)
+	})
+
 	t.Run("sdp", func(t *testing.T) {
 		var sdp webrtc.SessionDescription
 		sdp.Type = webrtc.SDPTypeOffer
@@ -53,4 +63,33 @@ func TestEncodeMessage(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, sdp, decodedSDP)
 	})
+	t.Run("video preference", func(t *testing.T) {
+		pref := MessageVideoPreference{
+			TrackID:  "video_sessionID_trackID",
+			MaxLevel: "l",
+			Paused:   true,
+		}
+
+		dcMsg, err := EncodeMessage(MessageTypeVideoPreference, pref)
+		require.NoError(t, err)
+
+		mt, payload, err := DecodeMessage(dcMsg)
+		require.NoError(t, err)
+		require.Equal(t, MessageTypeVideoPreference, mt)
+		require.Equal(t, pref, payload)
+	})
+	t.Run("audio slots", func(t *testing.T) {
+		slots := MessageAudioSlots{
+			"voice_sessionID_slotA": "sessionA",
+			"voice_sessionID_slotB": "",
+		}
+
+		dcMsg, err := EncodeMessage(MessageTypeAudioSlots, slots)
+		require.NoError(t, err)
+
+		mt, payload, err := DecodeMessage(dcMsg)
+		require.NoError(t, err)
+		require.Equal(t, MessageTypeAudioSlots, mt)
+		require.Equal(t, slots, payload)
+	})
 }
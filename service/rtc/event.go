@@ -0,0 +1,124 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mattermost/rtcd/service/random"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+type EventType string
+
+const (
+	CallStartedEvent   EventType = "call_started"
+	CallEndedEvent     EventType = "call_ended"
+	SessionJoinedEvent EventType = "session_joined"
+	SessionLeftEvent   EventType = "session_left"
+	ScreenOnEvent      EventType = "screen_on"
+	ScreenOffEvent     EventType = "screen_off"
+	MuteEvent          EventType = "mute"
+	UnmuteEvent        EventType = "unmute"
+	VoiceOnEvent       EventType = "voice_on"
+	VoiceOffEvent      EventType = "voice_off"
+)
+
+// Event notifies about a change in the lifecycle of a call. UserID and
+// SessionID are empty for call events.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      EventType `json:"type"`
+	Timestamp int64     `json:"timestamp"`
+	GroupID   string    `json:"groupID"`
+	CallID    string    `json:"callID"`
+	UserID    string    `json:"userID,omitempty"`
+	SessionID string    `json:"sessionID,omitempty"`
+	// ScreenStreamID is set for screen sharing events.
+	ScreenStreamID string `json:"screenStreamID,omitempty"`
+}
+
+func newEvent(evType EventType, cfg SessionConfig) Event {
+	return Event{
+		ID:        random.NewID(),
+		Type:      evType,
+		Timestamp: time.Now().UnixMilli(),
+		GroupID:   cfg.GroupID,
+		CallID:    cfg.CallID,
+		UserID:    cfg.UserID,
+		SessionID: cfg.SessionID,
+	}
+}
+
+func newCallEvent(evType EventType, groupID, callID string) Event {
+	return newEvent(evType, SessionConfig{GroupID: groupID, CallID: callID})
+}
+
+func newScreenEvent(evType EventType, cfg SessionConfig, streamID string) Event {
+	ev := newEvent(evType, cfg)
+	ev.ScreenStreamID = streamID
+	return ev
+}
+
+// hasPresence returns whether the session is reported as a call participant.
+// Relays link nodes rather than users and WHEP viewers only watch, so neither
+// is.
+func hasPresence(cfg SessionConfig) bool {
+	return cfg.Props.RelayDirection() == "" && !cfg.Props.WHEP()
+}
+
+// eventQueue holds the events to be consumed through EventCh. Sending is
+// guarded against the channel being closed on stop since sessions can still be
+// closing (or voice activity detected) concurrently.
+type eventQueue struct {
+	ch     chan Event
+	log    mlog.LoggerIFace
+	closed bool
+	mut    sync.RWMutex
+}
+
+func newEventQueue(log mlog.LoggerIFace, size int) *eventQueue {
+	return &eventQueue{
+		ch:  make(chan Event, size),
+		log: log,
+	}
+}
+
+// send queues the event without blocking, dropping it if the queue is full
+// (e.g. if events are not consumed) or closed.
+func (q *eventQueue) send(ev Event) {
+	q.mut.RLock()
+	defer q.mut.RUnlock()
+
+	if q.closed {
+		return
+	}
+
+	select {
+	case q.ch <- ev:
+	default:
+		q.log.Debug("dropping event: channel is full",
+			mlog.String("type", string(ev.Type)),
+			mlog.String("callID", ev.CallID),
+			mlog.String("sessionID", ev.SessionID))
+	}
+}
+
+func (q *eventQueue) close() {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.ch)
+	}
+}
+
+// EventCh returns the channel call lifecycle events are sent on. It's closed
+// when the server stops.
+func (s *Server) EventCh() <-chan Event {
+	return s.events.ch
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvents(t *testing.T) {
+	s, shutdown := setupServer(t)
+	defer shutdown()
+	require.NoError(t, s.Start())
+
+	go func() {
+		for range s.ReceiveCh() {
+		}
+	}()
+
+	waitForEvent := func(t *testing.T, evType EventType) Event {
+		t.Helper()
+		select {
+		case ev := <-s.EventCh():
+			require.Equal(t, evType, ev.Type)
+			require.NotEmpty(t, ev.ID)
+			require.NotZero(t, ev.Timestamp)
+			require.Equal(t, "groupID", ev.GroupID)
+			require.Equal(t, "callID", ev.CallID)
+			return ev
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for event", evType)
+		}
+		return Event{}
+	}
+
+	cfgA := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userA", SessionID: "sessionA"}
+	cfgB := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userB", SessionID: "sessionB"}
+
+	sendMsg := func(t *testing.T, msgType MessageType, data map[string]string) {
+		t.Helper()
+		msg := newMessage(&session{cfg: cfgA}, msgType, nil)
+		if data != nil {
+			var err error
+			msg.Data, err = json.Marshal(data)
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.Send(msg))
+	}
+
+	t.Run("join", func(t *testing.T) {
+		require.NoError(t, s.InitSession(cfgA, nil))
+		ev := waitForEvent(t, CallStartedEvent)
+		require.Empty(t, ev.SessionID)
+		ev = waitForEvent(t, SessionJoinedEvent)
+		require.Equal(t, "userA", ev.UserID)
+		require.Equal(t, "sessionA", ev.SessionID)
+
+		require.NoError(t, s.InitSession(cfgB, nil))
+		ev = waitForEvent(t, SessionJoinedEvent)
+		require.Equal(t, "sessionB", ev.SessionID)
+	})
+
+	t.Run("whep", func(t *testing.T) {
+		cfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userC", SessionID: "sessionC", Props: SessionProps{"whep": true}}
+		peerConn, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		require.NoError(t, err)
+		us, err := s.addSession(cfg, peerConn, nil)
+		require.NoError(t, err)
+		close(us.doneCh)
+		require.NoError(t, s.CloseSession(cfg.SessionID))
+		// Viewers have no presence so the next event expected is the unmute
+		// below.
+	})
+
+	t.Run("mute", func(t *testing.T) {
+		sendMsg(t, UnmuteMessage, nil)
+		ev := waitForEvent(t, UnmuteEvent)
+		require.Equal(t, "sessionA", ev.SessionID)
+
+		// Repeated states are not reported.
+		sendMsg(t, UnmuteMessage, nil)
+		sendMsg(t, MuteMessage, nil)
+		ev = waitForEvent(t, MuteEvent)
+		require.Equal(t, "sessionA", ev.SessionID)
+	})
+
+	t.Run("screen", func(t *testing.T) {
+		sendMsg(t, ScreenOnMessage, map[string]string{"screenStreamID": "screenA"})
+		ev := waitForEvent(t, ScreenOnEvent)
+		require.Equal(t, "sessionA", ev.SessionID)
+		require.Equal(t, "screenA", ev.ScreenStreamID)
+
+		sendMsg(t, ScreenOffMessage, map[string]string{"screenStreamID": "screenA"})
+		ev = waitForEvent(t, ScreenOffEvent)
+		require.Equal(t, "screenA", ev.ScreenStreamID)
+
+		// Screen shares end along with the session publishing them.
+		sendMsg(t, ScreenOnMessage, map[string]string{"screenStreamID": "screenB"})
+		waitForEvent(t, ScreenOnEvent)
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		require.NoError(t, s.CloseSession("sessionA"))
+		ev := waitForEvent(t, ScreenOffEvent)
+		require.Equal(t, "sessionA", ev.SessionID)
+		require.Equal(t, "screenB", ev.ScreenStreamID)
+		ev = waitForEvent(t, SessionLeftEvent)
+		require.Equal(t, "sessionA", ev.SessionID)
+
+		require.NoError(t, s.CloseSession("sessionB"))
+		ev = waitForEvent(t, SessionLeftEvent)
+		require.Equal(t, "sessionB", ev.SessionID)
+		ev = waitForEvent(t, CallEndedEvent)
+		require.Empty(t, ev.SessionID)
+	})
+}
+
+func TestEventQueue(t *testing.T) {
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, log.Shutdown())
+	}()
+
+	q := newEventQueue(log, 1)
+
+	q.send(newCallEvent(CallStartedEvent, "groupID", "callID"))
+	// Events are dropped rather than blocking when the queue is full.
+	q.send(newCallEvent(CallEndedEvent, "groupID", "callID"))
+
+	q.close()
+	// Sending after close is a no-op.
+	q.send(newCallEvent(CallEndedEvent, "groupID", "callID"))
+	q.close()
+
+	var evs []Event
+	for ev := range q.ch {
+		evs = append(evs, ev)
+	}
+	require.Len(t, evs, 1)
+	require.Equal(t, CallStartedEvent, evs[0].Type)
+}
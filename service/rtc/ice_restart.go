@@ -0,0 +1,143 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/rtcd/service/tracing"
+
+	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// Sessions whose connection failed are kept, along with their tracks and
+// call membership, for a grace period during which the client can restart
+// ICE, either by sending an offer with new ICE credentials or by requesting
+// the server to send one (ICERestartMessage or dc.MessageTypeICERestart).
+
+// canResume returns whether the session can recover from a failed connection.
+// HTTP (WHIP/WHEP) and relay sessions have no way to restart ICE.
+func (s *session) canResume() bool {
+	return !s.hasHTTPSignaling() && s.relayDirection() == ""
+}
+
+// handleSessionFailure closes the given session once the grace period expires
+// unless it recovers in the meantime.
+func (s *Server) handleSessionFailure(us *session) {
+	gracePeriod := time.Duration(s.cfg.ICERestartGracePeriodSeconds) * time.Second
+	if gracePeriod <= 0 || !us.canResume() {
+		if err := s.CloseSession(us.cfg.SessionID); err != nil {
+			s.log.Error("failed to close RTC session", mlog.Err(err), mlog.Any("sessionCfg", us.cfg))
+		}
+		return
+	}
+
+	us.mut.Lock()
+	defer us.mut.Unlock()
+
+	if us.resumeTimer != nil {
+		return
+	}
+
+	s.log.Debug("waiting for session to resume", mlog.String("sessionID", us.cfg.SessionID), mlog.Duration("gracePeriod", gracePeriod))
+
+	var timer *time.Timer
+	timer = time.AfterFunc(gracePeriod, func() {
+		us.mut.Lock()
+		if us.resumeTimer != timer {
+			us.mut.Unlock()
+			return
+		}
+		us.resumeTimer = nil
+		us.mut.Unlock()
+
+		select {
+		case <-us.closeCh:
+			return
+		default:
+		}
+
+		s.log.Debug("session failed to resume, closing", mlog.String("sessionID", us.cfg.SessionID))
+		if err := s.CloseSession(us.cfg.SessionID); err != nil {
+			s.log.Error("failed to close RTC session", mlog.Err(err), mlog.Any("sessionCfg", us.cfg))
+		}
+	})
+	us.resumeTimer = timer
+}
+
+// handleSessionConnected cancels the pending closing of the given session, if
+// any, as it recovered.
+func (s *Server) handleSessionConnected(us *session) {
+	us.mut.Lock()
+	timer := us.resumeTimer
+	us.resumeTimer = nil
+	us.mut.Unlock()
+
+	if timer == nil {
+		return
+	}
+
+	timer.Stop()
+	s.log.Debug("session resumed", mlog.String("sessionID", us.cfg.SessionID))
+	s.metrics.IncRTCConnState("resumed")
+}
+
+// requestICERestart queues an ICE restart to be performed by the server.
+// Requests are coalesced while one is pending.
+func (s *session) requestICERestart() error {
+	if !s.canResume() {
+		return fmt.Errorf("ICE restart is not supported for this session")
+	}
+
+	select {
+	case s.iceRestartCh <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// restartICE sends an offer with new ICE credentials and waits for the answer.
+func (s *session) restartICE(sdpOutCh chan<- Message) (errRet error) {
+	s.log.Debug("restartICE", mlog.String("sessionID", s.cfg.SessionID))
+
+	ctx, span := s.startSpan(trace.SpanContext{}, "rtc.ice_restart")
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
+	s.mut.Lock()
+	s.makingOffer = true
+	s.mut.Unlock()
+	defer func() {
+		s.mut.Lock()
+		s.makingOffer = false
+		s.mut.Unlock()
+	}()
+
+	if err := s.sendOffer(ctx, sdpOutCh, &webrtc.OfferOptions{ICERestart: true}); err != nil {
+		return fmt.Errorf("failed to send offer: %w", err)
+	}
+
+	select {
+	case answer, ok := <-s.sdpAnswerInCh:
+		if !ok {
+			return nil
+		}
+		if err := s.rtcConn.SetRemoteDescription(answer); err != nil {
+			return fmt.Errorf("failed to set remote description: %w", err)
+		}
+	case <-time.After(signalingTimeout):
+		return fmt.Errorf("timed out signaling")
+	case <-s.closeCh:
+		s.log.Debug("closeCh closed during signaling", mlog.Any("sessionCfg", s.cfg))
+		return nil
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestICERestart(t *testing.T) {
+	s := setupRelayServer(t, 30440)
+	s.cfg.ICERestartGracePeriodSeconds = 1
+
+	clientCh := make(chan Message, 50)
+	go func() {
+		for msg := range s.ReceiveCh() {
+			clientCh <- msg
+		}
+	}()
+
+	cfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userA", SessionID: "sessionA"}
+	require.NoError(t, s.InitSession(cfg, nil))
+	connectRelayClient(t, s, cfg, clientCh, nil)
+
+	us := s.getGroup("groupID").getCall("callID").getSession("sessionA")
+	require.NotNil(t, us)
+
+	isConnected := func() bool {
+		return us.rtcConn.ConnectionState() == webrtc.PeerConnectionStateConnected
+	}
+	require.Eventually(t, isConnected, 10*time.Second, 50*time.Millisecond)
+
+	getLocalUfrag := func() string {
+		parsed, err := us.rtcConn.LocalDescription().Unmarshal()
+		require.NoError(t, err)
+		ufrag, _ := parsed.MediaDescriptions[0].Attribute("ice-ufrag")
+		return ufrag
+	}
+
+	t.Run("restart requested", func(t *testing.T) {
+		ufrag := getLocalUfrag()
+
+		require.NoError(t, s.Send(Message{
+			GroupID:   cfg.GroupID,
+			CallID:    cfg.CallID,
+			UserID:    cfg.UserID,
+			SessionID: cfg.SessionID,
+			Type:      ICERestartMessage,
+		}))
+
+		require.Eventually(t, func() bool {
+			return us.rtcConn.SignalingState() == webrtc.SignalingStateStable && getLocalUfrag() != ufrag
+		}, 10*time.Second, 50*time.Millisecond)
+		require.Eventually(t, isConnected, 10*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("resumed within grace period", func(t *testing.T) {
+		s.handleSessionFailure(us)
+		us.mut.RLock()
+		require.NotNil(t, us.resumeTimer)
+		us.mut.RUnlock()
+
+		s.handleSessionConnected(us)
+		us.mut.RLock()
+		require.Nil(t, us.resumeTimer)
+		us.mut.RUnlock()
+
+		time.Sleep(1500 * time.Millisecond)
+		require.NotNil(t, s.getGroup("groupID").getCall("callID").getSession("sessionA"))
+	})
+
+	t.Run("grace period expired", func(t *testing.T) {
+		s.handleSessionFailure(us)
+		require.Eventually(t, func() bool {
+			return s.getGroup("groupID") == nil
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+}
+
+func TestSessionCanResume(t *testing.T) {
+	require.True(t, (&session{cfg: SessionConfig{}}).canResume())
+	require.False(t, (&session{cfg: SessionConfig{Props: SessionProps{"whip": true}}}).canResume())
+	require.False(t, (&session{cfg: SessionConfig{Props: SessionProps{"whep": true}}}).canResume())
+}
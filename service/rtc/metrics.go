@@ -11,9 +11,22 @@ type Metrics interface {
 	IncRTPTracks(groupID string, direction, trackType string)
 	DecRTPTracks(groupID string, direction, trackType string)
 	ObserveRTPTracksWrite(groupID, trackType string, dur float64)
+	IncRTCPublicIPChanges()
+	IncRTPPackets(groupID, direction, trackType string, bytes int)
+	IncRTCPPackets(groupID, direction, pktType string)
+	IncSimulcastLevelSwitches(groupID, direction string)
+	IncRTCRenegotiations(groupID string)
+	ObserveRTCRenegotiationTime(groupID string, dur float64)
+	ObserveRTCBWETargetBitrate(groupID string, rate int)
 
 	// Client metrics
 	ObserveRTCClientLossRate(groupID string, val float64)
 	ObserveRTCClientRTT(groupID string, val float64)
 	ObserveRTCClientJitter(groupID string, val float64)
+
+	// TURN metrics
+	IncTURNAllocations(protocol string)
+	DecTURNAllocations(protocol string)
+	AddTURNRelayedBytes(direction string, n int)
+	IncTURNAuthFailures()
 }
@@ -0,0 +1,113 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"strings"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+)
+
+// metricsInterceptorFactory creates interceptors tracking the media flowing
+// through a peer connection. It needs to be the first one added to the
+// registry so that packets generated by the other interceptors (e.g. NACKs,
+// retransmissions) are accounted for as well.
+type metricsInterceptorFactory struct {
+	groupID string
+	metrics Metrics
+}
+
+type metricsInterceptor struct {
+	interceptor.NoOp
+	groupID string
+	metrics Metrics
+}
+
+func newMetricsInterceptorFactory(groupID string, metrics Metrics) *metricsInterceptorFactory {
+	return &metricsInterceptorFactory{
+		groupID: groupID,
+		metrics: metrics,
+	}
+}
+
+func (f *metricsInterceptorFactory) NewInterceptor(_ string) (interceptor.Interceptor, error) {
+	return &metricsInterceptor{
+		groupID: f.groupID,
+		metrics: f.metrics,
+	}, nil
+}
+
+// getStreamType returns the kind of media carried by the stream, matching
+// the track types used for outgoing tracks.
+func getStreamType(info *interceptor.StreamInfo) string {
+	if strings.HasPrefix(strings.ToLower(info.MimeType), "audio/") {
+		return "audio"
+	}
+	return "video"
+}
+
+func (i *metricsInterceptor) incRTCPPackets(pkts []rtcp.Packet, direction string) {
+	for _, pkt := range pkts {
+		switch pkt.(type) {
+		case *rtcp.TransportLayerNack:
+			i.metrics.IncRTCPPackets(i.groupID, direction, "nack")
+		case *rtcp.PictureLossIndication:
+			i.metrics.IncRTCPPackets(i.groupID, direction, "pli")
+		}
+	}
+}
+
+func (i *metricsInterceptor) BindRTCPReader(reader interceptor.RTCPReader) interceptor.RTCPReader {
+	return interceptor.RTCPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err != nil {
+			return n, attr, err
+		}
+
+		if attr == nil {
+			attr = make(interceptor.Attributes)
+		}
+		// Parsed packets are cached in the attributes so the following
+		// interceptors don't need to parse them again.
+		if pkts, err := attr.GetRTCPPackets(b[:n]); err == nil {
+			i.incRTCPPackets(pkts, "in")
+		}
+
+		return n, attr, nil
+	})
+}
+
+func (i *metricsInterceptor) BindRTCPWriter(writer interceptor.RTCPWriter) interceptor.RTCPWriter {
+	return interceptor.RTCPWriterFunc(func(pkts []rtcp.Packet, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(pkts, attributes)
+		if err == nil {
+			i.incRTCPPackets(pkts, "out")
+		}
+		return n, err
+	})
+}
+
+func (i *metricsInterceptor) BindLocalStream(info *interceptor.StreamInfo, writer interceptor.RTPWriter) interceptor.RTPWriter {
+	streamType := getStreamType(info)
+	return interceptor.RTPWriterFunc(func(header *rtp.Header, payload []byte, attributes interceptor.Attributes) (int, error) {
+		n, err := writer.Write(header, payload, attributes)
+		if err == nil {
+			i.metrics.IncRTPPackets(i.groupID, "out", streamType, header.MarshalSize()+len(payload))
+		}
+		return n, err
+	})
+}
+
+func (i *metricsInterceptor) BindRemoteStream(info *interceptor.StreamInfo, reader interceptor.RTPReader) interceptor.RTPReader {
+	streamType := getStreamType(info)
+	return interceptor.RTPReaderFunc(func(b []byte, a interceptor.Attributes) (int, interceptor.Attributes, error) {
+		n, attr, err := reader.Read(b, a)
+		if err == nil {
+			i.metrics.IncRTPPackets(i.groupID, "in", streamType, n)
+		}
+		return n, attr, err
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"sort"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// SessionState holds the metadata needed to rebuild a session on a different
+// rtcd instance, e.g. when migrating away from a draining one.
+type SessionState struct {
+	GroupID   string       `msgpack:"group_id"`
+	CallID    string       `msgpack:"call_id"`
+	UserID    string       `msgpack:"user_id"`
+	SessionID string       `msgpack:"session_id"`
+	Props     SessionProps `msgpack:"props,omitempty"`
+	// Muted is set if the session isn't currently sending voice.
+	Muted bool `msgpack:"muted"`
+	// ScreenStreamIDs holds the IDs of the screen streams the session is
+	// currently sharing.
+	ScreenStreamIDs []string `msgpack:"screen_stream_ids,omitempty"`
+}
+
+// SessionConfig returns the config needed to join the session again.
+func (s SessionState) SessionConfig() SessionConfig {
+	return SessionConfig{
+		GroupID:   s.GroupID,
+		CallID:    s.CallID,
+		UserID:    s.UserID,
+		SessionID: s.SessionID,
+		Props:     s.Props,
+	}
+}
+
+// SessionOption customizes a session being initialized.
+type SessionOption func(opts *sessionOptions)
+
+type sessionOptions struct {
+	state *SessionState
+}
+
+// WithSessionState restores the mute and screen sharing state of a session
+// migrated from a different instance.
+func WithSessionState(state SessionState) SessionOption {
+	return func(opts *sessionOptions) {
+		opts.state = &state
+	}
+}
+
+// restoreSessionState applies the given migrated state to a newly created
+// session. Screen streams are registered as if they had just been announced
+// so that the tracks get handled accordingly once published.
+func (s *Server) restoreSessionState(c *call, us *session, state SessionState) {
+	us.mut.Lock()
+	us.muteState = muteStateUnmuted
+	if state.Muted {
+		us.muteState = muteStateMuted
+	}
+	for _, streamID := range state.ScreenStreamIDs {
+		us.screenStreamIDs[streamID] = true
+	}
+	us.mut.Unlock()
+
+	for _, streamID := range state.ScreenStreamIDs {
+		if err := c.addScreenStream(us, streamID); err != nil {
+			s.log.Error("failed to restore screen stream", mlog.Err(err),
+				mlog.String("sessionID", us.cfg.SessionID), mlog.String("screenStreamID", streamID))
+			continue
+		}
+		s.events.send(newScreenEvent(ScreenOnEvent, us.cfg, streamID))
+	}
+}
+
+func (c *call) getSessionState(us *session) SessionState {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	us.mut.RLock()
+	defer us.mut.RUnlock()
+
+	state := SessionState{
+		GroupID:   us.cfg.GroupID,
+		CallID:    us.cfg.CallID,
+		UserID:    us.cfg.UserID,
+		SessionID: us.cfg.SessionID,
+		Props:     us.cfg.Props,
+		Muted:     us.outVoiceTrack == nil || !us.outVoiceTrackEnabled,
+	}
+
+	for streamID, ss := range c.screenStreams {
+		if ss == us {
+			state.ScreenStreamIDs = append(state.ScreenStreamIDs, streamID)
+		}
+	}
+	sort.Strings(state.ScreenStreamIDs)
+
+	return state
+}
+
+func (s *Server) isDraining() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.draining
+}
+
+// Drain stops the server from accepting new sessions and returns the state of
+// the ongoing ones so that they can be migrated to a different instance.
+// Sessions keep going until closed.
+func (s *Server) Drain() []SessionState {
+	s.mut.Lock()
+	s.draining = true
+	cfgs := make([]SessionConfig, 0, len(s.sessions))
+	for _, cfg := range s.sessions {
+		cfgs = append(cfgs, cfg)
+	}
+	s.mut.Unlock()
+
+	s.log.Info("rtc: draining", mlog.Int("sessions", len(cfgs)))
+
+	states := make([]SessionState, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		state, err := s.getSessionState(cfg)
+		if err != nil {
+			// The session may have been closed in the meantime.
+			s.log.Debug("failed to get session state", mlog.Err(err), mlog.String("sessionID", cfg.SessionID))
+			continue
+		}
+		states = append(states, state)
+	}
+
+	return states
+}
+
+func (s *Server) getSessionState(cfg SessionConfig) (SessionState, error) {
+	us, err := s.getSession(cfg)
+	if err != nil {
+		return SessionState{}, err
+	}
+
+	return us.call.getSessionState(us), nil
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 
 	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type MessageType int
@@ -21,6 +22,8 @@ const (
 	ScreenOffMessage
 	VoiceOnMessage
 	VoiceOffMessage
+	DominantSpeakerMessage
+	ICERestartMessage
 )
 
 type Message struct {
@@ -30,6 +33,9 @@ type Message struct {
 	CallID    string      `msgpack:"call_id"`
 	Type      MessageType `msgpack:"type"`
 	Data      []byte      `msgpack:"data,omitempty"`
+	// SpanContext is the context of the span the message originates from, if
+	// any, so that handling it can be traced as part of the same operation.
+	SpanContext trace.SpanContext `msgpack:"-"`
 }
 
 func (m *Message) IsValid() error {
@@ -0,0 +1,123 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// shouldDiscoverPublicAddrs returns whether public addresses should be
+// discovered through STUN, which is only needed if no override is given.
+func (s *Server) shouldDiscoverPublicAddrs() bool {
+	return s.cfg.ICEHostOverride == "" && len(s.cfg.ICEServers) > 0
+}
+
+// discoverPublicAddrs concurrently discovers the public address of each local
+// interface through STUN, binding on the given port. Addresses that failed to
+// be discovered are returned empty.
+func (s *Server) discoverPublicAddrs(network string, port int) map[netip.Addr]string {
+	var wg sync.WaitGroup
+	var mut sync.Mutex
+	addrs := make(map[netip.Addr]string, len(s.localIPs))
+
+	for _, ip := range s.localIPs {
+		udpAddr, err := net.ResolveUDPAddr(network, netip.AddrPortFrom(ip, uint16(port)).String())
+		if err != nil {
+			s.log.Error("failed to resolve UDP address", mlog.Err(err))
+			continue
+		}
+
+		wg.Add(1)
+		go func(ip netip.Addr, udpAddr *net.UDPAddr) {
+			defer wg.Done()
+
+			addr, err := getPublicIP(udpAddr, network, s.cfg.ICEServers.getSTUN())
+			if err != nil {
+				s.log.Warn("failed to get public IP address for local interface", mlog.String("localAddr", ip.String()), mlog.Err(err))
+			}
+
+			mut.Lock()
+			addrs[ip] = addr
+			mut.Unlock()
+		}(ip, udpAddr)
+	}
+
+	wg.Wait()
+
+	return addrs
+}
+
+// refreshPublicAddrs discovers the public addresses again, updating the ones
+// that changed so that new sessions pick them up. Addresses that failed to be
+// discovered are left untouched.
+func (s *Server) refreshPublicAddrs(network string) {
+	// Binding on an ephemeral port since the ICE one is taken by then.
+	addrs := s.discoverPublicAddrs(network, 0)
+
+	var changed bool
+	s.addrsMut.Lock()
+	for ip, addr := range addrs {
+		prevAddr := s.publicAddrsMap[ip]
+		if addr == "" || addr == prevAddr {
+			continue
+		}
+
+		s.log.Info("rtc: public IP address changed for local interface",
+			mlog.String("localAddr", ip.String()),
+			mlog.String("prevRemoteAddr", prevAddr),
+			mlog.String("remoteAddr", addr))
+		s.metrics.IncRTCPublicIPChanges()
+
+		s.publicAddrsMap[ip] = addr
+		changed = true
+	}
+	s.addrsMut.Unlock()
+
+	if changed && s.cfg.ICEHostPortOverride != "" {
+		if err := s.initHostPortOverrides(); err != nil {
+			s.log.Error("failed to update host port overrides", mlog.Err(err))
+		}
+	}
+}
+
+func (s *Server) publicAddrsRefresher(network string, interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.refreshPublicAddrs(network)
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// getPublicAddrsMap returns a copy of the public addresses map.
+func (s *Server) getPublicAddrsMap() map[netip.Addr]string {
+	s.addrsMut.RLock()
+	defer s.addrsMut.RUnlock()
+
+	m := make(map[netip.Addr]string, len(s.publicAddrsMap))
+	for ip, addr := range s.publicAddrsMap {
+		m[ip] = addr
+	}
+
+	return m
+}
+
+func (s *Server) getHostPortOverride(addr string) (int, bool) {
+	s.addrsMut.RLock()
+	defer s.addrsMut.RUnlock()
+	port, ok := s.hostPortOverrides[addr]
+	return port, ok
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/mattermost/rtcd/service/perf"
+
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+func TestRefreshPublicAddrs(t *testing.T) {
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, log.Shutdown())
+	}()
+
+	// A TURN server answers STUN binding requests too.
+	stunConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	stunServer, err := turn.NewServer(turn.ServerConfig{
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: stunConn,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorNone{
+					Address: "127.0.0.1",
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	defer stunServer.Close()
+
+	cfg := ServerConfig{
+		ICEPortUDP:          30439,
+		ICEPortTCP:          30439,
+		ICEServers:          ICEServers{{URLs: []string{"stun:" + stunConn.LocalAddr().String()}}},
+		ICEHostPortOverride: "127.0.0.1/8443",
+		UDPSocketsCount:     1,
+	}
+
+	s, err := NewServer(cfg, log, perf.NewMetrics("rtcd", nil))
+	require.NoError(t, err)
+
+	localIP := netip.MustParseAddr("127.0.0.1")
+	s.localIPs = []netip.Addr{localIP}
+
+	t.Run("discover", func(t *testing.T) {
+		require.Equal(t, map[netip.Addr]string{
+			localIP: "127.0.0.1",
+		}, s.discoverPublicAddrs("udp4", 0))
+	})
+
+	t.Run("changed", func(t *testing.T) {
+		s.publicAddrsMap[localIP] = "8.8.8.8"
+		require.NoError(t, s.initHostPortOverrides())
+		_, ok := s.getHostPortOverride("127.0.0.1")
+		require.False(t, ok)
+		port, ok := s.getHostPortOverride("8.8.8.8")
+		require.True(t, ok)
+		require.Equal(t, 8443, port)
+
+		s.refreshPublicAddrs("udp4")
+		require.Equal(t, map[netip.Addr]string{
+			localIP: "127.0.0.1",
+		}, s.getPublicAddrsMap())
+
+		// The port overrides follow the new address.
+		_, ok = s.getHostPortOverride("8.8.8.8")
+		require.False(t, ok)
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		s.publicAddrsMap[localIP] = "8.8.8.8"
+		s.cfg.ICEServers = ICEServers{{URLs: []string{"stun:invalid:0"}}}
+		defer func() {
+			s.cfg.ICEServers = cfg.ICEServers
+		}()
+
+		// Previously discovered addresses are kept on failure.
+		s.refreshPublicAddrs("udp4")
+		require.Equal(t, map[netip.Addr]string{
+			localIP: "8.8.8.8",
+		}, s.getPublicAddrsMap())
+	})
+}
@@ -0,0 +1,393 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const recordingManifestFilename = "manifest.json"
+
+// recordingManifest describes the content of a call recording so that the
+// individual track files can be muxed later on. Times are Unix milliseconds.
+type recordingManifest struct {
+	CallID       string                  `json:"callID"`
+	StartAt      int64                   `json:"startAt"`
+	EndAt        int64                   `json:"endAt"`
+	Participants []*recordingParticipant `json:"participants"`
+	Tracks       []*recordingTrack       `json:"tracks"`
+}
+
+type recordingParticipant struct {
+	UserID    string `json:"userID"`
+	SessionID string `json:"sessionID"`
+	JoinAt    int64  `json:"joinAt"`
+	LeaveAt   int64  `json:"leaveAt,omitempty"`
+}
+
+type recordingTrack struct {
+	SessionID string `json:"sessionID"`
+	UserID    string `json:"userID"`
+	Type      string `json:"type"`
+	MimeType  string `json:"mimeType"`
+	Filename  string `json:"filename"`
+	StartAt   int64  `json:"startAt"`
+	EndAt     int64  `json:"endAt"`
+}
+
+// trackRecorder writes a single track to disk.
+type trackRecorder struct {
+	// level is the simulcast level being recorded (video only).
+	level string
+
+	mut    sync.Mutex
+	writer media.Writer
+	info   *recordingTrack
+}
+
+// callRecorder records a call's voice and screen tracks under a dedicated
+// directory, one file per track.
+type callRecorder struct {
+	callID  string
+	dir     string
+	startAt time.Time
+	log     mlog.LoggerIFace
+
+	mut          sync.Mutex
+	tracks       map[string]*trackRecorder
+	participants map[string]*recordingParticipant
+	stopped      bool
+}
+
+func newCallRecorder(baseDir, callID string, log mlog.LoggerIFace) (*callRecorder, error) {
+	if callID == "" || strings.ContainsAny(callID, `/\`) || callID == "." || callID == ".." {
+		return nil, fmt.Errorf("invalid call ID %q", callID)
+	}
+
+	startAt := time.Now()
+	dir := filepath.Join(baseDir, fmt.Sprintf("%s_%d", callID, startAt.UnixMilli()))
+
+	return &callRecorder{
+		callID:       callID,
+		dir:          dir,
+		startAt:      startAt,
+		log:          log,
+		tracks:       make(map[string]*trackRecorder),
+		participants: make(map[string]*recordingParticipant),
+	}, nil
+}
+
+func newMediaWriter(mimeType, path string) (media.Writer, error) {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return oggwriter.New(path, rtpAudioCodec.ClockRate, rtpAudioCodec.Channels)
+	case strings.ToLower(webrtc.MimeTypeVP8), strings.ToLower(webrtc.MimeTypeAV1):
+		return ivfwriter.New(path, ivfwriter.WithCodec(mimeType))
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return h264writer.New(path)
+	default:
+		return nil, fmt.Errorf("unsupported mime type %q", mimeType)
+	}
+}
+
+func getRecordingFileExt(mimeType string) string {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeOpus):
+		return "ogg"
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return "h264"
+	default:
+		return "ivf"
+	}
+}
+
+func (r *callRecorder) addParticipant(s *session) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	// WHEP viewers aren't part of the call.
+	if r.stopped || s.isWHEP() {
+		return
+	}
+
+	if p := r.participants[s.cfg.SessionID]; p != nil && p.LeaveAt == 0 {
+		return
+	}
+
+	r.participants[s.cfg.SessionID] = &recordingParticipant{
+		UserID:    s.cfg.UserID,
+		SessionID: s.cfg.SessionID,
+		JoinAt:    time.Now().UnixMilli(),
+	}
+}
+
+func (r *callRecorder) removeParticipant(sessionID string) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if p := r.participants[sessionID]; p != nil && p.LeaveAt == 0 {
+		p.LeaveAt = time.Now().UnixMilli()
+	}
+}
+
+// getTrack returns the recorder for the given track, creating it if needed,
+// along with whether it was just created. levelFn, if given, is called on
+// creation to pick the simulcast level to record.
+func (r *callRecorder) getTrack(s *session, tt trackType, streamID, mimeType string, levelFn func() string) (*trackRecorder, bool, error) {
+	key := s.cfg.SessionID + "_" + string(tt) + "_" + streamID
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.stopped {
+		return nil, false, nil
+	}
+
+	if tr := r.tracks[key]; tr != nil {
+		return tr, false, nil
+	}
+
+	filename := fmt.Sprintf("%s_%s_%d.%s", tt, s.cfg.SessionID, len(r.tracks), getRecordingFileExt(mimeType))
+	writer, err := newMediaWriter(mimeType, filepath.Join(r.dir, filename))
+	if err != nil {
+		// We keep an empty recorder so that the track gets skipped from now on.
+		r.tracks[key] = &trackRecorder{}
+		return nil, false, fmt.Errorf("failed to create media writer: %w", err)
+	}
+
+	var level string
+	if levelFn != nil {
+		level = levelFn()
+	}
+
+	now := time.Now().UnixMilli()
+	tr := &trackRecorder{
+		level:  level,
+		writer: writer,
+		info: &recordingTrack{
+			SessionID: s.cfg.SessionID,
+			UserID:    s.cfg.UserID,
+			Type:      string(tt),
+			MimeType:  mimeType,
+			Filename:  filename,
+			StartAt:   now,
+			EndAt:     now,
+		},
+	}
+	r.tracks[key] = tr
+
+	return tr, true, nil
+}
+
+// writeRTP records the given packet, received on the given simulcast level
+// (video only). It returns whether the track just started being recorded, in
+// which case a keyframe should be requested.
+func (r *callRecorder) writeRTP(s *session, tt trackType, streamID, mimeType, level string, levelFn func() string, pkt *rtp.Packet) (bool, error) {
+	tr, created, err := r.getTrack(s, tt, streamID, mimeType, levelFn)
+	if err != nil || tr == nil {
+		return false, err
+	}
+
+	if tr.level != level {
+		return created, nil
+	}
+
+	tr.mut.Lock()
+	defer tr.mut.Unlock()
+
+	if tr.writer == nil {
+		// Already closed.
+		return created, nil
+	}
+
+	if err := tr.writer.WriteRTP(pkt); err != nil {
+		// We stop recording the track as it's unlikely to recover (e.g. disk
+		// full).
+		if err := tr.writer.Close(); err != nil {
+			r.log.Error("failed to close track recording", mlog.Err(err), mlog.String("filename", tr.info.Filename))
+		}
+		tr.writer = nil
+		return created, fmt.Errorf("failed to write packet: %w", err)
+	}
+	tr.info.EndAt = time.Now().UnixMilli()
+
+	return created, nil
+}
+
+// stop closes all the track files and writes the manifest.
+func (r *callRecorder) stop() (*recordingManifest, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.stopped {
+		return nil, fmt.Errorf("recording already stopped")
+	}
+	r.stopped = true
+
+	endAt := time.Now().UnixMilli()
+	manifest := &recordingManifest{
+		CallID:  r.callID,
+		StartAt: r.startAt.UnixMilli(),
+		EndAt:   endAt,
+	}
+
+	for _, tr := range r.tracks {
+		tr.mut.Lock()
+		if tr.writer != nil {
+			if err := tr.writer.Close(); err != nil {
+				r.log.Error("failed to close track recording", mlog.Err(err), mlog.String("filename", tr.info.Filename))
+			}
+			tr.writer = nil
+			manifest.Tracks = append(manifest.Tracks, tr.info)
+		}
+		tr.mut.Unlock()
+	}
+	sort.Slice(manifest.Tracks, func(i, j int) bool {
+		return manifest.Tracks[i].Filename < manifest.Tracks[j].Filename
+	})
+
+	for _, p := range r.participants {
+		if p.LeaveAt == 0 {
+			p.LeaveAt = endAt
+		}
+		manifest.Participants = append(manifest.Participants, p)
+	}
+	sort.Slice(manifest.Participants, func(i, j int) bool {
+		return manifest.Participants[i].JoinAt < manifest.Participants[j].JoinAt
+	})
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(r.dir, recordingManifestFilename), data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// getRecordingLevel returns the simulcast level to record for the given
+// stream: the highest one being published.
+func (c *call) getRecordingLevel(s *session, ms *mediaStream, mimeType string) string {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	levels := c.simulcastLevels
+	for i := len(levels) - 1; i >= 0; i-- {
+		if ms.remoteTracks[getTrackIndex(mimeType, levels[i].RID)] != nil {
+			return levels[i].RID
+		}
+	}
+
+	return SimulcastLevelDefault
+}
+
+// recordRTP records the given packet if the call is being recorded. See
+// callRecorder.writeRTP.
+func (c *call) recordRTP(s *session, tt trackType, streamID, mimeType, level string, levelFn func() string, pkt *rtp.Packet) (bool, error) {
+	r := c.recorder.Load()
+	if r == nil {
+		return false, nil
+	}
+	return r.writeRTP(s, tt, streamID, mimeType, level, levelFn, pkt)
+}
+
+func (c *call) startRecording(baseDir string, log mlog.LoggerIFace) (*callRecorder, error) {
+	r, err := newCallRecorder(baseDir, c.id, log)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	if !c.recorder.CompareAndSwap(nil, r) {
+		return nil, fmt.Errorf("recording already in progress")
+	}
+
+	if err := os.MkdirAll(r.dir, 0700); err != nil {
+		c.recorder.Store(nil)
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	for _, s := range c.sessions {
+		r.addParticipant(s)
+	}
+
+	return r, nil
+}
+
+func (c *call) stopRecording() (*recordingManifest, error) {
+	r := c.recorder.Swap(nil)
+	if r == nil {
+		return nil, fmt.Errorf("no recording in progress")
+	}
+	return r.stop()
+}
+
+// StartRecording starts recording the voice and screen tracks of the given
+// call under the configured directory.
+func (s *Server) StartRecording(groupID, callID string) error {
+	if s.cfg.RecordingsDir == "" {
+		return fmt.Errorf("recording is disabled")
+	}
+
+	g := s.getGroup(groupID)
+	if g == nil {
+		return fmt.Errorf("group not found: %s", groupID)
+	}
+	c := g.getCall(callID)
+	if c == nil {
+		return fmt.Errorf("call not found: %s", callID)
+	}
+
+	r, err := c.startRecording(s.cfg.RecordingsDir, s.log)
+	if err != nil {
+		return fmt.Errorf("failed to start recording: %w", err)
+	}
+
+	s.log.Info("recording started", mlog.String("callID", callID), mlog.String("dir", r.dir))
+
+	return nil
+}
+
+// StopRecording stops recording the given call, writing the recording's
+// manifest.
+func (s *Server) StopRecording(groupID, callID string) error {
+	g := s.getGroup(groupID)
+	if g == nil {
+		return fmt.Errorf("group not found: %s", groupID)
+	}
+	c := g.getCall(callID)
+	if c == nil {
+		return fmt.Errorf("call not found: %s", callID)
+	}
+
+	manifest, err := c.stopRecording()
+	if err != nil {
+		return fmt.Errorf("failed to stop recording: %w", err)
+	}
+
+	s.log.Info("recording stopped", mlog.String("callID", callID), mlog.Int("tracks", len(manifest.Tracks)))
+
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCallRecorder(t *testing.T) {
+	for _, callID := range []string{"", ".", "..", "../callID", `call\ID`} {
+		r, err := newCallRecorder(t.TempDir(), callID, nil)
+		require.EqualError(t, err, fmt.Sprintf("invalid call ID %q", callID))
+		require.Nil(t, r)
+	}
+
+	baseDir := t.TempDir()
+	r, err := newCallRecorder(baseDir, "callID", nil)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	require.Equal(t, baseDir, filepath.Dir(r.dir))
+}
+
+func TestCallRecording(t *testing.T) {
+	c, sA, sB := newTestCall(t, 1)
+	baseDir := t.TempDir()
+
+	// Nothing gets recorded until started.
+	started, err := c.recordRTP(sA, trackTypeVoice, "streamA", webrtc.MimeTypeOpus, "", nil, newTestOpusPacket(100, 1000))
+	require.NoError(t, err)
+	require.False(t, started)
+
+	_, err = c.stopRecording()
+	require.EqualError(t, err, "no recording in progress")
+
+	r, err := c.startRecording(baseDir, sA.log)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	require.DirExists(t, r.dir)
+
+	_, err = c.startRecording(baseDir, sA.log)
+	require.EqualError(t, err, "recording already in progress")
+
+	for i := 0; i < 10; i++ {
+		started, err = c.recordRTP(sA, trackTypeVoice, "streamA", webrtc.MimeTypeOpus, "", nil, newTestOpusPacket(uint16(100+i), uint32(1000+i*960)))
+		require.NoError(t, err)
+		require.Equal(t, i == 0, started)
+	}
+
+	// Only the selected level gets recorded.
+	levelFn := func() string { return SimulcastLevelHigh }
+	started, err = c.recordRTP(sB, trackTypeScreen, "screenB", webrtc.MimeTypeVP8, SimulcastLevelLow, levelFn, newTestVP8Packet(100, 1000, true))
+	require.NoError(t, err)
+	require.True(t, started)
+	started, err = c.recordRTP(sB, trackTypeScreen, "screenB", webrtc.MimeTypeVP8, SimulcastLevelHigh, levelFn, newTestVP8Packet(200, 1000, true))
+	require.NoError(t, err)
+	require.False(t, started)
+
+	// Unsupported codecs are skipped.
+	_, err = c.recordRTP(sB, trackTypeScreen, "screenB2", webrtc.MimeTypeVP9, SimulcastLevelLow, nil, newTestVP8Packet(100, 1000, true))
+	require.EqualError(t, err, `failed to create media writer: unsupported mime type "video/VP9"`)
+	_, err = c.recordRTP(sB, trackTypeScreen, "screenB2", webrtc.MimeTypeVP9, SimulcastLevelLow, nil, newTestVP8Packet(101, 1000, true))
+	require.NoError(t, err)
+
+	sC, ok := c.addSession(SessionConfig{GroupID: "groupID", CallID: c.id, UserID: "userC", SessionID: "sessionC"}, nil, nil, sA.log)
+	require.True(t, ok)
+	r.removeParticipant(sC.cfg.SessionID)
+
+	manifest, err := c.stopRecording()
+	require.NoError(t, err)
+	require.Nil(t, c.recorder.Load())
+
+	// Writes after stop are ignored.
+	started, err = c.recordRTP(sA, trackTypeVoice, "streamA", webrtc.MimeTypeOpus, "", nil, newTestOpusPacket(110, 10600))
+	require.NoError(t, err)
+	require.False(t, started)
+
+	require.Equal(t, "callID", manifest.CallID)
+	require.GreaterOrEqual(t, manifest.EndAt, manifest.StartAt)
+	require.Len(t, manifest.Participants, 3)
+	for _, p := range manifest.Participants {
+		require.NotZero(t, p.JoinAt)
+		require.GreaterOrEqual(t, p.LeaveAt, p.JoinAt)
+	}
+
+	require.Len(t, manifest.Tracks, 2)
+	require.Equal(t, "screen_sessionB_1.ivf", manifest.Tracks[0].Filename)
+	require.Equal(t, "sessionB", manifest.Tracks[0].SessionID)
+	require.Equal(t, "userB", manifest.Tracks[0].UserID)
+	require.Equal(t, webrtc.MimeTypeVP8, manifest.Tracks[0].MimeType)
+	require.Equal(t, "voice_sessionA_0.ogg", manifest.Tracks[1].Filename)
+	require.Equal(t, string(trackTypeVoice), manifest.Tracks[1].Type)
+
+	for _, track := range manifest.Tracks {
+		info, err := os.Stat(filepath.Join(r.dir, track.Filename))
+		require.NoError(t, err)
+		require.NotZero(t, info.Size())
+	}
+
+	data, err := os.ReadFile(filepath.Join(r.dir, recordingManifestFilename))
+	require.NoError(t, err)
+	var fileManifest recordingManifest
+	require.NoError(t, json.Unmarshal(data, &fileManifest))
+	require.Equal(t, *manifest, fileManifest)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mattermost/rtcd/service/tracing"
+
+	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// A call can span multiple nodes by relaying tracks between them over
+// server-to-server peer connections. Each direction gets its own connection,
+// represented on both ends by a session sharing the same ID: the outgoing
+// relay receives the call's tracks like any other participant and offers them
+// to the remote node, where the incoming relay publishes them into the call
+// on behalf of the sessions that originally sent them. Signaling messages are
+// exchanged through the regular channels and are expected to be forwarded
+// from one node to the other by the client (e.g. the plugin).
+const (
+	RelayDirectionIn  = "in"
+	RelayDirectionOut = "out"
+
+	relayDataChannelLabel = "relay"
+)
+
+func (s *session) relayDirection() string {
+	return s.cfg.Props.RelayDirection()
+}
+
+// canForwardTo returns whether tracks published by the session should be
+// forwarded to the given receiver. Incoming relays and WHIP sessions only ever
+// publish, and tracks coming from a relay are not relayed any further to
+// prevent loops between nodes.
+func (s *session) canForwardTo(receiver *session) bool {
+	if s == receiver || receiver.relayDirection() == RelayDirectionIn || receiver.isWHIP() {
+		return false
+	}
+
+	return s.relayDirection() != RelayDirectionIn || receiver.relayDirection() != RelayDirectionOut
+}
+
+// initRelay starts the negotiation for an outgoing relay. Unlike clients, the
+// remote node never offers so we need to go first. A data channel is included
+// so that the connection gets established before any track is added.
+func (s *session) initRelay(sdpOutCh chan<- Message) (errRet error) {
+	ctx, span := s.startSpan(trace.SpanContext{}, "rtc.init_relay")
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
+	if _, err := s.rtcConn.CreateDataChannel(relayDataChannelLabel, nil); err != nil {
+		return fmt.Errorf("failed to create data channel: %w", err)
+	}
+
+	if err := s.sendOffer(ctx, sdpOutCh, nil); err != nil {
+		return fmt.Errorf("failed to send offer: %w", err)
+	}
+
+	select {
+	case answer, ok := <-s.sdpAnswerInCh:
+		if !ok {
+			return nil
+		}
+		if err := s.rtcConn.SetRemoteDescription(answer); err != nil {
+			return fmt.Errorf("failed to set remote description: %w", err)
+		}
+	case <-time.After(signalingTimeout):
+		return fmt.Errorf("timed out signaling")
+	case <-s.closeCh:
+		s.log.Debug("closeCh closed during signaling", mlog.Any("sessionCfg", s.cfg))
+	}
+
+	return nil
+}
+
+// newRelayICEMessage creates an ICE message in the format nodes expect from
+// clients so that it can be forwarded to the remote end of a relay as is.
+func newRelayICEMessage(s *session, c *webrtc.ICECandidate) (Message, error) {
+	js, err := json.Marshal(c.ToJSON())
+	if err != nil {
+		return Message{}, err
+	}
+	return newMessage(s, ICEMessage, js), nil
+}
+
+// clearAudioStream cleans up the state associated with an audio track
+// published by the given incoming relay, removing it from any receiving
+// session.
+func (c *call) clearAudioStream(us *session, streamID string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	us.mut.Lock()
+	ms := us.streams[streamID]
+	if ms == nil || ms.outAudioTrack == nil {
+		us.mut.Unlock()
+		return
+	}
+	delete(us.streams, streamID)
+	us.mut.Unlock()
+
+	if ms.trackType == trackTypeVoice {
+		if c.dominantSpeaker != nil {
+			c.dominantSpeaker.RemoveSpeaker(ms.publisherID)
+		}
+		c.removeAudioSlotsSpeaker(ms.publisherID, nil)
+	}
+
+	// Receivers are taken care of by handleSessionClose if the relay is gone.
+	if c.sessions[us.cfg.SessionID] != us {
+		return
+	}
+
+	for _, ss := range c.sessions {
+		if ss == us || ss.rtcConn == nil {
+			continue
+		}
+
+		for _, sender := range ss.rtcConn.GetSenders() {
+			if sender.Track() != ms.outAudioTrack {
+				continue
+			}
+			select {
+			case ss.tracksCh <- trackActionContext{action: trackActionRemove, track: ms.outAudioTrack}:
+			default:
+				ss.log.Error("failed to send audio track: channel is full", mlog.String("sessionID", ss.cfg.SessionID))
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/rtcd/service/perf"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+func TestSessionCanForwardTo(t *testing.T) {
+	newSession := func(sessionID, relayDirection string) *session {
+		return &session{cfg: SessionConfig{SessionID: sessionID, Props: SessionProps{"relayDirection": relayDirection}}}
+	}
+
+	sA := newSession("sessionA", "")
+	sB := newSession("sessionB", "")
+	relayIn := newSession("relayIn", RelayDirectionIn)
+	relayOut := newSession("relayOut", RelayDirectionOut)
+
+	require.False(t, sA.canForwardTo(sA))
+	require.True(t, sA.canForwardTo(sB))
+	require.True(t, sA.canForwardTo(relayOut))
+	require.False(t, sA.canForwardTo(relayIn))
+
+	require.True(t, relayIn.canForwardTo(sA))
+	require.False(t, relayIn.canForwardTo(relayOut))
+	require.False(t, relayIn.canForwardTo(relayIn))
+
+	require.True(t, relayOut.canForwardTo(sA))
+	require.False(t, relayOut.canForwardTo(relayIn))
+}
+
+func TestSessionConfigRelayDirection(t *testing.T) {
+	cfg := SessionConfig{
+		GroupID:   "groupID",
+		CallID:    "callID",
+		UserID:    "userID",
+		SessionID: "sessionID",
+	}
+	require.NoError(t, cfg.IsValid())
+
+	cfg.Props = SessionProps{"relayDirection": RelayDirectionIn}
+	require.NoError(t, cfg.IsValid())
+
+	cfg.Props = SessionProps{"relayDirection": RelayDirectionOut}
+	require.NoError(t, cfg.IsValid())
+
+	cfg.Props = SessionProps{"relayDirection": "both"}
+	require.EqualError(t, cfg.IsValid(), `invalid RelayDirection value: should be either "in" or "out"`)
+}
+
+func setupRelayServer(t *testing.T, port int) *Server {
+	t.Helper()
+
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+
+	cfg := ServerConfig{
+		ICEPortUDP:      port,
+		ICEPortTCP:      port,
+		UDPSocketsCount: 1,
+	}
+
+	s, err := NewServer(cfg, log, perf.NewMetrics("rtcd", nil))
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+
+	t.Cleanup(func() {
+		require.NoError(t, s.Stop())
+		require.NoError(t, log.Shutdown())
+	})
+
+	return s
+}
+
+// connectRelayClient connects a client to the given server, answering any
+// renegotiation, optionally publishing the given track. Tracks received are
+// sent on the returned channel.
+func connectRelayClient(t *testing.T, s *Server, cfg SessionConfig, msgCh <-chan Message, track webrtc.TrackLocal) <-chan *webrtc.TrackRemote {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, pc.Close())
+	})
+
+	send := func(msgType MessageType, data []byte) {
+		err := s.Send(Message{
+			GroupID:   cfg.GroupID,
+			CallID:    cfg.CallID,
+			UserID:    cfg.UserID,
+			SessionID: cfg.SessionID,
+			Type:      msgType,
+			Data:      data,
+		})
+		require.NoError(t, err)
+	}
+
+	tracksCh := make(chan *webrtc.TrackRemote, 10)
+	pc.OnTrack(func(remoteTrack *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		tracksCh <- remoteTrack
+	})
+
+	pc.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+		if candidate == nil {
+			return
+		}
+		data, err := json.Marshal(candidate.ToJSON())
+		require.NoError(t, err)
+		send(ICEMessage, data)
+	})
+
+	_, err = pc.CreateDataChannel("calls-dc", nil)
+	require.NoError(t, err)
+
+	if track != nil {
+		_, err = pc.AddTrack(track)
+		require.NoError(t, err)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	data, err := json.Marshal(offer)
+	require.NoError(t, err)
+	send(SDPMessage, data)
+
+	go func() {
+		var candidates []webrtc.ICECandidateInit
+		for msg := range msgCh {
+			switch msg.Type {
+			case ICEMessage:
+				var data struct {
+					Candidate webrtc.ICECandidateInit `json:"candidate"`
+				}
+				require.NoError(t, json.Unmarshal(msg.Data, &data))
+				if pc.RemoteDescription() == nil {
+					candidates = append(candidates, data.Candidate)
+					continue
+				}
+				require.NoError(t, pc.AddICECandidate(data.Candidate))
+			case SDPMessage:
+				var sdp webrtc.SessionDescription
+				require.NoError(t, json.Unmarshal(msg.Data, &sdp))
+				require.NoError(t, pc.SetRemoteDescription(sdp))
+				for _, candidate := range candidates {
+					require.NoError(t, pc.AddICECandidate(candidate))
+				}
+				candidates = nil
+
+				if sdp.Type != webrtc.SDPTypeOffer {
+					continue
+				}
+
+				answer, err := pc.CreateAnswer(nil)
+				require.NoError(t, err)
+				require.NoError(t, pc.SetLocalDescription(answer))
+				data, err := json.Marshal(answer)
+				require.NoError(t, err)
+				send(SDPMessage, data)
+			}
+		}
+	}()
+
+	return tracksCh
+}
+
+func TestRelay(t *testing.T) {
+	sA := setupRelayServer(t, 30433)
+	sB := setupRelayServer(t, 30434)
+
+	// The relay's signaling is forwarded between the nodes while the clients'
+	// one goes to their own channel.
+	clientChs := map[string]chan Message{
+		"sessionA": make(chan Message, 50),
+		"sessionB": make(chan Message, 50),
+	}
+	route := func(from, to *Server) {
+		for msg := range from.ReceiveCh() {
+			if msg.SessionID == "relayAB" {
+				require.NoError(t, to.Send(msg))
+				continue
+			}
+			if ch := clientChs[msg.SessionID]; ch != nil {
+				ch <- msg
+			}
+		}
+	}
+	go route(sA, sB)
+	go route(sB, sA)
+
+	relayCfg := SessionConfig{
+		GroupID:   "groupID",
+		CallID:    "callID",
+		UserID:    "relay",
+		SessionID: "relayAB",
+	}
+
+	// The receiving end needs to be ready before the relay starts offering.
+	relayCfg.Props = SessionProps{"relayDirection": RelayDirectionIn}
+	require.NoError(t, sB.InitSession(relayCfg, nil))
+	relayCfg.Props = SessionProps{"relayDirection": RelayDirectionOut}
+	require.NoError(t, sA.InitSession(relayCfg, nil))
+
+	cfgA := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userA", SessionID: "sessionA"}
+	require.NoError(t, sA.InitSession(cfgA, nil))
+	cfgB := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userB", SessionID: "sessionB"}
+	require.NoError(t, sB.InitSession(cfgB, nil))
+
+	voiceTrack, err := webrtc.NewTrackLocalStaticSample(rtpAudioCodec, "voice", "streamA")
+	require.NoError(t, err)
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := voiceTrack.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond})
+				require.NoError(t, err)
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	connectRelayClient(t, sA, cfgA, clientChs["sessionA"], voiceTrack)
+	tracksCh := connectRelayClient(t, sB, cfgB, clientChs["sessionB"], nil)
+
+	// The voice track published on node A shows up on node B as if it was
+	// published by sessionA locally.
+	select {
+	case track := <-tracksCh:
+		require.True(t, strings.HasPrefix(track.ID(), "voice_sessionA_"), track.ID())
+		_, _, err := track.ReadRTP()
+		require.NoError(t, err)
+	case <-time.After(20 * time.Second):
+		require.FailNow(t, "timed out waiting for relayed track")
+	}
+
+	callB := sB.getGroup("groupID").getCall("callID")
+	relayB := callB.getSession("relayAB")
+	require.NotNil(t, relayB)
+	relayB.mut.RLock()
+	require.Len(t, relayB.streams, 1)
+	for _, ms := range relayB.streams {
+		require.Equal(t, "sessionA", ms.publisherID)
+		require.Equal(t, trackTypeVoice, ms.trackType)
+	}
+	relayB.mut.RUnlock()
+
+	for _, s := range []*Server{sA, sB} {
+		for _, sessionID := range []string{"relayAB", "sessionA", "sessionB"} {
+			require.NoError(t, s.CloseSession(sessionID))
+		}
+	}
+}
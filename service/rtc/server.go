@@ -12,9 +12,12 @@ import (
 	"time"
 
 	"github.com/mattermost/rtcd/service/rtc/dc"
+	"github.com/mattermost/rtcd/service/tracing"
 
 	"github.com/pion/ice/v2"
+	"github.com/pion/turn/v2"
 	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
 )
@@ -25,6 +28,8 @@ const (
 	catchAllIP       = "0.0.0.0"
 )
 
+const defaultMaxScreenSharesPerCall = 1
+
 type Server struct {
 	cfg     ServerConfig
 	log     mlog.LoggerIFace
@@ -35,13 +40,28 @@ type Server struct {
 
 	udpMux         ice.UDPMux
 	tcpMux         ice.TCPMux
+	turnServer     *turn.Server
 	publicAddrsMap map[netip.Addr]string
-	localIPs       []netip.Addr
+	// hostPortOverrides maps external addresses to the port that should be
+	// advertised in their host candidates.
+	hostPortOverrides map[string]int
+	// addrsMut guards publicAddrsMap and hostPortOverrides as they can be
+	// updated at runtime.
+	addrsMut sync.RWMutex
+	localIPs []netip.Addr
 
 	sendCh    chan Message
 	receiveCh chan Message
+	events    *eventQueue
 	drainCh   chan struct{}
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
 	bufPool   *sync.Pool
+	// draining is set once the server stops accepting new sessions.
+	draining bool
+	// closingSessions counts the sessions being closed, which are no longer
+	// tracked in sessions.
+	closingSessions int
 
 	mut sync.RWMutex
 }
@@ -57,6 +77,17 @@ func NewServer(cfg ServerConfig, log mlog.LoggerIFace, metrics Metrics) (*Server
 		return nil, fmt.Errorf("metrics should not be nil")
 	}
 
+	if cfg.MaxScreenSharesPerCall == 0 {
+		cfg.MaxScreenSharesPerCall = defaultMaxScreenSharesPerCall
+	}
+
+	if len(cfg.SimulcastLevels) == 0 {
+		cfg.SimulcastLevels = GetDefaultSimulcastLevels()
+	}
+
+	// Levels are kept sorted by rate to simplify selecting them.
+	cfg.SimulcastLevels = cfg.SimulcastLevels.sorted()
+
 	s := &Server{
 		cfg:            cfg,
 		log:            log,
@@ -65,6 +96,8 @@ func NewServer(cfg ServerConfig, log mlog.LoggerIFace, metrics Metrics) (*Server
 		sessions:       map[string]SessionConfig{},
 		sendCh:         make(chan Message, msgChSize),
 		receiveCh:      make(chan Message, msgChSize),
+		events:         newEventQueue(log, msgChSize),
+		stopCh:         make(chan struct{}),
 		bufPool:        &sync.Pool{New: func() interface{} { return make([]byte, receiveMTU) }},
 		publicAddrsMap: make(map[netip.Addr]string),
 	}
@@ -107,42 +140,22 @@ func (s *Server) Start() error {
 
 	s.log.Debug("rtc: found local IPs", mlog.Any("ips", s.localIPs))
 
-	if m, _ := s.cfg.ICEHostPortOverride.ParseMap(); len(m) > 0 {
-		s.log.Debug("rtc: found ice host port override mappings", mlog.Any("mappings", s.cfg.ICEHostPortOverride))
-
-		for _, ip := range localIPs {
-			if port, ok := m[ip.String()]; ok {
-				s.log.Debug("rtc: found port override for local address", mlog.String("address", ip.String()), mlog.Int("port", port))
-				s.cfg.ICEHostPortOverride = ICEHostPortOverride(fmt.Sprintf("%d", port))
-				// NOTE: currently not supporting multiple ip/port mappings for the same rtcd instance.
-				break
-			}
-		}
-	}
-
 	// Populate public IP addresses map if override is not set and STUN is provided.
-	if s.cfg.ICEHostOverride == "" && len(s.cfg.ICEServers) > 0 {
-		for _, ip := range localIPs {
-			udpListenAddr := netip.AddrPortFrom(ip, uint16(s.cfg.ICEPortUDP)).String()
-			udpAddr, err := net.ResolveUDPAddr(udpNetwork, udpListenAddr)
-			if err != nil {
-				s.log.Error("failed to resolve UDP address", mlog.Err(err))
-				continue
-			}
-
-			// TODO: consider making this logic concurrent to lower total time taken
-			// in case of multiple interfaces.
-			addr, err := getPublicIP(udpAddr, udpNetwork, s.cfg.ICEServers.getSTUN())
-			if err != nil {
-				s.log.Warn("failed to get public IP address for local interface", mlog.String("localAddr", ip.String()), mlog.Err(err))
-			} else {
+	if s.shouldDiscoverPublicAddrs() {
+		for ip, addr := range s.discoverPublicAddrs(udpNetwork, s.cfg.ICEPortUDP) {
+			if addr != "" {
 				s.log.Info("got public IP address for local interface", mlog.String("localAddr", ip.String()), mlog.String("remoteAddr", addr))
 			}
-
 			s.publicAddrsMap[ip] = addr
 		}
 	}
 
+	if s.cfg.ICEHostPortOverride != "" {
+		if err := s.initHostPortOverrides(); err != nil {
+			return err
+		}
+	}
+
 	if err := s.initUDP(localIPs, udpNetwork); err != nil {
 		return err
 	}
@@ -151,15 +164,31 @@ func (s *Server) Start() error {
 		return err
 	}
 
+	if s.cfg.TURNConfig.Server.Enable {
+		if err := s.initTURNServer(udpNetwork, tcpNetwork); err != nil {
+			return fmt.Errorf("failed to start TURN server: %w", err)
+		}
+	}
+
 	go s.msgReader()
 
+	if s.shouldDiscoverPublicAddrs() && s.cfg.PublicIPRefreshIntervalMinutes > 0 {
+		s.wg.Add(1)
+		go s.publicAddrsRefresher(udpNetwork, time.Duration(s.cfg.PublicIPRefreshIntervalMinutes)*time.Minute)
+	}
+
+	if s.cfg.SessionStatsDumpIntervalSeconds > 0 {
+		s.wg.Add(1)
+		go s.sessionStatsDumper(time.Duration(s.cfg.SessionStatsDumpIntervalSeconds) * time.Second)
+	}
+
 	return nil
 }
 
 func (s *Server) Stop() error {
 	var drainCh chan struct{}
 	s.mut.Lock()
-	if len(s.sessions) > 0 {
+	if len(s.sessions) > 0 || s.closingSessions > 0 {
 		s.log.Info("rtc: sessions ongoing, draining before exiting")
 		drainCh = make(chan struct{})
 		s.drainCh = drainCh
@@ -172,7 +201,11 @@ func (s *Server) Stop() error {
 		<-drainCh
 	}
 
+	close(s.stopCh)
+	s.wg.Wait()
+
 	close(s.receiveCh)
+	s.events.close()
 	close(s.sendCh)
 
 	if s.tcpMux != nil {
@@ -187,6 +220,12 @@ func (s *Server) Stop() error {
 		}
 	}
 
+	if s.turnServer != nil {
+		if err := s.turnServer.Close(); err != nil {
+			return fmt.Errorf("failed to close TURN server: %w", err)
+		}
+	}
+
 	s.log.Info("rtc: server was shutdown")
 
 	return nil
@@ -229,6 +268,9 @@ func (s *Server) msgReader() {
 			continue
 		}
 
+		_, span := session.startSpan(msg.SpanContext, "rtc.message")
+		span.SetAttributes(tracing.RTCMsgTypeKey.Int(int(msg.Type)))
+
 		switch msg.Type {
 		case ICEMessage:
 			select {
@@ -237,35 +279,66 @@ func (s *Server) msgReader() {
 				s.log.Error("failed to send sdp message: channel is full", mlog.Any("session", session.cfg))
 			}
 		case SDPMessage:
-			if err := s.handleIncomingSDP(session, s.receiveCh, msg.Data); err != nil {
+			if err := s.handleIncomingSDP(session, s.receiveCh, msg.Data, span.SpanContext()); err != nil {
 				s.log.Error("failed to handle incoming sdp", mlog.Err(err), mlog.Any("session", session.cfg))
 			}
+		case ICERestartMessage:
+			if err := session.requestICERestart(); err != nil {
+				s.log.Error("failed to request ICE restart", mlog.Err(err), mlog.Any("session", session.cfg))
+			}
 		case ScreenOnMessage:
 			data := map[string]string{}
 			if err := json.Unmarshal(msg.Data, &data); err != nil {
 				s.log.Error("failed to unmarshal screen msg data", mlog.Err(err))
-				continue
+				break
 			}
 
 			s.log.Debug("received screen sharing stream ID", mlog.String("screenStreamID", data["screenStreamID"]))
 
 			session.mut.Lock()
-			session.screenStreamID = data["screenStreamID"]
+			session.screenStreamIDs[data["screenStreamID"]] = true
 			session.mut.Unlock()
 
-			if ok := call.setScreenSession(session); !ok {
-				s.log.Error("screen session should not be set")
+			if err := call.addScreenStream(session, data["screenStreamID"]); err != nil {
+				s.log.Error("failed to add screen stream", mlog.Err(err), mlog.String("sessionID", session.cfg.SessionID))
+				break
 			}
+
+			s.events.send(newScreenEvent(ScreenOnEvent, session.cfg, data["screenStreamID"]))
 		case ScreenOffMessage:
-			if err := call.clearScreenState(session); err != nil {
+			// The stream ID is optional for compatibility with older clients, in
+			// which case all the session's screen streams are stopped.
+			data := map[string]string{}
+			if len(msg.Data) > 0 {
+				if err := json.Unmarshal(msg.Data, &data); err != nil {
+					s.log.Error("failed to unmarshal screen msg data", mlog.Err(err))
+					break
+				}
+			}
+
+			if err := call.clearScreenState(session, data["screenStreamID"]); err != nil {
 				s.log.Error("failed to clear screen state", mlog.Err(err))
 			}
 		case MuteMessage, UnmuteMessage:
-			session.mut.RLock()
+			muteState, evType := muteStateUnmuted, UnmuteEvent
+			if msg.Type == MuteMessage {
+				muteState, evType = muteStateMuted, MuteEvent
+			}
+
+			session.mut.Lock()
+			changed := session.muteState != muteState
+			session.muteState = muteState
 			track := session.outVoiceTrack
-			session.mut.RUnlock()
+			session.mut.Unlock()
+
+			// Clients may repeat their state (e.g. on reconnect) so events are only
+			// sent on actual changes.
+			if changed {
+				s.events.send(newEvent(evType, session.cfg))
+			}
+
 			if track == nil {
-				continue
+				break
 			}
 
 			var enabled bool
@@ -291,7 +364,32 @@ func (s *Server) msgReader() {
 		default:
 			s.log.Error("received unexpected message type")
 		}
+
+		span.End()
+	}
+}
+
+func (s *Server) initHostPortOverrides() error {
+	publicAddrsMap := s.getPublicAddrsMap()
+
+	pairs, err := generateAddrsPairs(s.localIPs, publicAddrsMap, s.cfg.ICEHostOverride, s.cfg.EnableIPv6)
+	if err != nil {
+		return fmt.Errorf("failed to generate addresses pairs: %w", err)
+	}
+
+	overrides, err := getHostPortOverrides(s.cfg.ICEHostPortOverride, s.localIPs, pairs,
+		getExternalAddrMapFromHostOverride(s.cfg.ICEHostOverride, publicAddrsMap))
+	if err != nil {
+		return err
 	}
+
+	s.addrsMut.Lock()
+	s.hostPortOverrides = overrides
+	s.addrsMut.Unlock()
+
+	s.log.Debug("rtc: found ice host port overrides", mlog.Any("overrides", overrides))
+
+	return nil
 }
 
 func (s *Server) initUDP(localIPs []netip.Addr, network string) error {
@@ -353,7 +451,7 @@ func (s *Server) initTCP(network string) error {
 	return nil
 }
 
-func (s *Server) handleIncomingSDP(us *session, answerCh chan<- Message, data []byte) error {
+func (s *Server) handleIncomingSDP(us *session, answerCh chan<- Message, data []byte, spanCtx trace.SpanContext) error {
 	var sdp webrtc.SessionDescription
 	if err := json.Unmarshal(data, &sdp); err != nil {
 		return fmt.Errorf("failed to unmarshal sdp: %w", err)
@@ -363,7 +461,7 @@ func (s *Server) handleIncomingSDP(us *session, answerCh chan<- Message, data []
 
 	if sdp.Type == webrtc.SDPTypeOffer {
 		select {
-		case us.sdpOfferInCh <- offerMessage{sdp: sdp, answerCh: answerCh}:
+		case us.sdpOfferInCh <- offerMessage{sdp: sdp, answerCh: answerCh, spanCtx: spanCtx}:
 		default:
 			return fmt.Errorf("failed to send sdp offer: channel is full")
 		}
@@ -400,15 +498,24 @@ func (s *Server) handleDCMessage(data []byte, us *session, dataCh *webrtc.DataCh
 			return fmt.Errorf("failed to send pong message: %w", err)
 		}
 	case dc.MessageTypeSDP:
-		if err := s.handleIncomingSDP(us, us.dcSDPCh, payload.([]byte)); err != nil {
+		if err := s.handleIncomingSDP(us, us.dcSDPCh, payload.([]byte), trace.SpanContext{}); err != nil {
 			return fmt.Errorf("failed to handle incoming sdp message: %w", err)
 		}
+	case dc.MessageTypeICERestart:
+		if err := us.requestICERestart(); err != nil {
+			return fmt.Errorf("failed to request ICE restart: %w", err)
+		}
 	case dc.MessageTypeLossRate:
 		s.metrics.ObserveRTCClientLossRate(us.cfg.GroupID, payload.(float64))
 	case dc.MessageTypeRoundTripTime:
 		s.metrics.ObserveRTCClientRTT(us.cfg.GroupID, payload.(float64))
 	case dc.MessageTypeJitter:
 		s.metrics.ObserveRTCClientJitter(us.cfg.GroupID, payload.(float64))
+	case dc.MessageTypeVideoPreference:
+		pref := payload.(dc.MessageVideoPreference)
+		if err := us.setVideoPreference(pref.TrackID, pref.MaxLevel, pref.Paused); err != nil {
+			return fmt.Errorf("failed to set video preference: %w", err)
+		}
 	}
 
 	return nil
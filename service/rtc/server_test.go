@@ -14,12 +14,17 @@ import (
 
 	"github.com/mattermost/rtcd/service/perf"
 	"github.com/mattermost/rtcd/service/random"
+	"github.com/mattermost/rtcd/service/tracing"
 
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
 	"github.com/mattermost/rtcd/logger"
 	"github.com/pion/ice/v2"
 	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func setupServer(t *testing.T) (*Server, func()) {
@@ -96,6 +101,8 @@ func TestNewServer(t *testing.T) {
 		s, err := NewServer(cfg, log, metrics)
 		require.NoError(t, err)
 		require.NotNil(t, s)
+		require.Equal(t, defaultMaxScreenSharesPerCall, s.cfg.MaxScreenSharesPerCall)
+		require.Equal(t, GetDefaultSimulcastLevels().sorted(), s.cfg.SimulcastLevels)
 	})
 }
 
@@ -210,6 +217,92 @@ func TestDraining(t *testing.T) {
 
 		require.True(t, time.Since(beforeStop) > time.Second)
 	})
+
+	t.Run("migration", func(t *testing.T) {
+		s, err := NewServer(cfg, log, metrics)
+		require.NoError(t, err)
+		require.NotNil(t, s)
+
+		err = s.Start()
+		require.NoError(t, err)
+
+		require.Empty(t, s.Drain())
+		s.mut.Lock()
+		s.draining = false
+		s.mut.Unlock()
+
+		sessionCfgA := SessionConfig{
+			GroupID:   "groupID",
+			CallID:    "callID",
+			UserID:    "userA",
+			SessionID: "sessionA",
+			Props:     SessionProps{"channelID": "channelID"},
+		}
+		peerConnA, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		require.NoError(t, err)
+		sA, err := s.addSession(sessionCfgA, peerConnA, nil)
+		require.NoError(t, err)
+		err = sA.call.addScreenStream(sA, "screenStreamID")
+		require.NoError(t, err)
+
+		sessionCfgB := SessionConfig{
+			GroupID:   "groupID",
+			CallID:    "callID",
+			UserID:    "userB",
+			SessionID: "sessionB",
+		}
+		peerConnB, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		require.NoError(t, err)
+		sB, err := s.addSession(sessionCfgB, peerConnB, nil)
+		require.NoError(t, err)
+		sB.mut.Lock()
+		sB.outVoiceTrack, err = webrtc.NewTrackLocalStaticRTP(rtpAudioCodec, "voice", "streamID")
+		sB.outVoiceTrackEnabled = true
+		sB.mut.Unlock()
+		require.NoError(t, err)
+
+		states := s.Drain()
+		require.ElementsMatch(t, []SessionState{
+			{
+				GroupID:         "groupID",
+				CallID:          "callID",
+				UserID:          "userA",
+				SessionID:       "sessionA",
+				Props:           SessionProps{"channelID": "channelID"},
+				Muted:           true,
+				ScreenStreamIDs: []string{"screenStreamID"},
+			},
+			{
+				GroupID:   "groupID",
+				CallID:    "callID",
+				UserID:    "userB",
+				SessionID: "sessionB",
+			},
+		}, states)
+		for _, state := range states {
+			if state.SessionID == sessionCfgA.SessionID {
+				require.Equal(t, sessionCfgA, state.SessionConfig())
+			}
+		}
+
+		// No new sessions are accepted while draining.
+		err = s.InitSession(SessionConfig{
+			GroupID:   "groupID",
+			CallID:    "callID",
+			UserID:    "userC",
+			SessionID: "sessionC",
+		}, nil)
+		require.EqualError(t, err, "server is draining")
+
+		for _, us := range []*session{sA, sB} {
+			close(us.doneCh)
+			err = s.CloseSession(us.cfg.SessionID)
+			require.NoError(t, err)
+		}
+
+		err = s.Stop()
+		require.NoError(t, err)
+	})
 }
 
 func TestInitSession(t *testing.T) {
@@ -278,6 +371,46 @@ func TestInitSession(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestInitSessionWithState(t *testing.T) {
+	s, shutdown := setupServer(t)
+	defer shutdown()
+	require.NoError(t, s.Start())
+
+	state := SessionState{
+		GroupID:         "groupID",
+		CallID:          "callID",
+		UserID:          "userA",
+		SessionID:       "sessionA",
+		Props:           SessionProps{"channelID": "channelID"},
+		Muted:           true,
+		ScreenStreamIDs: []string{"screenStreamID"},
+	}
+
+	t.Run("session mismatch", func(t *testing.T) {
+		cfg := state.SessionConfig()
+		cfg.SessionID = "sessionB"
+		err := s.InitSession(cfg, nil, WithSessionState(state))
+		require.EqualError(t, err, "session state mismatch: sessionID=sessionB, stateSessionID=sessionA")
+	})
+
+	t.Run("restored", func(t *testing.T) {
+		err := s.InitSession(state.SessionConfig(), nil, WithSessionState(state))
+		require.NoError(t, err)
+
+		us, err := s.getSession(state.SessionConfig())
+		require.NoError(t, err)
+		us.mut.RLock()
+		require.Equal(t, muteStateMuted, us.muteState)
+		require.True(t, us.screenStreamIDs["screenStreamID"])
+		us.mut.RUnlock()
+
+		// The migrated state is carried over as is.
+		require.Equal(t, state, us.call.getSessionState(us))
+
+		require.NoError(t, s.CloseSession(state.SessionID))
+	})
+}
+
 func connectSession(t *testing.T, cfg SessionConfig, s *Server, receiveCh chan Message) {
 	t.Helper()
 
@@ -701,7 +834,7 @@ func TestICEHostPortOverride(t *testing.T) {
 		serverCfg := ServerConfig{
 			ICEPortUDP:          30433,
 			ICEPortTCP:          30433,
-			ICEHostOverride:     "8.8.8.8",
+			ICEHostOverride:     "8.8.8.8/127.0.0.1",
 			ICEHostPortOverride: "127.0.0.1/8443",
 			UDPSocketsCount:     GetDefaultUDPListeningSocketsCount(),
 		}
@@ -713,7 +846,7 @@ func TestICEHostPortOverride(t *testing.T) {
 			candidate := <-candidatesCh
 			require.Equal(t, ice.CandidateTypeHost, candidate.Type())
 
-			if candidate.Address() == serverCfg.ICEHostOverride {
+			if candidate.Address() == "8.8.8.8" {
 				require.Equal(t, 8443, candidate.Port())
 			} else {
 				require.Equal(t, serverCfg.ICEPortUDP, candidate.Port())
@@ -721,6 +854,50 @@ func TestICEHostPortOverride(t *testing.T) {
 		}
 	})
 
+	t.Run("host override - multiple mappings", func(t *testing.T) {
+		localIPs, err := getSystemIPs(log, false)
+		require.NoError(t, err)
+		var localIP string
+		for _, ip := range localIPs {
+			if !ip.IsLoopback() {
+				localIP = ip.String()
+				break
+			}
+		}
+		if localIP == "" {
+			t.Skip("no non-loopback address found")
+		}
+
+		serverCfg := ServerConfig{
+			ICEPortUDP:          30433,
+			ICEPortTCP:          30433,
+			ICEHostOverride:     fmt.Sprintf("8.8.8.8/127.0.0.1,8.8.4.4/%s", localIP),
+			ICEHostPortOverride: ICEHostPortOverride(fmt.Sprintf("127.0.0.1/8443,%s/8444", localIP)),
+			UDPSocketsCount:     GetDefaultUDPListeningSocketsCount(),
+		}
+
+		candidatesCh := gatherCandidates(serverCfg, nil)
+
+		require.NotEmpty(t, candidatesCh)
+		seen := make(map[string]bool)
+		for len(candidatesCh) > 0 {
+			candidate := <-candidatesCh
+			require.Equal(t, ice.CandidateTypeHost, candidate.Type())
+			seen[candidate.Address()] = true
+
+			switch candidate.Address() {
+			case "8.8.8.8":
+				require.Equal(t, 8443, candidate.Port())
+			case "8.8.4.4":
+				require.Equal(t, 8444, candidate.Port())
+			default:
+				require.Equal(t, serverCfg.ICEPortUDP, candidate.Port())
+			}
+		}
+		require.True(t, seen["8.8.8.8"])
+		require.True(t, seen["8.8.4.4"])
+	})
+
 	t.Run("host override from STUN", func(t *testing.T) {
 		serverCfg := ServerConfig{
 			ICEPortUDP:          30433,
@@ -748,3 +925,78 @@ func TestICEHostPortOverride(t *testing.T) {
 		}
 	})
 }
+
+func TestSignalingTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defaultProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(defaultProvider)
+
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+
+	cfg := ServerConfig{
+		ICEPortUDP:      30446,
+		ICEPortTCP:      30446,
+		UDPSocketsCount: 1,
+	}
+	s, err := NewServer(cfg, log, perf.NewMetrics("rtcd", nil))
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer func() {
+		require.NoError(t, s.Stop())
+		require.NoError(t, log.Shutdown())
+	}()
+
+	sessionCfg := SessionConfig{
+		GroupID:   "groupID",
+		CallID:    "callID",
+		UserID:    "userID",
+		SessionID: "sessionID",
+	}
+	require.NoError(t, s.InitSession(sessionCfg, nil))
+	defer func() {
+		require.NoError(t, s.CloseSession(sessionCfg.SessionID))
+	}()
+
+	var answerSpanCtx trace.SpanContext
+	receiveCh := make(chan Message, 50)
+	go func() {
+		for msg := range s.ReceiveCh() {
+			if msg.Type == SDPMessage {
+				answerSpanCtx = msg.SpanContext
+			}
+			receiveCh <- msg
+		}
+	}()
+
+	connectSession(t, sessionCfg, s, receiveCh)
+
+	var msgSpan, signalingSpan sdktrace.ReadOnlySpan
+	require.Eventually(t, func() bool {
+		for _, span := range recorder.Ended() {
+			switch span.Name() {
+			case "rtc.message":
+				for _, attr := range span.Attributes() {
+					if attr.Key == tracing.RTCMsgTypeKey && attr.Value.AsInt64() == int64(SDPMessage) {
+						msgSpan = span
+					}
+				}
+			case "rtc.signaling":
+				signalingSpan = span
+			}
+		}
+		return msgSpan != nil && signalingSpan != nil
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// The offer/answer round is a child of the message that started it and
+	// the answer carries its context back.
+	require.Equal(t, msgSpan.SpanContext().SpanID(), signalingSpan.Parent().SpanID())
+	require.Equal(t, msgSpan.SpanContext().TraceID(), signalingSpan.SpanContext().TraceID())
+	require.Equal(t, signalingSpan.SpanContext().SpanID(), answerSpanCtx.SpanID())
+
+	for _, span := range []sdktrace.ReadOnlySpan{msgSpan, signalingSpan} {
+		require.Contains(t, span.Attributes(), tracing.SessionIDKey.String(sessionCfg.SessionID))
+		require.Contains(t, span.Attributes(), tracing.CallIDKey.String(sessionCfg.CallID))
+	}
+}
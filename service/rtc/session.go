@@ -4,20 +4,26 @@
 package rtc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 
+	"github.com/mattermost/rtcd/service/rtc/dc"
 	"github.com/mattermost/rtcd/service/rtc/vad"
+	"github.com/mattermost/rtcd/service/tracing"
 
 	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
 )
@@ -32,8 +38,20 @@ const (
 type offerMessage struct {
 	sdp      webrtc.SessionDescription
 	answerCh chan<- Message
+	// spanCtx is the context of the span the offer was received in.
+	spanCtx trace.SpanContext
 }
 
+type muteState int
+
+const (
+	// muteStateUnknown is the state of sessions that never signaled it, whose
+	// voice track is enabled as soon as it's received.
+	muteStateUnknown muteState = iota
+	muteStateMuted
+	muteStateUnmuted
+)
+
 // session contains all the state necessary to connect a user to a call.
 type session struct {
 	cfg SessionConfig
@@ -45,23 +63,49 @@ type session struct {
 	sdpOfferInCh  chan offerMessage
 	sdpAnswerInCh chan webrtc.SessionDescription
 	dcSDPCh       chan Message
+	// dcMsgCh holds encoded messages to be sent to the client over the data
+	// channel.
+	dcMsgCh chan []byte
+	// iceRestartCh holds a pending ICE restart request.
+	iceRestartCh chan struct{}
 
 	// Sender (publishing side)
 	outVoiceTrack        *webrtc.TrackLocalStaticRTP
 	outVoiceTrackEnabled bool
-	screenStreamID       string
-	outScreenTracks      map[string][]*webrtc.TrackLocalStaticRTP
-	outScreenAudioTrack  *webrtc.TrackLocalStaticRTP
-	remoteScreenTracks   map[string]*webrtc.TrackRemote
-	screenRateMonitors   map[string]*RateMonitor
+	// muteState holds the mute state last signaled by the client (or
+	// restored on migration). The voice track starts disabled if muted.
+	muteState muteState
+	// screenStreamIDs holds the IDs of the screen streams announced by the
+	// session, whether or not they were accepted by the call.
+	screenStreamIDs map[string]bool
+	// streams holds the video streams (screen or camera) published by the
+	// session, keyed by stream ID.
+	streams map[string]*mediaStream
 
 	// Receiver
-	bwEstimator       cc.BandwidthEstimator
-	screenTrackSender *webrtc.RTPSender
+	bwEstimator cc.BandwidthEstimator
+	// streamSenders maps a video stream, keyed through getStreamSenderKey, to
+	// the sender used to forward it.
+	streamSenders map[string]*webrtc.RTPSender
+	// audioSlots are the voice tracks forwarding the most relevant speakers
+	// of the call when the number of audio slots is limited.
+	audioSlots []*audioSlotTrack
+	// whepSenders maps the senders negotiated with a WHEP viewer to the
+	// placeholder tracks they fall back to when idle.
+	whepSenders map[*webrtc.RTPSender]webrtc.TrackLocal
+
+	// statsGetter gives access to the RTP stats of the session's streams. Nil
+	// unless EnableSessionStats is set.
+	statsGetter stats.Getter
+	// statsSamples holds the previous stats sample of each stream, keyed by
+	// SSRC, to compute bitrates.
+	statsSamples map[uint32]trackStatsSample
 
 	closeCh chan struct{}
 	closeCb func() error
 	doneCh  chan struct{}
+	// resumeTimer is set while waiting for a failed connection to recover.
+	resumeTimer *time.Timer
 
 	vadMonitor *vad.Monitor
 
@@ -99,12 +143,22 @@ func (s *Server) addSession(cfg SessionConfig, peerConn *webrtc.PeerConnection,
 	if c == nil {
 		// call is missing, creating one
 		c = &call{
-			id:          cfg.CallID,
-			sessions:    map[string]*session{},
-			pliLimiters: map[webrtc.SSRC]*rate.Limiter{},
-			metrics:     s.metrics,
+			id:              cfg.CallID,
+			sessions:        map[string]*session{},
+			screenStreams:   map[string]*session{},
+			maxScreenShares: s.cfg.MaxScreenSharesPerCall,
+			simulcastLevels: s.cfg.SimulcastLevels,
+			audioSlots:      newAudioSlots(s.cfg.AudioSlots),
+			pliLimiters:     map[webrtc.SSRC]*rate.Limiter{},
+			metrics:         s.metrics,
+			events:          s.events,
+		}
+		if err := c.initDominantSpeaker(s.log, s.receiveCh); err != nil {
+			g.mut.Unlock()
+			return nil, err
 		}
 		g.calls[c.id] = c
+		s.events.send(newCallEvent(CallStartedEvent, cfg.GroupID, cfg.CallID))
 	}
 	g.mut.Unlock()
 
@@ -116,6 +170,26 @@ func (s *Server) addSession(cfg SessionConfig, peerConn *webrtc.PeerConnection,
 	s.sessions[cfg.SessionID] = cfg
 	s.mut.Unlock()
 
+	if hasPresence(cfg) {
+		s.events.send(newEvent(SessionJoinedEvent, cfg))
+	}
+
+	return us, nil
+}
+
+func (s *Server) getSession(cfg SessionConfig) (*session, error) {
+	g := s.getGroup(cfg.GroupID)
+	if g == nil {
+		return nil, fmt.Errorf("group not found: %s", cfg.GroupID)
+	}
+	c := g.getCall(cfg.CallID)
+	if c == nil {
+		return nil, fmt.Errorf("call not found: %s", cfg.CallID)
+	}
+	us := c.getSession(cfg.SessionID)
+	if us == nil {
+		return nil, fmt.Errorf("session not found: %s", cfg.SessionID)
+	}
 	return us, nil
 }
 
@@ -131,14 +205,10 @@ func (s *Server) handleNegotiations(us *session, call *call) {
 		}
 	}()
 
-	select {
-	case offerMsg, ok := <-us.sdpOfferInCh:
-		if !ok {
-			return
-		}
-		if err := us.signaling(offerMsg.sdp, offerMsg.answerCh); err != nil {
+	if us.relayDirection() == RelayDirectionOut {
+		if err := us.initRelay(s.receiveCh); err != nil {
 			s.metrics.IncRTCErrors(us.cfg.GroupID, "signaling")
-			s.log.Error("failed to signal", mlog.Err(err), mlog.Any("sessionCfg", us.cfg))
+			s.log.Error("failed to init relay", mlog.Err(err), mlog.Any("sessionCfg", us.cfg))
 
 			// We need to preemptively close doneCh to avoid CloseSession from blocking indefinitely on it.
 			close(us.doneCh)
@@ -148,20 +218,39 @@ func (s *Server) handleNegotiations(us *session, call *call) {
 
 			return
 		}
-	case <-time.After(signalingTimeout):
-		s.log.Error("timed out signaling", mlog.Any("sessionCfg", us.cfg))
-		s.metrics.IncRTCErrors(us.cfg.GroupID, "signaling")
+	} else {
+		select {
+		case offerMsg, ok := <-us.sdpOfferInCh:
+			if !ok {
+				return
+			}
+			if err := us.signaling(offerMsg.sdp, offerMsg.answerCh, offerMsg.spanCtx); err != nil {
+				s.metrics.IncRTCErrors(us.cfg.GroupID, "signaling")
+				s.log.Error("failed to signal", mlog.Err(err), mlog.Any("sessionCfg", us.cfg))
+
+				// We need to preemptively close doneCh to avoid CloseSession from blocking indefinitely on it.
+				close(us.doneCh)
+				if err := s.CloseSession(us.cfg.SessionID); err != nil {
+					s.log.Error("failed to close session", mlog.Any("sessionCfg", us.cfg))
+				}
 
-		// We need to preemptively close doneCh to avoid CloseSession from blocking indefinitely on it.
-		close(us.doneCh)
-		if err := s.CloseSession(us.cfg.SessionID); err != nil {
-			s.log.Error("failed to close session", mlog.Any("sessionCfg", us.cfg))
-		}
+				return
+			}
+		case <-time.After(signalingTimeout):
+			s.log.Error("timed out signaling", mlog.Any("sessionCfg", us.cfg))
+			s.metrics.IncRTCErrors(us.cfg.GroupID, "signaling")
 
-		return
-	case <-us.closeCh:
-		s.log.Debug("closeCh closed during signaling", mlog.Any("sessionCfg", us.cfg))
-		return
+			// We need to preemptively close doneCh to avoid CloseSession from blocking indefinitely on it.
+			close(us.doneCh)
+			if err := s.CloseSession(us.cfg.SessionID); err != nil {
+				s.log.Error("failed to close session", mlog.Any("sessionCfg", us.cfg))
+			}
+
+			return
+		case <-us.closeCh:
+			s.log.Debug("closeCh closed during signaling", mlog.Any("sessionCfg", us.cfg))
+			return
+		}
 	}
 
 	iceDoneCh := make(chan struct{})
@@ -175,13 +264,24 @@ func (s *Server) handleNegotiations(us *session, call *call) {
 	<-iceDoneCh
 }
 
-func (s *session) getScreenStreamID() string {
+func (s *session) isScreenStreamID(streamID string) bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.screenStreamIDs[streamID]
+}
+
+func (s *session) getStreamType(streamID string) trackType {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
-	return s.screenStreamID
+
+	if ms := s.streams[streamID]; ms != nil {
+		return ms.trackType
+	}
+
+	return ""
 }
 
-func (s *session) getRemoteScreenTrack(mimeType, rid string) *webrtc.TrackRemote {
+func (s *session) getRemoteTrack(streamID, mimeType, rid string) *webrtc.TrackRemote {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 
@@ -189,10 +289,17 @@ func (s *session) getRemoteScreenTrack(mimeType, rid string) *webrtc.TrackRemote
 		rid = SimulcastLevelDefault
 	}
 
-	return s.remoteScreenTracks[getTrackIndex(mimeType, rid)]
+	ms := s.streams[streamID]
+	if ms == nil {
+		return nil
+	}
+
+	return ms.remoteTracks[getTrackIndex(mimeType, rid)]
 }
 
-func (s *session) getSourceRate(mimeType, rid string) int {
+// getSourceRate returns the rate at which the track identified by the given
+// stream, mime type and rid is being received.
+func (s *session) getSourceRate(streamID, mimeType, rid string) int {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 
@@ -200,7 +307,10 @@ func (s *session) getSourceRate(mimeType, rid string) int {
 		rid = SimulcastLevelDefault
 	}
 
-	rm := s.screenRateMonitors[getTrackIndex(mimeType, rid)]
+	var rm *RateMonitor
+	if ms := s.streams[streamID]; ms != nil {
+		rm = ms.rateMonitors[getTrackIndex(mimeType, rid)]
+	}
 
 	if rm == nil {
 		s.log.Warn("rate monitor should not be nil", mlog.String("sessionID", s.cfg.SessionID))
@@ -212,11 +322,25 @@ func (s *session) getSourceRate(mimeType, rid string) int {
 	return rate
 }
 
-func (s *session) getOutScreenTrack(mimeType, rid string) *webrtc.TrackLocalStaticRTP {
+// getStreamMimeType returns the mime type of the tracks that should be
+// forwarded to receiver for the given stream, or an empty string if there's
+// none the receiver can decode.
+func (s *session) getStreamMimeType(streamID string, receiver *session) string {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
 
-	return pickRandom(s.outScreenTracks[getTrackIndex(mimeType, rid)])
+	ms := s.streams[streamID]
+	if ms == nil {
+		return ""
+	}
+
+	return ms.getMimeType(s, receiver)
+}
+
+func (s *session) getStreamSender(key string) *webrtc.RTPSender {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.streamSenders[key]
 }
 
 func (s *session) getExpectedSimulcastLevel() string {
@@ -224,10 +348,10 @@ func (s *session) getExpectedSimulcastLevel() string {
 	defer s.mut.RUnlock()
 
 	if s.bwEstimator == nil {
-		return SimulcastLevelDefault
+		return s.call.simulcastLevels.getLowest()
 	}
 
-	return getSimulcastLevelForRate(s.bwEstimator.GetTargetBitrate())
+	return s.call.simulcastLevels.getLevelForRate(s.bwEstimator.GetTargetBitrate())
 }
 
 // handleICE deals with trickle ICE candidates.
@@ -283,7 +407,7 @@ func (s *session) handleReceiverRTCP(receiver *webrtc.RTPReceiver, rid string) {
 }
 
 // handleSenderRTCP is used to listen for for RTCP packets such as PLI (Picture Loss Indication)
-// from a peer receiving a video track (e.g. screen).
+// from a peer receiving a video track (e.g. screen, camera).
 func (s *session) handleSenderRTCP(sender *webrtc.RTPSender) {
 	for {
 		pkts, _, err := sender.ReadRTCP()
@@ -303,56 +427,86 @@ func (s *session) handleSenderRTCP(sender *webrtc.RTPSender) {
 					s.log.Debug("received PLI request for track", mlog.String("sessionID", s.cfg.SessionID), mlog.Uint("SSRC", dstSSRC))
 				}
 
-				screenSession := s.call.getScreenSession()
-				if screenSession == nil {
-					s.log.Error("screenSession should not be nil", mlog.String("sessionID", s.cfg.SessionID))
+				senderTrack, ok := sender.Track().(*simulcastTrack)
+				if !ok && s.isWHEP() {
+					// Idle WHEP senders have nothing to request a keyframe for.
+					continue
+				} else if !ok {
+					s.log.Error("track conversion failed", mlog.String("sessionID", s.cfg.SessionID))
 					return
 				}
 
-				senderTrack, ok := sender.Track().(*webrtc.TrackLocalStaticRTP)
-				if !ok {
-					s.log.Error("track conversion failed", mlog.String("sessionID", s.cfg.SessionID))
-					return
+				// Nothing to request if the receiver paused the track.
+				if senderTrack.isPaused() {
+					continue
 				}
 
-				if senderTrack == nil {
-					s.log.Error("senderTrack should not be nil", mlog.String("sessionID", s.cfg.SessionID))
+				// The track ID tells us both the type of track and the session
+				// publishing it.
+				tt, publisherID, err := parseTrackID(senderTrack.ID())
+				if err != nil {
+					s.log.Error("failed to parse track ID", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
 					return
 				}
 
-				screenTrack := screenSession.getRemoteScreenTrack(senderTrack.Codec().MimeType, sender.Track().RID())
-				if screenTrack == nil {
-					s.log.Error("screenTrack should not be nil", mlog.String("sessionID", s.cfg.SessionID))
+				publisherSession := s.call.getSession(publisherID)
+				if publisherSession == nil {
+					s.log.Error("publisherSession should not be nil", mlog.String("sessionID", s.cfg.SessionID))
 					return
 				}
 
-				s.call.mut.Lock()
-				// We allow at most one PLI request per second for a given SSRC to avoid overloading the sender.
-				// If a receiving client were to miss it due to rate limiting (e.g. joining right in the second of backoff),
-				// it will request it again and eventually get it.
-				limiter, ok := s.call.pliLimiters[screenTrack.SSRC()]
-				if !ok {
-					s.log.Debug("creating new PLI limiter for track", mlog.Uint("SSRC", screenTrack.SSRC()))
-					limiter = rate.NewLimiter(1, 1)
-					s.call.pliLimiters[screenTrack.SSRC()] = limiter
+				remoteTrack := publisherSession.getRemoteTrack(senderTrack.StreamID(), senderTrack.mimeType, senderTrack.getLevel())
+				if remoteTrack == nil {
+					s.log.Error("remoteTrack should not be nil", mlog.String("sessionID", s.cfg.SessionID), mlog.String("trackType", string(tt)))
+					return
 				}
-				s.call.mut.Unlock()
-
-				if limiter.Allow() {
-					s.log.Debug("forwarding PLI request for track", mlog.String("sessionID", s.cfg.SessionID), mlog.Uint("SSRC", screenTrack.SSRC()))
-					if err := screenSession.rtcConn.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(screenTrack.SSRC())}}); err != nil {
-						s.log.Error("failed to write RTCP packet", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
-						return
-					}
+
+				if err := publisherSession.requestKeyFrame(remoteTrack); err != nil {
+					s.log.Error("failed to write RTCP packet", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
+					return
 				}
 			}
 		}
 	}
 }
 
-// sendOffer creates and sends out a new SDP offer.
-func (s *session) sendOffer(sdpOutCh chan<- Message) error {
-	offer, err := s.rtcConn.CreateOffer(nil)
+// requestKeyFrame sends a PLI request for the given track to the session
+// publishing it.
+// NOTE: this should not be called under lock (call.mut).
+func (s *session) requestKeyFrame(remoteTrack *webrtc.TrackRemote) error {
+	s.call.mut.Lock()
+	// We allow at most one PLI request per second for a given SSRC to avoid overloading the sender.
+	// If a receiving client were to miss it due to rate limiting (e.g. joining right in the second of backoff),
+	// it will request it again and eventually get it.
+	limiter, ok := s.call.pliLimiters[remoteTrack.SSRC()]
+	if !ok {
+		s.log.Debug("creating new PLI limiter for track", mlog.Uint("SSRC", remoteTrack.SSRC()))
+		limiter = rate.NewLimiter(1, 1)
+		s.call.pliLimiters[remoteTrack.SSRC()] = limiter
+	}
+	s.call.mut.Unlock()
+
+	if !limiter.Allow() {
+		return nil
+	}
+
+	s.log.Debug("forwarding PLI request for track", mlog.String("sessionID", s.cfg.SessionID), mlog.Uint("SSRC", remoteTrack.SSRC()))
+
+	return s.rtcConn.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remoteTrack.SSRC())}})
+}
+
+// startSpan starts a span for an operation on the session, as a child of the
+// given span context if valid.
+func (s *session) startSpan(spanCtx trace.SpanContext, name string) (context.Context, trace.Span) {
+	ctx := trace.ContextWithSpanContext(context.Background(), spanCtx)
+	return tracing.Tracer().Start(ctx, name,
+		trace.WithAttributes(tracing.SessionAttrs(s.cfg.GroupID, s.cfg.CallID, s.cfg.SessionID)...))
+}
+
+// sendOffer creates and sends out a new SDP offer. The offer is tied to the
+// span in the given context, if any.
+func (s *session) sendOffer(ctx context.Context, sdpOutCh chan<- Message, opts *webrtc.OfferOptions) error {
+	offer, err := s.rtcConn.CreateOffer(opts)
 	if err != nil {
 		return fmt.Errorf("failed to create offer: %w", err)
 	}
@@ -367,8 +521,11 @@ func (s *session) sendOffer(sdpOutCh chan<- Message) error {
 		return fmt.Errorf("failed to marshal sdp: %w", err)
 	}
 
+	msg := newMessage(s, SDPMessage, sdp)
+	msg.SpanContext = trace.SpanContextFromContext(ctx)
+
 	select {
-	case sdpOutCh <- newMessage(s, SDPMessage, sdp):
+	case sdpOutCh <- msg:
 		return nil
 	default:
 		return fmt.Errorf("failed to send SDP message: channel is full")
@@ -384,6 +541,12 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 	s.log.Debug("addTrack", mlog.String("sessionID", s.cfg.SessionID),
 		mlog.String("trackID", track.ID()))
 
+	ctx, span := s.startSpan(trace.SpanContext{}, "rtc.add_track")
+	span.SetAttributes(tracing.TrackIDKey.String(track.ID()))
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
 	s.mut.Lock()
 	s.makingOffer = true
 	s.mut.Unlock()
@@ -401,9 +564,17 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 		}
 	}
 
-	if track.Kind() == webrtc.RTPCodecTypeVideo && s.screenTrackSender != nil {
+	tt, publisherID, err := parseTrackID(track.ID())
+	if err != nil {
 		s.mut.Unlock()
-		return fmt.Errorf("screen track sender is already set")
+		return fmt.Errorf("failed to parse track ID: %w", err)
+	}
+
+	isVideo := tt == trackTypeScreen || tt == trackTypeVideo
+	senderKey := getStreamSenderKey(publisherID, track.StreamID())
+	if isVideo && s.streamSenders[senderKey] != nil {
+		s.mut.Unlock()
+		return fmt.Errorf("%s track sender is already set", tt)
 	}
 
 	sender, err := s.rtcConn.AddTrack(track)
@@ -412,6 +583,11 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 		return fmt.Errorf("failed to add track %s: %w", track.ID(), err)
 	}
 	s.call.metrics.IncRTPTracks(s.cfg.GroupID, "out", getTrackType(track.Kind()))
+	// The video sender is recorded right away so that concurrent cleanups
+	// (e.g. clearScreenState) can find it while negotiation is in progress.
+	if isVideo {
+		s.streamSenders[senderKey] = sender
+	}
 	s.mut.Unlock()
 
 	defer func() {
@@ -420,6 +596,9 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 		}
 
 		s.mut.Lock()
+		if isVideo && s.streamSenders[senderKey] == sender {
+			delete(s.streamSenders, senderKey)
+		}
 		if err := sender.ReplaceTrack(nil); err != nil {
 			s.log.Error("failed to replace track",
 				mlog.String("sessionID", s.cfg.SessionID),
@@ -432,9 +611,11 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 
 	go s.handleSenderRTCP(sender)
 
-	if err := s.sendOffer(sdpOutCh); err != nil {
+	offerSentAt := time.Now()
+	if err := s.sendOffer(ctx, sdpOutCh, nil); err != nil {
 		return fmt.Errorf("failed to send offer for track %s: %w", track.ID(), err)
 	}
+	s.call.metrics.IncRTCRenegotiations(s.cfg.GroupID)
 
 	select {
 	case answer, ok := <-s.sdpAnswerInCh:
@@ -444,11 +625,7 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 		if err := s.rtcConn.SetRemoteDescription(answer); err != nil {
 			return fmt.Errorf("failed to set remote description for track %s: %w", track.ID(), err)
 		}
-		if track.Kind() == webrtc.RTPCodecTypeVideo {
-			s.mut.Lock()
-			s.screenTrackSender = sender
-			s.mut.Unlock()
-		}
+		s.call.metrics.ObserveRTCRenegotiationTime(s.cfg.GroupID, time.Since(offerSentAt).Seconds())
 	case <-time.After(signalingTimeout):
 		return fmt.Errorf("timed out signaling")
 	case <-s.closeCh:
@@ -460,7 +637,7 @@ func (s *session) addTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (er
 }
 
 // removeTrack removes the given track to the peer and starts (re)negotiation.
-func (s *session) removeTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) error {
+func (s *session) removeTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal) (errRet error) {
 	if track == nil {
 		return fmt.Errorf("trying to remove a nil track")
 	}
@@ -468,6 +645,12 @@ func (s *session) removeTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal)
 	s.log.Debug("removeTrack", mlog.String("sessionID", s.cfg.SessionID),
 		mlog.String("trackID", track.ID()))
 
+	ctx, span := s.startSpan(trace.SpanContext{}, "rtc.remove_track")
+	span.SetAttributes(tracing.TrackIDKey.String(track.ID()))
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
 	var sender *webrtc.RTPSender
 
 	s.mut.Lock()
@@ -489,14 +672,22 @@ func (s *session) removeTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal)
 	}
 	s.call.metrics.DecRTPTracks(s.cfg.GroupID, "out", getTrackType(track.Kind()))
 
-	if s.screenTrackSender == sender {
-		s.screenTrackSender = nil
+	for key, streamSender := range s.streamSenders {
+		if streamSender == sender {
+			delete(s.streamSenders, key)
+		}
 	}
 	s.mut.Unlock()
 
-	if err := s.sendOffer(sdpOutCh); err != nil {
+	if outTrack, ok := track.(*simulcastTrack); ok {
+		outTrack.stream.removeOutTrack(outTrack)
+	}
+
+	offerSentAt := time.Now()
+	if err := s.sendOffer(ctx, sdpOutCh, nil); err != nil {
 		return fmt.Errorf("failed to send offer: %w", err)
 	}
+	s.call.metrics.IncRTCRenegotiations(s.cfg.GroupID)
 
 	select {
 	case answer, ok := <-s.sdpAnswerInCh:
@@ -506,6 +697,7 @@ func (s *session) removeTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal)
 		if err := s.rtcConn.SetRemoteDescription(answer); err != nil {
 			return fmt.Errorf("failed to set remote description: %w", err)
 		}
+		s.call.metrics.ObserveRTCRenegotiationTime(s.cfg.GroupID, time.Since(offerSentAt).Seconds())
 	case <-time.After(signalingTimeout):
 		return fmt.Errorf("timed out signaling")
 	case <-s.closeCh:
@@ -516,8 +708,14 @@ func (s *session) removeTrack(sdpOutCh chan<- Message, track webrtc.TrackLocal)
 	return nil
 }
 
-// signaling handles incoming SDP offers.
-func (s *session) signaling(offer webrtc.SessionDescription, answerCh chan<- Message) error {
+// signaling handles incoming SDP offers. The answer is tied to the span of the
+// offer, if any.
+func (s *session) signaling(offer webrtc.SessionDescription, answerCh chan<- Message, spanCtx trace.SpanContext) (errRet error) {
+	_, span := s.startSpan(spanCtx, "rtc.signaling")
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
 	if s.hasSignalingConflict() {
 		s.log.Debug("signaling conflict detected, ignoring offer", mlog.Any("session", s.cfg))
 		return nil
@@ -541,8 +739,11 @@ func (s *session) signaling(offer webrtc.SessionDescription, answerCh chan<- Mes
 		return err
 	}
 
+	msg := newMessage(s, SDPMessage, sdp)
+	msg.SpanContext = span.SpanContext()
+
 	select {
-	case answerCh <- newMessage(s, SDPMessage, sdp):
+	case answerCh <- msg:
 	default:
 		return fmt.Errorf("failed to send SDP message: channel is full")
 	}
@@ -564,12 +765,17 @@ func (s *session) InitVAD(log mlog.LoggerIFace, msgCh chan<- Message) error {
 		log.Debug("vad", mlog.Bool("voice", voice), mlog.String("sessionID", s.cfg.SessionID))
 
 		var msgType MessageType
+		var evType EventType
 		if voice {
 			msgType = VoiceOnMessage
+			evType = VoiceOnEvent
 		} else {
 			msgType = VoiceOffMessage
+			evType = VoiceOffEvent
 		}
 
+		s.call.events.send(newEvent(evType, s.cfg))
+
 		select {
 		case msgCh <- newMessage(s, msgType, nil):
 		default:
@@ -588,14 +794,6 @@ func (s *session) InitVAD(log mlog.LoggerIFace, msgCh chan<- Message) error {
 	return nil
 }
 
-func (s *session) clearScreenState() {
-	s.screenStreamID = ""
-	s.outScreenTracks = make(map[string][]*webrtc.TrackLocalStaticRTP)
-	s.outScreenAudioTrack = nil
-	s.remoteScreenTracks = make(map[string]*webrtc.TrackRemote)
-	s.screenRateMonitors = make(map[string]*RateMonitor)
-}
-
 func (s *session) supportsAV1() bool {
 	if s.cfg.Props == nil {
 		return false
@@ -604,6 +802,44 @@ func (s *session) supportsAV1() bool {
 	return s.cfg.Props.AV1Support()
 }
 
+// acceptsVideoCodec returns whether the session can receive video encoded with
+// the given codec. Sessions not advertising their codecs are assumed to accept
+// the default one, plus AV1 if supported.
+func (s *session) acceptsVideoCodec(mimeType string) bool {
+	// Relays forward whatever they get.
+	if s.relayDirection() != "" {
+		return isVideoMimeTypeSupported(mimeType)
+	}
+
+	var codecs []string
+	if s.cfg.Props != nil {
+		codecs = s.cfg.Props.VideoCodecs()
+	}
+
+	if len(codecs) == 0 {
+		codecs = []string{ScreenTrackMimeTypeDefault}
+		if s.supportsAV1() {
+			codecs = append(codecs, webrtc.MimeTypeAV1)
+		}
+	}
+
+	for _, codec := range codecs {
+		if strings.EqualFold(codec, mimeType) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *session) supportsVideo() bool {
+	if s.cfg.Props == nil {
+		return false
+	}
+
+	return s.cfg.Props.VideoSupport() || s.relayDirection() != ""
+}
+
 func (s *session) dcSignaling() bool {
 	if s.cfg.Props == nil {
 		return false
@@ -611,3 +847,20 @@ func (s *session) dcSignaling() bool {
 
 	return s.cfg.Props.DCSignaling()
 }
+
+// sendDCMessage queues a message to be sent to the client over the data
+// channel.
+func (s *session) sendDCMessage(mt dc.MessageType, payload any) error {
+	data, err := dc.EncodeMessage(mt, payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	select {
+	case s.dcMsgCh <- data:
+	default:
+		return fmt.Errorf("channel is full")
+	}
+
+	return nil
+}
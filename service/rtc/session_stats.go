@@ -0,0 +1,275 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/stats"
+	"github.com/pion/webrtc/v3"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// statsMinSampleInterval is the minimum interval over which bitrates are
+// computed. Samples taken more often report the previously computed bitrate.
+const statsMinSampleInterval = time.Second
+
+// SessionStats holds the transport and per-track stats of a session. Track
+// stats are only available if EnableSessionStats is set.
+type SessionStats struct {
+	GroupID   string `json:"groupID"`
+	CallID    string `json:"callID"`
+	UserID    string `json:"userID"`
+	SessionID string `json:"sessionID"`
+	Timestamp int64  `json:"timestamp"`
+	// BWETarget is the target bitrate (bps) estimated by the congestion
+	// controller for the media sent to the session.
+	BWETarget int `json:"bweTarget"`
+	// BytesSent and BytesReceived are the totals of the ICE transport.
+	BytesSent     uint64       `json:"bytesSent"`
+	BytesReceived uint64       `json:"bytesReceived"`
+	Inbound       []TrackStats `json:"inbound"`
+	Outbound      []TrackStats `json:"outbound"`
+}
+
+// TrackStats holds the stats of an RTP stream. For inbound streams the
+// NACK/PLI/FIR counts are the ones sent by the server, for outbound streams
+// the ones received from the client. Loss, jitter and RTT of outbound streams
+// are as reported by the client.
+type TrackStats struct {
+	TrackID  string `json:"trackID"`
+	RID      string `json:"rid,omitempty"`
+	SSRC     uint32 `json:"ssrc"`
+	Kind     string `json:"kind"`
+	MimeType string `json:"mimeType"`
+	Packets  uint64 `json:"packets"`
+	Bytes    uint64 `json:"bytes"`
+	// Bitrate (bps) is averaged since the previous sample, meaning the first
+	// sample of a stream reports none.
+	Bitrate      int     `json:"bitrate"`
+	PacketsLost  int64   `json:"packetsLost"`
+	FractionLost float64 `json:"fractionLost,omitempty"`
+	// Jitter and RoundTripTime are in seconds.
+	Jitter        float64 `json:"jitter"`
+	RoundTripTime float64 `json:"roundTripTime"`
+	NACKCount     uint32  `json:"nackCount"`
+	PLICount      uint32  `json:"pliCount"`
+	FIRCount      uint32  `json:"firCount"`
+}
+
+// trackStatsSample is the previous sample of a stream, used to compute its
+// bitrate.
+type trackStatsSample struct {
+	bytes   uint64
+	at      time.Time
+	bitrate int
+}
+
+// initStatsInterceptor adds the stats interceptor to the given registry. The
+// stats getter of the peer connection is sent on the returned channel once
+// created.
+func initStatsInterceptor(i *interceptor.Registry) (<-chan stats.Getter, error) {
+	statsInterceptor, err := stats.NewInterceptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stats interceptor: %w", err)
+	}
+
+	statsGetterCh := make(chan stats.Getter, 1)
+	statsInterceptor.OnNewPeerConnection(func(_ string, getter stats.Getter) {
+		statsGetterCh <- getter
+	})
+	i.Add(statsInterceptor)
+
+	return statsGetterCh, nil
+}
+
+func (s *session) getBitrate(ssrc uint32, bytes uint64, now time.Time) int {
+	prev, ok := s.statsSamples[ssrc]
+	if ok && now.Sub(prev.at) < statsMinSampleInterval {
+		return prev.bitrate
+	}
+
+	var bitrate int
+	if ok && bytes >= prev.bytes {
+		bitrate = int(float64(bytes-prev.bytes) * 8 / now.Sub(prev.at).Seconds())
+	}
+	s.statsSamples[ssrc] = trackStatsSample{bytes: bytes, at: now, bitrate: bitrate}
+
+	return bitrate
+}
+
+func (s *session) getStats() SessionStats {
+	now := time.Now()
+
+	res := SessionStats{
+		GroupID:   s.cfg.GroupID,
+		CallID:    s.cfg.CallID,
+		UserID:    s.cfg.UserID,
+		SessionID: s.cfg.SessionID,
+		Timestamp: now.UnixMilli(),
+		Inbound:   []TrackStats{},
+		Outbound:  []TrackStats{},
+	}
+
+	for _, report := range s.rtcConn.GetStats() {
+		if transportStats, ok := report.(webrtc.TransportStats); ok {
+			res.BytesSent = transportStats.BytesSent
+			res.BytesReceived = transportStats.BytesReceived
+		}
+	}
+
+	// The peer connection has a lock of its own so these are fetched before
+	// locking the session.
+	receivers := s.rtcConn.GetReceivers()
+	senders := s.rtcConn.GetSenders()
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.bwEstimator != nil {
+		res.BWETarget = s.bwEstimator.GetTargetBitrate()
+	}
+
+	if s.statsGetter == nil {
+		return res
+	}
+
+	seen := make(map[uint32]bool)
+
+	for _, receiver := range receivers {
+		for _, track := range receiver.Tracks() {
+			ssrc := uint32(track.SSRC())
+			st := s.statsGetter.Get(ssrc)
+			if ssrc == 0 || st == nil {
+				continue
+			}
+			seen[ssrc] = true
+
+			codec := track.Codec()
+			var jitter float64
+			if codec.ClockRate > 0 {
+				// Inbound jitter is in timestamp units.
+				jitter = st.InboundRTPStreamStats.Jitter / float64(codec.ClockRate)
+			}
+
+			res.Inbound = append(res.Inbound, TrackStats{
+				TrackID:       track.ID(),
+				RID:           track.RID(),
+				SSRC:          ssrc,
+				Kind:          track.Kind().String(),
+				MimeType:      codec.MimeType,
+				Packets:       st.InboundRTPStreamStats.PacketsReceived,
+				Bytes:         st.InboundRTPStreamStats.BytesReceived,
+				Bitrate:       s.getBitrate(ssrc, st.InboundRTPStreamStats.BytesReceived, now),
+				PacketsLost:   st.InboundRTPStreamStats.PacketsLost,
+				Jitter:        jitter,
+				RoundTripTime: st.RemoteOutboundRTPStreamStats.RoundTripTime.Seconds(),
+				NACKCount:     st.InboundRTPStreamStats.NACKCount,
+				PLICount:      st.InboundRTPStreamStats.PLICount,
+				FIRCount:      st.InboundRTPStreamStats.FIRCount,
+			})
+		}
+	}
+
+	for _, sender := range senders {
+		track := sender.Track()
+		if track == nil {
+			continue
+		}
+
+		params := sender.GetParameters()
+		var mimeType string
+		if len(params.Codecs) > 0 {
+			mimeType = params.Codecs[0].MimeType
+		}
+
+		for _, encoding := range params.Encodings {
+			ssrc := uint32(encoding.SSRC)
+			st := s.statsGetter.Get(ssrc)
+			if ssrc == 0 || st == nil {
+				continue
+			}
+			seen[ssrc] = true
+
+			res.Outbound = append(res.Outbound, TrackStats{
+				TrackID:       track.ID(),
+				SSRC:          ssrc,
+				Kind:          track.Kind().String(),
+				MimeType:      mimeType,
+				Packets:       st.OutboundRTPStreamStats.PacketsSent,
+				Bytes:         st.OutboundRTPStreamStats.BytesSent,
+				Bitrate:       s.getBitrate(ssrc, st.OutboundRTPStreamStats.BytesSent, now),
+				PacketsLost:   st.RemoteInboundRTPStreamStats.PacketsLost,
+				FractionLost:  st.RemoteInboundRTPStreamStats.FractionLost,
+				Jitter:        st.RemoteInboundRTPStreamStats.Jitter,
+				RoundTripTime: st.RemoteInboundRTPStreamStats.RoundTripTime.Seconds(),
+				NACKCount:     st.OutboundRTPStreamStats.NACKCount,
+				PLICount:      st.OutboundRTPStreamStats.PLICount,
+				FIRCount:      st.OutboundRTPStreamStats.FIRCount,
+			})
+		}
+	}
+
+	// Samples of streams that are gone are dropped.
+	for ssrc := range s.statsSamples {
+		if !seen[ssrc] {
+			delete(s.statsSamples, ssrc)
+		}
+	}
+
+	sortTrackStats := func(tracks []TrackStats) {
+		sort.Slice(tracks, func(i, j int) bool {
+			if tracks[i].TrackID != tracks[j].TrackID {
+				return tracks[i].TrackID < tracks[j].TrackID
+			}
+			return tracks[i].SSRC < tracks[j].SSRC
+		})
+	}
+	sortTrackStats(res.Inbound)
+	sortTrackStats(res.Outbound)
+
+	return res
+}
+
+// GetSessionsStats returns the stats of the sessions currently connected to
+// the server, optionally filtered by group, call and session.
+func (s *Server) GetSessionsStats(groupID, callID, sessionID string) []SessionStats {
+	res := []SessionStats{}
+	for _, c := range s.getCalls(groupID) {
+		if callID != "" && c.id != callID {
+			continue
+		}
+		for _, us := range c.getSessions() {
+			if sessionID != "" && us.cfg.SessionID != sessionID {
+				continue
+			}
+			res = append(res, us.getStats())
+		}
+	}
+
+	return res
+}
+
+// sessionStatsDumper periodically logs the stats of all sessions.
+func (s *Server) sessionStatsDumper(interval time.Duration) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, st := range s.GetSessionsStats("", "", "") {
+				s.log.Debug("rtc: session stats", mlog.String("sessionID", st.SessionID), mlog.Any("stats", st))
+			}
+		case <-s.stopCh:
+			return
+		}
+	}
+}
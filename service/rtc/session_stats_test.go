@@ -0,0 +1,140 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/rtcd/service/perf"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+func TestSessionGetBitrate(t *testing.T) {
+	us := &session{statsSamples: map[uint32]trackStatsSample{}}
+	now := time.Now()
+
+	// The first sample has nothing to compare against.
+	require.Zero(t, us.getBitrate(1, 1000, now))
+
+	// Samples taken too soon report the previous bitrate.
+	require.Zero(t, us.getBitrate(1, 2000, now.Add(500*time.Millisecond)))
+
+	require.Equal(t, 16000, us.getBitrate(1, 5000, now.Add(2*time.Second)))
+	require.Equal(t, 16000, us.getBitrate(1, 6000, now.Add(2500*time.Millisecond)))
+
+	// Counters going back (e.g. SSRC reused) don't yield bogus rates.
+	require.Zero(t, us.getBitrate(1, 100, now.Add(4*time.Second)))
+}
+
+func TestGetSessionsStats(t *testing.T) {
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+
+	cfg := ServerConfig{
+		ICEPortUDP:                      30445,
+		ICEPortTCP:                      30445,
+		UDPSocketsCount:                 1,
+		EnableSessionStats:              true,
+		SessionStatsDumpIntervalSeconds: 1,
+	}
+	metrics := perf.NewMetrics("rtcd", nil)
+	s, err := NewServer(cfg, log, metrics)
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer func() {
+		require.NoError(t, s.Stop())
+		require.NoError(t, log.Shutdown())
+	}()
+	defer func() {
+		// Stop waits for all the sessions to be closed.
+		_ = s.EndCall("groupID", "callID")
+	}()
+
+	go func() {
+		for msg := range s.ReceiveCh() {
+			require.Failf(t, "unexpected message", "%+v", msg)
+		}
+	}()
+
+	viewer, offer := newWHEPOffer(t, webrtc.RTPCodecTypeAudio)
+	viewerCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "viewer", SessionID: "whepSession"}
+	answer, err := s.InitWHEPSession(viewerCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, viewer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	voiceTrack, err := webrtc.NewTrackLocalStaticSample(rtpAudioCodec, "audio", "whipStream")
+	require.NoError(t, err)
+	publisher, offer := newWHIPOffer(t, voiceTrack)
+	publisherCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "obs", SessionID: "whipSession"}
+	answer, err = s.InitWHIPSession(publisherCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				require.NoError(t, voiceTrack.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond}))
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	require.Empty(t, s.GetSessionsStats("groupID", "otherCallID", ""))
+
+	// Bitrates need two samples at least a second apart.
+	var publisherStats, viewerStats SessionStats
+	require.Eventually(t, func() bool {
+		publisherStats = s.GetSessionsStats("groupID", "callID", "whipSession")[0]
+		viewerStats = s.GetSessionsStats("groupID", "callID", "whepSession")[0]
+		return len(publisherStats.Inbound) == 1 && publisherStats.Inbound[0].Bitrate > 0 &&
+			len(viewerStats.Outbound) == 1 && viewerStats.Outbound[0].Bitrate > 0
+	}, 10*time.Second, 200*time.Millisecond)
+
+	require.Equal(t, "obs", publisherStats.UserID)
+	require.NotZero(t, publisherStats.BytesReceived)
+	require.Empty(t, publisherStats.Outbound)
+	inbound := publisherStats.Inbound[0]
+	require.Equal(t, "audio", inbound.Kind)
+	require.Equal(t, webrtc.MimeTypeOpus, inbound.MimeType)
+	require.NotZero(t, inbound.SSRC)
+	require.NotZero(t, inbound.Packets)
+	require.NotZero(t, inbound.Bytes)
+
+	require.Equal(t, "viewer", viewerStats.UserID)
+	require.NotZero(t, viewerStats.BWETarget)
+	require.NotZero(t, viewerStats.BytesSent)
+	require.Empty(t, viewerStats.Inbound)
+	outbound := viewerStats.Outbound[0]
+	require.Equal(t, "audio", outbound.Kind)
+	require.Equal(t, webrtc.MimeTypeOpus, outbound.MimeType)
+	require.NotZero(t, outbound.Packets)
+
+	// Media flow is tracked regardless of session stats being enabled.
+	for _, direction := range []string{"in", "out"} {
+		labels := prometheus.Labels{"groupID": "groupID", "direction": direction, "type": "audio"}
+		require.NotZero(t, testutil.ToFloat64(metrics.RTPPackets.With(labels)))
+		require.NotZero(t, testutil.ToFloat64(metrics.RTPBytes.With(labels)))
+	}
+}
@@ -132,3 +132,30 @@ func TestCloseSessionConcurrent(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestSessionAcceptsVideoCodec(t *testing.T) {
+	t.Run("no props", func(t *testing.T) {
+		s := &session{}
+		require.True(t, s.acceptsVideoCodec(webrtc.MimeTypeVP8))
+		require.False(t, s.acceptsVideoCodec(webrtc.MimeTypeAV1))
+		require.False(t, s.acceptsVideoCodec(webrtc.MimeTypeH264))
+	})
+
+	t.Run("av1 support", func(t *testing.T) {
+		s := &session{cfg: SessionConfig{Props: SessionProps{"av1Support": true}}}
+		require.True(t, s.acceptsVideoCodec(webrtc.MimeTypeVP8))
+		require.True(t, s.acceptsVideoCodec(webrtc.MimeTypeAV1))
+		require.False(t, s.acceptsVideoCodec(webrtc.MimeTypeVP9))
+	})
+
+	t.Run("advertised codecs", func(t *testing.T) {
+		s := &session{cfg: SessionConfig{Props: SessionProps{
+			"av1Support":  true,
+			"videoCodecs": []any{"video/h264", "video/VP9"},
+		}}}
+		require.True(t, s.acceptsVideoCodec(webrtc.MimeTypeH264))
+		require.True(t, s.acceptsVideoCodec(webrtc.MimeTypeVP9))
+		require.False(t, s.acceptsVideoCodec(webrtc.MimeTypeVP8))
+		require.False(t, s.acceptsVideoCodec(webrtc.MimeTypeAV1))
+	})
+}
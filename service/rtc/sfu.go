@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"runtime"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -23,6 +22,7 @@ import (
 	"github.com/pion/interceptor/pkg/cc"
 	"github.com/pion/interceptor/pkg/gcc"
 	"github.com/pion/interceptor/pkg/nack"
+	"github.com/pion/interceptor/pkg/stats"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
 )
@@ -41,8 +41,11 @@ var (
 		SDPFmtpLine:  "minptime=10;useinbandfec=1",
 		RTCPFeedback: nil,
 	}
-	rtpVideoCodecs = map[string]webrtc.RTPCodecParameters{
-		webrtc.MimeTypeVP8: {
+	// rtpVideoCodecs holds the video codecs supported by the SFU. H.264 is
+	// registered once per packetization mode and profile since those need to
+	// match between peers.
+	rtpVideoCodecs = []webrtc.RTPCodecParameters{
+		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{
 				MimeType:     webrtc.MimeTypeVP8,
 				ClockRate:    90000,
@@ -51,7 +54,7 @@ var (
 			},
 			PayloadType: 96,
 		},
-		webrtc.MimeTypeAV1: {
+		{
 			RTPCodecCapability: webrtc.RTPCodecCapability{
 				MimeType:     webrtc.MimeTypeAV1,
 				ClockRate:    90000,
@@ -60,6 +63,77 @@ var (
 			},
 			PayloadType: 45,
 		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeVP9,
+				ClockRate:    90000,
+				SDPFmtpLine:  "profile-id=0",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 98,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeVP9,
+				ClockRate:    90000,
+				SDPFmtpLine:  "profile-id=2",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 100,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    90000,
+				SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42001f",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 102,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    90000,
+				SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42001f",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 127,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    90000,
+				SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 125,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    90000,
+				SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 108,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{
+				MimeType:     webrtc.MimeTypeH264,
+				ClockRate:    90000,
+				SDPFmtpLine:  "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032",
+				RTCPFeedback: videoRTCPFeedback,
+			},
+			PayloadType: 123,
+		},
+	}
+	// videoCodecsPreference lists the video codecs in the order the SFU
+	// prefers to forward them when a stream is available in more than one.
+	videoCodecsPreference = []string{
+		webrtc.MimeTypeAV1,
+		webrtc.MimeTypeVP9,
+		webrtc.MimeTypeH264,
+		webrtc.MimeTypeVP8,
 	}
 	rtpVideoExtensions = []string{
 		"urn:ietf:params:rtp-hdrext:sdes:mid",
@@ -96,7 +170,7 @@ func (s *Server) initSettingEngine() (webrtc.SettingEngine, error) {
 		sEngine.SetDTLSInsecureSkipHelloVerify(true)
 	}
 
-	pairs, err := generateAddrsPairs(s.localIPs, s.publicAddrsMap, s.cfg.ICEHostOverride, s.cfg.EnableIPv6)
+	pairs, err := generateAddrsPairs(s.localIPs, s.getPublicAddrsMap(), s.cfg.ICEHostOverride, s.cfg.EnableIPv6)
 	if err != nil {
 		return webrtc.SettingEngine{}, fmt.Errorf("failed to generate addresses pairs: %w", err)
 	} else if len(pairs) > 0 {
@@ -136,8 +210,12 @@ func initMediaEngine() (*webrtc.MediaEngine, error) {
 	return &m, nil
 }
 
-func initInterceptors(m *webrtc.MediaEngine) (*interceptor.Registry, <-chan cc.BandwidthEstimator, error) {
+func initInterceptors(m *webrtc.MediaEngine, levels SimulcastLevels, metricsInterceptor interceptor.Factory) (*interceptor.Registry, <-chan cc.BandwidthEstimator, error) {
 	var i interceptor.Registry
+
+	// Metrics (needs to go first to see all packets)
+	i.Add(metricsInterceptor)
+
 	generator, err := nack.NewGeneratorInterceptor()
 	if err != nil {
 		return nil, nil, err
@@ -164,8 +242,8 @@ func initInterceptors(m *webrtc.MediaEngine) (*interceptor.Registry, <-chan cc.B
 	}
 
 	// Congestion Control
-	minRate := int(float32(getRateForSimulcastLevel(SimulcastLevelLow)) * 0.5)
-	maxRate := int(float32(getRateForSimulcastLevel(SimulcastLevelHigh)) * 1.5)
+	minRate := int(float32(levels[0].Rate) * 0.5)
+	maxRate := int(float32(levels[len(levels)-1].Rate) * 1.5)
 	pacer := gcc.NewNoOpPacer()
 	bwEstimatorCh := make(chan cc.BandwidthEstimator, 1)
 	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
@@ -190,11 +268,23 @@ func initInterceptors(m *webrtc.MediaEngine) (*interceptor.Registry, <-chan cc.B
 	return &i, bwEstimatorCh, nil
 }
 
-func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
+func (s *Server) InitSession(cfg SessionConfig, closeCb func() error, opts ...SessionOption) error {
 	if err := cfg.IsValid(); err != nil {
 		return fmt.Errorf("invalid session config: %w", err)
 	}
 
+	var sessionOpts sessionOptions
+	for _, opt := range opts {
+		opt(&sessionOpts)
+	}
+	if state := sessionOpts.state; state != nil && state.SessionID != cfg.SessionID {
+		return fmt.Errorf("session state mismatch: sessionID=%s, stateSessionID=%s", cfg.SessionID, state.SessionID)
+	}
+
+	if s.isDraining() {
+		return fmt.Errorf("server is draining")
+	}
+
 	s.metrics.IncRTCSessions(cfg.GroupID)
 
 	iceServers := make([]webrtc.ICEServer, 0, len(s.cfg.ICEServers))
@@ -230,11 +320,19 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 		return fmt.Errorf("failed to init media engine: %w", err)
 	}
 
-	iRegistry, bwEstimatorCh, err := initInterceptors(mEngine)
+	iRegistry, bwEstimatorCh, err := initInterceptors(mEngine, s.cfg.SimulcastLevels, newMetricsInterceptorFactory(cfg.GroupID, s.metrics))
 	if err != nil {
 		return fmt.Errorf("failed to init interceptors: %w", err)
 	}
 
+	var statsGetterCh <-chan stats.Getter
+	if s.cfg.EnableSessionStats {
+		statsGetterCh, err = initStatsInterceptor(iRegistry)
+		if err != nil {
+			return fmt.Errorf("failed to init stats interceptor: %w", err)
+		}
+	}
+
 	sEngine, err := s.initSettingEngine()
 	if err != nil {
 		return fmt.Errorf("failed to init setting engine: %w", err)
@@ -258,28 +356,41 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 	group := s.getGroup(cfg.GroupID)
 	call := group.getCall(cfg.CallID)
 
+	if sessionOpts.state != nil {
+		s.restoreSessionState(call, us, *sessionOpts.state)
+	}
+
 	us.initBWEstimator(<-bwEstimatorCh)
 
+	if statsGetterCh != nil {
+		us.mut.Lock()
+		us.statsGetter = <-statsGetterCh
+		us.mut.Unlock()
+	}
+
 	peerConn.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		us.mut.RLock()
 		defer us.mut.RUnlock()
-		if candidate == nil {
+		// HTTP sessions get all the candidates as part of the answer.
+		if candidate == nil || us.hasHTTPSignaling() {
 			return
 		}
 
-		if port := s.cfg.ICEHostPortOverride.SinglePort(); port != 0 && candidate.Typ == webrtc.ICECandidateTypeHost {
-			if m := getExternalAddrMapFromHostOverride(s.cfg.ICEHostOverride, s.publicAddrsMap); m[candidate.Address] {
-				s.log.Debug("overriding host candidate port",
-					mlog.String("sessionID", cfg.SessionID),
-					mlog.Uint("port", candidate.Port),
-					mlog.Int("override", port),
-					mlog.String("addr", candidate.Address),
-					mlog.Int("protocol", candidate.Protocol))
-				candidate.Port = uint16(port)
-			}
+		if port, ok := s.getHostPortOverride(candidate.Address); ok && candidate.Typ == webrtc.ICECandidateTypeHost {
+			s.log.Debug("overriding host candidate port",
+				mlog.String("sessionID", cfg.SessionID),
+				mlog.Uint("port", candidate.Port),
+				mlog.Int("override", port),
+				mlog.String("addr", candidate.Address),
+				mlog.Int("protocol", candidate.Protocol))
+			candidate.Port = uint16(port)
 		}
 
-		msg, err := newICEMessage(us, candidate)
+		newMsg := newICEMessage
+		if us.relayDirection() != "" {
+			newMsg = newRelayICEMessage
+		}
+		msg, err := newMsg(us, candidate)
 		if err != nil {
 			s.log.Error("failed to create ICE message", mlog.Err(err), mlog.String("sessionID", cfg.SessionID))
 			return
@@ -320,7 +431,11 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 			s.metrics.IncRTCConnState("closed")
 		}
 		switch state {
-		case webrtc.PeerConnectionStateClosed, webrtc.PeerConnectionStateFailed:
+		case webrtc.PeerConnectionStateConnected:
+			s.handleSessionConnected(us)
+		case webrtc.PeerConnectionStateFailed:
+			s.handleSessionFailure(us)
+		case webrtc.PeerConnectionStateClosed:
 			if err := s.CloseSession(cfg.SessionID); err != nil {
 				s.log.Error("failed to close RTC session", mlog.Err(err), mlog.Any("sessionCfg", cfg))
 			}
@@ -350,6 +465,11 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 						continue
 					}
 
+					if err := dataCh.Send(dcMsg); err != nil {
+						s.log.Error("failed to send message", mlog.Err(err), mlog.String("sessionID", cfg.SessionID))
+						continue
+					}
+				case dcMsg := <-us.dcMsgCh:
 					if err := dataCh.Send(dcMsg); err != nil {
 						s.log.Error("failed to send message", mlog.Err(err), mlog.String("sessionID", cfg.SessionID))
 						continue
@@ -377,6 +497,11 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 	})
 
 	peerConn.OnTrack(func(remoteTrack *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if us.isWHEP() {
+			s.log.Error("received unexpected track from WHEP session", mlog.String("sessionID", us.cfg.SessionID))
+			return
+		}
+
 		streamID := remoteTrack.StreamID()
 		trackMimeType := remoteTrack.Codec().MimeType
 
@@ -413,37 +538,65 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 			s.metrics.DecRTPTracks(us.cfg.GroupID, "in", getTrackType(remoteTrack.Kind()))
 		}()
 
-		var screenStreamID string
-		if screenSession := call.getScreenSession(); screenSession != nil {
-			screenStreamID = screenSession.getScreenStreamID()
+		isScreenStream := us.getStreamType(streamID) == trackTypeScreen
+
+		// Tracks coming from a relay are published on behalf of the sessions
+		// that originally sent them, as encoded in their IDs.
+		publisherID := us.cfg.SessionID
+		isRelayed := us.relayDirection() == RelayDirectionIn
+		if isRelayed {
+			tt, originID, err := parseTrackID(remoteTrack.ID())
+			if err != nil {
+				s.log.Error("failed to parse relayed track ID", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+				return
+			}
+			publisherID = originID
+			isScreenStream = tt == trackTypeScreen || tt == trackTypeScreenAudio
+		}
+
+		// WHIP sessions don't announce screen streams: video is forwarded as a
+		// screen share and audio as voice, whatever comes in first.
+		if us.isWHIP() {
+			isScreenStream = remoteTrack.Kind() == webrtc.RTPCodecTypeVideo && call.addWHIPScreenStream(us, streamID)
 		}
 
 		go us.handleReceiverRTCP(receiver, remoteTrack.RID())
 
 		if trackMimeType == rtpAudioCodec.MimeType {
 			trackType := trackTypeVoice
-			if streamID == screenStreamID {
+			if isScreenStream {
 				s.log.Debug("received screen sharing audio track", mlog.String("sessionID", us.cfg.SessionID))
 				trackType = trackTypeScreenAudio
 			}
 
-			outAudioTrack, err := webrtc.NewTrackLocalStaticRTP(rtpAudioCodec, genTrackID(trackType, us.cfg.SessionID), random.NewID())
+			outAudioTrack, err := webrtc.NewTrackLocalStaticRTP(rtpAudioCodec, genTrackID(trackType, publisherID), random.NewID())
 			if err != nil {
 				s.log.Error("failed to create local track", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
 				return
 			}
 
 			us.mut.Lock()
-			if trackType == trackTypeVoice {
+			if isRelayed {
+				// A relay carries the audio of many sessions so each track gets its
+				// own stream.
+				ms := newMediaStream(streamID, publisherID, trackType)
+				ms.outAudioTrack = outAudioTrack
+				us.streams[streamID] = ms
+				defer call.clearAudioStream(us, streamID)
+			} else if trackType == trackTypeVoice {
 				us.outVoiceTrack = outAudioTrack
-				us.outVoiceTrackEnabled = true
-			} else {
-				us.outScreenAudioTrack = outAudioTrack
+				us.outVoiceTrackEnabled = us.muteState != muteStateMuted
+			} else if ms := us.streams[streamID]; ms != nil {
+				ms.outAudioTrack = outAudioTrack
 			}
 			us.mut.Unlock()
 
+			// With audio slots, voice is forwarded through the receivers' own slot
+			// tracks so there's nothing to add other than to outgoing relays.
+			useAudioSlots := trackType == trackTypeVoice && call.audioSlots != nil
+
 			call.iterSessions(func(ss *session) {
-				if ss.cfg.SessionID == us.cfg.SessionID {
+				if !us.canForwardTo(ss) || (trackType == trackTypeVoice && !call.forwardsVoice(ss)) {
 					return
 				}
 				select {
@@ -467,8 +620,10 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 				}
 			}
 
+			// The voice activity of relayed tracks is reported by the node they
+			// come from while HTTP sessions have no one to report it to.
 			var hasVAD bool
-			if audioLevelExtensionID > 0 {
+			if audioLevelExtensionID > 0 && !isRelayed && !us.hasHTTPSignaling() {
 				if err := us.InitVAD(s.log, s.receiveCh); err != nil {
 					s.log.Error("failed to init VAD", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
 				} else {
@@ -487,31 +642,59 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 					return
 				}
 
-				if hasVAD {
-					var ext rtp.AudioLevelExtension
+				var ext rtp.AudioLevelExtension
+				var hasAudioLevel bool
+				if audioLevelExtensionID > 0 {
 					audioExtData := packet.GetExtension(uint8(audioLevelExtensionID))
 					if audioExtData != nil {
 						if err := ext.Unmarshal(audioExtData); err != nil {
 							s.log.Error("failed to unmarshal audio level extension",
 								mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+						} else {
+							hasAudioLevel = true
+						}
+						if hasVAD {
+							us.mut.RLock()
+							us.vadMonitor.PushAudioLevel(ext.Level)
+							us.mut.RUnlock()
 						}
-						us.mut.RLock()
-						us.vadMonitor.PushAudioLevel(ext.Level)
-						us.mut.RUnlock()
 					}
 				}
 
 				if trackType == trackTypeVoice {
-					us.mut.RLock()
-					isEnabled := us.outVoiceTrackEnabled
-					us.mut.RUnlock()
-					if !isEnabled {
-						continue
+					// Muted sessions stop being relayed by the node they are on.
+					if !isRelayed {
+						us.mut.RLock()
+						isEnabled := us.outVoiceTrackEnabled
+						us.mut.RUnlock()
+						if !isEnabled {
+							continue
+						}
 					}
+
+					// Muted sessions are not taken into account when ranking speakers.
+					if hasAudioLevel {
+						call.pushAudioLevel(publisherID, ext.Level)
+						call.pushSpeakerLevel(publisherID, ext.Level)
+					}
+				}
+
+				if _, err := call.recordRTP(us, trackType, streamID, trackMimeType, "", nil, packet); err != nil {
+					s.log.Error("failed to record RTP packet",
+						mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
 				}
 
 				writeStartTime := time.Now()
-				if err := outAudioTrack.WriteRTP(packet); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+				var err error
+				if useAudioSlots {
+					err = call.writeAudioSlots(publisherID, packet)
+				}
+				// The voice track is still written when using audio slots as outgoing
+				// relays may be receiving it.
+				if err == nil {
+					err = outAudioTrack.WriteRTP(packet)
+				}
+				if err != nil && !errors.Is(err, io.ErrClosedPipe) {
 					s.log.Error("failed to write RTP packet",
 						mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
 					s.metrics.IncRTCErrors(us.cfg.GroupID, "rtp")
@@ -519,34 +702,23 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 				}
 				s.metrics.ObserveRTPTracksWrite(us.cfg.GroupID, string(trackType), time.Since(writeStartTime).Seconds())
 			}
-		} else if params, ok := rtpVideoCodecs[trackMimeType]; ok {
-			if screenStreamID != "" && screenStreamID != streamID {
-				s.log.Error("received unexpected video track",
+		} else if isVideoMimeTypeSupported(trackMimeType) {
+			trackType := trackTypeVideo
+			if isScreenStream {
+				s.log.Debug("received screen sharing stream", mlog.String("streamID", streamID), mlog.String("sessionID", us.cfg.SessionID))
+				trackType = trackTypeScreen
+			} else if us.isScreenStreamID(streamID) {
+				// The session announced a screen stream but it wasn't accepted by
+				// the call (e.g. too many concurrent screen shares).
+				s.log.Error("received unexpected screen track",
 					mlog.String("streamID", streamID), mlog.String("sessionID", us.cfg.SessionID))
 				return
-			}
-
-			s.log.Debug("received screen sharing stream", mlog.String("streamID", streamID), mlog.String("sessionID", us.cfg.SessionID))
-
-			// To improve concurrency and support larger calls we create NumCPU output tracks and randomly distribute the receivers among them.
-			createOutScreenTracks := func(num int) ([]*webrtc.TrackLocalStaticRTP, error) {
-				outTracks := make([]*webrtc.TrackLocalStaticRTP, num)
-				for i := 0; i < num; i++ {
-					outTrack, err := webrtc.NewTrackLocalStaticRTP(params.RTPCodecCapability,
-						genTrackID(trackTypeScreen, us.cfg.SessionID), random.NewID(), webrtc.WithRTPStreamID(remoteTrack.RID()))
-					if err != nil {
-						return nil, fmt.Errorf("failed to create screen track")
-					}
-					outTracks[i] = outTrack
-				}
-				return outTracks, nil
-			}
-
-			outScreenTracks, err := createOutScreenTracks(runtime.NumCPU())
-			if err != nil {
-				s.log.Error("failed to create local track",
-					mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+			} else if !us.supportsVideo() {
+				s.log.Error("received unexpected video track",
+					mlog.String("streamID", streamID), mlog.String("sessionID", us.cfg.SessionID))
 				return
+			} else {
+				s.log.Debug("received video stream", mlog.String("streamID", streamID), mlog.String("sessionID", us.cfg.SessionID))
 			}
 
 			rid := remoteTrack.RID()
@@ -554,7 +726,7 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 				rid = SimulcastLevelDefault
 			}
 
-			rm, err := NewRateMonitor(simulcastRateMonitorSampleSizes[rid], nil)
+			rm, err := NewRateMonitor(call.simulcastLevels.getMonitorWindow(rid), nil)
 			if err != nil {
 				s.log.Error("failed to create rate monitor", mlog.Err(err))
 				return
@@ -562,13 +734,23 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 
 			trackIdx := getTrackIndex(trackMimeType, rid)
 			us.mut.Lock()
-			us.outScreenTracks[trackIdx] = outScreenTracks
-			us.remoteScreenTracks[trackIdx] = remoteTrack
-			us.screenRateMonitors[trackIdx] = rm
+			ms := us.streams[streamID]
+			if ms == nil {
+				ms = newMediaStream(streamID, publisherID, trackType)
+				us.streams[streamID] = ms
+			}
+			ms.remoteTracks[trackIdx] = remoteTrack
+			ms.rateMonitors[trackIdx] = rm
 			us.mut.Unlock()
 
+			if trackType == trackTypeVideo || isRelayed {
+				// Camera and relayed tracks have no explicit off signal, so we clean
+				// up as soon as the publisher stops sending.
+				defer call.clearVideoTrack(us, streamID, trackIdx)
+			}
+
 			call.iterSessions(func(ss *session) {
-				if ss.cfg.SessionID == us.cfg.SessionID {
+				if !us.canForwardTo(ss) {
 					return
 				}
 
@@ -581,28 +763,46 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 					return
 				}
 
-				if trackMimeType == ScreenTrackMimeTypeDefault && us.supportsAV1() && ss.supportsAV1() {
-					s.log.Debug("skipping VP8 track for AV1 supported receiver",
-						mlog.String("sessionID", ss.cfg.SessionID),
-					)
+				if trackType == trackTypeVideo && !ss.supportsVideo() {
 					return
-				} else if trackMimeType == webrtc.MimeTypeAV1 && !ss.supportsAV1() {
-					s.log.Debug("skipping AV1 track for unsupported receiver",
+				}
+
+				if mimeType := us.getStreamMimeType(streamID, ss); trackMimeType != mimeType {
+					s.log.Debug("skipping track not matching receiver's codec",
 						mlog.String("sessionID", ss.cfg.SessionID),
+						mlog.String("trackMimeType", trackMimeType),
+						mlog.String("mimeType", mimeType),
 					)
 					return
 				}
 
+				// We forward the publisher's codec parameters (e.g. H.264 profile and
+				// packetization mode) so that receivers negotiate a compatible format.
+				codec := remoteTrack.Codec().RTPCodecCapability
+				codec.RTCPFeedback = videoRTCPFeedback
+				outTrack, err := ms.newOutTrack(codec, genTrackID(trackType, publisherID), ss.cfg.SessionID, rid)
+				if err != nil {
+					s.log.Error("failed to create local track",
+						mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+					return
+				} else if outTrack == nil {
+					// The receiver is already getting this stream (e.g. through a
+					// different codec).
+					return
+				}
+
 				s.log.Debug("received track matches expected level, sending",
 					mlog.String("lvl", expectedLevel),
 					mlog.String("sessionID", ss.cfg.SessionID),
 					mlog.String("trackMimeType", trackMimeType),
+					mlog.String("trackType", string(trackType)),
 				)
 
 				select {
-				case ss.tracksCh <- trackActionContext{action: trackActionAdd, track: pickRandom(outScreenTracks)}:
+				case ss.tracksCh <- trackActionContext{action: trackActionAdd, track: outTrack}:
 				default:
-					s.log.Error("failed to send screen track: channel is full",
+					ms.removeOutTrack(outTrack)
+					s.log.Error("failed to send video track: channel is full",
 						mlog.String("userID", ss.cfg.UserID),
 						mlog.String("sessionID", ss.cfg.SessionID),
 						mlog.String("trackUserID", us.cfg.UserID),
@@ -611,24 +811,35 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 				}
 			})
 
-			writeTrack := func(writerCh <-chan *rtp.Packet, outTrack *webrtc.TrackLocalStaticRTP) {
+			// Each writer forwards packets to the receivers' tracks in its shard
+			// that have this track's codec and level selected.
+			writeTracks := func(writerCh <-chan *rtp.Packet, shard int) {
 				for pkt := range writerCh {
 					writeStartTime := time.Now()
-					if err := outTrack.WriteRTP(pkt); err != nil && !errors.Is(err, io.ErrClosedPipe) {
-						s.log.Error("failed to write RTP packet",
-							mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
-						s.metrics.IncRTCErrors(us.cfg.GroupID, "rtp")
-						continue
-					}
-					s.metrics.ObserveRTPTracksWrite(us.cfg.GroupID, string(trackTypeScreen), time.Since(writeStartTime).Seconds())
+					ms.iterOutTracks(shard, func(outTrack *simulcastTrack) {
+						if outTrack.mimeType != trackMimeType {
+							return
+						}
+						if err := outTrack.writeRTP(pkt, rid); err != nil && !errors.Is(err, io.ErrClosedPipe) {
+							s.log.Error("failed to write RTP packet",
+								mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+							s.metrics.IncRTCErrors(us.cfg.GroupID, "rtp")
+						}
+					})
+					s.metrics.ObserveRTPTracksWrite(us.cfg.GroupID, string(trackType), time.Since(writeStartTime).Seconds())
 				}
 			}
 
-			writerChs := make([]chan *rtp.Packet, len(outScreenTracks))
-			for i := 0; i < len(outScreenTracks); i++ {
+			writerChs := make([]chan *rtp.Packet, len(ms.outTracks))
+			for i := 0; i < len(writerChs); i++ {
 				writerChs[i] = make(chan *rtp.Packet, writerQueueSize)
 				defer close(writerChs[i])
-				go writeTrack(writerChs[i], outScreenTracks[i])
+				go writeTracks(writerChs[i], i)
+			}
+
+			// Screen shares get recorded at the highest level being published.
+			recordingLevelFn := func() string {
+				return call.getRecordingLevel(us, ms, trackMimeType)
 			}
 
 			limiter := rate.NewLimiter(0.25, 1)
@@ -648,6 +859,7 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 					rate, dur := rm.GetRate()
 					s.log.Debug("rate monitor",
 						mlog.String("sessionID", us.cfg.SessionID),
+						mlog.String("trackType", string(trackType)),
 						mlog.String("RID", rid),
 						mlog.Int("rate", rate),
 						mlog.Float("duration", dur.Seconds()),
@@ -655,6 +867,24 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 					)
 				}
 
+				if trackType == trackTypeScreen {
+					if started, err := call.recordRTP(us, trackType, streamID, trackMimeType, rid, recordingLevelFn, packet); err != nil {
+						s.log.Error("failed to record RTP packet",
+							mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+					} else if started {
+						// Recording needs to start from a keyframe.
+						level := recordingLevelFn()
+						us.mut.RLock()
+						recTrack, _ := ms.getRemoteTrack(trackMimeType, level)
+						us.mut.RUnlock()
+						if recTrack != nil {
+							if err := us.requestKeyFrame(recTrack); err != nil {
+								s.log.Error("failed to request keyframe", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+							}
+						}
+					}
+				}
+
 				for i, writerCh := range writerChs {
 					// We need to copy the packet header to keep it race free in case
 					// of simulcast as we are dealing with concurrent writers.
@@ -664,7 +894,7 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 					select {
 					case writerCh <- &pkt:
 					default:
-						s.log.Error("failed to write RTP packet to writer channel", mlog.String("trackID", outScreenTracks[i].ID()))
+						s.log.Error("failed to write RTP packet to writer channel", mlog.String("trackID", remoteTrack.ID()), mlog.Int("shard", i))
 						s.metrics.IncRTCErrors(us.cfg.GroupID, "rtp")
 					}
 				}
@@ -687,19 +917,28 @@ func (s *Server) InitSession(cfg SessionConfig, closeCb func() error) error {
 func (s *Server) CloseSession(sessionID string) error {
 	s.mut.Lock()
 	cfg, ok := s.sessions[sessionID]
-	delete(s.sessions, sessionID)
-
-	if len(s.sessions) == 0 && s.drainCh != nil {
-		s.log.Debug("closing drain channel")
-		close(s.drainCh)
-		s.drainCh = nil
+	if ok {
+		delete(s.sessions, sessionID)
+		s.closingSessions++
 	}
-
 	s.mut.Unlock()
 	if !ok {
 		return nil
 	}
 
+	// The server is only drained once the session is fully closed, so that
+	// the events it emits are sent before stopping.
+	defer func() {
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		s.closingSessions--
+		if len(s.sessions) == 0 && s.closingSessions == 0 && s.drainCh != nil {
+			s.log.Debug("closing drain channel")
+			close(s.drainCh)
+			s.drainCh = nil
+		}
+	}()
+
 	s.metrics.DecRTCSessions(cfg.GroupID)
 
 	group := s.getGroup(cfg.GroupID)
@@ -717,10 +956,19 @@ func (s *Server) CloseSession(sessionID string) error {
 
 	call.mut.Lock()
 
+	// Screen shares of the closing session end along with it.
+	var screenStreamIDs []string
+	for streamID, ss := range call.screenStreams {
+		if ss == us {
+			screenStreamIDs = append(screenStreamIDs, streamID)
+		}
+	}
+
 	call.handleSessionClose(us)
 
 	delete(call.sessions, cfg.SessionID)
-	if len(call.sessions) == 0 {
+	callEnded := len(call.sessions) == 0
+	if callEnded {
 		group.mut.Lock()
 		delete(group.calls, cfg.CallID)
 		if len(group.calls) == 0 {
@@ -732,6 +980,25 @@ func (s *Server) CloseSession(sessionID string) error {
 	}
 	call.mut.Unlock()
 
+	for _, streamID := range screenStreamIDs {
+		s.events.send(newScreenEvent(ScreenOffEvent, cfg, streamID))
+	}
+	if hasPresence(cfg) {
+		s.events.send(newEvent(SessionLeftEvent, cfg))
+	}
+	if callEnded {
+		s.events.send(newCallEvent(CallEndedEvent, cfg.GroupID, cfg.CallID))
+	}
+
+	// A recording can't outlive its call.
+	if callEnded && call.recorder.Load() != nil {
+		if _, err := call.stopRecording(); err != nil {
+			s.log.Error("failed to stop recording", mlog.Err(err), mlog.String("callID", cfg.CallID))
+		} else {
+			s.log.Info("recording stopped", mlog.String("callID", cfg.CallID))
+		}
+	}
+
 	us.mut.Lock()
 	close(us.closeCh)
 	us.mut.Unlock()
@@ -749,41 +1016,73 @@ func (s *Server) CloseSession(sessionID string) error {
 
 // handleTracks manages (adds and removes) a/v tracks for the peer associated with the session.
 func (s *Server) handleTracks(call *call, us *session) {
+	// Audio slots are added once on join, speakers are then mapped into them
+	// as needed.
+	for _, slot := range us.audioSlots {
+		select {
+		case us.tracksCh <- trackActionContext{action: trackActionAdd, track: slot}:
+		default:
+			s.metrics.IncRTCErrors(us.cfg.GroupID, "track")
+			s.log.Error("failed to add audio slot on join: channel is full", mlog.String("sessionID", us.cfg.SessionID))
+		}
+	}
+
 	call.iterSessions(func(ss *session) {
-		if ss.cfg.SessionID == us.cfg.SessionID {
+		if !ss.canForwardTo(us) {
 			return
 		}
 
+		videoLevel := us.getExpectedSimulcastLevel()
+
+		var outTracks []webrtc.TrackLocal
 		ss.mut.RLock()
-		outVoiceTrack := ss.outVoiceTrack
-
-		// Screen track selection. Both sender and receiver support it
-		// in order to send out the AV1 track.
-		screenTrackMimeType := ScreenTrackMimeTypeDefault
-		if ss.supportsAV1() && us.supportsAV1() {
-			s.log.Debug("both sender and receiver support AV1", mlog.String("sessionID", us.cfg.SessionID))
-			screenTrackMimeType = webrtc.MimeTypeAV1
+		if ss.outVoiceTrack != nil && call.forwardsVoice(us) {
+			outTracks = append(outTracks, ss.outVoiceTrack)
 		}
-		outScreenTracks := ss.outScreenTracks[getTrackIndex(screenTrackMimeType, SimulcastLevelDefault)]
+		for _, ms := range ss.streams {
+			if ms.outAudioTrack != nil && (ms.trackType != trackTypeVoice || call.forwardsVoice(us)) {
+				outTracks = append(outTracks, ms.outAudioTrack)
+			}
 
-		outScreenAudioTrack := ss.outScreenAudioTrack
-		ss.mut.RUnlock()
+			if ms.trackType == trackTypeVoice || ms.trackType == trackTypeScreenAudio {
+				continue
+			}
 
-		var outTracks []*webrtc.TrackLocalStaticRTP
-		if outVoiceTrack != nil {
-			outTracks = append(outTracks, outVoiceTrack)
-		}
-		if len(outScreenTracks) > 0 {
-			outTracks = append(outTracks, pickRandom(outScreenTracks))
-		}
-		if outScreenAudioTrack != nil {
-			outTracks = append(outTracks, outScreenAudioTrack)
+			if ms.trackType == trackTypeVideo && !us.supportsVideo() {
+				continue
+			}
+
+			mimeType := ms.getMimeType(ss, us)
+			if mimeType == "" {
+				continue
+			}
+
+			// Screen tracks are always sent starting from the default level.
+			level := videoLevel
+			if ms.trackType == trackTypeScreen {
+				level = call.simulcastLevels.getLowest()
+			}
+
+			if remoteTrack, level := ms.getRemoteTrack(mimeType, level); remoteTrack != nil {
+				codec := remoteTrack.Codec().RTPCodecCapability
+				codec.RTCPFeedback = videoRTCPFeedback
+				outTrack, err := ms.newOutTrack(codec, genTrackID(ms.trackType, ms.publisherID), us.cfg.SessionID, level)
+				if err != nil {
+					s.log.Error("failed to create local track", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+				} else if outTrack != nil {
+					outTracks = append(outTracks, outTrack)
+				}
+			}
 		}
+		ss.mut.RUnlock()
 
 		for _, track := range outTracks {
 			select {
 			case us.tracksCh <- trackActionContext{action: trackActionAdd, track: track}:
 			default:
+				if outTrack, ok := track.(*simulcastTrack); ok {
+					outTrack.stream.removeOutTrack(outTrack)
+				}
 				s.metrics.IncRTCErrors(us.cfg.GroupID, "track")
 				s.log.Error("failed to add track on join: channel is full", mlog.String("sessionID", us.cfg.SessionID))
 			}
@@ -797,6 +1096,14 @@ func (s *Server) handleTracks(call *call, us *session) {
 				return
 			}
 
+			if us.isWHEP() {
+				if err := s.handleWHEPTrack(call, us, ctx); err != nil {
+					s.metrics.IncRTCErrors(us.cfg.GroupID, "track")
+					s.log.Error("failed to handle WHEP track", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+				}
+				continue
+			}
+
 			sdpCh := s.receiveCh
 			if us.dcSignaling() {
 				sdpCh = us.dcSDPCh
@@ -804,6 +1111,9 @@ func (s *Server) handleTracks(call *call, us *session) {
 
 			if ctx.action == trackActionAdd {
 				if err := us.addTrack(sdpCh, ctx.track); err != nil {
+					if outTrack, ok := ctx.track.(*simulcastTrack); ok {
+						outTrack.stream.removeOutTrack(outTrack)
+					}
 					s.metrics.IncRTCErrors(us.cfg.GroupID, "track")
 					s.log.Error("failed to add track", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID), mlog.String("trackID", ctx.track.ID()))
 					continue
@@ -827,11 +1137,19 @@ func (s *Server) handleTracks(call *call, us *session) {
 				return
 			}
 
-			if err := us.signaling(offerMsg.sdp, offerMsg.answerCh); err != nil {
+			if err := us.signaling(offerMsg.sdp, offerMsg.answerCh, offerMsg.spanCtx); err != nil {
 				s.metrics.IncRTCErrors(us.cfg.GroupID, "signaling")
 				s.log.Error("failed to signal", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
 				continue
 			}
+		case <-us.iceRestartCh:
+			// The restart offer always goes through the WebSocket as the data
+			// channel depends on the connection being restarted.
+			if err := us.restartICE(s.receiveCh); err != nil {
+				s.metrics.IncRTCErrors(us.cfg.GroupID, "signaling")
+				s.log.Error("failed to restart ICE", mlog.Err(err), mlog.String("sessionID", us.cfg.SessionID))
+				continue
+			}
 		case <-us.closeCh:
 			return
 		}
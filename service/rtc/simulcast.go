@@ -6,6 +6,7 @@ package rtc
 import (
 	"fmt"
 	"math"
+	"sort"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -17,41 +18,115 @@ import (
 )
 
 const (
-	SimulcastLevelHigh        = "h"
-	SimulcastLevelLow         = "l"
+	SimulcastLevelHigh = "h"
+	SimulcastLevelLow  = "l"
+	// SimulcastLevelDefault is the level under which non-simulcast tracks are
+	// indexed.
 	SimulcastLevelDefault     = SimulcastLevelLow
 	levelChangeInitialBackoff = 10 * time.Second
 	rateTolerance             = 0.9
 )
 
-var simulcastRates = map[string]int{
-	SimulcastLevelHigh: 2_500_000,
-	SimulcastLevelLow:  500_000,
+// GetDefaultSimulcastLevels returns the simulcast levels used if none are
+// configured.
+func GetDefaultSimulcastLevels() SimulcastLevels {
+	return SimulcastLevels{
+		{RID: SimulcastLevelHigh, Rate: 2_500_000, MonitorWindowMs: 2000},
+		{RID: SimulcastLevelLow, Rate: 500_000, MonitorWindowMs: 5000},
+	}
+}
+
+// sorted returns a copy of the levels sorted by ascending rate.
+func (l SimulcastLevels) sorted() SimulcastLevels {
+	levels := make(SimulcastLevels, len(l))
+	copy(levels, l)
+	sort.SliceStable(levels, func(i, j int) bool {
+		return levels[i].Rate < levels[j].Rate
+	})
+	return levels
+}
+
+// The methods below expect the levels to be sorted by ascending rate.
+
+func (l SimulcastLevels) getLowest() string {
+	if len(l) == 0 {
+		return SimulcastLevelDefault
+	}
+	return l[0].RID
 }
 
-var simulcastRateMonitorSampleSizes = map[string]time.Duration{
-	SimulcastLevelHigh: 2 * time.Second,
-	SimulcastLevelLow:  5 * time.Second,
+func (l SimulcastLevels) getLevelIndex(level string) int {
+	for i, cfg := range l {
+		if cfg.RID == level {
+			return i
+		}
+	}
+	return -1
 }
 
-func getRateForSimulcastLevel(level string) int {
-	return simulcastRates[level]
+func (l SimulcastLevels) getRate(level string) int {
+	if idx := l.getLevelIndex(level); idx >= 0 {
+		return l[idx].Rate
+	}
+	return 0
 }
 
-func getSimulcastLevel(downRate, sourceRate int) string {
-	if sourceRate > 0 && downRate > int(float32(sourceRate)*rateTolerance) {
-		return SimulcastLevelHigh
+// getMonitorWindow returns the rate monitor sampling window for the given
+// level. Unknown levels (e.g. non-simulcast tracks) get the lowest level's one.
+func (l SimulcastLevels) getMonitorWindow(level string) time.Duration {
+	idx := l.getLevelIndex(level)
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(l) {
+		return 5 * time.Second
+	}
+	return time.Duration(l[idx].MonitorWindowMs) * time.Millisecond
+}
+
+// getLevel returns the best level to forward given the estimated downlink
+// rate. sourceRates holds the measured rates of the levels being published,
+// which are the only ones considered. When the current level is the best fit
+// the next one up is returned to probe whether more bandwidth is available.
+func (l SimulcastLevels) getLevel(currLevel string, downRate int, sourceRates map[string]int) string {
+	next := ""
+	for i := len(l) - 1; i >= 0; i-- {
+		rate, ok := sourceRates[l[i].RID]
+		if !ok {
+			continue
+		}
+		if rate <= 0 {
+			rate = l[i].Rate
+		}
+
+		if downRate > int(float32(rate)*rateTolerance) {
+			if l[i].RID == currLevel && next != "" {
+				return next
+			}
+			return l[i].RID
+		}
+
+		next = l[i].RID
+	}
+
+	// Nothing fits, we go with the lowest level available.
+	if next != "" {
+		return next
 	}
 
-	return SimulcastLevelLow
+	return currLevel
 }
 
-func getSimulcastLevelForRate(rate int) string {
-	if rate > int(float32(simulcastRates[SimulcastLevelHigh])*rateTolerance) {
-		return SimulcastLevelHigh
+// getLevelForRate returns the highest level whose target rate fits the given
+// rate.
+func (l SimulcastLevels) getLevelForRate(rate int) string {
+	for i := len(l) - 1; i >= 0; i-- {
+		if rate > int(float32(l[i].Rate)*rateTolerance) {
+			return l[i].RID
+		}
 	}
 
-	return SimulcastLevelLow
+	return l.getLowest()
 }
 
 func (s *session) initBWEstimator(bwEstimator cc.BandwidthEstimator) {
@@ -74,13 +149,16 @@ func (s *session) initBWEstimator(bwEstimator cc.BandwidthEstimator) {
 		}
 	})
 
-	currLevel := SimulcastLevelDefault
+	levels := s.call.simulcastLevels
+	currLevel := levels.getLowest()
 	backoff := levelChangeInitialBackoff
 	var lastLevelChangeAt time.Time
 	var lastDelayRate int
 	var lastLossRate int
 
 	rateChangeHandler := func(rate int) {
+		s.call.metrics.ObserveRTCBWETargetBitrate(s.cfg.GroupID, rate)
+
 		stats := bwEstimator.GetStats()
 		lossRate, _ := stats["lossTargetBitrate"].(int)
 		delayRate, _ := stats["delayTargetBitrate"].(int)
@@ -108,7 +186,7 @@ func (s *session) initBWEstimator(bwEstimator cc.BandwidthEstimator) {
 		// before attempting to change level again, unless we are serving the
 		// high rate track and there was a drop in the estimated rate
 		// in which case we want to act as quickly as possible.
-		if time.Since(lastLevelChangeAt) < backoff && (currLevel == SimulcastLevelLow || rateDiff >= 0) {
+		if time.Since(lastLevelChangeAt) < backoff && (currLevel == levels.getLowest() || rateDiff >= 0) {
 			s.log.Debug("skipping bitrate check due to backoff, no drop", mlog.String("sessionID", s.cfg.SessionID))
 			return
 		}
@@ -119,7 +197,7 @@ func (s *session) initBWEstimator(bwEstimator cc.BandwidthEstimator) {
 			// not enough bandwidth to handle the higher rate track.
 			backoff = backoff + backoff/2
 
-			if newLevel == SimulcastLevelHigh {
+			if levels.getLevelIndex(newLevel) > levels.getLevelIndex(currLevel) {
 				// On upgrading level we update the target rate to better reflect the
 				// actual rate of the source.
 				bwEstimator.SetTargetBitrate(newRate)
@@ -151,74 +229,151 @@ func (s *session) initBWEstimator(bwEstimator cc.BandwidthEstimator) {
 	}()
 }
 
+// handleSenderBitrateChange checks whether any of the video tracks (screen or
+// camera) the session is receiving should switch simulcast level following a
+// change in the estimated downlink rate, which is split evenly among them.
+// It returns whether any level changed, the overall source rate of the
+// tracks being forwarded and the highest level among them.
 func (s *session) handleSenderBitrateChange(downRate int, lossRate int) (bool, int, string) {
-	screenSession := s.call.getScreenSession()
-	if screenSession == nil {
-		return false, 0, ""
-	}
-
 	s.mut.RLock()
-	sender := s.screenTrackSender
+	senders := make([]*webrtc.RTPSender, 0, len(s.streamSenders))
+	for _, sender := range s.streamSenders {
+		// Paused tracks don't take any bandwidth.
+		if outTrack, ok := sender.Track().(*simulcastTrack); ok && outTrack.isPaused() {
+			continue
+		}
+		senders = append(senders, sender)
+	}
 	s.mut.RUnlock()
 
-	if sender == nil {
-		// nothing to do if the session is not receiving a screen track
+	if len(senders) == 0 {
+		// nothing to do if the session is not receiving any video track
 		return false, 0, ""
 	}
 
-	currTrack := sender.Track()
+	streamDownRate := downRate / len(senders)
+	streamLossRate := lossRate / len(senders)
+
+	var changed bool
+	var totalRate int
+	var maxLevel string
+	for _, sender := range senders {
+		ok, sourceRate, level := s.handleSenderTrackBitrateChange(sender, streamDownRate, streamLossRate)
+		if ok {
+			changed = true
+		}
+		totalRate += sourceRate
+		if maxLevel == "" || s.call.simulcastLevels.getLevelIndex(level) > s.call.simulcastLevels.getLevelIndex(maxLevel) {
+			maxLevel = level
+		}
+	}
 
-	if currTrack == nil {
-		s.log.Error("track should not be nil", mlog.String("sessionID", s.cfg.SessionID))
+	if !changed {
 		return false, 0, ""
 	}
 
-	currLevel := currTrack.RID()
-	if currLevel == "" {
-		// not a simulcast track
+	return true, totalRate, maxLevel
+}
+
+// handleSenderTrackBitrateChange switches the simulcast level of the track
+// forwarded through the given sender if needed. It returns whether the level
+// changed along with the source rate and level of the track being forwarded
+// as a result.
+// Switching happens in place: the new level's packets start being forwarded
+// on the same track as soon as a keyframe for it is received, which avoids
+// renegotiating.
+func (s *session) handleSenderTrackBitrateChange(sender *webrtc.RTPSender, downRate int, lossRate int) (bool, int, string) {
+	if sender.Track() == nil {
+		// the sender may be pending negotiation or already removed
 		return false, 0, ""
 	}
 
-	localTrack, ok := currTrack.(*webrtc.TrackLocalStaticRTP)
+	outTrack, ok := sender.Track().(*simulcastTrack)
 	if !ok {
 		s.log.Error("track conversion failed", mlog.String("sessionID", s.cfg.SessionID))
 		return false, 0, ""
 	}
-	mimeType := localTrack.Codec().MimeType
+	mimeType := outTrack.mimeType
+
+	tt, publisherID, err := parseTrackID(outTrack.ID())
+	if err != nil {
+		s.log.Error("failed to parse track ID", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
+		return false, 0, ""
+	}
 
-	currSourceRate := screenSession.getSourceRate(mimeType, currLevel)
+	publisherSession := s.call.getSession(publisherID)
+	if publisherSession == nil {
+		return false, 0, ""
+	}
+
+	streamID := outTrack.StreamID()
+	currLevel := outTrack.getLevel()
+
+	currRemoteTrack := publisherSession.getRemoteTrack(streamID, mimeType, currLevel)
+	if currRemoteTrack == nil || currRemoteTrack.RID() == "" {
+		// not a simulcast track
+		return false, 0, ""
+	}
+
+	currSourceRate := publisherSession.getSourceRate(streamID, mimeType, currLevel)
 	if currSourceRate <= 0 {
 		s.log.Warn("current source rate not available yet", mlog.String("sessionID", s.cfg.SessionID))
-		return false, 0, ""
+		return false, 0, currLevel
+	}
+
+	// Levels above the receiver's preferred maximum are not considered.
+	levels := s.call.simulcastLevels
+	if maxIdx := levels.getLevelIndex(outTrack.getMaxLevel()); maxIdx >= 0 {
+		levels = levels[:maxIdx+1]
+	}
+	sourceRates := make(map[string]int, len(levels))
+	for _, cfg := range levels {
+		if publisherSession.getRemoteTrack(streamID, mimeType, cfg.RID) != nil {
+			sourceRates[cfg.RID] = publisherSession.getSourceRate(streamID, mimeType, cfg.RID)
+		}
+	}
+	if _, ok := sourceRates[currLevel]; ok {
+		sourceRates[currLevel] = currSourceRate
 	}
 
-	newLevel := getSimulcastLevel(downRate, currSourceRate)
+	newLevel := levels.getLevel(currLevel, downRate, sourceRates)
 	if newLevel == currLevel {
-		// no level change, nothing to do
-		return false, 0, ""
+		// no level change, nothing to do other than cancelling any pending switch
+		outTrack.setTargetLevel(currLevel)
+		return false, currSourceRate, currLevel
 	}
 
 	// If the loss based rate estimation is greater than the source rate we avoid
 	// potentially downgrading the level due to fluctuating delay rate estimation.
-	if currLevel == SimulcastLevelHigh && lossRate > int(float32(currSourceRate)*rateTolerance) {
+	if levels.getLevelIndex(newLevel) < levels.getLevelIndex(currLevel) && lossRate > int(float32(currSourceRate)*rateTolerance) {
 		s.log.Debug("skipping level downgrade, no loss", mlog.String("sessionID", s.cfg.SessionID))
-		return false, 0, ""
+		return false, currSourceRate, currLevel
 	}
 
-	newTrack := screenSession.getOutScreenTrack(mimeType, newLevel)
-	if newTrack == nil {
+	newRemoteTrack := publisherSession.getRemoteTrack(streamID, mimeType, newLevel)
+	if newRemoteTrack == nil {
 		// if the desired track is not available we keep the current one
-		return false, 0, ""
+		return false, currSourceRate, currLevel
 	}
 
-	sourceRate := screenSession.getSourceRate(mimeType, newLevel)
+	if outTrack.getTargetLevel() == newLevel {
+		// The switch is already pending, waiting on a keyframe. We ask again in
+		// case the previous request got lost.
+		if err := publisherSession.requestKeyFrame(newRemoteTrack); err != nil {
+			s.log.Error("failed to request keyframe", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
+		}
+		return false, currSourceRate, currLevel
+	}
+
+	sourceRate := publisherSession.getSourceRate(streamID, mimeType, newLevel)
 	if sourceRate <= 0 {
 		s.log.Warn("source rate not available", mlog.String("sessionID", s.cfg.SessionID))
-		return false, 0, ""
+		return false, currSourceRate, currLevel
 	}
 
 	s.log.Debug("switching simulcast level",
 		mlog.String("sessionID", s.cfg.SessionID),
+		mlog.String("trackType", string(tt)),
 		mlog.String("currLevel", currLevel),
 		mlog.String("newLevel", newLevel),
 		mlog.Int("downRate", downRate),
@@ -226,19 +381,93 @@ func (s *session) handleSenderBitrateChange(downRate int, lossRate int) (bool, i
 		mlog.Int("newSourceRate", sourceRate),
 	)
 
-	select {
-	case s.tracksCh <- trackActionContext{action: trackActionRemove, track: currTrack}:
-	default:
-		s.log.Error("failed to send screen track: channel is full", mlog.String("sessionID", s.cfg.SessionID))
-		return false, 0, ""
+	outTrack.setTargetLevel(newLevel)
+	direction := "up"
+	if levels.getLevelIndex(newLevel) < levels.getLevelIndex(currLevel) {
+		direction = "down"
 	}
-
-	select {
-	case s.tracksCh <- trackActionContext{action: trackActionAdd, track: newTrack}:
-	default:
-		s.log.Error("failed to send screen track: channel is full", mlog.String("sessionID", s.cfg.SessionID))
-		return false, 0, ""
+	s.call.metrics.IncSimulcastLevelSwitches(s.cfg.GroupID, direction)
+	if err := publisherSession.requestKeyFrame(newRemoteTrack); err != nil {
+		s.log.Error("failed to request keyframe", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
 	}
 
 	return true, sourceRate, newLevel
 }
+
+// setVideoPreference applies the preferences of the receiving client for the
+// given track. These are respected on top of the decisions based on the
+// bandwidth estimation: the maximum level caps the levels that can be selected
+// while pausing stops forwarding altogether.
+func (s *session) setVideoPreference(trackID, maxLevel string, paused bool) error {
+	levels := s.call.simulcastLevels
+	if maxLevel != "" && levels.getLevelIndex(maxLevel) < 0 {
+		return fmt.Errorf("invalid max level %q", maxLevel)
+	}
+
+	var outTrack *simulcastTrack
+	for _, sender := range s.rtcConn.GetSenders() {
+		if track, ok := sender.Track().(*simulcastTrack); ok && track.ID() == trackID {
+			outTrack = track
+			break
+		}
+	}
+	if outTrack == nil {
+		return fmt.Errorf("track %s not found", trackID)
+	}
+
+	_, publisherID, err := parseTrackID(outTrack.ID())
+	if err != nil {
+		return fmt.Errorf("failed to parse track ID: %w", err)
+	}
+
+	publisherSession := s.call.getSession(publisherID)
+	if publisherSession == nil {
+		return fmt.Errorf("publisher session %s not found", publisherID)
+	}
+
+	s.log.Debug("setting video preference",
+		mlog.String("sessionID", s.cfg.SessionID),
+		mlog.String("trackID", trackID),
+		mlog.String("maxLevel", maxLevel),
+		mlog.Bool("paused", paused),
+	)
+
+	wasPaused := outTrack.isPaused()
+	outTrack.setMaxLevel(maxLevel)
+	outTrack.setPaused(paused)
+	if paused {
+		return nil
+	}
+
+	streamID := outTrack.StreamID()
+	targetLevel := outTrack.getTargetLevel()
+	needsKeyFrame := wasPaused
+
+	// If the level being forwarded is above the maximum we switch to the
+	// highest one available below it.
+	if maxIdx := levels.getLevelIndex(maxLevel); maxIdx >= 0 && levels.getLevelIndex(targetLevel) > maxIdx {
+		for i := maxIdx; i >= 0; i-- {
+			if publisherSession.getRemoteTrack(streamID, outTrack.mimeType, levels[i].RID) != nil {
+				targetLevel = levels[i].RID
+				outTrack.setTargetLevel(targetLevel)
+				needsKeyFrame = true
+				break
+			}
+		}
+	}
+
+	if !needsKeyFrame {
+		return nil
+	}
+
+	remoteTrack := publisherSession.getRemoteTrack(streamID, outTrack.mimeType, targetLevel)
+	if remoteTrack == nil {
+		return fmt.Errorf("remote track not found for level %s", targetLevel)
+	}
+
+	if err := publisherSession.requestKeyFrame(remoteTrack); err != nil {
+		return fmt.Errorf("failed to request keyframe: %w", err)
+	}
+
+	return nil
+}
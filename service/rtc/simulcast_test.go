@@ -0,0 +1,76 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimulcastLevels(t *testing.T) {
+	levels := SimulcastLevels{
+		{RID: "h", Rate: 2_500_000, MonitorWindowMs: 2000},
+		{RID: "l", Rate: 500_000, MonitorWindowMs: 5000},
+		{RID: "m", Rate: 1_000_000, MonitorWindowMs: 3000},
+	}.sorted()
+
+	t.Run("sorted", func(t *testing.T) {
+		require.Equal(t, []string{"l", "m", "h"}, []string{levels[0].RID, levels[1].RID, levels[2].RID})
+		require.Equal(t, "l", levels.getLowest())
+		require.Equal(t, 2, levels.getLevelIndex("h"))
+		require.Equal(t, -1, levels.getLevelIndex("x"))
+		require.Equal(t, 1_000_000, levels.getRate("m"))
+		require.Zero(t, levels.getRate("x"))
+	})
+
+	t.Run("monitor window", func(t *testing.T) {
+		require.Equal(t, 3*time.Second, levels.getMonitorWindow("m"))
+		require.Equal(t, 5*time.Second, levels.getMonitorWindow(""))
+		require.Equal(t, 5*time.Second, SimulcastLevels{}.getMonitorWindow("h"))
+	})
+
+	t.Run("level for rate", func(t *testing.T) {
+		require.Equal(t, "l", levels.getLevelForRate(0))
+		require.Equal(t, "l", levels.getLevelForRate(800_000))
+		require.Equal(t, "m", levels.getLevelForRate(1_500_000))
+		require.Equal(t, "h", levels.getLevelForRate(3_000_000))
+	})
+
+	t.Run("level", func(t *testing.T) {
+		allRates := map[string]int{"l": 450_000, "m": 950_000, "h": 2_400_000}
+
+		// Current level fits, probing the next one up.
+		require.Equal(t, "m", levels.getLevel("l", 600_000, allRates))
+		require.Equal(t, "h", levels.getLevel("m", 1_200_000, allRates))
+
+		// Mid-bandwidth receivers get the middle level rather than the lowest.
+		require.Equal(t, "m", levels.getLevel("h", 1_200_000, allRates))
+
+		// Highest level fits.
+		require.Equal(t, "h", levels.getLevel("h", 3_000_000, allRates))
+		require.Equal(t, "h", levels.getLevel("l", 3_000_000, allRates))
+
+		// Nothing fits.
+		require.Equal(t, "l", levels.getLevel("h", 100_000, allRates))
+
+		// Missing levels are skipped.
+		require.Equal(t, "h", levels.getLevel("l", 1_200_000, map[string]int{"l": 450_000, "h": 2_400_000}))
+		require.Equal(t, "l", levels.getLevel("h", 1_200_000, map[string]int{"l": 450_000, "h": 2_400_000}))
+
+		// Unknown measured rates fall back to the configured ones.
+		require.Equal(t, "m", levels.getLevel("h", 1_200_000, map[string]int{"l": 0, "m": -1, "h": 0}))
+
+		// No level available.
+		require.Equal(t, "l", levels.getLevel("l", 1_200_000, nil))
+	})
+}
+
+func TestSessionSetVideoPreference(t *testing.T) {
+	_, sA, _ := newTestCall(t, 1)
+
+	err := sA.setVideoPreference("video_sessionB_trackID", "x", false)
+	require.EqualError(t, err, `invalid max level "x"`)
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// simulcastTrack is the outgoing track forwarding a video stream to a single
+// receiver. Packets are taken from whichever simulcast level is currently
+// selected and their sequence numbers and timestamps are rewritten so that
+// switching level is seamless to the receiver and doesn't require any
+// renegotiation. The SSRC is rewritten by the underlying local track.
+type simulcastTrack struct {
+	*webrtc.TrackLocalStaticRTP
+
+	// stream is the stream being forwarded.
+	stream     *mediaStream
+	receiverID string
+	mimeType   string
+	clockRate  uint32
+	// shard is the index of the stream writer forwarding packets to this track.
+	shard int
+
+	mut sync.Mutex
+	// level is the simulcast level currently being forwarded.
+	level string
+	// targetLevel is the level to switch to as soon as a keyframe for it is
+	// received.
+	targetLevel string
+	// maxLevel is the highest level the receiver wants (empty means no limit).
+	maxLevel string
+	// paused is set when the receiver doesn't want the track forwarded.
+	paused bool
+	// resync is set when forwarding should restart from the next keyframe
+	// (e.g. after resuming).
+	resync    bool
+	started   bool
+	seqOffset uint16
+	tsOffset  uint32
+	// baseSeq is the first (rewritten) sequence number sent since the last
+	// level switch.
+	baseSeq     uint16
+	lastSeq     uint16
+	lastTS      uint32
+	lastWriteAt time.Time
+}
+
+func newSimulcastTrack(codec webrtc.RTPCodecCapability, trackID, streamID, receiverID, level string, shard int) (*simulcastTrack, error) {
+	if level == "" {
+		level = SimulcastLevelDefault
+	}
+
+	localTrack, err := webrtc.NewTrackLocalStaticRTP(codec, trackID, streamID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create local track: %w", err)
+	}
+
+	return &simulcastTrack{
+		TrackLocalStaticRTP: localTrack,
+		receiverID:          receiverID,
+		mimeType:            codec.MimeType,
+		clockRate:           codec.ClockRate,
+		shard:               shard,
+		level:               level,
+		targetLevel:         level,
+	}, nil
+}
+
+// getLevel returns the simulcast level currently being forwarded.
+func (t *simulcastTrack) getLevel() string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.level
+}
+
+// getTargetLevel returns the simulcast level the track is switching to, which
+// matches the current level if no switch is pending.
+func (t *simulcastTrack) getTargetLevel() string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.targetLevel
+}
+
+// setTargetLevel schedules a switch to the given level, which will happen on
+// the next keyframe received for it. Setting the current level cancels any
+// pending switch.
+func (t *simulcastTrack) setTargetLevel(level string) {
+	if level == "" {
+		level = SimulcastLevelDefault
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.targetLevel = level
+}
+
+// getMaxLevel returns the highest level the receiver wants to get.
+func (t *simulcastTrack) getMaxLevel() string {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.maxLevel
+}
+
+// setMaxLevel sets the highest level the receiver wants to get. An empty
+// level means no limit.
+func (t *simulcastTrack) setMaxLevel(level string) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.maxLevel = level
+}
+
+func (t *simulcastTrack) isPaused() bool {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.paused
+}
+
+// setPaused stops or resumes forwarding. Resuming restarts from the next
+// keyframe received for the target level.
+func (t *simulcastTrack) setPaused(paused bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if t.paused && !paused {
+		t.resync = true
+	}
+	t.paused = paused
+}
+
+// rewriteRTP returns the packet to forward for the given incoming packet
+// received on the given level or false if it should be dropped.
+func (t *simulcastTrack) rewriteRTP(pkt *rtp.Packet, level string) (rtp.Packet, bool) {
+	if level == "" {
+		level = SimulcastLevelDefault
+	}
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if t.paused {
+		return rtp.Packet{}, false
+	}
+
+	if level != t.level || t.resync {
+		// Switching level (or resuming) is only possible on a keyframe as the
+		// receiver wouldn't be able to decode anything else.
+		if level != t.targetLevel || !isKeyFrame(t.mimeType, pkt.Payload) {
+			return rtp.Packet{}, false
+		}
+
+		t.level = level
+		t.resync = false
+		if t.started {
+			// We make it look like the new level's packets follow the last ones sent.
+			elapsed := uint32(time.Since(t.lastWriteAt).Seconds() * float64(t.clockRate))
+			if elapsed == 0 {
+				elapsed = 1
+			}
+			t.seqOffset = t.lastSeq + 1 - pkt.SequenceNumber
+			t.tsOffset = t.lastTS + elapsed - pkt.Timestamp
+			t.baseSeq = t.lastSeq + 1
+		}
+	}
+
+	out := *pkt
+	out.SequenceNumber = pkt.SequenceNumber + t.seqOffset
+	out.Timestamp = pkt.Timestamp + t.tsOffset
+
+	if !t.started {
+		t.started = true
+		t.baseSeq = out.SequenceNumber
+		t.lastSeq = out.SequenceNumber
+		t.lastTS = out.Timestamp
+		t.lastWriteAt = time.Now()
+		return out, true
+	}
+
+	// Late packets from before the switch would collide with the ones
+	// already sent from the previous level.
+	if int16(out.SequenceNumber-t.baseSeq) < 0 {
+		return rtp.Packet{}, false
+	}
+
+	if int16(out.SequenceNumber-t.lastSeq) > 0 {
+		t.lastSeq = out.SequenceNumber
+		t.lastTS = out.Timestamp
+		t.lastWriteAt = time.Now()
+	}
+
+	return out, true
+}
+
+// writeRTP forwards the given packet, received on the given simulcast level,
+// if it belongs to the level currently selected.
+func (t *simulcastTrack) writeRTP(pkt *rtp.Packet, level string) error {
+	out, ok := t.rewriteRTP(pkt, level)
+	if !ok {
+		return nil
+	}
+	return t.TrackLocalStaticRTP.WriteRTP(&out)
+}
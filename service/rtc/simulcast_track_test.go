@@ -0,0 +1,226 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSimulcastTrack(t *testing.T, level string) *simulcastTrack {
+	t.Helper()
+
+	track, err := newSimulcastTrack(webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeVP8,
+		ClockRate: 90000,
+	}, "video_sessionID_trackID", "streamID", "receiverID", level, 0)
+	require.NoError(t, err)
+	require.NotNil(t, track)
+
+	return track
+}
+
+func newTestVP8Packet(seq uint16, ts uint32, keyFrame bool) *rtp.Packet {
+	payload := []byte{0x10, 0x01, 0x00, 0x00, 0x9d}
+	if keyFrame {
+		payload[1] = 0x00
+	}
+	return &rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			SequenceNumber: seq,
+			Timestamp:      ts,
+		},
+		Payload: payload,
+	}
+}
+
+func TestSimulcastTrackRewriteRTP(t *testing.T) {
+	t.Run("default level", func(t *testing.T) {
+		track := newTestSimulcastTrack(t, "")
+		require.Equal(t, SimulcastLevelDefault, track.getLevel())
+		require.Equal(t, SimulcastLevelDefault, track.getTargetLevel())
+
+		// Non simulcast tracks are forwarded as they are.
+		pkt, ok := track.rewriteRTP(newTestVP8Packet(100, 1000, false), "")
+		require.True(t, ok)
+		require.Equal(t, uint16(100), pkt.SequenceNumber)
+		require.Equal(t, uint32(1000), pkt.Timestamp)
+	})
+
+	t.Run("other levels are dropped", func(t *testing.T) {
+		track := newTestSimulcastTrack(t, SimulcastLevelLow)
+
+		_, ok := track.rewriteRTP(newTestVP8Packet(100, 1000, false), SimulcastLevelLow)
+		require.True(t, ok)
+
+		_, ok = track.rewriteRTP(newTestVP8Packet(5000, 50000, true), SimulcastLevelHigh)
+		require.False(t, ok)
+	})
+
+	t.Run("switch on keyframe", func(t *testing.T) {
+		track := newTestSimulcastTrack(t, SimulcastLevelLow)
+
+		for i := 0; i < 10; i++ {
+			pkt, ok := track.rewriteRTP(newTestVP8Packet(uint16(100+i), uint32(1000+i*3000), false), SimulcastLevelLow)
+			require.True(t, ok)
+			require.Equal(t, uint16(100+i), pkt.SequenceNumber)
+		}
+
+		track.setTargetLevel(SimulcastLevelHigh)
+		require.Equal(t, SimulcastLevelLow, track.getLevel())
+		require.Equal(t, SimulcastLevelHigh, track.getTargetLevel())
+
+		// Not a keyframe, keep forwarding the current level.
+		_, ok := track.rewriteRTP(newTestVP8Packet(5000, 50000, false), SimulcastLevelHigh)
+		require.False(t, ok)
+		pkt, ok := track.rewriteRTP(newTestVP8Packet(110, 31000, false), SimulcastLevelLow)
+		require.True(t, ok)
+		require.Equal(t, uint16(110), pkt.SequenceNumber)
+
+		// Keyframe on the target level, switching.
+		pkt, ok = track.rewriteRTP(newTestVP8Packet(5001, 53000, true), SimulcastLevelHigh)
+		require.True(t, ok)
+		require.Equal(t, SimulcastLevelHigh, track.getLevel())
+		require.Equal(t, uint16(111), pkt.SequenceNumber)
+		require.Greater(t, pkt.Timestamp, uint32(31000))
+		switchTS := pkt.Timestamp
+
+		// Following packets keep the same offsets.
+		pkt, ok = track.rewriteRTP(newTestVP8Packet(5002, 56000, false), SimulcastLevelHigh)
+		require.True(t, ok)
+		require.Equal(t, uint16(112), pkt.SequenceNumber)
+		require.Equal(t, switchTS+3000, pkt.Timestamp)
+
+		// Previous level is now dropped.
+		_, ok = track.rewriteRTP(newTestVP8Packet(111, 34000, false), SimulcastLevelLow)
+		require.False(t, ok)
+
+		// Late packets from before the switch are dropped.
+		_, ok = track.rewriteRTP(newTestVP8Packet(5000, 50000, false), SimulcastLevelHigh)
+		require.False(t, ok)
+	})
+
+	t.Run("cancel pending switch", func(t *testing.T) {
+		track := newTestSimulcastTrack(t, SimulcastLevelLow)
+
+		_, ok := track.rewriteRTP(newTestVP8Packet(100, 1000, false), SimulcastLevelLow)
+		require.True(t, ok)
+
+		track.setTargetLevel(SimulcastLevelHigh)
+		track.setTargetLevel(SimulcastLevelLow)
+
+		_, ok = track.rewriteRTP(newTestVP8Packet(5000, 50000, true), SimulcastLevelHigh)
+		require.False(t, ok)
+		require.Equal(t, SimulcastLevelLow, track.getLevel())
+	})
+
+	t.Run("sequence number wrap around", func(t *testing.T) {
+		track := newTestSimulcastTrack(t, SimulcastLevelLow)
+
+		_, ok := track.rewriteRTP(newTestVP8Packet(65535, 1000, false), SimulcastLevelLow)
+		require.True(t, ok)
+
+		track.setTargetLevel(SimulcastLevelHigh)
+		pkt, ok := track.rewriteRTP(newTestVP8Packet(10, 50000, true), SimulcastLevelHigh)
+		require.True(t, ok)
+		require.Equal(t, uint16(0), pkt.SequenceNumber)
+
+		pkt, ok = track.rewriteRTP(newTestVP8Packet(11, 53000, false), SimulcastLevelHigh)
+		require.True(t, ok)
+		require.Equal(t, uint16(1), pkt.SequenceNumber)
+	})
+}
+
+func TestSimulcastTrackPause(t *testing.T) {
+	track := newTestSimulcastTrack(t, SimulcastLevelLow)
+	require.False(t, track.isPaused())
+
+	pkt, ok := track.rewriteRTP(newTestVP8Packet(100, 1000, false), SimulcastLevelLow)
+	require.True(t, ok)
+	require.Equal(t, uint16(100), pkt.SequenceNumber)
+
+	track.setPaused(true)
+	require.True(t, track.isPaused())
+
+	// Nothing is forwarded while paused, keyframes included.
+	for i := 0; i < 10; i++ {
+		_, ok = track.rewriteRTP(newTestVP8Packet(uint16(101+i), uint32(4000+i*3000), i == 5), SimulcastLevelLow)
+		require.False(t, ok)
+	}
+
+	track.setPaused(false)
+	require.False(t, track.isPaused())
+
+	// On resume we wait for a keyframe.
+	_, ok = track.rewriteRTP(newTestVP8Packet(111, 34000, false), SimulcastLevelLow)
+	require.False(t, ok)
+
+	// Sequence numbers continue from the last forwarded packet.
+	pkt, ok = track.rewriteRTP(newTestVP8Packet(112, 37000, true), SimulcastLevelLow)
+	require.True(t, ok)
+	require.Equal(t, uint16(101), pkt.SequenceNumber)
+	require.Greater(t, pkt.Timestamp, uint32(1000))
+
+	pkt, ok = track.rewriteRTP(newTestVP8Packet(113, 40000, false), SimulcastLevelLow)
+	require.True(t, ok)
+	require.Equal(t, uint16(102), pkt.SequenceNumber)
+}
+
+func TestSimulcastTrackMaxLevel(t *testing.T) {
+	track := newTestSimulcastTrack(t, SimulcastLevelHigh)
+	require.Empty(t, track.getMaxLevel())
+	track.setMaxLevel(SimulcastLevelLow)
+	require.Equal(t, SimulcastLevelLow, track.getMaxLevel())
+	track.setMaxLevel("")
+	require.Empty(t, track.getMaxLevel())
+}
+
+func TestMediaStreamOutTracks(t *testing.T) {
+	ms := newMediaStream("streamID", "sessionID", trackTypeVideo)
+	codec := webrtc.RTPCodecCapability{
+		MimeType:  webrtc.MimeTypeVP8,
+		ClockRate: 90000,
+	}
+
+	trackA, err := ms.newOutTrack(codec, "video_sessionID_trackA", "receiverA", SimulcastLevelHigh)
+	require.NoError(t, err)
+	require.NotNil(t, trackA)
+	require.Equal(t, "streamID", trackA.StreamID())
+	require.Equal(t, SimulcastLevelHigh, trackA.getLevel())
+	require.Equal(t, ms, trackA.stream)
+
+	// Only one track per receiver.
+	track, err := ms.newOutTrack(codec, "video_sessionID_trackA2", "receiverA", SimulcastLevelLow)
+	require.NoError(t, err)
+	require.Nil(t, track)
+
+	trackB, err := ms.newOutTrack(codec, "video_sessionID_trackB", "receiverB", SimulcastLevelLow)
+	require.NoError(t, err)
+	require.NotNil(t, trackB)
+
+	require.Equal(t, trackA, ms.getOutTrack("receiverA"))
+	require.Equal(t, trackB, ms.getOutTrack("receiverB"))
+	require.ElementsMatch(t, []*simulcastTrack{trackA, trackB}, ms.getOutTracks())
+
+	var iterated []*simulcastTrack
+	for i := range ms.outTracks {
+		ms.iterOutTracks(i, func(track *simulcastTrack) {
+			iterated = append(iterated, track)
+		})
+	}
+	require.ElementsMatch(t, []*simulcastTrack{trackA, trackB}, iterated)
+
+	ms.removeOutTrack(trackA)
+	require.Nil(t, ms.getOutTrack("receiverA"))
+	require.Equal(t, []*simulcastTrack{trackB}, ms.getOutTracks())
+
+	// Once removed a new one can be created.
+	track, err = ms.newOutTrack(codec, "video_sessionID_trackA2", "receiverA", SimulcastLevelLow)
+	require.NoError(t, err)
+	require.NotNil(t, track)
+}
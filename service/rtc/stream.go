@@ -0,0 +1,170 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// mediaStream holds the state of a video stream (screen or camera) published
+// by a session. A stream is made of one remote track per codec and simulcast
+// level, all indexed by getTrackIndex.
+type mediaStream struct {
+	id string
+	// publisherID is the ID of the session that originally published the
+	// stream. This differs from the owning session for streams coming from
+	// a relay.
+	publisherID  string
+	trackType    trackType
+	remoteTracks map[string]*webrtc.TrackRemote
+	rateMonitors map[string]*RateMonitor
+	// outAudioTrack is the audio track optionally accompanying a screen share,
+	// or the relayed audio track for audio streams coming from a relay.
+	outAudioTrack *webrtc.TrackLocalStaticRTP
+
+	// outTracks holds the tracks forwarding the stream, one per receiving
+	// session. To improve concurrency and support larger calls these are
+	// sharded (NumCPU) so that writing can be spread across goroutines.
+	// Unlike the fields above, these are guarded by outTracksMut.
+	outTracks    []map[string]*simulcastTrack
+	outTracksMut sync.RWMutex
+}
+
+func newMediaStream(id, publisherID string, tt trackType) *mediaStream {
+	outTracks := make([]map[string]*simulcastTrack, runtime.NumCPU())
+	for i := range outTracks {
+		outTracks[i] = make(map[string]*simulcastTrack)
+	}
+
+	return &mediaStream{
+		id:           id,
+		publisherID:  publisherID,
+		trackType:    tt,
+		remoteTracks: make(map[string]*webrtc.TrackRemote),
+		rateMonitors: make(map[string]*RateMonitor),
+		outTracks:    outTracks,
+	}
+}
+
+// getMimeType returns the mime type of the tracks that should be forwarded to
+// the given receiver. This is the first codec, in order of preference, that
+// the receiver accepts and the publisher is actually sending.
+func (ms *mediaStream) getMimeType(sender, receiver *session) string {
+	codecs := make(map[string]bool, len(ms.remoteTracks))
+	for _, track := range ms.remoteTracks {
+		codecs[strings.ToLower(track.Codec().MimeType)] = true
+	}
+
+	for _, mimeType := range videoCodecsPreference {
+		if !receiver.acceptsVideoCodec(mimeType) {
+			continue
+		}
+
+		if codecs[strings.ToLower(mimeType)] {
+			return mimeType
+		}
+
+		// Screen sharing clients supporting AV1 send it alongside the default
+		// codec so it's worth waiting for it rather than settling for the
+		// default one.
+		if ms.trackType == trackTypeScreen && mimeType == webrtc.MimeTypeAV1 && sender.supportsAV1() {
+			return mimeType
+		}
+	}
+
+	return ""
+}
+
+// getRemoteTrack returns the remote track for the given mime type and level
+// along with the level itself. Tracks may not be simulcast, in which case they
+// are indexed under the default level.
+func (ms *mediaStream) getRemoteTrack(mimeType, level string) (*webrtc.TrackRemote, string) {
+	if track := ms.remoteTracks[getTrackIndex(mimeType, level)]; track != nil {
+		return track, level
+	}
+	return ms.remoteTracks[getTrackIndex(mimeType, SimulcastLevelDefault)], SimulcastLevelDefault
+}
+
+// newOutTrack creates a track forwarding the stream to the given receiver,
+// starting from the given level. It returns nil if the receiver already has one.
+func (ms *mediaStream) newOutTrack(codec webrtc.RTPCodecCapability, trackID, receiverID, level string) (*simulcastTrack, error) {
+	ms.outTracksMut.Lock()
+	defer ms.outTracksMut.Unlock()
+
+	for _, tracks := range ms.outTracks {
+		if tracks[receiverID] != nil {
+			return nil, nil
+		}
+	}
+
+	shard := rand.Intn(len(ms.outTracks))
+	track, err := newSimulcastTrack(codec, trackID, ms.id, receiverID, level, shard)
+	if err != nil {
+		return nil, err
+	}
+	track.stream = ms
+	ms.outTracks[shard][receiverID] = track
+
+	return track, nil
+}
+
+// getOutTrack returns the track forwarding the stream to the given receiver.
+func (ms *mediaStream) getOutTrack(receiverID string) *simulcastTrack {
+	ms.outTracksMut.RLock()
+	defer ms.outTracksMut.RUnlock()
+
+	for _, tracks := range ms.outTracks {
+		if track := tracks[receiverID]; track != nil {
+			return track
+		}
+	}
+
+	return nil
+}
+
+// getOutTracks returns all the tracks forwarding the stream.
+func (ms *mediaStream) getOutTracks() []*simulcastTrack {
+	ms.outTracksMut.RLock()
+	defer ms.outTracksMut.RUnlock()
+
+	var outTracks []*simulcastTrack
+	for _, tracks := range ms.outTracks {
+		for _, track := range tracks {
+			outTracks = append(outTracks, track)
+		}
+	}
+
+	return outTracks
+}
+
+// removeOutTrack stops forwarding the stream through the given track.
+func (ms *mediaStream) removeOutTrack(track *simulcastTrack) {
+	ms.outTracksMut.Lock()
+	defer ms.outTracksMut.Unlock()
+
+	if ms.outTracks[track.shard][track.receiverID] == track {
+		delete(ms.outTracks[track.shard], track.receiverID)
+	}
+}
+
+// iterOutTracks calls cb for each of the tracks in the given shard.
+func (ms *mediaStream) iterOutTracks(shard int, cb func(track *simulcastTrack)) {
+	ms.outTracksMut.RLock()
+	defer ms.outTracksMut.RUnlock()
+
+	for _, track := range ms.outTracks[shard] {
+		cb(track)
+	}
+}
+
+// getStreamSenderKey returns the key used to index the sender forwarding the
+// given stream on the receiving side.
+func getStreamSenderKey(publisherID, streamID string) string {
+	return publisherID + "_" + streamID
+}
@@ -8,6 +8,9 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -19,6 +22,68 @@ type TURNConfig struct {
 	StaticAuthSecret string `toml:"static_auth_secret"`
 	// The number of minutes that the generated TURN credentials will be valid for.
 	CredentialsExpirationMinutes int `toml:"credentials_expiration_minutes"`
+	// Server configures the optional embedded TURN server.
+	Server TURNServerConfig `toml:"server"`
+}
+
+type TURNServerConfig struct {
+	// Enable controls whether the embedded TURN server should be started.
+	// Clients authenticate using the short-lived credentials generated
+	// through StaticAuthSecret.
+	Enable bool `toml:"enable"`
+	// ListenAddress is the address the TURN server listens on, for both UDP
+	// and TCP.
+	ListenAddress string `toml:"listen_address"`
+	// TLSListenAddress optionally specifies the address the TURN server
+	// listens on for TLS connections.
+	TLSListenAddress string `toml:"tls_listen_address"`
+	// TLSCertFile is the path to the certificate used for TLS connections.
+	TLSCertFile string `toml:"tls_cert_file"`
+	// TLSKeyFile is the path to the key used for TLS connections.
+	TLSKeyFile string `toml:"tls_key_file"`
+	// PublicIP is the IP address advertised to clients in relayed candidates.
+	PublicIP string `toml:"public_ip"`
+	// RelayAddress is the local address relay sockets are bound to.
+	RelayAddress string `toml:"relay_address"`
+	// RelayPortMin and RelayPortMax optionally restrict the range of ports
+	// used for relay sockets.
+	RelayPortMin int `toml:"relay_port_min"`
+	RelayPortMax int `toml:"relay_port_max"`
+}
+
+func (c TURNServerConfig) IsValid() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		return fmt.Errorf("invalid ListenAddress value: %w", err)
+	}
+
+	if c.TLSListenAddress != "" {
+		if _, _, err := net.SplitHostPort(c.TLSListenAddress); err != nil {
+			return fmt.Errorf("invalid TLSListenAddress value: %w", err)
+		}
+		if c.TLSCertFile == "" || c.TLSKeyFile == "" {
+			return fmt.Errorf("invalid TLS config: TLSCertFile and TLSKeyFile should be set")
+		}
+	}
+
+	if net.ParseIP(c.PublicIP) == nil {
+		return fmt.Errorf("invalid PublicIP value: not a valid address")
+	}
+
+	if net.ParseIP(c.RelayAddress) == nil {
+		return fmt.Errorf("invalid RelayAddress value: not a valid address")
+	}
+
+	if c.RelayPortMin != 0 || c.RelayPortMax != 0 {
+		if c.RelayPortMin < 1024 || c.RelayPortMax > 65535 || c.RelayPortMin > c.RelayPortMax {
+			return fmt.Errorf("invalid relay port range: [%d, %d] should be within [1024, 65535]", c.RelayPortMin, c.RelayPortMax)
+		}
+	}
+
+	return nil
 }
 
 func (c TURNConfig) IsValid() error {
@@ -31,6 +96,14 @@ func (c TURNConfig) IsValid() error {
 		}
 	}
 
+	if c.Server.Enable && c.StaticAuthSecret == "" {
+		return fmt.Errorf("invalid StaticAuthSecret value: should be set when the TURN server is enabled")
+	}
+
+	if err := c.Server.IsValid(); err != nil {
+		return fmt.Errorf("invalid Server config: %w", err)
+	}
+
 	return nil
 }
 
@@ -51,16 +124,43 @@ func genTURNCredentials(username, secret string, expirationTS int64) (string, st
 		return "", "", fmt.Errorf("expirationTS cannot be more than a week into the future")
 	}
 
-	h := hmac.New(sha1.New, []byte(secret))
 	username = fmt.Sprintf("%d:%s", expirationTS, username)
-	_, err := h.Write([]byte(username))
+	password, err := genTURNPassword(username, secret)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to write hmac: %w", err)
+		return "", "", err
 	}
-	password := base64.StdEncoding.EncodeToString(h.Sum(nil))
 	return username, password, nil
 }
 
+func genTURNPassword(username, secret string) (string, error) {
+	h := hmac.New(sha1.New, []byte(secret))
+	_, err := h.Write([]byte(username))
+	if err != nil {
+		return "", fmt.Errorf("failed to write hmac: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// getTURNPassword returns the password matching the given short-lived
+// username, as generated by genTURNCredentials, failing if expired.
+func getTURNPassword(username, secret string, now time.Time) (string, error) {
+	tsStr, _, ok := strings.Cut(username, ":")
+	if !ok {
+		return "", fmt.Errorf("invalid username format")
+	}
+
+	expirationTS, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid expiration timestamp: %w", err)
+	}
+
+	if now.Unix() > expirationTS {
+		return "", fmt.Errorf("credentials expired")
+	}
+
+	return genTURNPassword(username, secret)
+}
+
 func GenTURNConfigs(turnServers ICEServers, username, secret string, expiryMinutes int) (ICEServers, error) {
 	var configs ICEServers
 	ts := time.Now().Add(time.Duration(expiryMinutes) * time.Minute).Unix()
@@ -0,0 +1,194 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v2"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const turnServerRealm = "rtcd"
+
+// turnRelayAddressGenerator wraps a RelayAddressGenerator to keep track of
+// allocations and relayed traffic.
+type turnRelayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	protocol string
+	metrics  Metrics
+}
+
+func (g *turnRelayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	g.metrics.IncTURNAllocations(g.protocol)
+
+	return &turnRelayConn{
+		PacketConn: conn,
+		protocol:   g.protocol,
+		metrics:    g.metrics,
+	}, addr, nil
+}
+
+// turnRelayConn is the relay socket of an allocation. Reads are from peers
+// (in) and writes are to peers (out).
+type turnRelayConn struct {
+	net.PacketConn
+	protocol  string
+	metrics   Metrics
+	closeOnce sync.Once
+}
+
+func (c *turnRelayConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if n > 0 {
+		c.metrics.AddTURNRelayedBytes("in", n)
+	}
+	return n, addr, err
+}
+
+func (c *turnRelayConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if n > 0 {
+		c.metrics.AddTURNRelayedBytes("out", n)
+	}
+	return n, err
+}
+
+func (c *turnRelayConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.metrics.DecTURNAllocations(c.protocol)
+	})
+	return c.PacketConn.Close()
+}
+
+// turnAuthHandler validates the short-lived credentials generated through
+// GenTURNConfigs.
+func (s *Server) turnAuthHandler(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	password, err := getTURNPassword(username, s.cfg.TURNConfig.StaticAuthSecret, time.Now())
+	if err != nil {
+		s.metrics.IncTURNAuthFailures()
+		s.log.Debug("turn: authentication failed",
+			mlog.String("username", username),
+			mlog.String("srcAddr", srcAddr.String()),
+			mlog.Err(err))
+		return nil, false
+	}
+
+	return turn.GenerateAuthKey(username, realm, password), true
+}
+
+func (s *Server) newTURNRelayAddressGenerator(protocol string) turn.RelayAddressGenerator {
+	cfg := s.cfg.TURNConfig.Server
+
+	var gen turn.RelayAddressGenerator = &turn.RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP(cfg.PublicIP),
+		Address:      cfg.RelayAddress,
+	}
+	if cfg.RelayPortMin > 0 {
+		gen = &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: net.ParseIP(cfg.PublicIP),
+			Address:      cfg.RelayAddress,
+			MinPort:      uint16(cfg.RelayPortMin),
+			MaxPort:      uint16(cfg.RelayPortMax),
+		}
+	}
+
+	return &turnRelayAddressGenerator{
+		RelayAddressGenerator: gen,
+		protocol:              protocol,
+		metrics:               s.metrics,
+	}
+}
+
+func (s *Server) initTURNServer(udpNetwork, tcpNetwork string) error {
+	cfg := s.cfg.TURNConfig.Server
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	udpConn, err := net.ListenPacket(udpNetwork, cfg.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp: %w", err)
+	}
+
+	tcpListener, err := net.Listen(tcpNetwork, cfg.ListenAddress)
+	if err != nil {
+		udpConn.Close()
+		return fmt.Errorf("failed to listen on tcp: %w", err)
+	}
+	listeners = append(listeners, tcpListener)
+
+	listenerConfigs := []turn.ListenerConfig{
+		{
+			Listener:              tcpListener,
+			RelayAddressGenerator: s.newTURNRelayAddressGenerator("tcp"),
+		},
+	}
+
+	if cfg.TLSListenAddress != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			udpConn.Close()
+			closeAll()
+			return fmt.Errorf("failed to load TLS key pair: %w", err)
+		}
+
+		tlsListener, err := tls.Listen(tcpNetwork, cfg.TLSListenAddress, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		})
+		if err != nil {
+			udpConn.Close()
+			closeAll()
+			return fmt.Errorf("failed to listen on tls: %w", err)
+		}
+		listeners = append(listeners, tlsListener)
+
+		listenerConfigs = append(listenerConfigs, turn.ListenerConfig{
+			Listener:              tlsListener,
+			RelayAddressGenerator: s.newTURNRelayAddressGenerator("tls"),
+		})
+	}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:         turnServerRealm,
+		AuthHandler:   s.turnAuthHandler,
+		LoggerFactory: s,
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            udpConn,
+				RelayAddressGenerator: s.newTURNRelayAddressGenerator("udp"),
+			},
+		},
+		ListenerConfigs: listenerConfigs,
+	})
+	if err != nil {
+		udpConn.Close()
+		closeAll()
+		return fmt.Errorf("failed to create TURN server: %w", err)
+	}
+
+	s.turnServer = server
+
+	s.log.Info("rtc: TURN server started",
+		mlog.String("listenAddress", cfg.ListenAddress),
+		mlog.String("tlsListenAddress", cfg.TLSListenAddress),
+		mlog.String("publicIP", cfg.PublicIP))
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mattermost/rtcd/service/perf"
+
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+func TestTURNServer(t *testing.T) {
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+
+	cfg := ServerConfig{
+		ICEPortUDP:      30437,
+		ICEPortTCP:      30437,
+		UDPSocketsCount: 1,
+		TURNConfig: TURNConfig{
+			StaticAuthSecret:             "secret",
+			CredentialsExpirationMinutes: 1440,
+			Server: TURNServerConfig{
+				Enable:        true,
+				ListenAddress: "127.0.0.1:30438",
+				PublicIP:      "127.0.0.1",
+				RelayAddress:  "127.0.0.1",
+			},
+		},
+	}
+
+	s, err := NewServer(cfg, log, perf.NewMetrics("rtcd", nil))
+	require.NoError(t, err)
+	require.NoError(t, s.Start())
+	defer func() {
+		require.NoError(t, s.Stop())
+		require.NoError(t, log.Shutdown())
+	}()
+
+	newClient := func(t *testing.T, username, password string) *turn.Client {
+		t.Helper()
+
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := turn.NewClient(&turn.ClientConfig{
+			STUNServerAddr: cfg.TURNConfig.Server.ListenAddress,
+			TURNServerAddr: cfg.TURNConfig.Server.ListenAddress,
+			Conn:           conn,
+			Username:       username,
+			Password:       password,
+			RTO:            100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+		t.Cleanup(func() {
+			client.Close()
+			require.NoError(t, conn.Close())
+		})
+
+		return client
+	}
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		username, _, err := genTURNCredentials("userA", "secret", time.Now().Add(time.Minute).Unix())
+		require.NoError(t, err)
+
+		_, err = newClient(t, username, "invalid").Allocate()
+		require.Error(t, err)
+	})
+
+	t.Run("expired credentials", func(t *testing.T) {
+		username := "1:userA"
+		password, err := genTURNPassword(username, "secret")
+		require.NoError(t, err)
+
+		_, err = newClient(t, username, password).Allocate()
+		require.Error(t, err)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		configs, err := GenTURNConfigs(ICEServers{{URLs: []string{"turn:127.0.0.1:30438"}}}, "userA", "secret", 10)
+		require.NoError(t, err)
+		require.Len(t, configs, 1)
+
+		relayConn, err := newClient(t, configs[0].Username, configs[0].Credential).Allocate()
+		require.NoError(t, err)
+		defer relayConn.Close()
+
+		relayAddr, ok := relayConn.LocalAddr().(*net.UDPAddr)
+		require.True(t, ok)
+		require.Equal(t, "127.0.0.1", relayAddr.IP.String())
+
+		peerConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer peerConn.Close()
+
+		// Traffic gets relayed in both directions.
+		_, err = relayConn.WriteTo([]byte("ping"), peerConn.LocalAddr())
+		require.NoError(t, err)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peerConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, from, err := peerConn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(buf[:n]))
+		require.Equal(t, relayAddr.Port, from.(*net.UDPAddr).Port)
+
+		_, err = peerConn.WriteTo([]byte("pong"), from)
+		require.NoError(t, err)
+
+		require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err = relayConn.ReadFrom(buf)
+		require.NoError(t, err)
+		require.Equal(t, "pong", string(buf[:n]))
+	})
+}
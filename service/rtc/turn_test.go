@@ -98,3 +98,98 @@ func TestGenTURNConfigs(t *testing.T) {
 		require.NotEmpty(t, configs[1].Credential)
 	})
 }
+
+func TestTURNServerConfigIsValid(t *testing.T) {
+	validCfg := func() TURNConfig {
+		return TURNConfig{
+			StaticAuthSecret:             "secret",
+			CredentialsExpirationMinutes: 1440,
+			Server: TURNServerConfig{
+				Enable:        true,
+				ListenAddress: ":3478",
+				PublicIP:      "8.8.8.8",
+				RelayAddress:  "0.0.0.0",
+			},
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		var cfg TURNConfig
+		require.NoError(t, cfg.IsValid())
+	})
+
+	t.Run("missing secret", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.StaticAuthSecret = ""
+		require.EqualError(t, cfg.IsValid(), "invalid StaticAuthSecret value: should be set when the TURN server is enabled")
+	})
+
+	t.Run("invalid listen address", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.Server.ListenAddress = "3478"
+		require.EqualError(t, cfg.IsValid(), "invalid Server config: invalid ListenAddress value: address 3478: missing port in address")
+	})
+
+	t.Run("missing TLS cert", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.Server.TLSListenAddress = ":5349"
+		require.EqualError(t, cfg.IsValid(), "invalid Server config: invalid TLS config: TLSCertFile and TLSKeyFile should be set")
+	})
+
+	t.Run("invalid public IP", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.Server.PublicIP = ""
+		require.EqualError(t, cfg.IsValid(), "invalid Server config: invalid PublicIP value: not a valid address")
+	})
+
+	t.Run("invalid relay address", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.Server.RelayAddress = "invalid"
+		require.EqualError(t, cfg.IsValid(), "invalid Server config: invalid RelayAddress value: not a valid address")
+	})
+
+	t.Run("invalid relay port range", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.Server.RelayPortMin = 50000
+		cfg.Server.RelayPortMax = 40000
+		require.EqualError(t, cfg.IsValid(), "invalid Server config: invalid relay port range: [50000, 40000] should be within [1024, 65535]")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		cfg := validCfg()
+		cfg.Server.RelayPortMin = 40000
+		cfg.Server.RelayPortMax = 50000
+		require.NoError(t, cfg.IsValid())
+	})
+}
+
+func TestGetTURNPassword(t *testing.T) {
+	ts := time.Now().Add(30 * time.Minute).Unix()
+	username, password, err := genTURNCredentials("username", "secret", ts)
+	require.NoError(t, err)
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := getTURNPassword("username", "secret", time.Now())
+		require.EqualError(t, err, "invalid username format")
+	})
+
+	t.Run("invalid timestamp", func(t *testing.T) {
+		_, err := getTURNPassword("ts:username", "secret", time.Now())
+		require.Error(t, err)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		_, err := getTURNPassword(username, "secret", time.Now().Add(time.Hour))
+		require.EqualError(t, err, "credentials expired")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		pass, err := getTURNPassword(username, "secret", time.Now())
+		require.NoError(t, err)
+		require.Equal(t, password, pass)
+
+		pass, err = getTURNPassword(username, "otherSecret", time.Now())
+		require.NoError(t, err)
+		require.NotEqual(t, password, pass)
+	})
+}
@@ -5,13 +5,13 @@ package rtc
 
 import (
 	"fmt"
-	"math/rand"
 	"net/netip"
 	"strings"
 	"time"
 
 	"github.com/mattermost/rtcd/service/random"
 
+	"github.com/pion/rtp/codecs"
 	"github.com/pion/webrtc/v3"
 )
 
@@ -21,12 +21,14 @@ const (
 	trackTypeVoice       trackType = "voice"
 	trackTypeScreen      trackType = "screen"
 	trackTypeScreenAudio trackType = "screen-audio"
+	trackTypeVideo       trackType = "video"
 )
 
 var trackTypes = map[string]trackType{
 	"voice":        trackTypeVoice,
 	"screen":       trackTypeScreen,
 	"screen-audio": trackTypeScreenAudio,
+	"video":        trackTypeVideo,
 }
 
 func genTrackID(tt trackType, baseID string) string {
@@ -37,6 +39,15 @@ func getTrackIndex(mimeType, rid string) string {
 	return mimeType + "_" + rid
 }
 
+func isVideoMimeTypeSupported(mimeType string) bool {
+	for _, params := range rtpVideoCodecs {
+		if strings.EqualFold(params.MimeType, mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
 func isValidTrackID(trackID string) bool {
 	fields := strings.Split(trackID, "_")
 	if len(fields) != 3 {
@@ -46,6 +57,22 @@ func isValidTrackID(trackID string) bool {
 	return trackTypes[fields[0]] != ""
 }
 
+// parseTrackID returns the track type and the ID of the session that
+// generated the track with the given ID.
+func parseTrackID(trackID string) (trackType, string, error) {
+	fields := strings.Split(trackID, "_")
+	if len(fields) != 3 {
+		return "", "", fmt.Errorf("invalid trackID %q", trackID)
+	}
+
+	tt := trackTypes[fields[0]]
+	if tt == "" {
+		return "", "", fmt.Errorf("invalid track type %q", fields[0])
+	}
+
+	return tt, fields[1], nil
+}
+
 func getTrackType(kind webrtc.RTPCodecType) string {
 	if kind == webrtc.RTPCodecTypeAudio {
 		return "audio"
@@ -166,9 +193,116 @@ func getExternalAddrMapFromHostOverride(override string, publicAddrsMap map[neti
 	return m
 }
 
-func pickRandom[S ~[]*E, E any](s S) *E {
-	if len(s) == 0 {
-		return nil
+// getHostPortOverrides returns the port that should be advertised in host
+// candidates for each external address. A single port override applies to all
+// the external addresses while a mapping applies to the external address
+// each local address is paired with (e.g. "EA/IA" pairs as generated by
+// generateAddrsPairs). If multiple local addresses share the same external
+// one, the first mapping found wins.
+func getHostPortOverrides(override ICEHostPortOverride, localIPs []netip.Addr, pairs []string, externalAddrs map[string]bool) (map[string]int, error) {
+	m := make(map[string]int)
+
+	if port := override.SinglePort(); port != 0 {
+		for addr := range externalAddrs {
+			m[addr] = port
+		}
+		return m, nil
+	}
+
+	portsMap, err := override.ParseMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse port override mapping: %w", err)
 	}
-	return s[rand.Intn(len(s))]
+	if len(portsMap) == 0 {
+		return m, nil
+	}
+
+	localToExternal := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		pair := strings.Split(p, "/")
+		if len(pair) != 2 || pair[0] == pair[1] {
+			continue
+		}
+		localToExternal[pair[1]] = pair[0]
+	}
+
+	for _, ip := range localIPs {
+		port, ok := portsMap[ip.String()]
+		if !ok {
+			continue
+		}
+
+		externalAddr, ok := localToExternal[ip.String()]
+		if !ok {
+			continue
+		}
+
+		if _, ok := m[externalAddr]; !ok {
+			m[externalAddr] = port
+		}
+	}
+
+	return m, nil
+}
+
+// isKeyFrame returns whether the given RTP payload is the start of a keyframe
+// for the given video codec.
+func isKeyFrame(mimeType string, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeVP8):
+		var pkt codecs.VP8Packet
+		if _, err := pkt.Unmarshal(payload); err != nil || len(pkt.Payload) == 0 {
+			return false
+		}
+		// The inverse key frame flag is the first bit of the VP8 payload header.
+		return pkt.S == 1 && pkt.PID == 0 && pkt.Payload[0]&0x01 == 0
+	case strings.ToLower(webrtc.MimeTypeVP9):
+		var pkt codecs.VP9Packet
+		if _, err := pkt.Unmarshal(payload); err != nil {
+			return false
+		}
+		return pkt.B && !pkt.P && pkt.SID == 0
+	case strings.ToLower(webrtc.MimeTypeH264):
+		return isH264KeyFrame(payload)
+	case strings.ToLower(webrtc.MimeTypeAV1):
+		// The N bit of the aggregation header signals the first packet of a
+		// new coded video sequence.
+		return payload[0]&0x08 != 0
+	}
+
+	return false
+}
+
+const (
+	h264NALUTypeIDR   = 5
+	h264NALUTypeSPS   = 7
+	h264NALUTypeSTAPA = 24
+	h264NALUTypeFUA   = 28
+)
+
+func isH264KeyFrame(payload []byte) bool {
+	switch naluType := payload[0] & 0x1F; naluType {
+	case h264NALUTypeIDR, h264NALUTypeSPS:
+		return true
+	case h264NALUTypeSTAPA:
+		for i := 1; i+2 < len(payload); {
+			size := int(payload[i])<<8 | int(payload[i+1])
+			if i+2+size > len(payload) || size == 0 {
+				return false
+			}
+			if t := payload[i+2] & 0x1F; t == h264NALUTypeIDR || t == h264NALUTypeSPS {
+				return true
+			}
+			i += 2 + size
+		}
+	case h264NALUTypeFUA:
+		// Only the first fragment (start bit set) of an IDR unit counts.
+		return len(payload) > 1 && payload[1]&0x80 != 0 && payload[1]&0x1F == h264NALUTypeIDR
+	}
+
+	return false
 }
@@ -7,6 +7,7 @@ import (
 	"net/netip"
 	"testing"
 
+	"github.com/pion/webrtc/v3"
 	"github.com/stretchr/testify/require"
 )
 
@@ -128,7 +129,7 @@ func TestIsValidTrackID(t *testing.T) {
 		},
 		{
 			name:   "invalid track type",
-			input:  "video_id_id",
+			input:  "camera_id_id",
 			result: false,
 		},
 		{
@@ -146,6 +147,11 @@ func TestIsValidTrackID(t *testing.T) {
 			input:  "screen-audio_id_id",
 			result: true,
 		},
+		{
+			name:   "valid video",
+			input:  "video_id_id",
+			result: true,
+		},
 	}
 
 	for _, tc := range tcs {
@@ -155,6 +161,32 @@ func TestIsValidTrackID(t *testing.T) {
 	}
 }
 
+func TestParseTrackID(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		tt, sessionID, err := parseTrackID("screen_id")
+		require.EqualError(t, err, `invalid trackID "screen_id"`)
+		require.Empty(t, tt)
+		require.Empty(t, sessionID)
+
+		tt, sessionID, err = parseTrackID("camera_id_id")
+		require.EqualError(t, err, `invalid track type "camera"`)
+		require.Empty(t, tt)
+		require.Empty(t, sessionID)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		tt, sessionID, err := parseTrackID(genTrackID(trackTypeVideo, "sessionID"))
+		require.NoError(t, err)
+		require.Equal(t, trackTypeVideo, tt)
+		require.Equal(t, "sessionID", sessionID)
+
+		tt, sessionID, err = parseTrackID(genTrackID(trackTypeScreen, "sessionID"))
+		require.NoError(t, err)
+		require.Equal(t, trackTypeScreen, tt)
+		require.Equal(t, "sessionID", sessionID)
+	})
+}
+
 func TestGetExternalAddrMapFromHostOverride(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		m := getExternalAddrMapFromHostOverride("", nil)
@@ -194,3 +226,90 @@ func TestGetExternalAddrMapFromHostOverride(t *testing.T) {
 		}, m)
 	})
 }
+
+func TestGetHostPortOverrides(t *testing.T) {
+	localIPs := []netip.Addr{
+		netip.MustParseAddr("127.0.0.1"),
+		netip.MustParseAddr("192.168.1.1"),
+		netip.MustParseAddr("192.168.1.2"),
+		netip.MustParseAddr("192.168.1.3"),
+	}
+	pairs := []string{"127.0.0.1/127.0.0.1", "10.0.0.1/192.168.1.1", "10.0.0.2/192.168.1.2", "10.0.0.2/192.168.1.3"}
+	externalAddrs := map[string]bool{"10.0.0.1": true, "10.0.0.2": true}
+
+	t.Run("empty", func(t *testing.T) {
+		m, err := getHostPortOverrides("", localIPs, pairs, externalAddrs)
+		require.NoError(t, err)
+		require.Empty(t, m)
+	})
+
+	t.Run("single port", func(t *testing.T) {
+		m, err := getHostPortOverrides("8443", localIPs, pairs, externalAddrs)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{
+			"10.0.0.1": 8443,
+			"10.0.0.2": 8443,
+		}, m)
+	})
+
+	t.Run("invalid mapping", func(t *testing.T) {
+		_, err := getHostPortOverrides("192.168.1.1:8443", localIPs, pairs, externalAddrs)
+		require.EqualError(t, err, "failed to parse port override mapping: invalid map pairing syntax")
+	})
+
+	t.Run("mapping", func(t *testing.T) {
+		m, err := getHostPortOverrides("127.0.0.1/8442,192.168.1.1/8443,192.168.1.3/8444,192.168.1.2/8445,192.168.2.1/8446", localIPs, pairs, externalAddrs)
+		require.NoError(t, err)
+		require.Equal(t, map[string]int{
+			"10.0.0.1": 8443,
+			"10.0.0.2": 8445,
+		}, m)
+	})
+
+	t.Run("no matching pairs", func(t *testing.T) {
+		m, err := getHostPortOverrides("192.168.1.1/8443", localIPs, nil, nil)
+		require.NoError(t, err)
+		require.Empty(t, m)
+	})
+}
+
+func TestIsVideoMimeTypeSupported(t *testing.T) {
+	require.True(t, isVideoMimeTypeSupported("video/VP8"))
+	require.True(t, isVideoMimeTypeSupported("video/AV1"))
+	require.True(t, isVideoMimeTypeSupported("video/VP9"))
+	require.True(t, isVideoMimeTypeSupported("video/h264"))
+	require.False(t, isVideoMimeTypeSupported("video/H265"))
+	require.False(t, isVideoMimeTypeSupported("audio/opus"))
+}
+
+func TestIsKeyFrame(t *testing.T) {
+	tcs := []struct {
+		name     string
+		mimeType string
+		payload  []byte
+		expected bool
+	}{
+		{"empty", webrtc.MimeTypeVP8, nil, false},
+		{"unsupported", webrtc.MimeTypeOpus, []byte{0x10, 0x00, 0x00, 0x00}, false},
+		{"vp8 keyframe", webrtc.MimeTypeVP8, []byte{0x10, 0x00, 0x00, 0x00, 0x9d}, true},
+		{"vp8 interframe", webrtc.MimeTypeVP8, []byte{0x10, 0x01, 0x00, 0x00, 0x9d}, false},
+		{"vp8 keyframe continuation", webrtc.MimeTypeVP8, []byte{0x00, 0x00, 0x00, 0x00, 0x9d}, false},
+		{"vp9 keyframe", webrtc.MimeTypeVP9, []byte{0x08, 0xaa}, true},
+		{"vp9 interframe", webrtc.MimeTypeVP9, []byte{0x48, 0xaa}, false},
+		{"h264 idr", webrtc.MimeTypeH264, []byte{0x65, 0xaa}, true},
+		{"h264 sps", webrtc.MimeTypeH264, []byte{0x67, 0xaa}, true},
+		{"h264 non idr", webrtc.MimeTypeH264, []byte{0x41, 0xaa}, false},
+		{"h264 stap-a with sps", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42, 0x00, 0x01, 0x68}, true},
+		{"h264 stap-a without sps", webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x01, 0x41, 0x00, 0x01, 0x41}, false},
+		{"h264 fu-a idr start", webrtc.MimeTypeH264, []byte{0x7c, 0x85, 0xaa}, true},
+		{"h264 fu-a idr middle", webrtc.MimeTypeH264, []byte{0x7c, 0x05, 0xaa}, false},
+		{"av1 new sequence", webrtc.MimeTypeAV1, []byte{0x18, 0xaa}, true},
+		{"av1 interframe", webrtc.MimeTypeAV1, []byte{0x10, 0xaa}, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, isKeyFrame(tc.mimeType, tc.payload))
+		})
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package vad
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDominantSpeakerWindow             = 2 * time.Second
+	defaultDominantSpeakerEvaluationInterval = 300 * time.Millisecond
+	defaultDominantSpeakerMinSwitchInterval  = 1500 * time.Millisecond
+	defaultDominantSpeakerSwitchMargin       = 0.2
+	defaultDominantSpeakerNoiseLevel         = 70
+	// maxAudioLevel is the level (-dBov) denoting silence.
+	maxAudioLevel = 127
+)
+
+type DominantSpeakerCB func(speakerID string)
+
+type DominantSpeakerConfig struct {
+	// Window is the duration of the sliding window used to rank speakers.
+	Window time.Duration
+	// EvaluationInterval is the minimum time between rankings.
+	EvaluationInterval time.Duration
+	// MinSwitchInterval is the minimum time a speaker stays dominant before
+	// another one can take over.
+	MinSwitchInterval time.Duration
+	// SwitchMargin is how much higher (as a fraction) a speaker's score needs
+	// to be compared to the dominant one's in order to take over.
+	SwitchMargin float64
+	// NoiseLevel is the audio level (-dBov) above which samples are treated as
+	// silence.
+	NoiseLevel uint8
+}
+
+func (c DominantSpeakerConfig) SetDefaults() DominantSpeakerConfig {
+	if c.Window == 0 {
+		c.Window = defaultDominantSpeakerWindow
+	}
+
+	if c.EvaluationInterval == 0 {
+		c.EvaluationInterval = defaultDominantSpeakerEvaluationInterval
+	}
+
+	if c.MinSwitchInterval == 0 {
+		c.MinSwitchInterval = defaultDominantSpeakerMinSwitchInterval
+	}
+
+	if c.SwitchMargin == 0 {
+		c.SwitchMargin = defaultDominantSpeakerSwitchMargin
+	}
+
+	if c.NoiseLevel == 0 {
+		c.NoiseLevel = defaultDominantSpeakerNoiseLevel
+	}
+
+	return c
+}
+
+func (c DominantSpeakerConfig) IsValid() error {
+	if c.Window <= 0 {
+		return fmt.Errorf("Window should be > 0")
+	}
+
+	if c.EvaluationInterval <= 0 {
+		return fmt.Errorf("EvaluationInterval should be > 0")
+	}
+
+	if c.MinSwitchInterval < 0 {
+		return fmt.Errorf("MinSwitchInterval should be >= 0")
+	}
+
+	if c.SwitchMargin < 0 {
+		return fmt.Errorf("SwitchMargin should be >= 0")
+	}
+
+	if c.NoiseLevel == 0 || c.NoiseLevel > maxAudioLevel {
+		return fmt.Errorf("NoiseLevel should be in range [1, %d]", maxAudioLevel)
+	}
+
+	return nil
+}
+
+type levelSample struct {
+	ts       time.Time
+	loudness int
+}
+
+// DominantSpeakerDetector ranks the speakers of a call based on the audio
+// levels they send over a sliding window. The dominant speaker only changes
+// when another speaker is louder by a margin and has been for long enough.
+type DominantSpeakerDetector struct {
+	cfg DominantSpeakerConfig
+	cb  DominantSpeakerCB
+	now func() time.Time
+
+	speakers     map[string][]levelSample
+	dominant     string
+	lastSwitchAt time.Time
+	lastEvalAt   time.Time
+	mut          sync.Mutex
+}
+
+func NewDominantSpeakerDetector(cfg DominantSpeakerConfig, cb DominantSpeakerCB, now func() time.Time) (*DominantSpeakerDetector, error) {
+	if err := cfg.IsValid(); err != nil {
+		return nil, fmt.Errorf("invalid config: %s", err)
+	}
+
+	if cb == nil {
+		return nil, fmt.Errorf("dominant speaker callback is required")
+	}
+
+	if now == nil {
+		now = time.Now
+	}
+
+	return &DominantSpeakerDetector{
+		cfg:      cfg,
+		cb:       cb,
+		now:      now,
+		speakers: make(map[string][]levelSample),
+	}, nil
+}
+
+// PushAudioLevel adds an audio level sample for the given speaker. Speakers
+// are ranked at most once every EvaluationInterval, in which case the
+// callback is called if the dominant speaker changed.
+func (d *DominantSpeakerDetector) PushAudioLevel(speakerID string, level uint8) {
+	now := d.now()
+
+	d.mut.Lock()
+	var loudness int
+	if level < d.cfg.NoiseLevel {
+		loudness = int(d.cfg.NoiseLevel - level)
+	}
+	d.speakers[speakerID] = append(d.speakers[speakerID], levelSample{ts: now, loudness: loudness})
+
+	if now.Sub(d.lastEvalAt) < d.cfg.EvaluationInterval {
+		d.mut.Unlock()
+		return
+	}
+	d.lastEvalAt = now
+
+	changed := d.evaluate(now)
+	dominant := d.dominant
+	d.mut.Unlock()
+
+	if changed {
+		d.cb(dominant)
+	}
+}
+
+// RemoveSpeaker removes any state associated with the given speaker.
+func (d *DominantSpeakerDetector) RemoveSpeaker(speakerID string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	delete(d.speakers, speakerID)
+	if d.dominant == speakerID {
+		d.dominant = ""
+	}
+}
+
+// GetDominantSpeaker returns the current dominant speaker, if any.
+func (d *DominantSpeakerDetector) GetDominantSpeaker() string {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	return d.dominant
+}
+
+// evaluate ranks the speakers and returns whether the dominant one changed.
+// NOTE: this is expected to be called under lock.
+func (d *DominantSpeakerDetector) evaluate(now time.Time) bool {
+	var topSpeaker string
+	var topScore, dominantScore int
+	for speakerID, samples := range d.speakers {
+		// Dropping samples that are no longer in the window.
+		i := 0
+		for i < len(samples) && now.Sub(samples[i].ts) > d.cfg.Window {
+			i++
+		}
+		samples = samples[i:]
+		d.speakers[speakerID] = samples
+
+		var score int
+		for _, sample := range samples {
+			score += sample.loudness
+		}
+
+		if speakerID == d.dominant {
+			dominantScore = score
+		}
+
+		if score > topScore || (score == topScore && score > 0 && speakerID < topSpeaker) {
+			topScore = score
+			topSpeaker = speakerID
+		}
+	}
+
+	if topSpeaker == "" || topSpeaker == d.dominant {
+		return false
+	}
+
+	if d.dominant != "" {
+		if now.Sub(d.lastSwitchAt) < d.cfg.MinSwitchInterval {
+			return false
+		}
+
+		if float64(topScore) <= float64(dominantScore)*(1+d.cfg.SwitchMargin) {
+			return false
+		}
+	}
+
+	d.dominant = topSpeaker
+	d.lastSwitchAt = now
+
+	return true
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package vad
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestNewDominantSpeakerDetector(t *testing.T) {
+	defaultCfg := (DominantSpeakerConfig{}).SetDefaults()
+
+	t.Run("invalid config", func(t *testing.T) {
+		d, err := NewDominantSpeakerDetector(DominantSpeakerConfig{}, func(_ string) {}, nil)
+		require.EqualError(t, err, "invalid config: Window should be > 0")
+		require.Nil(t, d)
+
+		cfg := defaultCfg
+		cfg.NoiseLevel = 128
+		d, err = NewDominantSpeakerDetector(cfg, func(_ string) {}, nil)
+		require.EqualError(t, err, "invalid config: NoiseLevel should be in range [1, 127]")
+		require.Nil(t, d)
+	})
+
+	t.Run("missing callback", func(t *testing.T) {
+		d, err := NewDominantSpeakerDetector(defaultCfg, nil, nil)
+		require.EqualError(t, err, "dominant speaker callback is required")
+		require.Nil(t, d)
+	})
+
+	t.Run("default config", func(t *testing.T) {
+		d, err := NewDominantSpeakerDetector(defaultCfg, func(_ string) {}, nil)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+		require.Equal(t, defaultCfg, d.cfg)
+		require.Empty(t, d.GetDominantSpeaker())
+	})
+}
+
+func TestDominantSpeakerDetector(t *testing.T) {
+	cfg := DominantSpeakerConfig{
+		Window:             time.Second,
+		EvaluationInterval: 100 * time.Millisecond,
+		MinSwitchInterval:  500 * time.Millisecond,
+		SwitchMargin:       0.2,
+		NoiseLevel:         70,
+	}
+
+	setup := func(t *testing.T) (*DominantSpeakerDetector, *testClock, *[]string) {
+		t.Helper()
+		clock := &testClock{now: time.Now()}
+		var events []string
+		d, err := NewDominantSpeakerDetector(cfg, func(speakerID string) {
+			events = append(events, speakerID)
+		}, clock.Now)
+		require.NoError(t, err)
+		return d, clock, &events
+	}
+
+	// push sends one level per speaker every 20ms for the given duration.
+	push := func(d *DominantSpeakerDetector, clock *testClock, dur time.Duration, levels map[string]uint8) {
+		speakerIDs := make([]string, 0, len(levels))
+		for speakerID := range levels {
+			speakerIDs = append(speakerIDs, speakerID)
+		}
+		sort.Strings(speakerIDs)
+
+		for elapsed := time.Duration(0); elapsed < dur; elapsed += 20 * time.Millisecond {
+			clock.Add(20 * time.Millisecond)
+			for _, speakerID := range speakerIDs {
+				d.PushAudioLevel(speakerID, levels[speakerID])
+			}
+		}
+	}
+
+	t.Run("silence", func(t *testing.T) {
+		d, clock, events := setup(t)
+		push(d, clock, 2*time.Second, map[string]uint8{"a": 127, "b": 90})
+		require.Empty(t, *events)
+		require.Empty(t, d.GetDominantSpeaker())
+	})
+
+	t.Run("single speaker", func(t *testing.T) {
+		d, clock, events := setup(t)
+		push(d, clock, time.Second, map[string]uint8{"a": 30, "b": 127})
+		require.Equal(t, []string{"a"}, *events)
+		require.Equal(t, "a", d.GetDominantSpeaker())
+	})
+
+	t.Run("switch", func(t *testing.T) {
+		d, clock, events := setup(t)
+		push(d, clock, time.Second, map[string]uint8{"a": 30, "b": 127})
+		push(d, clock, 2*time.Second, map[string]uint8{"a": 127, "b": 30})
+		require.Equal(t, []string{"a", "b"}, *events)
+		require.Equal(t, "b", d.GetDominantSpeaker())
+	})
+
+	t.Run("hysteresis", func(t *testing.T) {
+		d, clock, events := setup(t)
+		push(d, clock, time.Second, map[string]uint8{"a": 30, "b": 127})
+
+		// Slightly louder challenger doesn't take over.
+		push(d, clock, 2*time.Second, map[string]uint8{"a": 30, "b": 26})
+		require.Equal(t, []string{"a"}, *events)
+
+		// Brief interruptions don't cause a switch either.
+		d, clock, events = setup(t)
+		push(d, clock, time.Second, map[string]uint8{"a": 30, "b": 127})
+		push(d, clock, 200*time.Millisecond, map[string]uint8{"a": 127, "b": 10})
+		push(d, clock, time.Second, map[string]uint8{"a": 30, "b": 127})
+		require.Equal(t, []string{"a"}, *events)
+	})
+
+	t.Run("min switch interval", func(t *testing.T) {
+		d, clock, events := setup(t)
+		push(d, clock, 100*time.Millisecond, map[string]uint8{"a": 30})
+		require.Equal(t, []string{"a"}, *events)
+
+		// Louder challenger but still within the min switch interval.
+		push(d, clock, 300*time.Millisecond, map[string]uint8{"b": 30})
+		require.Equal(t, []string{"a"}, *events)
+
+		push(d, clock, 300*time.Millisecond, map[string]uint8{"b": 30})
+		require.Equal(t, []string{"a", "b"}, *events)
+	})
+
+	t.Run("remove speaker", func(t *testing.T) {
+		d, clock, events := setup(t)
+		push(d, clock, time.Second, map[string]uint8{"a": 30, "b": 50})
+		require.Equal(t, []string{"a"}, *events)
+
+		d.RemoveSpeaker("a")
+		require.Empty(t, d.GetDominantSpeaker())
+		require.Equal(t, []string{"a"}, *events)
+
+		push(d, clock, 200*time.Millisecond, map[string]uint8{"b": 50})
+		require.Equal(t, []string{"a", "b"}, *events)
+	})
+}
@@ -0,0 +1,324 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// WHEP (WebRTC-HTTP Egress Protocol) sessions are receive-only sessions
+// negotiated over a single HTTP request. Since they can't be renegotiated,
+// the tracks forwarded through the regular fan-out are bound to the senders
+// negotiated upfront, one per media section offered by the viewer. Idle
+// senders are bound to placeholder tracks that never send anything.
+
+var errNoIdleWHEPSender = errors.New("no idle sender")
+
+func (s *session) isWHEP() bool {
+	return s.cfg.Props.WHEP()
+}
+
+// whepOffer holds the (recvonly) media sections a WHEP viewer offered.
+type whepOffer struct {
+	audioCount int
+	videoCount int
+	// videoCodecs are the supported video codecs the viewer accepts.
+	videoCodecs []string
+}
+
+func parseWHEPOffer(offer string) (whepOffer, error) {
+	var res whepOffer
+
+	parsed, err := (&webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}).Unmarshal()
+	if err != nil {
+		return res, fmt.Errorf("failed to parse offer: %w", err)
+	}
+
+	for _, md := range parsed.MediaDescriptions {
+		if _, ok := md.Attribute(webrtc.RTPTransceiverDirectionRecvonly.String()); !ok {
+			continue
+		}
+
+		switch md.MediaName.Media {
+		case "audio":
+			res.audioCount++
+		case "video":
+			res.videoCount++
+			for _, attr := range md.Attributes {
+				if attr.Key != "rtpmap" {
+					continue
+				}
+				// e.g. "96 VP8/90000"
+				fields := strings.Fields(attr.Value)
+				if len(fields) != 2 {
+					continue
+				}
+				mimeType := "video/" + strings.Split(fields[1], "/")[0]
+				if !isVideoMimeTypeSupported(mimeType) || containsMimeType(res.videoCodecs, mimeType) {
+					continue
+				}
+				res.videoCodecs = append(res.videoCodecs, mimeType)
+			}
+		}
+	}
+
+	if res.audioCount == 0 && (res.videoCount == 0 || len(res.videoCodecs) == 0) {
+		return res, fmt.Errorf("nothing to receive")
+	}
+
+	return res, nil
+}
+
+func containsMimeType(mimeTypes []string, mimeType string) bool {
+	for _, mt := range mimeTypes {
+		if strings.EqualFold(mt, mimeType) {
+			return true
+		}
+	}
+	return false
+}
+
+// InitWHEPSession creates a receive-only session in the given call out of a
+// WHEP offer, returning the SDP answer.
+func (s *Server) InitWHEPSession(cfg SessionConfig, offer string) (string, error) {
+	wo, err := parseWHEPOffer(offer)
+	if err != nil {
+		return "", err
+	}
+
+	props := SessionProps{}
+	for k, v := range cfg.Props {
+		props[k] = v
+	}
+	props["whep"] = true
+	props["videoCodecs"] = wo.videoCodecs
+	cfg.Props = props
+
+	return s.initHTTPSession(cfg, offer, func(us *session) error {
+		return us.initWHEPSenders(wo)
+	})
+}
+
+// CloseWHEPSession closes the given WHEP session, making sure it belongs to
+// the given group and call.
+func (s *Server) CloseWHEPSession(groupID, callID, sessionID string) error {
+	return s.closeHTTPSession(groupID, callID, sessionID, SessionProps.WHEP)
+}
+
+// initWHEPSenders adds one sender per media section the viewer offered to
+// receive so that they get matched to them during negotiation.
+func (s *session) initWHEPSenders(wo whepOffer) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.whepSenders = make(map[*webrtc.RTPSender]webrtc.TrackLocal)
+
+	addSender := func(codec webrtc.RTPCodecCapability, tt trackType) error {
+		placeholder, err := webrtc.NewTrackLocalStaticRTP(codec, genTrackID(tt, s.cfg.SessionID), s.cfg.SessionID)
+		if err != nil {
+			return fmt.Errorf("failed to create placeholder track: %w", err)
+		}
+
+		transceiver, err := s.rtcConn.AddTransceiverFromTrack(placeholder, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendonly,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add transceiver: %w", err)
+		}
+		s.call.metrics.IncRTPTracks(s.cfg.GroupID, "out", getTrackType(placeholder.Kind()))
+
+		sender := transceiver.Sender()
+		s.whepSenders[sender] = placeholder
+		go s.handleSenderRTCP(sender)
+
+		return nil
+	}
+
+	for i := 0; i < wo.audioCount; i++ {
+		if err := addSender(rtpAudioCodec, trackTypeVoice); err != nil {
+			return err
+		}
+	}
+
+	if len(wo.videoCodecs) == 0 {
+		return nil
+	}
+
+	var videoCodec webrtc.RTPCodecCapability
+	for _, mimeType := range videoCodecsPreference {
+		if containsMimeType(wo.videoCodecs, mimeType) {
+			videoCodec = webrtc.RTPCodecCapability{MimeType: mimeType, ClockRate: 90000}
+			break
+		}
+	}
+	for i := 0; i < wo.videoCount; i++ {
+		if err := addSender(videoCodec, trackTypeScreen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindWHEPTrack starts forwarding the given track through an idle sender of
+// the same kind.
+func (s *session) bindWHEPTrack(track webrtc.TrackLocal) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var idleSender *webrtc.RTPSender
+	for sender, placeholder := range s.whepSenders {
+		if sender.Track() == track {
+			return fmt.Errorf("sender for track already exists")
+		}
+		if idleSender == nil && sender.Track() == placeholder && placeholder.Kind() == track.Kind() {
+			idleSender = sender
+		}
+	}
+
+	if idleSender == nil {
+		return errNoIdleWHEPSender
+	}
+
+	if err := idleSender.ReplaceTrack(track); err != nil {
+		return fmt.Errorf("failed to replace track: %w", err)
+	}
+
+	if track.Kind() == webrtc.RTPCodecTypeVideo {
+		_, publisherID, err := parseTrackID(track.ID())
+		if err != nil {
+			return fmt.Errorf("failed to parse track ID: %w", err)
+		}
+		s.streamSenders[getStreamSenderKey(publisherID, track.StreamID())] = idleSender
+	}
+
+	return nil
+}
+
+// unbindWHEPTrack stops forwarding the given track, switching its sender back
+// to idle.
+func (s *session) unbindWHEPTrack(track webrtc.TrackLocal) error {
+	s.mut.Lock()
+	var sender *webrtc.RTPSender
+	for snd := range s.whepSenders {
+		if snd.Track() == track {
+			sender = snd
+			break
+		}
+	}
+
+	if sender == nil {
+		s.mut.Unlock()
+		return fmt.Errorf("failed to find sender for track")
+	}
+
+	if err := sender.ReplaceTrack(s.whepSenders[sender]); err != nil {
+		s.mut.Unlock()
+		return fmt.Errorf("failed to replace track: %w", err)
+	}
+
+	for key, streamSender := range s.streamSenders {
+		if streamSender == sender {
+			delete(s.streamSenders, key)
+		}
+	}
+	s.mut.Unlock()
+
+	if outTrack, ok := track.(*simulcastTrack); ok {
+		outTrack.stream.removeOutTrack(outTrack)
+	}
+
+	return nil
+}
+
+// getUnboundAudioTrack returns an audio track that should be forwarded to the
+// given WHEP session but isn't bound to any of its senders, if any.
+func (c *call) getUnboundAudioTrack(us *session) webrtc.TrackLocal {
+	bound := make(map[webrtc.TrackLocal]bool)
+	us.mut.RLock()
+	for sender := range us.whepSenders {
+		bound[sender.Track()] = true
+	}
+	us.mut.RUnlock()
+
+	var track webrtc.TrackLocal
+	c.iterSessions(func(ss *session) {
+		if track != nil || !ss.canForwardTo(us) {
+			return
+		}
+
+		ss.mut.RLock()
+		defer ss.mut.RUnlock()
+
+		if ss.outVoiceTrack != nil && c.forwardsVoice(us) && !bound[ss.outVoiceTrack] {
+			track = ss.outVoiceTrack
+			return
+		}
+
+		for _, ms := range ss.streams {
+			if ms.outAudioTrack == nil || bound[ms.outAudioTrack] {
+				continue
+			}
+			if ms.trackType == trackTypeVoice && !c.forwardsVoice(us) {
+				continue
+			}
+			track = ms.outAudioTrack
+			return
+		}
+	})
+
+	return track
+}
+
+// handleWHEPTrack applies the given track action to a WHEP session. Tracks are
+// bound to the senders negotiated upfront rather than added, meaning viewers
+// only get as many tracks as they offered to receive.
+func (s *Server) handleWHEPTrack(call *call, us *session, ctx trackActionContext) error {
+	if ctx.track == nil {
+		return fmt.Errorf("unexpected nil track")
+	}
+
+	switch ctx.action {
+	case trackActionAdd:
+		err := us.bindWHEPTrack(ctx.track)
+		if err == nil {
+			return nil
+		}
+
+		if outTrack, ok := ctx.track.(*simulcastTrack); ok {
+			outTrack.stream.removeOutTrack(outTrack)
+		}
+
+		if errors.Is(err, errNoIdleWHEPSender) {
+			s.log.Debug("no idle WHEP sender for track", mlog.String("sessionID", us.cfg.SessionID), mlog.String("trackID", ctx.track.ID()))
+			return nil
+		}
+
+		return fmt.Errorf("failed to bind track %s: %w", ctx.track.ID(), err)
+	case trackActionRemove:
+		if err := us.unbindWHEPTrack(ctx.track); err != nil {
+			return fmt.Errorf("failed to unbind track %s: %w", ctx.track.ID(), err)
+		}
+
+		// Audio tracks that didn't fit can take over the freed sender.
+		if ctx.track.Kind() != webrtc.RTPCodecTypeAudio {
+			return nil
+		}
+		if track := call.getUnboundAudioTrack(us); track != nil {
+			if err := us.bindWHEPTrack(track); err != nil && !errors.Is(err, errNoIdleWHEPSender) {
+				return fmt.Errorf("failed to bind track %s: %w", track.ID(), err)
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("invalid track action %d", ctx.action)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+)
+
+// newWHEPOffer creates a non-trickle offer to receive the given kinds of
+// media, as WHEP players do.
+func newWHEPOffer(t *testing.T, kinds ...webrtc.RTPCodecType) (*webrtc.PeerConnection, string) {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, pc.Close())
+	})
+
+	for _, kind := range kinds {
+		_, err := pc.AddTransceiverFromKind(kind, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		require.NoError(t, err)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	gatherCh := webrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gatherCh
+
+	return pc, pc.LocalDescription().SDP
+}
+
+func TestParseWHEPOffer(t *testing.T) {
+	t.Run("invalid", func(t *testing.T) {
+		_, err := parseWHEPOffer("invalid")
+		require.Error(t, err)
+	})
+
+	t.Run("nothing to receive", func(t *testing.T) {
+		track, err := webrtc.NewTrackLocalStaticSample(rtpAudioCodec, "audio", "stream")
+		require.NoError(t, err)
+		_, offer := newWHIPOffer(t, track)
+		_, err = parseWHEPOffer(offer)
+		require.EqualError(t, err, "nothing to receive")
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		_, offer := newWHEPOffer(t, webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo)
+		wo, err := parseWHEPOffer(offer)
+		require.NoError(t, err)
+		require.Equal(t, 1, wo.audioCount)
+		require.Equal(t, 1, wo.videoCount)
+		require.Contains(t, wo.videoCodecs, webrtc.MimeTypeVP8)
+	})
+}
+
+func TestWHEP(t *testing.T) {
+	s := setupRelayServer(t, 30436)
+
+	go func() {
+		for msg := range s.ReceiveCh() {
+			require.Failf(t, "unexpected message", "%+v", msg)
+		}
+	}()
+
+	viewer, offer := newWHEPOffer(t, webrtc.RTPCodecTypeAudio, webrtc.RTPCodecTypeVideo)
+	tracksCh := make(chan *webrtc.TrackRemote, 10)
+	viewer.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		tracksCh <- track
+	})
+
+	viewerCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "viewer", SessionID: "whepSession"}
+	answer, err := s.InitWHEPSession(viewerCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, viewer.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	voiceTrack, err := webrtc.NewTrackLocalStaticSample(rtpAudioCodec, "audio", "whipStream")
+	require.NoError(t, err)
+	screenTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "whipStream")
+	require.NoError(t, err)
+
+	publisher, offer := newWHIPOffer(t, voiceTrack, screenTrack)
+	publisherCfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "obs", SessionID: "whipSession"}
+	answer, err = s.InitWHIPSession(publisherCfg, offer)
+	require.NoError(t, err)
+	require.NoError(t, publisher.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				require.NoError(t, voiceTrack.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond}))
+				require.NoError(t, screenTrack.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond}))
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	// The published tracks are forwarded through the senders negotiated
+	// upfront.
+	kinds := map[webrtc.RTPCodecType]bool{}
+	for len(kinds) < 2 {
+		select {
+		case track := <-tracksCh:
+			_, _, err := track.ReadRTP()
+			require.NoError(t, err)
+			kinds[track.Kind()] = true
+		case <-time.After(20 * time.Second):
+			require.FailNow(t, "timed out waiting for WHEP tracks")
+		}
+	}
+
+	us := s.getGroup("groupID").getCall("callID").getSession("whepSession")
+	require.NotNil(t, us)
+	isIdle := func() bool {
+		us.mut.RLock()
+		defer us.mut.RUnlock()
+		for sender, placeholder := range us.whepSenders {
+			if sender.Track() != placeholder {
+				return false
+			}
+		}
+		return true
+	}
+	require.False(t, isIdle())
+
+	// Senders go back to idle once the publisher is gone.
+	require.NoError(t, s.CloseWHIPSession("groupID", "callID", "whipSession"))
+	require.Eventually(t, isIdle, 5*time.Second, 50*time.Millisecond)
+
+	require.EqualError(t, s.CloseWHIPSession("groupID", "callID", "whepSession"), "session not found: whepSession")
+	require.NoError(t, s.CloseWHEPSession("groupID", "callID", "whepSession"))
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+// WHIP (WebRTC-HTTP Ingestion Protocol) sessions are publish-only sessions
+// negotiated over a single HTTP request: the answer is returned along with all
+// the local candidates so nothing goes through the regular signaling channels.
+// Voice is forwarded as such while video is forwarded as a screen share.
+
+func (s *session) isWHIP() bool {
+	return s.cfg.Props.WHIP()
+}
+
+// hasHTTPSignaling returns whether the session was negotiated through a
+// single HTTP request (WHIP or WHEP), meaning there's no channel to send
+// signaling or notification messages on.
+func (s *session) hasHTTPSignaling() bool {
+	return s.isWHIP() || s.isWHEP()
+}
+
+// InitWHIPSession creates a publish-only session in the given call out of a
+// WHIP offer, returning the SDP answer.
+func (s *Server) InitWHIPSession(cfg SessionConfig, offer string) (string, error) {
+	props := SessionProps{"whip": true}
+	for k, v := range cfg.Props {
+		props[k] = v
+	}
+	cfg.Props = props
+
+	return s.initHTTPSession(cfg, offer, nil)
+}
+
+// initHTTPSession creates a session negotiated through a single HTTP request
+// (WHIP or WHEP) out of the given offer, returning the SDP answer along with
+// all the local candidates. setup, if given, is called right before the offer
+// gets processed.
+func (s *Server) initHTTPSession(cfg SessionConfig, offer string, setup func(us *session) error) (string, error) {
+	if err := s.InitSession(cfg, nil); err != nil {
+		return "", err
+	}
+
+	us, err := s.getSession(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	closeSession := func() {
+		if err := s.CloseSession(cfg.SessionID); err != nil {
+			s.log.Error("failed to close HTTP session", mlog.Err(err), mlog.String("sessionID", cfg.SessionID))
+		}
+	}
+
+	if setup != nil {
+		if err := setup(us); err != nil {
+			closeSession()
+			return "", err
+		}
+	}
+
+	answerCh := make(chan Message, 1)
+	select {
+	case us.sdpOfferInCh <- offerMessage{sdp: webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}, answerCh: answerCh}:
+	default:
+		closeSession()
+		return "", fmt.Errorf("failed to send sdp offer: channel is full")
+	}
+
+	select {
+	case <-answerCh:
+	case <-us.doneCh:
+		// The session gets closed if the initial negotiation fails.
+		return "", fmt.Errorf("failed to negotiate session")
+	case <-time.After(signalingTimeout):
+		closeSession()
+		return "", fmt.Errorf("timed out signaling")
+	}
+
+	select {
+	case <-webrtc.GatheringCompletePromise(us.rtcConn):
+	case <-time.After(signalingTimeout):
+		closeSession()
+		return "", fmt.Errorf("timed out gathering candidates")
+	}
+
+	return us.rtcConn.LocalDescription().SDP, nil
+}
+
+// addWHIPScreenStream registers the given video stream as a screen share since,
+// unlike clients, WHIP sessions don't announce them. It returns whether the
+// stream was accepted.
+func (c *call) addWHIPScreenStream(s *session, streamID string) bool {
+	s.mut.Lock()
+	s.screenStreamIDs[streamID] = true
+	s.mut.Unlock()
+
+	// Simulcast layers come in as separate tracks so the stream may have been
+	// registered already.
+	if err := c.addScreenStream(s, streamID); err != nil {
+		s.log.Debug("failed to add WHIP screen stream", mlog.Err(err), mlog.String("sessionID", s.cfg.SessionID))
+	}
+
+	return s.getStreamType(streamID) == trackTypeScreen
+}
+
+// CloseWHIPSession closes the given WHIP session, making sure it belongs to
+// the given group and call.
+func (s *Server) CloseWHIPSession(groupID, callID, sessionID string) error {
+	return s.closeHTTPSession(groupID, callID, sessionID, SessionProps.WHIP)
+}
+
+// closeHTTPSession closes the given session if it belongs to the given group
+// and call, and matches the given type.
+func (s *Server) closeHTTPSession(groupID, callID, sessionID string, isType func(SessionProps) bool) error {
+	s.mut.RLock()
+	cfg, ok := s.sessions[sessionID]
+	s.mut.RUnlock()
+
+	if !ok || cfg.GroupID != groupID || cfg.CallID != callID || !isType(cfg.Props) {
+		return fmt.Errorf("session not found: %s", sessionID)
+	}
+
+	return s.CloseSession(sessionID)
+}
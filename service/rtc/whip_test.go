@@ -0,0 +1,127 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package rtc
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionCanForwardToWHIP(t *testing.T) {
+	sA := &session{cfg: SessionConfig{SessionID: "sessionA"}}
+	whip := &session{cfg: SessionConfig{SessionID: "whip", Props: SessionProps{"whip": true}}}
+
+	require.True(t, whip.canForwardTo(sA))
+	require.False(t, sA.canForwardTo(whip))
+}
+
+// newWHIPOffer creates a non-trickle offer publishing the given tracks, as WHIP
+// clients do.
+func newWHIPOffer(t *testing.T, tracks ...webrtc.TrackLocal) (*webrtc.PeerConnection, string) {
+	t.Helper()
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, pc.Close())
+	})
+
+	for _, track := range tracks {
+		_, err := pc.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+		require.NoError(t, err)
+	}
+
+	offer, err := pc.CreateOffer(nil)
+	require.NoError(t, err)
+	gatherCh := webrtc.GatheringCompletePromise(pc)
+	require.NoError(t, pc.SetLocalDescription(offer))
+	<-gatherCh
+
+	return pc, pc.LocalDescription().SDP
+}
+
+func TestWHIP(t *testing.T) {
+	s := setupRelayServer(t, 30435)
+
+	clientCh := make(chan Message, 50)
+	go func() {
+		for msg := range s.ReceiveCh() {
+			require.NotEqual(t, "whipSession", msg.SessionID)
+			clientCh <- msg
+		}
+	}()
+
+	cfgA := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "userA", SessionID: "sessionA"}
+	require.NoError(t, s.InitSession(cfgA, nil))
+	tracksCh := connectRelayClient(t, s, cfgA, clientCh, nil)
+
+	voiceTrack, err := webrtc.NewTrackLocalStaticSample(rtpAudioCodec, "audio", "whipStream")
+	require.NoError(t, err)
+	screenTrack, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "whipStream")
+	require.NoError(t, err)
+
+	pc, offer := newWHIPOffer(t, voiceTrack, screenTrack)
+
+	cfg := SessionConfig{GroupID: "groupID", CallID: "callID", UserID: "obs", SessionID: "whipSession"}
+	answer, err := s.InitWHIPSession(cfg, offer)
+	require.NoError(t, err)
+	require.Contains(t, answer, "a=candidate")
+	require.NoError(t, pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}))
+
+	stopCh := make(chan struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		close(stopCh)
+		wg.Wait()
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				require.NoError(t, voiceTrack.WriteSample(media.Sample{Data: []byte{0xf8, 0xff, 0xfe}, Duration: 20 * time.Millisecond}))
+				require.NoError(t, screenTrack.WriteSample(media.Sample{Data: []byte{0x10, 0x02, 0x00, 0x9d, 0x01, 0x2a}, Duration: 20 * time.Millisecond}))
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	// Voice is forwarded as such and video as a screen share.
+	var trackIDs []string
+	for len(trackIDs) < 2 {
+		select {
+		case track := <-tracksCh:
+			trackIDs = append(trackIDs, track.ID())
+		case <-time.After(20 * time.Second):
+			require.FailNow(t, "timed out waiting for WHIP tracks", trackIDs)
+		}
+	}
+	var hasVoice, hasScreen bool
+	for _, id := range trackIDs {
+		hasVoice = hasVoice || strings.HasPrefix(id, "voice_whipSession_")
+		hasScreen = hasScreen || strings.HasPrefix(id, "screen_whipSession_")
+	}
+	require.True(t, hasVoice, trackIDs)
+	require.True(t, hasScreen, trackIDs)
+
+	t.Run("close", func(t *testing.T) {
+		require.EqualError(t, s.CloseWHIPSession("groupID", "callID", "sessionA"), "session not found: sessionA")
+		require.EqualError(t, s.CloseWHIPSession("otherGroupID", "callID", "whipSession"), "session not found: whipSession")
+		require.EqualError(t, s.CloseWHIPSession("groupID", "otherCallID", "whipSession"), "session not found: whipSession")
+		require.NoError(t, s.CloseWHIPSession("groupID", "callID", "whipSession"))
+		require.Nil(t, s.getGroup("groupID").getCall("callID").getSession("whipSession"))
+	})
+
+	require.NoError(t, s.CloseSession("sessionA"))
+}
@@ -4,6 +4,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"net/http/pprof"
 	"os"
@@ -17,14 +18,19 @@ import (
 	"github.com/mattermost/rtcd/service/perf"
 	"github.com/mattermost/rtcd/service/rtc"
 	"github.com/mattermost/rtcd/service/store"
+	"github.com/mattermost/rtcd/service/tracing"
+	"github.com/mattermost/rtcd/service/webhook"
 	"github.com/mattermost/rtcd/service/ws"
 
 	"github.com/mattermost/mattermost/server/public/shared/mlog"
 
 	godeltaprof "github.com/grafana/pyroscope-go/godeltaprof/http/pprof"
 	"github.com/prometheus/procfs"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracingShutdownTimeout = 5 * time.Second
+
 type Service struct {
 	cfg          Config
 	apiServer    *api.Server
@@ -44,6 +50,18 @@ type Service struct {
 	connMap map[string]string
 	mut     sync.RWMutex
 	stopCh  chan struct{}
+
+	// tracingShutdown flushes pending spans and stops the exporter.
+	tracingShutdown func(context.Context) error
+	// webhooks sends call events to the configured targets. It's nil if
+	// webhooks are disabled.
+	webhooks *webhook.Dispatcher
+	// eventsDoneCh is closed once all the events emitted by the rtc server
+	// have been handled.
+	eventsDoneCh chan struct{}
+	// jwtVerifier validates JWT bearer tokens. It's nil if JWT
+	// authentication is disabled.
+	jwtVerifier *auth.JWTVerifier
 }
 
 func New(cfg Config) (*Service, error) {
@@ -57,6 +75,7 @@ func New(cfg Config) (*Service, error) {
 		connMap: map[string]string{},
 		stopCh:  make(chan struct{}),
 	}
+	s.metrics.SetMaxGroupLabels(cfg.Metrics.MaxGroupLabels)
 
 	var err error
 	s.log, err = logger.New(cfg.Logger)
@@ -66,6 +85,14 @@ func New(cfg Config) (*Service, error) {
 
 	s.log.Info("rtcd: starting up", getVersionInfo().logFields()...)
 
+	s.tracingShutdown, err = tracing.Init(cfg.Tracing, getVersionInfo().BuildVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init tracing: %w", err)
+	}
+	if cfg.Tracing.Enable {
+		s.log.Info("initiated tracing", mlog.String("exporter", cfg.Tracing.Exporter))
+	}
+
 	// Mac does not have /proc/stat
 	if runtime.GOOS != "darwin" {
 		proc, err := procfs.NewDefaultFS()
@@ -83,7 +110,11 @@ func New(cfg Config) (*Service, error) {
 	}
 	s.log.Info("initiated data store", mlog.String("DataSource", cfg.Store.DataSource))
 
-	s.sessionCache, err = auth.NewSessionCache(cfg.API.Security.SessionCache)
+	var sessionCacheOpts []auth.SessionCacheOption
+	if cfg.API.Security.SessionCache.Persist {
+		sessionCacheOpts = append(sessionCacheOpts, auth.WithSessionStore(s.store, s.log))
+	}
+	s.sessionCache, err = auth.NewSessionCache(cfg.API.Security.SessionCache, sessionCacheOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session cache: %w", err)
 	}
@@ -94,6 +125,22 @@ func New(cfg Config) (*Service, error) {
 	}
 	s.log.Info("initiated auth service")
 
+	if cfg.API.Security.JWT.Enable {
+		s.jwtVerifier, err = auth.NewJWTVerifier(cfg.API.Security.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JWT verifier: %w", err)
+		}
+		s.log.Info("enabled JWT authentication")
+	}
+
+	if cfg.Webhook.Enable {
+		s.webhooks, err = webhook.NewDispatcher(cfg.Webhook, s.store, s.log)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create webhook dispatcher: %w", err)
+		}
+		s.log.Info("initiated webhook dispatcher", mlog.Int("targets", len(cfg.Webhook.Targets)))
+	}
+
 	s.apiServer, err = api.NewServer(cfg.API.HTTP, s.log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create api server: %w", err)
@@ -118,12 +165,18 @@ func New(cfg Config) (*Service, error) {
 	s.apiServer.RegisterHandleFunc("/login", s.loginClient)
 	s.apiServer.RegisterHandleFunc("/register", s.registerClient)
 	s.apiServer.RegisterHandleFunc("/unregister", s.unregisterClient)
+	s.apiServer.RegisterHandleFunc(whipPathPrefix, s.handleWHIP)
+	s.apiServer.RegisterHandleFunc(whepPathPrefix, s.handleWHEP)
 	s.apiServer.RegisterHandler("/ws", s.wsServer)
 
 	if runtime.GOOS != "darwin" {
 		s.apiServer.RegisterHandleFunc("/system", s.getSystemInfo)
 	}
 
+	if cfg.API.Security.EnableAdmin {
+		s.apiServer.RegisterHandleFunc(adminPathPrefix, s.handleAdmin)
+	}
+
 	if val := os.Getenv("PERF_PROFILES"); val == "true" {
 		runtime.SetMutexProfileFraction(5)
 		runtime.SetBlockProfileRate(5)
@@ -153,6 +206,10 @@ func (s *Service) Start() error {
 		return fmt.Errorf("failed to start rtc server: %w", err)
 	}
 
+	if s.webhooks != nil {
+		s.webhooks.Start()
+	}
+
 	go func() {
 		for msg := range s.wsServer.ReceiveCh() {
 			switch msg.Type {
@@ -192,6 +249,23 @@ func (s *Service) Start() error {
 		}
 	}()
 
+	s.eventsDoneCh = make(chan struct{})
+	go func() {
+		defer close(s.eventsDoneCh)
+		for ev := range s.rtcServer.EventCh() {
+			if s.webhooks == nil {
+				continue
+			}
+			if err := s.webhooks.Send(string(ev.Type), ev); err != nil {
+				s.log.Error("failed to send webhook event",
+					mlog.Err(err),
+					mlog.String("type", string(ev.Type)),
+					mlog.String("groupID", ev.GroupID),
+					mlog.String("callID", ev.CallID))
+			}
+		}
+	}()
+
 	go func() {
 		for msg := range s.rtcServer.ReceiveCh() {
 			if err := s.handleRTCMsg(msg); err != nil {
@@ -213,6 +287,12 @@ func (s *Service) Stop() error {
 
 	close(s.stopCh)
 
+	// If enabled, ongoing sessions are moved to other instances rather than
+	// waiting for them to end.
+	if s.cfg.RTC.MigrateSessionsOnStop {
+		s.migrateSessions()
+	}
+
 	if err := s.rtcServer.Stop(); err != nil {
 		return fmt.Errorf("failed to stop rtc server: %w", err)
 	}
@@ -223,10 +303,26 @@ func (s *Service) Stop() error {
 		return fmt.Errorf("failed to stop api server: %w", err)
 	}
 
+	// Pending events need to be queued before the dispatcher stops.
+	if s.eventsDoneCh != nil {
+		<-s.eventsDoneCh
+	}
+	if s.webhooks != nil {
+		s.webhooks.Stop()
+	}
+
+	s.sessionCache.Close()
+
 	if err := s.store.Close(); err != nil {
 		return fmt.Errorf("failed to close store: %w", err)
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := s.tracingShutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shutdown tracing: %w", err)
+	}
+
 	if err := s.log.Shutdown(); err != nil {
 		return fmt.Errorf("failed to shutdown logger: %w", err)
 	}
@@ -234,12 +330,20 @@ func (s *Service) Stop() error {
 	return nil
 }
 
-func (s *Service) handleRTCMsg(msg rtc.Message) error {
+func (s *Service) handleRTCMsg(msg rtc.Message) (errRet error) {
+	ctx := trace.ContextWithSpanContext(context.Background(), msg.SpanContext)
+	_, span := tracing.Tracer().Start(ctx, "ws.rtc_message",
+		trace.WithAttributes(tracing.SessionAttrs(msg.GroupID, msg.CallID, msg.SessionID)...),
+		trace.WithAttributes(tracing.RTCMsgTypeKey.Int(int(msg.Type))))
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
 	var cm ClientMessage
 	switch msg.Type {
 	case rtc.SDPMessage, rtc.ICEMessage:
 		cm.Type = ClientMessageRTC
-	case rtc.VoiceOnMessage, rtc.VoiceOffMessage:
+	case rtc.VoiceOnMessage, rtc.VoiceOffMessage, rtc.DominantSpeakerMessage:
 		cm.Type = ClientMessageVAD
 	default:
 		return fmt.Errorf("unexpected rtc message type: %s", cm.Type)
@@ -275,11 +379,22 @@ func (s *Service) handleRTCMsg(msg rtc.Message) error {
 	return nil
 }
 
-func (s *Service) handleClientMsg(msg ws.Message) error {
+func (s *Service) handleClientMsg(msg ws.Message) (errRet error) {
+	// The span starts from when the message was read off the connection so
+	// that any time spent queued is accounted for.
+	_, span := tracing.Tracer().Start(context.Background(), "ws.client_message",
+		trace.WithTimestamp(msg.ReceivedAt),
+		trace.WithAttributes(tracing.GroupIDKey.String(msg.ClientID)))
+	span.AddEvent("dequeued")
+	defer func() {
+		tracing.EndSpan(span, errRet)
+	}()
+
 	var cm ClientMessage
 	if err := cm.Unpack(msg.Data); err != nil {
 		return fmt.Errorf("failed to unpack data: %w", err)
 	}
+	span.SetAttributes(tracing.MsgTypeKey.String(cm.Type))
 
 	s.metrics.IncWSMessages(msg.ClientID, cm.Type, "in")
 
@@ -296,37 +411,27 @@ func (s *Service) handleClientMsg(msg ws.Message) error {
 			return fmt.Errorf("failed to read session config from map: %w", err)
 		}
 		cfg.GroupID = msg.ClientID
-
-		closeCb := func() error {
-			s.mut.Lock()
-			defer s.mut.Unlock()
-			delete(s.connMap, cfg.SessionID)
-
-			data, err := NewPackedClientMessage(ClientMessageClose, map[string]string{
-				"sessionID": cfg.SessionID,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to pack close message: %w", err)
-			}
-
-			if err := s.sendClientMessage(msg.ConnID, msg.ClientID, data); err != nil {
-				return fmt.Errorf("failed to send close message: %w", err)
-			}
-
-			return nil
-		}
+		span.SetAttributes(tracing.SessionAttrs(cfg.GroupID, cfg.CallID, cfg.SessionID)...)
 
 		s.log.Debug("join message", mlog.Any("sessionCfg", cfg))
 
-		if err := s.rtcServer.InitSession(cfg, closeCb); err != nil {
-			return fmt.Errorf("failed to initialize rtc session: %w", err)
+		return s.initSession(msg, cfg)
+	case ClientMessageMigrate:
+		// The session is being moved from a draining instance, the state it had
+		// there is restored.
+		state, ok := cm.Data.(rtc.SessionState)
+		if !ok {
+			return fmt.Errorf("unexpected data type: %T", cm.Data)
 		}
 
-		s.mut.Lock()
-		s.connMap[cfg.SessionID] = msg.ConnID
-		s.mut.Unlock()
+		cfg := state.SessionConfig()
+		cfg.GroupID = msg.ClientID
+		state.GroupID = msg.ClientID
+		span.SetAttributes(tracing.SessionAttrs(cfg.GroupID, cfg.CallID, cfg.SessionID)...)
 
-		return nil
+		s.log.Debug("migrate message", mlog.Any("sessionState", state))
+
+		return s.initSession(msg, cfg, rtc.WithSessionState(state))
 	case ClientMessageReconnect:
 		data, ok := cm.Data.(map[string]string)
 		if !ok {
@@ -337,6 +442,8 @@ func (s *Service) handleClientMsg(msg ws.Message) error {
 			return fmt.Errorf("missing sessionID in client message")
 		}
 
+		span.SetAttributes(tracing.SessionIDKey.String(sessionID))
+
 		s.log.Debug("reconnect message, updating connMap", mlog.String("sessionID", sessionID))
 		s.mut.Lock()
 		s.connMap[sessionID] = msg.ConnID
@@ -353,11 +460,34 @@ func (s *Service) handleClientMsg(msg ws.Message) error {
 			return fmt.Errorf("missing sessionID in client message")
 		}
 
+		span.SetAttributes(tracing.SessionIDKey.String(sessionID))
+
 		s.log.Debug("leave message", mlog.String("sessionID", sessionID))
 		if err := s.rtcServer.CloseSession(sessionID); err != nil {
 			return fmt.Errorf("failed to close session: %w", err)
 		}
 		return nil
+	case ClientMessageStartRecording, ClientMessageStopRecording:
+		data, ok := cm.Data.(map[string]string)
+		if !ok {
+			return fmt.Errorf("unexpected data type: %T", cm.Data)
+		}
+		callID := data["callID"]
+		if callID == "" {
+			return fmt.Errorf("missing callID in client message")
+		}
+
+		span.SetAttributes(tracing.CallIDKey.String(callID))
+
+		s.log.Debug("recording message", mlog.String("type", cm.Type), mlog.String("callID", callID))
+		if cm.Type == ClientMessageStartRecording {
+			if err := s.rtcServer.StartRecording(msg.ClientID, callID); err != nil {
+				return fmt.Errorf("failed to start recording: %w", err)
+			}
+		} else if err := s.rtcServer.StopRecording(msg.ClientID, callID); err != nil {
+			return fmt.Errorf("failed to stop recording: %w", err)
+		}
+		return nil
 	case ClientMessageRTC:
 		var ok bool
 		rtcMsg, ok = cm.Data.(rtc.Message)
@@ -365,6 +495,9 @@ func (s *Service) handleClientMsg(msg ws.Message) error {
 			return fmt.Errorf("unexpected data type: %T", cm.Data)
 		}
 		s.log.Debug("rtc message", mlog.String("sessionID", rtcMsg.SessionID), mlog.Int("type", int(rtcMsg.Type)))
+		span.SetAttributes(tracing.SessionAttrs(msg.ClientID, rtcMsg.CallID, rtcMsg.SessionID)...)
+		span.SetAttributes(tracing.RTCMsgTypeKey.Int(int(rtcMsg.Type)))
+		rtcMsg.SpanContext = span.SpanContext()
 	default:
 		return fmt.Errorf("unexpected client message type: %s", cm.Type)
 	}
@@ -376,6 +509,66 @@ func (s *Service) handleClientMsg(msg ws.Message) error {
 	return nil
 }
 
+// initSession creates the rtc session for the given config, tying it to the
+// connection the message came from.
+func (s *Service) initSession(msg ws.Message, cfg rtc.SessionConfig, opts ...rtc.SessionOption) error {
+	closeCb := func() error {
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		delete(s.connMap, cfg.SessionID)
+
+		data, err := NewPackedClientMessage(ClientMessageClose, map[string]string{
+			"sessionID": cfg.SessionID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to pack close message: %w", err)
+		}
+
+		if err := s.sendClientMessage(msg.ConnID, msg.ClientID, data); err != nil {
+			return fmt.Errorf("failed to send close message: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := s.rtcServer.InitSession(cfg, closeCb, opts...); err != nil {
+		return fmt.Errorf("failed to initialize rtc session: %w", err)
+	}
+
+	s.mut.Lock()
+	s.connMap[cfg.SessionID] = msg.ConnID
+	s.mut.Unlock()
+
+	return nil
+}
+
+// migrateSessions drains the rtc server, asking clients to move their
+// sessions to a different instance.
+func (s *Service) migrateSessions() {
+	for _, state := range s.rtcServer.Drain() {
+		s.mut.RLock()
+		connID := s.connMap[state.SessionID]
+		s.mut.RUnlock()
+		if connID == "" {
+			s.log.Warn("no connection found for session, skipping migration", mlog.String("sessionID", state.SessionID))
+			continue
+		}
+
+		data, err := NewPackedClientMessage(ClientMessageMigrate, state)
+		if err != nil {
+			s.log.Error("failed to pack migrate message", mlog.Err(err), mlog.String("sessionID", state.SessionID))
+			continue
+		}
+
+		if err := s.sendClientMessage(connID, state.GroupID, data); err != nil {
+			s.log.Error("failed to send migrate message", mlog.Err(err), mlog.String("sessionID", state.SessionID))
+			continue
+		}
+
+		s.metrics.IncWSMessages(state.GroupID, ClientMessageMigrate, "out")
+	}
+}
+
 func (s *Service) sendClientMessage(connID, clientID string, data []byte) error {
 	wsMsg := ws.Message{
 		ConnID:   connID,
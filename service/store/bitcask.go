@@ -110,6 +110,44 @@ func (s *bitcaskStore) Delete(key string) error {
 	return nil
 }
 
+func (s *bitcaskStore) Scan(prefix string, fn func(key, value string) error) error {
+	type entry struct {
+		key   string
+		value string
+	}
+
+	// Entries are collected first so that fn is called without holding the
+	// lock.
+	var entries []entry
+	s.mut.RLock()
+	err := s.db.Scan([]byte(prefix), func(key []byte) error {
+		entries = append(entries, entry{key: string(key)})
+		return nil
+	})
+	if err == nil {
+		for i := range entries {
+			val, getErr := s.db.Get([]byte(entries[i].key))
+			if getErr != nil {
+				err = getErr
+				break
+			}
+			entries[i].value = string(val)
+		}
+	}
+	s.mut.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to scan keys: %w", err)
+	}
+
+	for _, e := range entries {
+		if err := fn(e.key, e.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s *bitcaskStore) Close() error {
 	s.mut.Lock()
 	defer s.mut.Unlock()
@@ -5,8 +5,21 @@ package store
 
 import (
 	"errors"
+	"strings"
 )
 
+// Keys starting with any of the reserved prefixes hold internal data and
+// shouldn't be written to by clients (e.g. registered as client IDs).
+const (
+	SessionKeyPrefix = "auth_session_"
+	WebhookKeyPrefix = "webhook_delivery_"
+)
+
+var reservedKeyPrefixes = []string{
+	SessionKeyPrefix,
+	WebhookKeyPrefix,
+}
+
 var (
 	ErrNotFound = errors.New("error: not found")
 	ErrEmptyKey = errors.New("error: empty key")
@@ -18,9 +31,22 @@ type Store interface {
 	Set(key, value string) error
 	Get(key string) (string, error)
 	Delete(key string) error
+	// Scan calls fn with every key starting with prefix, along with its
+	// value. It's safe to modify the store from fn.
+	Scan(prefix string, fn func(key, value string) error) error
 	Close() error
 }
 
+// IsReservedKey returns whether the given key falls in the internal keyspace.
+func IsReservedKey(key string) bool {
+	for _, prefix := range reservedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 func New(dataSource string) (Store, error) {
 	return newBitcaskStore(dataSource)
 }
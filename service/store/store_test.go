@@ -170,3 +170,60 @@ func TestDelete(t *testing.T) {
 		require.Empty(t, val)
 	})
 }
+
+func TestScan(t *testing.T) {
+	dbDir, err := os.MkdirTemp("", "db")
+	require.NoError(t, err)
+	defer os.RemoveAll(dbDir)
+
+	store, err := New(dbDir)
+	require.NoError(t, err)
+	require.NotNil(t, store)
+	defer store.Close()
+
+	scan := func(t *testing.T, prefix string) map[string]string {
+		t.Helper()
+		res := map[string]string{}
+		err := store.Scan(prefix, func(key, value string) error {
+			res[key] = value
+			return nil
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		require.Empty(t, scan(t, "prefix_"))
+	})
+
+	require.NoError(t, store.Set("prefix_a", "a"))
+	require.NoError(t, store.Set("prefix_b", "b"))
+	require.NoError(t, store.Set("other", "c"))
+
+	t.Run("prefix", func(t *testing.T) {
+		require.Equal(t, map[string]string{"prefix_a": "a", "prefix_b": "b"}, scan(t, "prefix_"))
+	})
+
+	t.Run("deleting while scanning", func(t *testing.T) {
+		err := store.Scan("prefix_", func(key, _ string) error {
+			return store.Delete(key)
+		})
+		require.NoError(t, err)
+		require.Empty(t, scan(t, "prefix_"))
+		require.Equal(t, map[string]string{"other": "c"}, scan(t, "other"))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		err := store.Scan("other", func(_, _ string) error {
+			return ErrConflict
+		})
+		require.ErrorIs(t, err, ErrConflict)
+	})
+}
+
+func TestIsReservedKey(t *testing.T) {
+	require.True(t, IsReservedKey(SessionKeyPrefix+"id"))
+	require.True(t, IsReservedKey(WebhookKeyPrefix+"id"))
+	require.False(t, IsReservedKey("clientID"))
+	require.False(t, IsReservedKey(""))
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+const tracerName = "github.com/mattermost/rtcd"
+
+// Attribute keys shared by all spans so that the ones belonging to the same
+// session can be found together.
+const (
+	GroupIDKey    = attribute.Key("rtcd.group_id")
+	CallIDKey     = attribute.Key("rtcd.call_id")
+	SessionIDKey  = attribute.Key("rtcd.session_id")
+	TrackIDKey    = attribute.Key("rtcd.track_id")
+	MsgTypeKey    = attribute.Key("rtcd.msg_type")
+	RTCMsgTypeKey = attribute.Key("rtcd.rtc_msg_type")
+)
+
+type Config struct {
+	// Whether or not to enable tracing.
+	Enable bool `toml:"enable"`
+	// The exporter to use: "otlp", "stdout" or "file".
+	Exporter string `toml:"exporter"`
+	// The URL of the OTLP/HTTP collector (e.g. http://localhost:4318).
+	OTLPEndpoint string `toml:"otlp_endpoint"`
+	// The path to the file spans get written to when using the file exporter.
+	FileLocation string `toml:"file_location"`
+	// The ratio of traces to sample, between 0 and 1.
+	SampleRatio float64 `toml:"sample_ratio"`
+}
+
+func (c Config) IsValid() error {
+	if !c.Enable {
+		return nil
+	}
+
+	switch c.Exporter {
+	case ExporterOTLP:
+		if c.OTLPEndpoint == "" {
+			return fmt.Errorf("invalid OTLPEndpoint value: should not be empty")
+		}
+	case ExporterStdout:
+	case ExporterFile:
+		if c.FileLocation == "" {
+			return fmt.Errorf("invalid FileLocation value: should not be empty")
+		}
+	default:
+		return fmt.Errorf("invalid Exporter value %q", c.Exporter)
+	}
+
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("invalid SampleRatio value: should be in the range [0, 1]")
+	}
+
+	return nil
+}
+
+// Init sets up the global tracer provider as configured. The returned function
+// flushes any pending span and stops the exporter. If tracing is disabled
+// the default no-op provider is left in place.
+func Init(cfg Config, version string) (func(context.Context) error, error) {
+	if !cfg.Enable {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	var err error
+	switch cfg.Exporter {
+	case ExporterOTLP:
+		exporter, err = otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(cfg.FileLocation, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open traces file: %w", err)
+		}
+		closer = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		err = fmt.Errorf("unexpected exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("failed to create exporter: %w", err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("rtcd"),
+		semconv.ServiceVersion(version),
+	)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Tracer returns the tracer to create spans with.
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// SessionAttrs returns the attributes identifying a session.
+func SessionAttrs(groupID, callID, sessionID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		GroupIDKey.String(groupID),
+		CallIDKey.String(callID),
+		SessionIDKey.String(sessionID),
+	}
+}
+
+// EndSpan ends the given span, recording the error if any.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
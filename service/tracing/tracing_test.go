@@ -0,0 +1,144 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigIsValid(t *testing.T) {
+	tcs := []struct {
+		name   string
+		cfg    Config
+		expErr string
+	}{
+		{
+			name: "disabled",
+			cfg:  Config{},
+		},
+		{
+			name:   "invalid exporter",
+			cfg:    Config{Enable: true, Exporter: "invalid"},
+			expErr: `invalid Exporter value "invalid"`,
+		},
+		{
+			name:   "missing OTLPEndpoint",
+			cfg:    Config{Enable: true, Exporter: ExporterOTLP},
+			expErr: "invalid OTLPEndpoint value: should not be empty",
+		},
+		{
+			name:   "missing FileLocation",
+			cfg:    Config{Enable: true, Exporter: ExporterFile},
+			expErr: "invalid FileLocation value: should not be empty",
+		},
+		{
+			name:   "invalid SampleRatio",
+			cfg:    Config{Enable: true, Exporter: ExporterStdout, SampleRatio: 1.5},
+			expErr: "invalid SampleRatio value: should be in the range [0, 1]",
+		},
+		{
+			name: "valid",
+			cfg:  Config{Enable: true, Exporter: ExporterOTLP, OTLPEndpoint: "http://localhost:4318", SampleRatio: 0.5},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.cfg.IsValid()
+			if tc.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expErr)
+			}
+		})
+	}
+}
+
+func TestInit(t *testing.T) {
+	defaultProvider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(defaultProvider)
+
+	t.Run("disabled", func(t *testing.T) {
+		shutdown, err := Init(Config{}, "dev")
+		require.NoError(t, err)
+		require.NoError(t, shutdown(context.Background()))
+
+		_, span := Tracer().Start(context.Background(), "test")
+		defer span.End()
+		require.False(t, span.SpanContext().IsValid())
+	})
+
+	t.Run("file", func(t *testing.T) {
+		traceFile := filepath.Join(t.TempDir(), "traces.json")
+		shutdown, err := Init(Config{
+			Enable:       true,
+			Exporter:     ExporterFile,
+			FileLocation: traceFile,
+			SampleRatio:  1,
+		}, "dev")
+		require.NoError(t, err)
+
+		_, span := Tracer().Start(context.Background(), "test_span")
+		span.SetAttributes(SessionAttrs("groupID", "callID", "sessionID")...)
+		require.True(t, span.SpanContext().IsSampled())
+		span.End()
+
+		require.NoError(t, shutdown(context.Background()))
+
+		data, err := os.ReadFile(traceFile)
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"Name":"test_span"`)
+		require.Contains(t, string(data), `"Key":"rtcd.session_id"`)
+		require.Contains(t, string(data), `"Value":"sessionID"`)
+	})
+
+	t.Run("not sampled", func(t *testing.T) {
+		traceFile := filepath.Join(t.TempDir(), "traces.json")
+		shutdown, err := Init(Config{
+			Enable:       true,
+			Exporter:     ExporterFile,
+			FileLocation: traceFile,
+		}, "dev")
+		require.NoError(t, err)
+
+		_, span := Tracer().Start(context.Background(), "test_span")
+		require.False(t, span.SpanContext().IsSampled())
+		span.End()
+
+		require.NoError(t, shutdown(context.Background()))
+
+		data, err := os.ReadFile(traceFile)
+		require.NoError(t, err)
+		require.Empty(t, data)
+	})
+}
+
+func TestEndSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	_, span := tp.Tracer("test").Start(context.Background(), "ok")
+	EndSpan(span, nil)
+
+	_, span = tp.Tracer("test").Start(context.Background(), "failed")
+	EndSpan(span, errors.New("some error"))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Equal(t, "some error", spans[1].Status().Description)
+	require.Len(t, spans[1].Events(), 1)
+}
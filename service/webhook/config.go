@@ -0,0 +1,116 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type TargetConfig struct {
+	// The URL events are POSTed to.
+	URL string `toml:"url" json:"url"`
+	// The key used to sign the payloads sent to the target.
+	SecretKey string `toml:"secret_key" json:"secret_key"`
+}
+
+type Targets []TargetConfig
+
+func (c TargetConfig) IsValid() error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid URL scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid empty URL host")
+	}
+
+	if c.SecretKey == "" {
+		return fmt.Errorf("invalid SecretKey value: should not be empty")
+	}
+
+	return nil
+}
+
+func (t Targets) IsValid() error {
+	seen := make(map[string]bool, len(t))
+	for _, cfg := range t {
+		if err := cfg.IsValid(); err != nil {
+			return err
+		}
+		if seen[cfg.URL] {
+			return fmt.Errorf("duplicate URL %q", cfg.URL)
+		}
+		seen[cfg.URL] = true
+	}
+	return nil
+}
+
+func (t *Targets) Decode(value string) error {
+	return json.Unmarshal([]byte(value), t)
+}
+
+type Config struct {
+	// Whether or not to send call events to the configured targets.
+	Enable bool `toml:"enable"`
+	// The targets events are sent to.
+	Targets Targets `toml:"targets"`
+	// The types of the events to send. Other events are ignored.
+	Events []string `toml:"events"`
+	// The maximum number of attempts at delivering an event before giving up.
+	MaxAttempts int `toml:"max_attempts"`
+	// The delay, in seconds, before the first retry. It doubles on each
+	// subsequent failure up to RetryMaxIntervalSeconds.
+	RetryIntervalSeconds int `toml:"retry_interval_seconds"`
+	// The maximum delay, in seconds, between retries.
+	RetryMaxIntervalSeconds int `toml:"retry_max_interval_seconds"`
+	// The maximum number of events queued per target. Newer events are dropped
+	// when a target falls this far behind.
+	MaxQueueSize int `toml:"max_queue_size"`
+}
+
+func (c Config) IsValid() error {
+	if !c.Enable {
+		return nil
+	}
+
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("invalid Targets value: should not be empty")
+	}
+
+	if err := c.Targets.IsValid(); err != nil {
+		return fmt.Errorf("invalid Targets value: %w", err)
+	}
+
+	if len(c.Events) == 0 {
+		return fmt.Errorf("invalid Events value: should not be empty")
+	}
+	for _, ev := range c.Events {
+		if ev == "" {
+			return fmt.Errorf("invalid Events value: should not contain empty types")
+		}
+	}
+
+	if c.MaxAttempts <= 0 {
+		return fmt.Errorf("invalid MaxAttempts value: should be greater than zero")
+	}
+
+	if c.RetryIntervalSeconds <= 0 {
+		return fmt.Errorf("invalid RetryIntervalSeconds value: should be greater than zero")
+	}
+
+	if c.RetryMaxIntervalSeconds < c.RetryIntervalSeconds {
+		return fmt.Errorf("invalid RetryMaxIntervalSeconds value: should not be lower than RetryIntervalSeconds")
+	}
+
+	if c.MaxQueueSize <= 0 {
+		return fmt.Errorf("invalid MaxQueueSize value: should be greater than zero")
+	}
+
+	return nil
+}
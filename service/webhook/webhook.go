@@ -0,0 +1,363 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattermost/rtcd/service/random"
+	"github.com/mattermost/rtcd/service/store"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body,
+	// keyed with the target's secret and prefixed with "sha256=".
+	SignatureHeader = "X-RTCD-Signature"
+	// EventHeader holds the type of the event being sent.
+	EventHeader = "X-RTCD-Event"
+	// DeliveryHeader holds an ID unique to each delivery, which stays the same
+	// across retries.
+	DeliveryHeader = "X-RTCD-Delivery"
+)
+
+const (
+	deliveryKeyPrefix = store.WebhookKeyPrefix
+	requestTimeout    = 10 * time.Second
+)
+
+// delivery is an event queued to be sent to a target. Deliveries are
+// persisted until they either succeed or run out of attempts so that they
+// survive restarts.
+type delivery struct {
+	ID        string          `json:"id"`
+	TargetURL string          `json:"targetURL"`
+	EventType string          `json:"eventType"`
+	Payload   json.RawMessage `json:"payload"`
+	// CreatedAt (ns) is used to deliver events in order.
+	CreatedAt     int64 `json:"createdAt"`
+	Attempts      int   `json:"attempts"`
+	NextAttemptAt int64 `json:"nextAttemptAt"`
+}
+
+type target struct {
+	cfg    TargetConfig
+	queue  []*delivery
+	wakeCh chan struct{}
+}
+
+// Dispatcher sends events to the configured targets. Each target is served
+// by its own worker which delivers events in order, retrying the oldest one
+// with backoff until it succeeds, so that a failing target doesn't hold the
+// others back.
+type Dispatcher struct {
+	cfg     Config
+	store   store.Store
+	log     mlog.LoggerIFace
+	client  *http.Client
+	targets []*target
+	events  map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mut    sync.Mutex
+}
+
+func NewDispatcher(cfg Config, store store.Store, log mlog.LoggerIFace) (*Dispatcher, error) {
+	if err := cfg.IsValid(); err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return nil, fmt.Errorf("store should not be nil")
+	}
+	if log == nil {
+		return nil, fmt.Errorf("log should not be nil")
+	}
+
+	d := &Dispatcher{
+		cfg:    cfg,
+		store:  store,
+		log:    log,
+		client: &http.Client{Timeout: requestTimeout},
+		events: make(map[string]bool, len(cfg.Events)),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	for _, ev := range cfg.Events {
+		d.events[ev] = true
+	}
+
+	for _, cfg := range cfg.Targets {
+		d.targets = append(d.targets, &target{
+			cfg:    cfg,
+			wakeCh: make(chan struct{}, 1),
+		})
+	}
+
+	if err := d.loadQueue(); err != nil {
+		return nil, fmt.Errorf("failed to load queue: %w", err)
+	}
+
+	return d, nil
+}
+
+// loadQueue restores the deliveries left pending by a previous run.
+// Deliveries to targets that are no longer configured are dropped.
+func (d *Dispatcher) loadQueue() error {
+	targets := make(map[string]*target, len(d.targets))
+	for _, t := range d.targets {
+		targets[t.cfg.URL] = t
+	}
+
+	err := d.store.Scan(deliveryKeyPrefix, func(key, value string) error {
+		var dl delivery
+		if err := json.Unmarshal([]byte(value), &dl); err != nil {
+			// The key was not necessarily written by us so it's left untouched.
+			d.log.Warn("webhook: failed to unmarshal delivery, skipping", mlog.Err(err), mlog.String("key", key))
+			return nil
+		}
+
+		t := targets[dl.TargetURL]
+		if t == nil {
+			d.log.Warn("webhook: target not found, dropping delivery",
+				mlog.String("deliveryID", dl.ID),
+				mlog.String("targetURL", dl.TargetURL))
+			return d.store.Delete(key)
+		}
+
+		t.queue = append(t.queue, &dl)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range d.targets {
+		sort.Slice(t.queue, func(i, j int) bool {
+			if t.queue[i].CreatedAt != t.queue[j].CreatedAt {
+				return t.queue[i].CreatedAt < t.queue[j].CreatedAt
+			}
+			return t.queue[i].ID < t.queue[j].ID
+		})
+		if len(t.queue) > 0 {
+			d.log.Info("webhook: restored pending deliveries",
+				mlog.String("targetURL", t.cfg.URL),
+				mlog.Int("count", len(t.queue)))
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) Start() {
+	for _, t := range d.targets {
+		d.wg.Add(1)
+		go d.worker(t)
+	}
+}
+
+// Stop waits for the workers to exit. Any delivery still pending is kept in
+// the store to be resumed on the next start.
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// Send queues the given event for delivery to all the targets. Events of a
+// type not enabled in the config are ignored.
+func (d *Dispatcher) Send(eventType string, payload any) error {
+	if !d.events[eventType] {
+		return nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for _, t := range d.targets {
+		if len(t.queue) >= d.cfg.MaxQueueSize {
+			d.log.Error("webhook: queue is full, dropping event",
+				mlog.String("targetURL", t.cfg.URL),
+				mlog.String("eventType", eventType))
+			continue
+		}
+
+		now := time.Now()
+		dl := &delivery{
+			ID:            random.NewID(),
+			TargetURL:     t.cfg.URL,
+			EventType:     eventType,
+			Payload:       data,
+			CreatedAt:     now.UnixNano(),
+			NextAttemptAt: now.UnixMilli(),
+		}
+		if err := d.saveDelivery(dl); err != nil {
+			// Other targets shouldn't miss the event because of this one.
+			d.log.Error("webhook: failed to queue event",
+				mlog.Err(err),
+				mlog.String("targetURL", t.cfg.URL),
+				mlog.String("eventType", eventType))
+			continue
+		}
+
+		t.queue = append(t.queue, dl)
+
+		select {
+		case t.wakeCh <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) saveDelivery(dl *delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivery: %w", err)
+	}
+
+	if err := d.store.Set(deliveryKeyPrefix+dl.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to save delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (d *Dispatcher) removeDelivery(t *target, dl *delivery) {
+	d.mut.Lock()
+	t.queue = t.queue[1:]
+	d.mut.Unlock()
+
+	if err := d.store.Delete(deliveryKeyPrefix + dl.ID); err != nil {
+		d.log.Error("webhook: failed to delete delivery", mlog.Err(err), mlog.String("deliveryID", dl.ID))
+	}
+}
+
+// getRetryInterval returns how long to wait before the next attempt, given
+// the number of failed ones.
+func (d *Dispatcher) getRetryInterval(attempts int) time.Duration {
+	interval := time.Duration(d.cfg.RetryIntervalSeconds) * time.Second
+	maxInterval := time.Duration(d.cfg.RetryMaxIntervalSeconds) * time.Second
+	for i := 1; i < attempts && interval < maxInterval; i++ {
+		interval *= 2
+	}
+	return min(interval, maxInterval)
+}
+
+func (d *Dispatcher) worker(t *target) {
+	defer d.wg.Done()
+
+	for {
+		d.mut.Lock()
+		var dl *delivery
+		if len(t.queue) > 0 {
+			dl = t.queue[0]
+		}
+		d.mut.Unlock()
+
+		if dl == nil {
+			select {
+			case <-t.wakeCh:
+				continue
+			case <-d.ctx.Done():
+				return
+			}
+		}
+
+		if wait := time.Until(time.UnixMilli(dl.NextAttemptAt)); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-d.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		err := d.deliver(t.cfg, dl)
+		if d.ctx.Err() != nil {
+			// Interrupted deliveries don't count as attempts.
+			return
+		}
+
+		dl.Attempts++
+		if err == nil {
+			d.removeDelivery(t, dl)
+			continue
+		}
+
+		if dl.Attempts >= d.cfg.MaxAttempts {
+			d.log.Error("webhook: delivery failed, giving up",
+				mlog.Err(err),
+				mlog.String("targetURL", t.cfg.URL),
+				mlog.String("deliveryID", dl.ID),
+				mlog.Int("attempts", dl.Attempts))
+			d.removeDelivery(t, dl)
+			continue
+		}
+
+		retryInterval := d.getRetryInterval(dl.Attempts)
+		d.log.Warn("webhook: delivery failed, retrying",
+			mlog.Err(err),
+			mlog.String("targetURL", t.cfg.URL),
+			mlog.String("deliveryID", dl.ID),
+			mlog.Int("attempts", dl.Attempts),
+			mlog.Any("retryInterval", retryInterval))
+
+		dl.NextAttemptAt = time.Now().Add(retryInterval).UnixMilli()
+		if err := d.saveDelivery(dl); err != nil {
+			d.log.Error("webhook: failed to update delivery", mlog.Err(err), mlog.String("deliveryID", dl.ID))
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(cfg TargetConfig, dl *delivery) error {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, cfg.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.EventType)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(SignatureHeader, Sign(cfg.SecretKey, dl.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	// Draining the body allows the connection to be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1024*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the signature of the given payload as sent in SignatureHeader.
+func Sign(secretKey string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secretKey))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
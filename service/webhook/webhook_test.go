@@ -0,0 +1,417 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package webhook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/rtcd/service/store"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigIsValid(t *testing.T) {
+	validCfg := Config{
+		Enable:                  true,
+		Targets:                 Targets{{URL: "https://example.com/events", SecretKey: "secret"}},
+		Events:                  []string{"call_started", "call_ended"},
+		MaxAttempts:             10,
+		RetryIntervalSeconds:    5,
+		RetryMaxIntervalSeconds: 300,
+		MaxQueueSize:            100,
+	}
+
+	tcs := []struct {
+		name   string
+		mod    func(cfg *Config)
+		expErr string
+	}{
+		{
+			name: "disabled",
+			mod: func(cfg *Config) {
+				*cfg = Config{}
+			},
+		},
+		{
+			name: "valid",
+			mod:  func(_ *Config) {},
+		},
+		{
+			name: "no targets",
+			mod: func(cfg *Config) {
+				cfg.Targets = nil
+			},
+			expErr: "invalid Targets value: should not be empty",
+		},
+		{
+			name: "invalid URL",
+			mod: func(cfg *Config) {
+				cfg.Targets[0].URL = "ftp://example.com"
+			},
+			expErr: `invalid Targets value: invalid URL scheme "ftp"`,
+		},
+		{
+			name: "missing secret",
+			mod: func(cfg *Config) {
+				cfg.Targets[0].SecretKey = ""
+			},
+			expErr: "invalid Targets value: invalid SecretKey value: should not be empty",
+		},
+		{
+			name: "duplicate URL",
+			mod: func(cfg *Config) {
+				cfg.Targets = append(cfg.Targets, cfg.Targets[0])
+			},
+			expErr: `invalid Targets value: duplicate URL "https://example.com/events"`,
+		},
+		{
+			name: "no events",
+			mod: func(cfg *Config) {
+				cfg.Events = nil
+			},
+			expErr: "invalid Events value: should not be empty",
+		},
+		{
+			name: "empty event",
+			mod: func(cfg *Config) {
+				cfg.Events = []string{"call_started", ""}
+			},
+			expErr: "invalid Events value: should not contain empty types",
+		},
+		{
+			name: "invalid MaxAttempts",
+			mod: func(cfg *Config) {
+				cfg.MaxAttempts = 0
+			},
+			expErr: "invalid MaxAttempts value: should be greater than zero",
+		},
+		{
+			name: "invalid RetryMaxIntervalSeconds",
+			mod: func(cfg *Config) {
+				cfg.RetryMaxIntervalSeconds = 1
+			},
+			expErr: "invalid RetryMaxIntervalSeconds value: should not be lower than RetryIntervalSeconds",
+		},
+		{
+			name: "invalid MaxQueueSize",
+			mod: func(cfg *Config) {
+				cfg.MaxQueueSize = 0
+			},
+			expErr: "invalid MaxQueueSize value: should be greater than zero",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := validCfg
+			cfg.Targets = append(Targets{}, validCfg.Targets...)
+			tc.mod(&cfg)
+			err := cfg.IsValid()
+			if tc.expErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tc.expErr)
+			}
+		})
+	}
+}
+
+func TestTargetsDecode(t *testing.T) {
+	var targets Targets
+	err := targets.Decode(`[{"url": "https://example.com/events", "secret_key": "secret"}]`)
+	require.NoError(t, err)
+	require.Equal(t, Targets{{URL: "https://example.com/events", SecretKey: "secret"}}, targets)
+}
+
+type testTarget struct {
+	*httptest.Server
+	t        *testing.T
+	secret   string
+	mut      sync.Mutex
+	failures int
+	received []string
+}
+
+func newTestTarget(t *testing.T, secret string, failures int) *testTarget {
+	tt := &testTarget{t: t, secret: secret, failures: failures}
+	tt.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, Sign(tt.secret, body), r.Header.Get(SignatureHeader))
+		require.NotEmpty(t, r.Header.Get(DeliveryHeader))
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		tt.mut.Lock()
+		defer tt.mut.Unlock()
+		if tt.failures > 0 {
+			tt.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		tt.received = append(tt.received, r.Header.Get(EventHeader)+":"+string(body))
+	}))
+	return tt
+}
+
+func (tt *testTarget) getReceived() []string {
+	tt.mut.Lock()
+	defer tt.mut.Unlock()
+	return append([]string{}, tt.received...)
+}
+
+func setupStore(t *testing.T) (store.Store, func()) {
+	t.Helper()
+	dbDir, err := os.MkdirTemp("", "db")
+	require.NoError(t, err)
+	st, err := store.New(dbDir)
+	require.NoError(t, err)
+	return st, func() {
+		require.NoError(t, st.Close())
+		require.NoError(t, os.RemoveAll(dbDir))
+	}
+}
+
+func countDeliveries(t *testing.T, st store.Store) int {
+	t.Helper()
+	var n int
+	err := st.Scan(deliveryKeyPrefix, func(_, _ string) error {
+		n++
+		return nil
+	})
+	require.NoError(t, err)
+	return n
+}
+
+// failingStore fails the first n writes.
+type failingStore struct {
+	store.Store
+	n int
+}
+
+func (s *failingStore) Set(key, value string) error {
+	if s.n > 0 {
+		s.n--
+		return errors.New("store failure")
+	}
+	return s.Store.Set(key, value)
+}
+
+func makeConfig(targets ...*testTarget) Config {
+	cfg := Config{
+		Enable:                  true,
+		Events:                  []string{"call_started", "call_ended"},
+		MaxAttempts:             3,
+		RetryIntervalSeconds:    1,
+		RetryMaxIntervalSeconds: 1,
+		MaxQueueSize:            10,
+	}
+	for _, tt := range targets {
+		cfg.Targets = append(cfg.Targets, TargetConfig{URL: tt.URL, SecretKey: tt.secret})
+	}
+	return cfg
+}
+
+func TestDispatcher(t *testing.T) {
+	log, err := mlog.NewLogger()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, log.Shutdown())
+	}()
+
+	t.Run("delivery", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		targetA := newTestTarget(t, "secretA", 0)
+		defer targetA.Close()
+		targetB := newTestTarget(t, "secretB", 0)
+		defer targetB.Close()
+
+		d, err := NewDispatcher(makeConfig(targetA, targetB), st, log)
+		require.NoError(t, err)
+		d.Start()
+		defer d.Stop()
+
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.NoError(t, d.Send("call_ended", map[string]string{"callID": "callA"}))
+
+		expected := []string{`call_started:{"callID":"callA"}`, `call_ended:{"callID":"callA"}`}
+		require.Eventually(t, func() bool {
+			return len(targetA.getReceived()) == 2 && len(targetB.getReceived()) == 2
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, expected, targetA.getReceived())
+		require.Equal(t, expected, targetB.getReceived())
+		require.Zero(t, countDeliveries(t, st))
+	})
+
+	t.Run("filtered events", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		target := newTestTarget(t, "secret", 0)
+		defer target.Close()
+
+		d, err := NewDispatcher(makeConfig(target), st, log)
+		require.NoError(t, err)
+
+		require.NoError(t, d.Send("voice_on", map[string]string{"callID": "callA"}))
+		require.Zero(t, countDeliveries(t, st))
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.Equal(t, 1, countDeliveries(t, st))
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		target := newTestTarget(t, "secret", 1)
+		defer target.Close()
+
+		d, err := NewDispatcher(makeConfig(target), st, log)
+		require.NoError(t, err)
+		d.Start()
+		defer d.Stop()
+
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.NoError(t, d.Send("call_ended", map[string]string{"callID": "callA"}))
+
+		// Order is preserved across retries.
+		require.Eventually(t, func() bool {
+			return len(target.getReceived()) == 2
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, []string{`call_started:{"callID":"callA"}`, `call_ended:{"callID":"callA"}`}, target.getReceived())
+		require.Zero(t, countDeliveries(t, st))
+	})
+
+	t.Run("giving up", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		target := newTestTarget(t, "secret", 3)
+		defer target.Close()
+
+		d, err := NewDispatcher(makeConfig(target), st, log)
+		require.NoError(t, err)
+		d.Start()
+		defer d.Stop()
+
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.NoError(t, d.Send("call_ended", map[string]string{"callID": "callA"}))
+
+		require.Eventually(t, func() bool {
+			return len(target.getReceived()) == 1
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, []string{`call_ended:{"callID":"callA"}`}, target.getReceived())
+		require.Zero(t, countDeliveries(t, st))
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		target := newTestTarget(t, "secret", 0)
+		defer target.Close()
+
+		cfg := makeConfig(target)
+		cfg.MaxQueueSize = 1
+		d, err := NewDispatcher(cfg, st, log)
+		require.NoError(t, err)
+
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.NoError(t, d.Send("call_ended", map[string]string{"callID": "callA"}))
+		require.Equal(t, 1, countDeliveries(t, st))
+
+		d.Start()
+		defer d.Stop()
+
+		require.Eventually(t, func() bool {
+			return len(target.getReceived()) == 1
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, []string{`call_started:{"callID":"callA"}`}, target.getReceived())
+	})
+
+	t.Run("save failure", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		targetA := newTestTarget(t, "secretA", 0)
+		defer targetA.Close()
+		targetB := newTestTarget(t, "secretB", 0)
+		defer targetB.Close()
+
+		// Failing to queue for a target doesn't prevent queuing for the others.
+		d, err := NewDispatcher(makeConfig(targetA, targetB), &failingStore{Store: st, n: 1}, log)
+		require.NoError(t, err)
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.Empty(t, d.targets[0].queue)
+		require.Len(t, d.targets[1].queue, 1)
+		require.Equal(t, 1, countDeliveries(t, st))
+	})
+
+	t.Run("malformed delivery", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		target := newTestTarget(t, "secret", 0)
+		defer target.Close()
+
+		// Keys that can't be parsed as deliveries are skipped but kept.
+		require.NoError(t, st.Set(deliveryKeyPrefix+"malformed", "not a delivery"))
+		d, err := NewDispatcher(makeConfig(target), st, log)
+		require.NoError(t, err)
+		require.Empty(t, d.targets[0].queue)
+		value, err := st.Get(deliveryKeyPrefix + "malformed")
+		require.NoError(t, err)
+		require.Equal(t, "not a delivery", value)
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		st, teardown := setupStore(t)
+		defer teardown()
+
+		target := newTestTarget(t, "secret", 0)
+		defer target.Close()
+		removedTarget := newTestTarget(t, "secret", 0)
+		defer removedTarget.Close()
+
+		// Events queued while not running are persisted.
+		d, err := NewDispatcher(makeConfig(target, removedTarget), st, log)
+		require.NoError(t, err)
+		require.NoError(t, d.Send("call_started", map[string]string{"callID": "callA"}))
+		require.NoError(t, d.Send("call_ended", map[string]string{"callID": "callA"}))
+		require.Equal(t, 4, countDeliveries(t, st))
+
+		// Deliveries to targets no longer configured are dropped.
+		d, err = NewDispatcher(makeConfig(target), st, log)
+		require.NoError(t, err)
+		require.Equal(t, 2, countDeliveries(t, st))
+
+		d.Start()
+		defer d.Stop()
+
+		require.Eventually(t, func() bool {
+			return len(target.getReceived()) == 2
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Equal(t, []string{`call_started:{"callID":"callA"}`, `call_ended:{"callID":"callA"}`}, target.getReceived())
+		require.Empty(t, removedTarget.getReceived())
+		require.Zero(t, countDeliveries(t, st))
+	})
+}
+
+func TestGetRetryInterval(t *testing.T) {
+	d := &Dispatcher{cfg: Config{RetryIntervalSeconds: 5, RetryMaxIntervalSeconds: 30}}
+	require.Equal(t, 5*time.Second, d.getRetryInterval(1))
+	require.Equal(t, 10*time.Second, d.getRetryInterval(2))
+	require.Equal(t, 20*time.Second, d.getRetryInterval(3))
+	require.Equal(t, 30*time.Second, d.getRetryInterval(4))
+	require.Equal(t, 30*time.Second, d.getRetryInterval(100))
+}
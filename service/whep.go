@@ -0,0 +1,27 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package service
+
+import (
+	"net/http"
+)
+
+const whepPathPrefix = "/whep/"
+
+// handleWHEP implements the WHEP (WebRTC-HTTP Egress Protocol) endpoint:
+//
+//	POST /whep/{callID} creates a receive-only session in the given call.
+//	DELETE /whep/{callID}/{sessionID} closes it.
+//
+// Authentication works the same as for the WHIP endpoint.
+func (s *Service) handleWHEP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.startHTTPSession(w, r, "startWHEPSession", whepPathPrefix, s.rtcServer.InitWHEPSession)
+	case http.MethodDelete:
+		s.stopHTTPSession(w, r, "stopWHEPSession", whepPathPrefix, s.rtcServer.CloseWHEPSession)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWHEP(t *testing.T) {
+	th := SetupTestHelper(t, nil)
+	defer th.Teardown()
+
+	doRequest := func(t *testing.T, method, path, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, th.apiURL+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("", th.srvc.cfg.API.Security.AdminSecretKey)
+		req.Header.Set("Content-Type", "application/sdp")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("invalid method", func(t *testing.T) {
+		resp, err := http.Get(th.apiURL + "/whep/callID")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("invalid offer", func(t *testing.T) {
+		resp := doRequest(t, http.MethodPost, "/whep/callID?groupID=groupID", "invalid")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		require.NoError(t, err)
+		defer pc.Close()
+		_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+		require.NoError(t, err)
+		offer, err := pc.CreateOffer(nil)
+		require.NoError(t, err)
+		gatherCh := webrtc.GatheringCompletePromise(pc)
+		require.NoError(t, pc.SetLocalDescription(offer))
+		<-gatherCh
+
+		resp := doRequest(t, http.MethodPost, "/whep/callID?groupID=groupID", pc.LocalDescription().SDP)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		answer, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(answer)}))
+
+		location := resp.Header.Get("Location")
+		require.True(t, strings.HasPrefix(location, "/whep/callID/"), location)
+
+		// WHEP sessions can't be closed through the WHIP endpoint.
+		resp = doRequest(t, http.MethodDelete, strings.Replace(location, "/whep/", "/whip/", 1)+"?groupID=groupID", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = doRequest(t, http.MethodDelete, location+"?groupID=groupID", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package service
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/mattermost/rtcd/service/random"
+	"github.com/mattermost/rtcd/service/rtc"
+
+	"github.com/mattermost/mattermost/server/public/shared/mlog"
+)
+
+const (
+	whipPathPrefix = "/whip/"
+	// httpSessionMaxSDPSize is the maximum size of the offers accepted by the
+	// WHIP and WHEP endpoints.
+	httpSessionMaxSDPSize = 64 * 1024
+)
+
+// handleWHIP implements the WHIP (WebRTC-HTTP Ingestion Protocol) endpoint:
+//
+//	POST /whip/{callID} creates a publish-only session in the given call.
+//	DELETE /whip/{callID}/{sessionID} closes it.
+//
+// The group is the authenticated client. Admins need to pass it through the
+// groupID query parameter.
+func (s *Service) handleWHIP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.startHTTPSession(w, r, "startWHIPSession", whipPathPrefix, s.rtcServer.InitWHIPSession)
+	case http.MethodDelete:
+		s.stopHTTPSession(w, r, "stopWHIPSession", whipPathPrefix, s.rtcServer.CloseWHIPSession)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Service) getHTTPSessionGroupID(w http.ResponseWriter, r *http.Request) (string, int, error) {
+	clientID, code, err := s.authHandler(w, r)
+	if err != nil {
+		return "", code, err
+	}
+
+	if clientID != "" {
+		return clientID, http.StatusOK, nil
+	}
+
+	groupID := r.URL.Query().Get("groupID")
+	if groupID == "" {
+		return "", http.StatusBadRequest, fmt.Errorf("missing groupID")
+	}
+
+	return groupID, http.StatusOK, nil
+}
+
+// startHTTPSession creates a session out of the SDP offer in the request body,
+// through the given init function, responding with the SDP answer.
+func (s *Service) startHTTPSession(w http.ResponseWriter, r *http.Request, handler, pathPrefix string,
+	initFn func(cfg rtc.SessionConfig, offer string) (string, error)) {
+	data := &httpData{
+		reqData: map[string]string{},
+		resData: map[string]string{},
+	}
+
+	groupID, code, err := s.getHTTPSessionGroupID(w, r)
+	if err != nil {
+		data.err = err.Error()
+		data.code = code
+		s.httpAudit(handler, data, w, r)
+		return
+	}
+	data.reqData["clientID"] = groupID
+
+	// The SDP answer is returned as is so we only write JSON on failure.
+	defer func() {
+		if data.err != "" {
+			s.httpAudit(handler, data, w, r)
+			return
+		}
+		s.httpAudit(handler, data, nil, r)
+	}()
+
+	callID := strings.TrimPrefix(r.URL.Path, pathPrefix)
+	if callID == "" || strings.Contains(callID, "/") {
+		data.err = "invalid callID"
+		data.code = http.StatusBadRequest
+		return
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/sdp" {
+		data.err = "unsupported content type"
+		data.code = http.StatusUnsupportedMediaType
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, httpSessionMaxSDPSize))
+	if err != nil {
+		data.err = err.Error()
+		data.code = http.StatusBadRequest
+		return
+	}
+
+	cfg := rtc.SessionConfig{
+		GroupID:   groupID,
+		CallID:    callID,
+		UserID:    r.URL.Query().Get("userID"),
+		SessionID: random.NewID(),
+	}
+	if cfg.UserID == "" {
+		cfg.UserID = cfg.SessionID
+	}
+
+	answer, err := initFn(cfg, string(offer))
+	if err != nil {
+		data.err = err.Error()
+		data.code = http.StatusBadRequest
+		return
+	}
+
+	s.log.Debug("started HTTP session", mlog.String("handler", handler), mlog.String("callID", callID), mlog.String("sessionID", cfg.SessionID))
+
+	data.code = http.StatusCreated
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", pathPrefix+callID+"/"+cfg.SessionID)
+	w.WriteHeader(http.StatusCreated)
+	if _, err := io.WriteString(w, answer); err != nil {
+		s.log.Error("failed to write answer", mlog.Err(err))
+	}
+}
+
+// stopHTTPSession closes the session identified by the request path through
+// the given close function.
+func (s *Service) stopHTTPSession(w http.ResponseWriter, r *http.Request, handler, pathPrefix string,
+	closeFn func(groupID, callID, sessionID string) error) {
+	data := &httpData{
+		reqData: map[string]string{},
+		resData: map[string]string{},
+	}
+	defer s.httpAudit(handler, data, w, r)
+
+	groupID, code, err := s.getHTTPSessionGroupID(w, r)
+	if err != nil {
+		data.err = err.Error()
+		data.code = code
+		return
+	}
+	data.reqData["clientID"] = groupID
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, pathPrefix), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		data.err = "invalid session path"
+		data.code = http.StatusBadRequest
+		return
+	}
+
+	if err := closeFn(groupID, parts[0], parts[1]); err != nil {
+		data.err = err.Error()
+		data.code = http.StatusNotFound
+		return
+	}
+
+	data.code = http.StatusOK
+}
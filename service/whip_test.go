@@ -0,0 +1,109 @@
+// Copyright (c) 2022-present Mattermost, Inc. All Rights Reserved.
+// See LICENSE.txt for license information.
+
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWHIP(t *testing.T) {
+	th := SetupTestHelper(t, nil)
+	defer th.Teardown()
+
+	newOffer := func(t *testing.T) string {
+		t.Helper()
+		pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			require.NoError(t, pc.Close())
+		})
+		_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionSendonly})
+		require.NoError(t, err)
+		offer, err := pc.CreateOffer(nil)
+		require.NoError(t, err)
+		gatherCh := webrtc.GatheringCompletePromise(pc)
+		require.NoError(t, pc.SetLocalDescription(offer))
+		<-gatherCh
+		return pc.LocalDescription().SDP
+	}
+
+	doRequest := func(t *testing.T, method, path, contentType, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, th.apiURL+path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.SetBasicAuth("", th.srvc.cfg.API.Security.AdminSecretKey)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("invalid method", func(t *testing.T) {
+		resp, err := http.Get(th.apiURL + "/whip/callID")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		resp, err := http.Post(th.apiURL+"/whip/callID", "application/sdp", bytes.NewBufferString(newOffer(t)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("missing groupID", func(t *testing.T) {
+		resp := doRequest(t, http.MethodPost, "/whip/callID", "application/sdp", newOffer(t))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("invalid content type", func(t *testing.T) {
+		resp := doRequest(t, http.MethodPost, "/whip/callID?groupID=groupID", "application/json", newOffer(t))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+	})
+
+	t.Run("invalid offer", func(t *testing.T) {
+		resp := doRequest(t, http.MethodPost, "/whip/callID?groupID=groupID", "application/sdp", "invalid")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("session not found", func(t *testing.T) {
+		resp := doRequest(t, http.MethodDelete, "/whip/callID/sessionID?groupID=groupID", "", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		resp := doRequest(t, http.MethodPost, "/whip/callID?groupID=groupID&userID=obs", "application/sdp", newOffer(t))
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusCreated, resp.StatusCode)
+		require.Equal(t, "application/sdp", resp.Header.Get("Content-Type"))
+		answer, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(string(answer), "v=0"))
+
+		location := resp.Header.Get("Location")
+		require.True(t, strings.HasPrefix(location, "/whip/callID/"), location)
+
+		resp = doRequest(t, http.MethodDelete, location+"?groupID=otherGroupID", "", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		resp = doRequest(t, http.MethodDelete, location+"?groupID=groupID", "", "")
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
@@ -3,6 +3,10 @@
 
 package ws
 
+import (
+	"time"
+)
+
 // MessageType defines the type of message sent to or received from a ws
 // connection.
 type MessageType int
@@ -20,6 +24,8 @@ type Message struct {
 	ConnID   string
 	Type     MessageType
 	Data     []byte
+	// ReceivedAt is the time a message was read from the connection.
+	ReceivedAt time.Time
 }
 
 func newOpenMessage(connID, clientID string) Message {
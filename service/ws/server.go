@@ -178,10 +178,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 
 		s.receiveCh <- Message{
-			ConnID:   connID,
-			ClientID: conn.clientID,
-			Type:     msgType,
-			Data:     data,
+			ConnID:     connID,
+			ClientID:   conn.clientID,
+			Type:       msgType,
+			Data:       data,
+			ReceivedAt: time.Now(),
 		}
 	}
 }